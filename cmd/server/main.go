@@ -7,8 +7,11 @@ import (
 	apiv1 "github.com.Vova4o/nasforhome/internal/apiV1"
 	"github.com.Vova4o/nasforhome/internal/service"
 	"github.com.Vova4o/nasforhome/pkg/config"
+	"github.com.Vova4o/nasforhome/pkg/ldapauth"
+	"github.com.Vova4o/nasforhome/pkg/mailer"
 	miniolocal "github.com.Vova4o/nasforhome/pkg/minio"
 	"github.com.Vova4o/nasforhome/pkg/storagedb"
+	"github.com.Vova4o/nasforhome/pkg/webauthn"
 	"github.com/joho/godotenv"
 )
 
@@ -70,11 +73,106 @@ func main() {
 			AccessTTL:     config.JWTAccessTTL,
 			RefreshTTL:    config.JWTRefreshTTL,
 		},
+		service.Limits{
+			QuotaBytes:          config.UserQuotaBytes,
+			MaxExtractBytes:     config.MaxExtractBytes,
+			MaxUploadBytes:      config.MaxUploadBytes,
+			MaxAdminUploadBytes: config.MaxAdminUpload,
+		},
 	)
 
+	service.Mailer = mailer.New(mailer.Config{
+		SMTPHost:     config.SMTPHost,
+		SMTPPort:     config.SMTPPort,
+		SMTPUser:     config.SMTPUser,
+		SMTPPassword: config.SMTPPassword,
+		From:         config.MailFrom,
+		File:         config.MailFile,
+	})
+	service.Registration.RequireEmailVerification = config.VerifyEmail
+	service.Registration.Mode = config.RegistrationMode
+	if err := service.Registration.Validate(); err != nil {
+		log.Fatalf("Ошибка настройки регистрации: %v", err)
+	}
+
+	service.WebAuthn = webauthn.Config{
+		RPID:    config.WebAuthnRPID,
+		RPName:  config.WebAuthnRPName,
+		Origins: config.WebAuthnOrigins,
+	}
+	if err := service.WebAuthn.Validate(); err != nil {
+		log.Fatalf("Ошибка настройки входа по ключам доступа: %v", err)
+	}
+
+	service.OIDC.Issuer = config.OIDCIssuer
+	service.OIDC.ClientID = config.OIDCClientID
+	service.OIDC.ClientSecret = config.OIDCClientSecret
+	service.OIDC.RedirectURL = config.OIDCRedirectURL
+	service.OIDC.AutoProvision = config.OIDCCreateUsers
+	if err := service.OIDC.Validate(); err != nil {
+		log.Fatalf("Ошибка настройки входа через поставщика учетных записей: %v", err)
+	}
+
+	// С каталогом LDAP пароль проверяет каталог, а пользователи создаются при первом входе
+	if config.LDAPURL != "" {
+		directory, err := ldapauth.New(ldapauth.Config{
+			URL:          config.LDAPURL,
+			StartTLS:     config.LDAPStartTLS,
+			BindDN:       config.LDAPBindDN,
+			BindPassword: config.LDAPBindPassword,
+			BaseDN:       config.LDAPBaseDN,
+			UserFilter:   config.LDAPUserFilter,
+			GroupBaseDN:  config.LDAPGroupBaseDN,
+			GroupFilter:  config.LDAPGroupFilter,
+			AdminGroups:  config.LDAPAdminGroups,
+			UserGroups:   config.LDAPUserGroups,
+		})
+		if err != nil {
+			log.Fatalf("Ошибка настройки каталога LDAP: %v", err)
+		}
+		service.Authenticator = directory
+	}
+
+	// Счетчики неудачных попыток входа в БД переживают перезапуск и общие для нескольких экземпляров сервера
+	switch config.LoginStore {
+	case "memory":
+	case "postgres":
+		service.LoginThrottle = postgresDB
+	default:
+		log.Fatalf("Неизвестное хранилище счетчиков попыток входа: %s", config.LoginStore)
+	}
+	service.LoginLimits.UserLockout = config.LoginUserLockout
+	service.LoginLimits.IPLockout = config.LoginIPLockout
+
+	// Освобождаем имена и адреса регистраций, которые так и не подтвердили
+	if err := service.CleanupUnverifiedUsers(); err != nil {
+		log.Printf("Ошибка удаления неподтвержденных регистраций: %v", err)
+	}
+
+	// Доводим до конца или откатываем регистрации, прерванные прошлым запуском
+	if err := service.ReconcileProvisioning(ctx); err != nil {
+		log.Printf("Ошибка восстановления создания пользователей: %v", err)
+	}
+	// Регистрации, прерванные незадолго до перезапуска, становятся устаревшими позже — проверяем их периодически
+	go service.RunProvisioningReconciler(ctx)
+
+	// Переводим существующих пользователей с глобальной политики readwrite на политики со своим бакетом
+	if err := service.ReconcileUserPolicies(ctx); err != nil {
+		log.Printf("Ошибка обновления политик MinIO: %v", err)
+	}
+
 	serverAddress := config.ServerAddress + ":" + config.ServerPort
 
 	api := apiv1.New(service)
+	// Без доверенных прокси адрес клиента берется из соединения, а не из подделываемого X-Forwarded-For
+	if err := api.SetTrustedProxies(config.TrustedProxies); err != nil {
+		log.Fatalf("Ошибка настройки доверенных прокси: %v", err)
+	}
+	api.SetLimits(apiv1.Limits{
+		IP:           apiv1.RateLimit{PerMinute: config.IPRateLimit, Burst: config.IPRateBurst},
+		User:         apiv1.RateLimit{PerMinute: config.UserRateLimit, Burst: config.UserRateBurst},
+		MaxJSONBytes: config.MaxJSONBytes,
+	})
 	if err := api.Run(serverAddress); err != nil {
 		log.Fatalf("Ошибка запуска сервера: %v", err)
 	}
@@ -0,0 +1,121 @@
+package apiv1
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ListInvites обработчик для получения списка приглашений
+func (a *APIV1) ListInvites(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	invites, err := a.service.ListInvites(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]gin.H, 0, len(invites))
+	for _, invite := range invites {
+		result = append(result, gin.H{
+			"id":          invite.ID,
+			"max_uses":    invite.MaxUses,
+			"uses":        invite.Uses,
+			"quota_bytes": invite.QuotaBytes,
+			"expires_at":  invite.ExpiresAt,
+			"created_at":  invite.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invites": result})
+}
+
+// CreateInvite обработчик для создания приглашения. Код возвращается только в этом ответе.
+func (a *APIV1) CreateInvite(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req struct {
+		ExpiresIn  int   `json:"expires_in" binding:"required"` // Срок действия в секундах
+		MaxUses    int   `json:"max_uses"`                      // По умолчанию приглашение одноразовое
+		QuotaBytes int64 `json:"quota_bytes"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+
+	invite, code, err := a.service.CreateInvite(c.Request.Context(), userID, service.InviteOptions{
+		TTL:        time.Duration(req.ExpiresIn) * time.Second,
+		MaxUses:    req.MaxUses,
+		QuotaBytes: req.QuotaBytes,
+	})
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":          invite.ID,
+		"code":        code,
+		"max_uses":    invite.MaxUses,
+		"quota_bytes": invite.QuotaBytes,
+		"expires_at":  invite.ExpiresAt,
+	})
+}
+
+// DeleteInvite обработчик для отзыва приглашения
+func (a *APIV1) DeleteInvite(c *gin.Context) {
+	userID := c.GetInt("userID")
+	inviteID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID приглашения"})
+		return
+	}
+
+	if err := a.service.DeleteInvite(c.Request.Context(), userID, inviteID); err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "приглашение отозвано"})
+}
+
+// ListLoginFailures обработчик для просмотра журнала неудачных попыток входа
+func (a *APIV1) ListLoginFailures(c *gin.Context) {
+	userID := c.GetInt("userID")
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "неверное значение limit: " + value})
+			return
+		}
+	}
+
+	failures, err := a.service.ListLoginFailures(c.Request.Context(), userID, limit)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]gin.H, 0, len(failures))
+	for _, failure := range failures {
+		result = append(result, gin.H{
+			"id":         failure.ID,
+			"username":   failure.Username,
+			"ip":         failure.IP,
+			"reason":     failure.Reason,
+			"created_at": failure.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"failures": result})
+}
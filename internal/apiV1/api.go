@@ -1,11 +1,16 @@
 package apiv1
 
 import (
+	"errors"
 	"fmt"
+	"mime"
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
 
 	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com.Vova4o/nasforhome/pkg/models"
 	"github.com/gin-gonic/gin"
 )
 
@@ -13,6 +18,11 @@ import (
 type APIV1 struct {
 	router  *gin.Engine
 	service *service.Service
+
+	ipLimiter    *rateLimiter    // Частота запросов по адресу клиента; nil — без ограничений
+	userLimiter  *rateLimiter    // Частота запросов по пользователю; nil — без ограничений
+	maxJSONBytes int64           // Максимальный размер тела запроса, кроме загрузки файлов
+	uploadRoutes map[string]bool // Шаблоны путей загрузки файлов, для которых bodyLimit не действует
 }
 
 // Config конфигурация для API
@@ -22,44 +32,94 @@ type Config struct {
 
 // New создает и настраивает экземпляр API v1
 func New(service *service.Service) *APIV1 {
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Logger(), gin.CustomRecovery(recoverPanic))
 
 	api := &APIV1{
 		router:  router,
 		service: service,
 	}
 
+	api.SetLimits(Limits{})
 	api.setupRoutes()
 	return api
 }
 
+// recoverPanic отвечает 500 на панику в обработчике, как стандартный Recovery.
+// http.ErrAbortHandler пробрасывается дальше, чтобы net/http оборвал соединение и клиент не принял
+// недописанный ответ за полный.
+func recoverPanic(c *gin.Context, err any) {
+	if err == http.ErrAbortHandler {
+		panic(err)
+	}
+	c.AbortWithStatus(http.StatusInternalServerError)
+}
+
 // setupRoutes настраивает маршруты API
 func (a *APIV1) setupRoutes() {
 	// Создаем группу маршрутов с префиксом /api/v1
-	v1 := a.router.Group("/api/v1")
+	v1 := a.router.Group("/api/v1", a.ipRateLimit(), a.bodyLimit())
 	{
 		// Публичные маршруты (без авторизации)
 		v1.POST("/users/register", a.RegisterUser)
 		v1.POST("/users/login", a.LoginUser)
+		v1.POST("/users/login/mfa", a.CompleteMFALogin)
+		v1.POST("/users/login/passkey/begin", a.BeginPasskeyLogin)
+		v1.POST("/users/login/passkey/finish", a.FinishPasskeyLogin)
+		v1.POST("/users/login/oidc/begin", a.BeginOIDCLogin)
+		v1.POST("/users/login/oidc/finish", a.FinishOIDCLogin)
 		v1.POST("/users/refresh", a.RefreshToken)
+		v1.POST("/users/email/confirm", a.ConfirmEmail)
+		v1.POST("/users/email/verify", a.VerifyEmail)
+		v1.POST("/users/password/forgot", a.ForgotPassword)
+		v1.POST("/users/password/reset", a.ResetPassword)
 		v1.GET("/ping", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{"message": "pong"})
 		})
 
 		// Защищенные маршруты с проверкой авторизации
 		authorized := v1.Group("/")
-		authorized.Use(a.authMiddleware())
+		authorized.Use(a.authMiddleware(), a.userRateLimit())
 		{
 			// Маршруты для пользователя
 			authorized.GET("/users/me", a.GetUserInfo)
+			authorized.PATCH("/users/me", a.sessionOnly(), a.UpdateCurrentUser)
+			authorized.POST("/users/me/password", a.sessionOnly(), a.ChangePassword)
+			authorized.POST("/users/me/email/verify", a.ResendEmailVerification)
+
+			// Двухфакторная аутентификация
+			authorized.POST("/users/me/mfa/totp", a.sessionOnly(), a.BeginTOTPEnrollment)
+			authorized.POST("/users/me/mfa/totp/confirm", a.sessionOnly(), a.ConfirmTOTPEnrollment)
+			authorized.DELETE("/users/me/mfa/totp", a.sessionOnly(), a.DisableTOTP)
+			authorized.POST("/users/me/mfa/recovery-codes", a.sessionOnly(), a.RegenerateRecoveryCodes)
+
+			// Ключи доступа (passkeys)
+			authorized.GET("/users/me/passkeys", a.ListPasskeys)
+			authorized.POST("/users/me/passkeys/begin", a.sessionOnly(), a.BeginPasskeyRegistration)
+			authorized.POST("/users/me/passkeys/finish", a.sessionOnly(), a.FinishPasskeyRegistration)
+			authorized.DELETE("/users/me/passkeys/:id", a.sessionOnly(), a.DeletePasskey)
+
+			// Персональные токены API; токеном API ими управлять нельзя
+			authorized.GET("/users/me/tokens", a.sessionOnly(), a.ListAPITokens)
+			authorized.POST("/users/me/tokens", a.sessionOnly(), a.CreateAPIToken)
+			authorized.DELETE("/users/me/tokens/:id", a.sessionOnly(), a.DeleteAPIToken)
+
+			// Сеансы на устройствах, где выполнен вход
+			authorized.GET("/users/me/sessions", a.sessionOnly(), a.ListSessions)
+			authorized.DELETE("/users/me/sessions/:id", a.sessionOnly(), a.DeleteSession)
 
 			// Маршруты для файлов
 			files := authorized.Group("/files")
 			{
 				files.GET("/list", a.ListFiles)
-				files.GET("/download/:filename", a.DownloadFile)
-				files.POST("/upload", a.UploadFile)
-				files.DELETE("/:filename", a.DeleteFile)
+				files.GET("/download/*path", a.DownloadFile)
+				files.GET("/archive", a.DownloadArchive)
+				files.POST("/archive", a.DownloadSelectionArchive)
+				a.uploadRoute(files, "/upload", a.UploadFile)
+				files.POST("/move", a.MoveFile)
+				files.DELETE("/*path", a.DeleteFile)
+				files.POST("/extract", a.ExtractArchive)
+				files.GET("/extract/:id", a.GetExtractJob)
 			}
 
 			// Маршруты для папок
@@ -67,7 +127,63 @@ func (a *APIV1) setupRoutes() {
 			{
 				folders.GET("/list", a.ListFolders)
 				folders.POST("/create", a.CreateFolder)
-				folders.DELETE("/:foldername", a.DeleteFolder)
+				folders.DELETE("/*path", a.DeleteFolder)
+			}
+
+			// Общие пространства и доступ к ним
+			spaces := authorized.Group("/spaces")
+			{
+				spaces.GET("", a.ListSpaces)
+				spaces.POST("", a.CreateSpace)
+				spaces.DELETE("/:id", a.DeleteSpace)
+				spaces.GET("/:id/members", a.ListSpaceMembers)
+				spaces.PUT("/:id/members", a.GrantSpaceAccess)
+				spaces.DELETE("/:id/members/:userID", a.RevokeSpaceAccess)
+				spaces.DELETE("/:id/groups/:groupID", a.RevokeSpaceGroupAccess)
+
+				// Файлы общего пространства обслуживаются теми же обработчиками, что и личные
+				spaceFiles := spaces.Group("/:id/files", a.spaceContext())
+				{
+					spaceFiles.GET("/list", a.ListFiles)
+					spaceFiles.GET("/download/*path", a.DownloadFile)
+					spaceFiles.GET("/archive", a.DownloadArchive)
+					spaceFiles.POST("/archive", a.DownloadSelectionArchive)
+					a.uploadRoute(spaceFiles, "/upload", a.UploadFile)
+					spaceFiles.POST("/move", a.MoveFile)
+					spaceFiles.DELETE("/*path", a.DeleteFile)
+				}
+				spaces.GET("/:id/directory/list", a.spaceContext(), a.ListDirectory)
+				spaces.POST("/:id/folders/create", a.spaceContext(), a.CreateFolder)
+				spaces.DELETE("/:id/folders/*path", a.spaceContext(), a.DeleteFolder)
+			}
+
+			// Группы пользователей
+			groups := authorized.Group("/groups")
+			{
+				groups.GET("", a.ListGroups)
+				groups.POST("", a.CreateGroup)
+				groups.DELETE("/:id", a.DeleteGroup)
+				groups.GET("/:id/members", a.ListGroupMembers)
+				groups.PUT("/:id/members", a.AddGroupMember)
+				groups.DELETE("/:id/members/:userID", a.RemoveGroupMember)
+			}
+
+			// Содержимое папки (папки и файлы) одной страницей
+			authorized.GET("/directory/list", a.ListDirectory)
+
+			// Поток событий с файлами (SSE)
+			authorized.GET("/events", a.StreamEvents)
+
+			// Журнал изменений для клиентов синхронизации
+			authorized.GET("/sync/changes", a.ListChanges)
+
+			// Администрирование; права проверяются в сервисе
+			admin := authorized.Group("/admin", a.requireAPITokenScope(models.APITokenScopeAdmin))
+			{
+				admin.GET("/invites", a.ListInvites)
+				admin.POST("/invites", a.CreateInvite)
+				admin.DELETE("/invites/:id", a.DeleteInvite)
+				admin.GET("/login-failures", a.ListLoginFailures)
 			}
 		}
 	}
@@ -91,16 +207,23 @@ func (a *APIV1) authMiddleware() gin.HandlerFunc {
             return
         }
 
+        // Персональные токены API отличаются от JWT префиксом
+        if strings.HasPrefix(tokenParts[1], service.APITokenPrefix) {
+            a.authenticateAPIToken(c, tokenParts[1])
+            return
+        }
+
         // Проверяем валидность токена
-        claims, err := a.service.VerifyAccessToken(tokenParts[1])
+        claims, err := a.service.AuthenticateAccessToken(tokenParts[1])
         if err != nil {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "недействительный токен"})
             c.Abort()
             return
         }
 
-        // Устанавливаем ID пользователя в контекст
+        // Устанавливаем ID пользователя и сеанса в контекст
         c.Set("userID", claims.UserID)
+        c.Set("sessionID", claims.SessionID)
         c.Next()
     }
 }
@@ -113,8 +236,8 @@ func (a *APIV1) RefreshToken(c *gin.Context) {
         return
     }
 
-    // Обновляем токены
-    tokens, err := a.service.RefreshTokens(refreshToken)
+    // Обновляем токены; адрес и браузер сохраняются в сеансе
+    tokens, err := a.service.RefreshTokens(clientContext(c, ""), refreshToken)
     if err != nil {
         c.JSON(http.StatusUnauthorized, gin.H{"error": "недействительный refresh токен"})
         return
@@ -135,12 +258,19 @@ func (a *APIV1) Run(addr string) error {
 	return a.router.Run(addr)
 }
 
+// SetTrustedProxies задает адреса обратных прокси, которым разрешено передавать адрес клиента в X-Forwarded-For.
+// Пустой список означает, что адрес клиента всегда берется из соединения.
+func (a *APIV1) SetTrustedProxies(proxies []string) error {
+	return a.router.SetTrustedProxies(proxies)
+}
+
 // RegisterUser обработчик для регистрации пользователя
 func (a *APIV1) RegisterUser(c *gin.Context) {
 	var req struct {
 		Username string `json:"username" binding:"required"`
 		Password string `json:"password" binding:"required"`
 		Email    string `json:"email" binding:"required,email"`
+		Invite   string `json:"invite"` // Код приглашения; обязателен в режиме регистрации по приглашениям
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -148,9 +278,9 @@ func (a *APIV1) RegisterUser(c *gin.Context) {
 		return
 	}
 
-	user, tokens, err := a.service.RegisterUser(c.Request.Context(), req.Username, req.Password, req.Email)
+	user, tokens, err := a.service.RegisterUser(clientContext(c, ""), req.Username, req.Password, req.Email, req.Invite)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 
@@ -159,18 +289,34 @@ func (a *APIV1) RegisterUser(c *gin.Context) {
 
 	// Return access token in JSON response (Frontend will store it in memory)
 	c.JSON(http.StatusOK, gin.H{
-		"message":      "Пользователь успешно зарегистрирован",
-		"user_id":      user.ID,
-		"access_token": tokens.AccessToken,
-		"expires_in":   tokens.ExpiresIn,
+		"message":        "Пользователь успешно зарегистрирован",
+		"user_id":        user.ID,
+		"email_verified": user.EmailVerified,
+		"access_token":   tokens.AccessToken,
+		"expires_in":     tokens.ExpiresIn,
 	})
 }
 
+// loginThrottled отвечает 429 с заголовком Retry-After, если вход задержан после неудачных попыток
+func loginThrottled(c *gin.Context, err error) bool {
+	var throttled *service.LoginThrottledError
+	if !errors.As(err, &throttled) {
+		return false
+	}
+	c.Header("Retry-After", strconv.Itoa(throttled.RetryAfterSeconds()))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       err.Error(),
+		"retry_after": throttled.RetryAfterSeconds(),
+	})
+	return true
+}
+
 // LoginUser обработчик для входа пользователя
 func (a *APIV1) LoginUser(c *gin.Context) {
 	var req struct {
-		Username string `json:"username" binding:"required"`
-		Password string `json:"password" binding:"required"`
+		Username   string `json:"username" binding:"required"`
+		Password   string `json:"password" binding:"required"`
+		DeviceName string `json:"device_name"` // Название устройства в списке сеансов
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -178,7 +324,24 @@ func (a *APIV1) LoginUser(c *gin.Context) {
 		return
 	}
 
-	user, tokens, err := a.service.LoginUser(c.Request.Context(), req.Username, req.Password)
+	user, tokens, err := a.service.LoginUser(clientContext(c, req.DeviceName), req.Username, req.Password, c.ClientIP())
+	if loginThrottled(c, err) {
+		return
+	}
+	var mfa *service.MFARequiredError
+	if errors.As(err, &mfa) {
+		// Пароль верный, но токены выдаются только после кода второго фактора
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    mfa.Token,
+			"expires_in":   mfa.ExpiresIn,
+		})
+		return
+	}
+	if errors.Is(err, service.ErrAccountNotReady) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "хранилище пользователя еще создается, повторите попытку позже"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "неверное имя пользователя или пароль"})
 		return
@@ -189,10 +352,11 @@ func (a *APIV1) LoginUser(c *gin.Context) {
 
 	// Return access token in JSON response (Frontend will store it in memory)
 	c.JSON(http.StatusOK, gin.H{
-		"message":      "Пользователь успешно зарегистрирован",
-		"user_id":      user.ID,
-		"access_token": tokens.AccessToken,
-		"expires_in":   tokens.ExpiresIn,
+		"message":        "Пользователь успешно зарегистрирован",
+		"user_id":        user.ID,
+		"email_verified": user.EmailVerified,
+		"access_token":   tokens.AccessToken,
+		"expires_in":     tokens.ExpiresIn,
 	})
 }
 
@@ -205,64 +369,99 @@ func (a *APIV1) GetUserInfo(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка получения данных пользователя"})
 		return
 	}
+	mfaEnabled, err := a.service.MFAEnabled(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка получения данных пользователя"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"id":       user.ID,
-		"username": user.UserName,
-		"email":    user.Email,
+		"id":             user.ID,
+		"username":       user.UserName,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"is_admin":       user.IsAdmin,
+		"mfa_enabled":    mfaEnabled,
 	})
 }
 
-// ListFiles обработчик для получения списка файлов
+// ListFiles обработчик для получения страницы списка файлов
 func (a *APIV1) ListFiles(c *gin.Context) {
 	userID := c.GetInt("userID")
-	prefix := c.DefaultQuery("prefix", "")
-	recursive := c.DefaultQuery("recursive", "false") == "true"
 
-	// Используем метод сервиса для получения списка файлов
-	objects, err := a.service.ListUserFiles(c.Request.Context(), userID, prefix, recursive)
+	opts, err := listOptions(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка получения списка файлов"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Форматируем результат для ответа
-	var files []map[string]interface{}
-	for _, obj := range objects {
-		files = append(files, map[string]interface{}{
-			"name":          obj.Key,
-			"size":          obj.Size,
-			"etag":          obj.ETag,
-			"content_type":  obj.ContentType,
-			"last_modified": obj.LastModified,
-		})
+	// Используем метод сервиса для получения списка файлов
+	page, err := a.service.ListUserFiles(c.Request.Context(), userID, opts)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": "ошибка получения списка файлов"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"files": files,
+		"files":       formatFiles(page.Files),
+		"next_cursor": page.NextCursor,
 	})
 }
 
-// DownloadFile обработчик для скачивания файла
+// DownloadFile обработчик для скачивания файла.
+// Поддерживает Range, условные запросы по ETag/дате изменения и ?inline=1 для показа в браузере.
 func (a *APIV1) DownloadFile(c *gin.Context) {
 	userID := c.GetInt("userID")
-	filename := c.Param("filename")
+	filename := objectKeyParam(c)
 
-	// Получаем файл с помощью сервиса
-	object, stat, err := a.service.GetUserFile(c.Request.Context(), userID, filename)
+	// Сначала получаем только метаданные, чтобы ответить 304 без чтения файла
+	stat, err := a.service.StatUserFile(c.Request.Context(), userID, filename)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": "ошибка получения файла"})
+		return
+	}
+
+	c.Header("ETag", quoteETag(stat.ETag))
+	c.Header("Last-Modified", stat.LastModified.UTC().Format(http.TimeFormat))
+	c.Header("Accept-Ranges", "bytes")
+
+	if notModified(c.Request, stat) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	byteRange, err := requestedRange(c.Request, stat)
+	if err != nil {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", stat.Size))
+		c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Получаем файл (или его часть) с помощью сервиса
+	object, _, err := a.service.GetUserFile(c.Request.Context(), userID, filename, byteRange)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка получения файла"})
 		return
 	}
+	defer object.Close()
+
+	disposition := "attachment"
+	if c.Query("inline") == "1" {
+		disposition = "inline"
+	}
 
 	// Устанавливаем заголовки
 	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Disposition", "attachment; filename="+filename)
-	c.Header("Content-Type", stat.ContentType)
-	c.Header("Content-Length", fmt.Sprintf("%d", stat.Size))
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": path.Base(filename)}))
+
+	status, length := http.StatusOK, stat.Size
+	if byteRange != nil {
+		status, length = http.StatusPartialContent, byteRange.Length()
+		c.Header("Content-Range", byteRange.ContentRange(stat.Size))
+	}
 
 	// Передаем файл клиенту
-	c.DataFromReader(http.StatusOK, stat.Size, stat.ContentType, object, nil)
+	c.DataFromReader(status, length, stat.ContentType, object, nil)
 }
 
 // UploadFile обработчик для загрузки файла
@@ -271,6 +470,10 @@ func (a *APIV1) UploadFile(c *gin.Context) {
 
 	// Получаем загруженный файл из формы
 	file, header, err := c.Request.FormFile("file")
+	if bodyTooLarge(err) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": service.ErrUploadTooLarge.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "файл не найден в запросе"})
 		return
@@ -292,29 +495,68 @@ func (a *APIV1) UploadFile(c *gin.Context) {
 		contentType = "application/octet-stream"
 	}
 
+	// Клиенты синхронизации передают версию, на которой основаны их изменения
+	cond, ok := uploadCondition(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректные условия загрузки"})
+		return
+	}
+
+	// Некорректный запрос на распаковку отклоняется до загрузки, чтобы не оставлять архив без распаковки
+	extract := c.DefaultPostForm("extract", "false") == "true"
+	extractTarget := c.DefaultPostForm("extract_to", path)
+	if extract {
+		if err := service.ValidateExtract(objectName, extractTarget); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	// Загружаем файл с помощью сервиса
-	info, err := a.service.UploadUserFile(c.Request.Context(), userID, objectName, file, header.Size, contentType)
+	info, err := a.service.UploadUserFileConditional(c.Request.Context(), userID, objectName, file, header.Size, contentType, cond)
+	if errors.Is(err, service.ErrConflict) {
+		c.JSON(conflictStatus(c), gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка загрузки файла"})
+		c.JSON(fileErrorStatus(err), gin.H{"error": "ошибка загрузки файла"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message":     "файл успешно загружен",
 		"object_name": info.Key,
 		"etag":        info.ETag,
 		"size":        info.Size,
-	})
+	}
+	if info.Key != objectName {
+		response["message"] = "файл изменен другим клиентом, загрузка сохранена как конфликтующая копия"
+		response["conflicted_copy"] = true
+	}
+	c.Header("ETag", quoteETag(info.ETag))
+
+	// По запросу распаковываем загруженный архив в фоне. Файл уже сохранен, поэтому ошибка запуска
+	// распаковки не делает загрузку неуспешной и возвращается в ответе
+	if extract {
+		job, err := a.service.StartExtractArchive(c.Request.Context(), userID, info.Key, extractTarget)
+		if err != nil {
+			response["extract_error"] = err.Error()
+		} else {
+			response["extract_job"] = job
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 // DeleteFile обработчик для удаления файла
 func (a *APIV1) DeleteFile(c *gin.Context) {
 	userID := c.GetInt("userID")
-	filename := c.Param("filename")
+	filename := objectKeyParam(c)
 
 	// Удаляем файл с помощью сервиса
 	if err := a.service.DeleteUserFile(c.Request.Context(), userID, filename); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка удаления файла"})
+		c.JSON(fileErrorStatus(err), gin.H{"error": "ошибка удаления файла"})
 		return
 	}
 
@@ -324,28 +566,26 @@ func (a *APIV1) DeleteFile(c *gin.Context) {
 	})
 }
 
-// ListFolders обработчик для получения списка папок
+// ListFolders обработчик для получения страницы списка папок
 func (a *APIV1) ListFolders(c *gin.Context) {
 	userID := c.GetInt("userID")
-	prefix := c.DefaultQuery("prefix", "")
 
-	// Используем метод сервиса для получения списка папок
-	folders, err := a.service.ListUserFolders(c.Request.Context(), userID, prefix)
+	opts, err := listOptions(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка получения списка папок"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Форматируем результат для ответа
-	var result []map[string]interface{}
-	for _, folder := range folders {
-		result = append(result, map[string]interface{}{
-			"name": folder,
-		})
+	// Используем метод сервиса для получения списка папок
+	page, err := a.service.ListUserFolders(c.Request.Context(), userID, opts)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": "ошибка получения списка папок"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"folders": result,
+		"folders":     formatFolders(page.Folders),
+		"next_cursor": page.NextCursor,
 	})
 }
 
@@ -365,7 +605,7 @@ func (a *APIV1) CreateFolder(c *gin.Context) {
 	// Создаем папку с помощью сервиса
 	err := a.service.CreateUserFolder(c.Request.Context(), userID, req.FolderName)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка создания папки"})
+		c.JSON(fileErrorStatus(err), gin.H{"error": "ошибка создания папки"})
 		return
 	}
 
@@ -378,11 +618,11 @@ func (a *APIV1) CreateFolder(c *gin.Context) {
 // DeleteFolder обработчик для удаления папки
 func (a *APIV1) DeleteFolder(c *gin.Context) {
 	userID := c.GetInt("userID")
-	folderName := c.Param("foldername")
+	folderName := objectKeyParam(c)
 
 	// Удаляем папку с помощью сервиса
 	if err := a.service.DeleteUserFolder(c.Request.Context(), userID, folderName); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка удаления папки"})
+		c.JSON(fileErrorStatus(err), gin.H{"error": "ошибка удаления папки"})
 		return
 	}
 
@@ -0,0 +1,135 @@
+package apiv1
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/gin-gonic/gin"
+)
+
+// authenticateAPIToken проверяет персональный токен API вместо JWT. Запросы GET и HEAD требуют права read,
+// остальные — write; административные маршруты дополнительно проверяются в requireAPITokenScope.
+func (a *APIV1) authenticateAPIToken(c *gin.Context, raw string) {
+	token, err := a.service.AuthenticateAPIToken(raw)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "недействительный токен"})
+		return
+	}
+
+	scope := models.APITokenScopeWrite
+	if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+		scope = models.APITokenScopeRead
+	}
+	if !service.APITokenAllows(token, scope) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": service.ErrAPITokenScope.Error()})
+		return
+	}
+
+	c.Set("userID", token.UserID)
+	c.Set("apiToken", token)
+	c.Next()
+}
+
+// requireAPITokenScope требует у токена API права scope. Запросы с JWT пропускаются без проверки.
+func (a *APIV1) requireAPITokenScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token, ok := c.Get("apiToken"); ok && !service.APITokenAllows(token.(*models.APIToken), scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": service.ErrAPITokenScope.Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+// sessionOnly запрещает действие с токеном API: пароль, второй фактор, ключи доступа и сами токены
+// меняются только после входа, иначе утекший токен позволил бы захватить учетную запись.
+func (a *APIV1) sessionOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("apiToken"); ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": service.ErrAPITokenSessionRequired.Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+// ListAPITokens обработчик для получения токенов API текущего пользователя
+func (a *APIV1) ListAPITokens(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	tokens, err := a.service.ListAPITokens(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]gin.H, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, gin.H{
+			"id":           token.ID,
+			"name":         token.Name,
+			"scopes":       token.Scopes,
+			"expires_at":   token.ExpiresAt,
+			"last_used_at": token.LastUsedAt,
+			"created_at":   token.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tokens": result})
+}
+
+// CreateAPIToken обработчик для создания токена API. Токен показывается только в этом ответе.
+func (a *APIV1) CreateAPIToken(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req struct {
+		Name          string   `json:"name" binding:"required"`
+		Scopes        []string `json:"scopes" binding:"required"`
+		ExpiresInDays int      `json:"expires_in_days"` // 0 — бессрочный
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, raw, err := a.service.CreateAPIToken(c.Request.Context(), userID, service.APITokenOptions{
+		Name:   req.Name,
+		Scopes: req.Scopes,
+		TTL:    time.Duration(req.ExpiresInDays) * 24 * time.Hour,
+	})
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":         token.ID,
+		"token":      raw,
+		"name":       token.Name,
+		"scopes":     token.Scopes,
+		"expires_at": token.ExpiresAt,
+		"created_at": token.CreatedAt,
+	})
+}
+
+// DeleteAPIToken обработчик для отзыва токена API текущего пользователя
+func (a *APIV1) DeleteAPIToken(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	tokenID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID токена"})
+		return
+	}
+
+	if err := a.service.DeleteAPIToken(c.Request.Context(), userID, tokenID); err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "токен отозван"})
+}
@@ -0,0 +1,105 @@
+package apiv1
+
+import (
+	"log"
+	"mime"
+	"net/http"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// DownloadArchive обработчик для скачивания папки одним архивом
+func (a *APIV1) DownloadArchive(c *gin.Context) {
+	userID := c.GetInt("userID")
+	prefix := c.DefaultQuery("prefix", "")
+
+	format, err := service.ParseArchiveFormat(c.DefaultQuery("format", ""))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Путь проверяем до отправки заголовков, пока еще можно вернуть 400
+	if err := service.ValidatePrefix(prefix); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	w := &archiveResponse{c: c, filename: service.ArchiveName(prefix, format), format: format}
+	if err := a.service.ArchiveUserFolder(c.Request.Context(), userID, w, format, prefix); err != nil {
+		w.fail(err)
+	}
+}
+
+// DownloadSelectionArchive обработчик для скачивания выбранных файлов и папок одним архивом
+func (a *APIV1) DownloadSelectionArchive(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req struct {
+		Keys   []string `json:"keys" binding:"required,min=1"`
+		Format string   `json:"format"`
+		Name   string   `json:"name"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format, err := service.ParseArchiveFormat(req.Format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, key := range req.Keys {
+		if err := service.ValidateObjectKey(key); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	w := &archiveResponse{c: c, filename: service.ArchiveName(req.Name, format), format: format}
+	if err := a.service.ArchiveUserSelection(c.Request.Context(), userID, w, format, req.Keys); err != nil {
+		w.fail(err)
+	}
+}
+
+// archiveResponse откладывает заголовки и статус 200 до первого байта архива,
+// чтобы ошибку, возникшую до начала передачи, можно было вернуть обычным ответом
+type archiveResponse struct {
+	c        *gin.Context
+	filename string
+	format   service.ArchiveFormat
+	started  bool
+}
+
+// Write отправляет заголовки архива при первой записи
+func (w *archiveResponse) Write(p []byte) (int, error) {
+	if !w.started {
+		setArchiveHeaders(w.c, w.filename, w.format)
+		w.started = true
+	}
+	return w.c.Writer.Write(p)
+}
+
+// fail сообщает об ошибке: статусом, если передача еще не началась, иначе только в лог
+func (w *archiveResponse) fail(err error) {
+	log.Printf("ошибка создания архива: %v", err)
+	if !w.started {
+		w.c.JSON(fileErrorStatus(err), gin.H{"error": "ошибка создания архива"})
+		return
+	}
+	// Архив собирается на лету, поэтому после начала передачи соединение остается только оборвать:
+	// при обычном завершении клиент получил бы обрезанный архив со статусом 200
+	panic(http.ErrAbortHandler)
+}
+
+// setArchiveHeaders устанавливает заголовки для потоковой отдачи архива
+func setArchiveHeaders(c *gin.Context, filename string, format service.ArchiveFormat) {
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Header("Content-Type", format.ContentType())
+	c.Status(http.StatusOK)
+}
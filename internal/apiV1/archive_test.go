@@ -0,0 +1,49 @@
+package apiv1
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestArchiveResponseFail проверяет, что ошибка до начала передачи возвращается статусом,
+// а после начала обрывает соединение, и клиент не принимает обрезанный архив за полный
+func TestArchiveResponseFail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(gin.CustomRecoveryWithWriter(io.Discard, recoverPanic))
+
+	router.GET("/missing", func(c *gin.Context) {
+		w := &archiveResponse{c: c, filename: "files.zip", format: service.ArchiveZip}
+		w.fail(service.ErrArchiveSourceNotFound)
+	})
+	router.GET("/broken", func(c *gin.Context) {
+		w := &archiveResponse{c: c, filename: "files.zip", format: service.ArchiveZip}
+		_, _ = w.Write([]byte("PK"))
+		c.Writer.Flush()
+		w.fail(errors.New("ошибка чтения объекта"))
+	})
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/missing")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "application/json")
+
+	resp, err = http.Get(server.URL + "/broken")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = io.ReadAll(resp.Body)
+	assert.Error(t, err, "Обрезанный архив должен завершаться ошибкой чтения, а не концом ответа")
+}
@@ -0,0 +1,81 @@
+package apiv1
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com/minio/minio-go/v7"
+)
+
+// quoteETag возвращает ETag в кавычках, как того требует HTTP
+func quoteETag(etag string) string {
+	return `"` + strings.Trim(etag, `"`) + `"`
+}
+
+// etagMatches проверяет, есть ли ETag объекта в списке из заголовка (слабое сравнение)
+func etagMatches(header, etag string) bool {
+	etag = quoteETag(etag)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified проверяет If-None-Match и If-Modified-Since.
+// If-Modified-Since учитывается, только если If-None-Match не передан.
+func notModified(r *http.Request, stat *minio.ObjectInfo) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, stat.ETag)
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+
+	return !stat.LastModified.Truncate(time.Second).After(t)
+}
+
+// requestedRange возвращает запрошенный диапазон или nil, если отдавать нужно весь файл.
+// Ошибка возвращается только для недостижимого диапазона (416).
+func requestedRange(r *http.Request, stat *minio.ObjectInfo) (*service.ByteRange, error) {
+	header := r.Header.Get("Range")
+	if header == "" {
+		return nil, nil
+	}
+
+	// If-Range: если файл изменился, диапазон игнорируется и файл отдается целиком
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" {
+		if strings.HasPrefix(ifRange, `"`) {
+			if ifRange != quoteETag(stat.ETag) {
+				return nil, nil
+			}
+		} else {
+			t, err := http.ParseTime(ifRange)
+			if err != nil || !stat.LastModified.Truncate(time.Second).Equal(t) {
+				return nil, nil
+			}
+		}
+	}
+
+	byteRange, err := service.ParseByteRange(header, stat.Size)
+	if errors.Is(err, service.ErrRangeNotSatisfiable) {
+		return nil, err
+	}
+	if err != nil {
+		// Синтаксически неверный Range по RFC 9110 игнорируется
+		return nil, nil
+	}
+
+	return byteRange, nil
+}
@@ -0,0 +1,47 @@
+package apiv1
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventsHeartbeat интервал служебных сообщений, чтобы прокси не закрывали простаивающее соединение
+const eventsHeartbeat = 30 * time.Second
+
+// StreamEvents обработчик для получения событий с файлами пользователя через Server-Sent Events
+func (a *APIV1) StreamEvents(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	events, unsubscribe, err := a.service.SubscribeEvents(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка подписки на события"})
+		return
+	}
+	defer unsubscribe()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			c.SSEvent(string(event.Type), event)
+			return true
+		case <-heartbeat.C:
+			c.SSEvent("ping", gin.H{"time": time.Now()})
+			return true
+		}
+	})
+}
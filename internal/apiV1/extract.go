@@ -0,0 +1,48 @@
+package apiv1
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExtractArchive обработчик для запуска распаковки архива, уже лежащего в бакете
+func (a *APIV1) ExtractArchive(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req struct {
+		Archive string `json:"archive" binding:"required"`
+		Target  string `json:"target"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	job, err := a.service.StartExtractArchive(c.Request.Context(), userID, req.Archive, req.Target)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "распаковка архива запущена",
+		"job":     job,
+	})
+}
+
+// GetExtractJob обработчик для получения прогресса распаковки
+func (a *APIV1) GetExtractJob(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	job, err := a.service.GetExtractJob(userID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"job": job,
+	})
+}
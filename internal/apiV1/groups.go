@@ -0,0 +1,160 @@
+package apiv1
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// groupIDParam возвращает ID группы из пути
+func groupIDParam(c *gin.Context) (int, bool) {
+	groupID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID группы"})
+		return 0, false
+	}
+	return groupID, true
+}
+
+// ListGroups обработчик для получения групп пользователя
+func (a *APIV1) ListGroups(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	groups, err := a.service.ListGroups(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка получения списка групп"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, gin.H{
+			"id":         group.ID,
+			"name":       group.Name,
+			"owner":      group.OwnerID == userID,
+			"created_at": group.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"groups": result})
+}
+
+// CreateGroup обработчик для создания группы
+func (a *APIV1) CreateGroup(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	group, err := a.service.CreateGroup(c.Request.Context(), userID, req.Name)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "группа успешно создана",
+		"id":      group.ID,
+		"name":    group.Name,
+	})
+}
+
+// DeleteGroup обработчик для удаления группы
+func (a *APIV1) DeleteGroup(c *gin.Context) {
+	userID := c.GetInt("userID")
+	groupID, ok := groupIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := a.service.DeleteGroup(c.Request.Context(), userID, groupID); err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "группа успешно удалена"})
+}
+
+// ListGroupMembers обработчик для получения участников группы
+func (a *APIV1) ListGroupMembers(c *gin.Context) {
+	userID := c.GetInt("userID")
+	groupID, ok := groupIDParam(c)
+	if !ok {
+		return
+	}
+
+	members, err := a.service.ListGroupMembers(c.Request.Context(), userID, groupID)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]gin.H, 0, len(members))
+	for _, member := range members {
+		result = append(result, gin.H{
+			"user_id":  member.UserID,
+			"username": member.UserName,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"members": result})
+}
+
+// AddGroupMember обработчик для добавления пользователя в группу
+func (a *APIV1) AddGroupMember(c *gin.Context) {
+	userID := c.GetInt("userID")
+	groupID, ok := groupIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Username string `json:"username" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	member, err := a.service.AddGroupMember(c.Request.Context(), userID, groupID, req.Username)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "участник добавлен",
+		"user_id":  member.UserID,
+		"username": member.UserName,
+	})
+}
+
+// RemoveGroupMember обработчик для исключения участника из группы (или выхода из нее)
+func (a *APIV1) RemoveGroupMember(c *gin.Context) {
+	userID := c.GetInt("userID")
+	groupID, ok := groupIDParam(c)
+	if !ok {
+		return
+	}
+
+	memberID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID пользователя"})
+		return
+	}
+
+	if err := a.service.RemoveGroupMember(c.Request.Context(), userID, groupID, memberID); err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "участник исключен"})
+}
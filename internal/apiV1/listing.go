@@ -0,0 +1,80 @@
+package apiv1
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+// listOptions собирает параметры листинга из запроса: prefix, recursive, limit, cursor и sort
+func listOptions(c *gin.Context) (service.ListOptions, error) {
+	opts := service.ListOptions{
+		Prefix:    c.DefaultQuery("prefix", ""),
+		Recursive: c.DefaultQuery("recursive", "false") == "true",
+		Cursor:    c.Query("cursor"),
+		Sort:      c.Query("sort"),
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		value, err := strconv.Atoi(limit)
+		if err != nil {
+			return opts, fmt.Errorf("неверное значение limit: %s", limit)
+		}
+		opts.Limit = value
+	}
+
+	return opts, nil
+}
+
+// formatFiles форматирует файлы для ответа
+func formatFiles(objects []minio.ObjectInfo) []map[string]interface{} {
+	var files []map[string]interface{}
+	for _, obj := range objects {
+		files = append(files, map[string]interface{}{
+			"name":          obj.Key,
+			"size":          obj.Size,
+			"etag":          obj.ETag,
+			"content_type":  obj.ContentType,
+			"last_modified": obj.LastModified,
+		})
+	}
+	return files
+}
+
+// formatFolders форматирует папки для ответа
+func formatFolders(folders []string) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, folder := range folders {
+		result = append(result, map[string]interface{}{
+			"name": folder,
+		})
+	}
+	return result
+}
+
+// ListDirectory обработчик для получения содержимого папки (папки и файлы) одной страницей
+func (a *APIV1) ListDirectory(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	opts, err := listOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := a.service.ListUserDirectory(c.Request.Context(), userID, opts)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": "ошибка получения содержимого папки"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"folders":     formatFolders(page.Folders),
+		"files":       formatFiles(page.Files),
+		"next_cursor": page.NextCursor,
+	})
+}
@@ -0,0 +1,132 @@
+package apiv1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// CompleteMFALogin обработчик второго шага входа: обменивает токен первого шага и код на пару токенов.
+// Вместо кода из приложения можно указать код восстановления.
+func (a *APIV1) CompleteMFALogin(c *gin.Context) {
+	var req struct {
+		MFAToken string `json:"mfa_token" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, tokens, err := a.service.CompleteMFALogin(clientContext(c, ""), req.MFAToken, req.Code, c.ClientIP())
+	if loginThrottled(c, err) {
+		return
+	}
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidToken) || errors.Is(err, service.ErrTokenRevoked) ||
+			errors.Is(err, service.ErrAccessDenied) || errors.Is(err, service.ErrConflict) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка входа"})
+		return
+	}
+
+	c.SetCookie("refresh_token", tokens.RefreshToken, tokens.RefreshTTL, "/", "", true, true)
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "вход выполнен",
+		"user_id":        user.ID,
+		"email_verified": user.EmailVerified,
+		"access_token":   tokens.AccessToken,
+		"expires_in":     tokens.ExpiresIn,
+	})
+}
+
+// BeginTOTPEnrollment обработчик для получения нового секрета приложения-аутентификатора
+func (a *APIV1) BeginTOTPEnrollment(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	enrollment, err := a.service.BeginTOTPEnrollment(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret": enrollment.Secret,
+		"uri":    enrollment.URI,
+	})
+}
+
+// ConfirmTOTPEnrollment обработчик для включения второго фактора кодом из приложения.
+// Коды восстановления возвращаются только в этом ответе.
+func (a *APIV1) ConfirmTOTPEnrollment(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := a.service.ConfirmTOTPEnrollment(c.Request.Context(), userID, req.Code)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "двухфакторная аутентификация включена",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTOTP обработчик для отключения второго фактора по текущему паролю
+func (a *APIV1) DisableTOTP(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req struct {
+		Password string `json:"password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.service.DisableTOTP(c.Request.Context(), userID, req.Password); err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "двухфакторная аутентификация отключена"})
+}
+
+// RegenerateRecoveryCodes обработчик для замены кодов восстановления.
+// Новые коды возвращаются только в этом ответе, прежние перестают действовать.
+func (a *APIV1) RegenerateRecoveryCodes(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req struct {
+		Code string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := a.service.RegenerateRecoveryCodes(c.Request.Context(), userID, req.Code)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
@@ -0,0 +1,54 @@
+package apiv1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// MoveFile обработчик для перемещения (переименования) файла.
+// If-Match проверяет ETag перемещаемого файла, If-None-Match: * запрещает перезапись файла в месте назначения
+// (проверяется перед копированием и не защищает от файла, созданного одновременно с перемещением).
+func (a *APIV1) MoveFile(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req struct {
+		From string `json:"from" binding:"required"`
+		To   string `json:"to" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ifMatch, ifNoneMatch, ok := writePreconditions(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректные условия перемещения"})
+		return
+	}
+
+	info, err := a.service.MoveUserFile(c.Request.Context(), userID, req.From, req.To, service.MoveCondition{
+		IfMatch:     ifMatch,
+		IfNoneMatch: ifNoneMatch,
+	})
+	if errors.Is(err, service.ErrConflict) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": "ошибка перемещения файла"})
+		return
+	}
+
+	c.Header("ETag", quoteETag(info.ETag))
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "файл успешно перемещен",
+		"object_name": info.Key,
+		"old_name":    req.From,
+		"etag":        info.ETag,
+		"size":        info.Size,
+	})
+}
@@ -0,0 +1,85 @@
+package apiv1
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// oidcStateCookie cookie с ключом браузера, который начал вход через поставщика
+	oidcStateCookie = "oidc_state"
+	oidcStatePath   = "/api/v1/users/login/oidc"
+	oidcStateMaxAge = 10 * 60 // Столько же, сколько хранится state на сервере
+)
+
+// setOIDCStateCookie сохраняет ключ браузера; maxAge < 0 удаляет cookie.
+// SameSite=Lax: cookie не уходит с запросами, которые начинает чужой сайт.
+func setOIDCStateCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, oidcStatePath, "", true, true)
+}
+
+// BeginOIDCLogin обработчик для начала входа через поставщика учетных записей.
+// Веб-интерфейс переходит по auth_url, а поставщик возвращает пользователя на OIDC_REDIRECT_URL с code и state.
+func (a *APIV1) BeginOIDCLogin(c *gin.Context) {
+	authURL, browserKey, err := a.service.BeginOIDCLogin(c.Request.Context())
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	setOIDCStateCookie(c, browserKey, oidcStateMaxAge)
+
+	c.JSON(http.StatusOK, gin.H{"auth_url": authURL})
+}
+
+// FinishOIDCLogin обработчик для завершения входа по коду поставщика. Выдает ту же пару токенов, что и вход по паролю.
+func (a *APIV1) FinishOIDCLogin(c *gin.Context) {
+	var req struct {
+		Code  string `json:"code" binding:"required"`
+		State string `json:"state" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Без cookie вход не завершится: ссылка с кодом открыта не в том браузере, который начал вход
+	browserKey, _ := c.Cookie(oidcStateCookie)
+	user, tokens, err := a.service.FinishOIDCLogin(clientContext(c, ""), req.Code, req.State, browserKey)
+	setOIDCStateCookie(c, "", -1)
+	if err != nil {
+		if errors.Is(err, service.ErrAccountNotReady) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "хранилище пользователя еще создается, повторите попытку позже"})
+			return
+		}
+		if errors.Is(err, service.ErrOIDCDisabled) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrAccessDenied) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		// Автоматическое создание пользователя: имя занято или не подходит
+		if status := fileErrorStatus(err); status != http.StatusInternalServerError {
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка входа"})
+		return
+	}
+
+	c.SetCookie("refresh_token", tokens.RefreshToken, tokens.RefreshTTL, "/", "", true, true)
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "вход выполнен",
+		"user_id":        user.ID,
+		"email_verified": user.EmailVerified,
+		"access_token":   tokens.AccessToken,
+		"expires_in":     tokens.ExpiresIn,
+	})
+}
@@ -0,0 +1,143 @@
+package apiv1
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com.Vova4o/nasforhome/pkg/webauthn"
+	"github.com/gin-gonic/gin"
+)
+
+// BeginPasskeyLogin обработчик для начала входа по ключу доступа.
+// Ответ передается в navigator.credentials.get() через PublicKeyCredential.parseRequestOptionsFromJSON().
+func (a *APIV1) BeginPasskeyLogin(c *gin.Context) {
+	options, err := a.service.BeginPasskeyLogin(c.Request.Context())
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+// FinishPasskeyLogin обработчик для входа по ответу аутентификатора. Выдает ту же пару токенов, что и вход по паролю.
+func (a *APIV1) FinishPasskeyLogin(c *gin.Context) {
+	var req webauthn.AssertionCredential
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, tokens, err := a.service.FinishPasskeyLogin(clientContext(c, ""), &req)
+	if err != nil {
+		if errors.Is(err, service.ErrAccountNotReady) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "хранилище пользователя еще создается, повторите попытку позже"})
+			return
+		}
+		if errors.Is(err, service.ErrWebAuthnDisabled) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrAccessDenied) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка входа"})
+		return
+	}
+
+	c.SetCookie("refresh_token", tokens.RefreshToken, tokens.RefreshTTL, "/", "", true, true)
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "вход выполнен",
+		"user_id":        user.ID,
+		"email_verified": user.EmailVerified,
+		"access_token":   tokens.AccessToken,
+		"expires_in":     tokens.ExpiresIn,
+	})
+}
+
+// ListPasskeys обработчик для получения ключей доступа текущего пользователя
+func (a *APIV1) ListPasskeys(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	passkeys, err := a.service.ListPasskeys(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]gin.H, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		result = append(result, gin.H{
+			"id":           passkey.ID,
+			"name":         passkey.Name,
+			"transports":   passkey.Transports,
+			"created_at":   passkey.CreatedAt,
+			"last_used_at": passkey.LastUsedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"passkeys": result})
+}
+
+// BeginPasskeyRegistration обработчик для начала добавления ключа доступа.
+// Ответ передается в navigator.credentials.create() через PublicKeyCredential.parseCreationOptionsFromJSON().
+func (a *APIV1) BeginPasskeyRegistration(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	options, err := a.service.BeginPasskeyRegistration(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, options)
+}
+
+// FinishPasskeyRegistration обработчик для сохранения ключа доступа по ответу аутентификатора
+func (a *APIV1) FinishPasskeyRegistration(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req struct {
+		Name       string                          `json:"name" binding:"required"`
+		Credential webauthn.RegistrationCredential `json:"credential"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	passkey, err := a.service.FinishPasskeyRegistration(c.Request.Context(), userID, req.Name, &req.Credential)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":         passkey.ID,
+		"name":       passkey.Name,
+		"transports": passkey.Transports,
+		"created_at": passkey.CreatedAt,
+	})
+}
+
+// DeletePasskey обработчик для удаления ключа доступа текущего пользователя
+func (a *APIV1) DeletePasskey(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	passkeyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID ключа доступа"})
+		return
+	}
+
+	if err := a.service.DeletePasskey(c.Request.Context(), userID, passkeyID); err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "ключ доступа удален"})
+}
@@ -0,0 +1,53 @@
+package apiv1
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// objectKeyParam возвращает ключ объекта из параметра запроса key или из wildcard-части пути.
+// Gin уже декодирует путь, поэтому вложенные папки можно передавать и как a/b/c, и как a%2Fb%2Fc.
+func objectKeyParam(c *gin.Context) string {
+	if key := c.Query("key"); key != "" {
+		return key
+	}
+	return strings.TrimPrefix(c.Param("path"), "/")
+}
+
+// fileErrorStatus возвращает HTTP-статус для ошибки файловой операции
+func fileErrorStatus(err error) int {
+	if errors.Is(err, service.ErrInvalidPath) || errors.Is(err, service.ErrInvalidListOptions) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, service.ErrInvalidSpaceName) || errors.Is(err, service.ErrInvalidSpaceRole) ||
+		errors.Is(err, service.ErrInvalidGroupName) || errors.Is(err, service.ErrInvalidGroupMember) ||
+		errors.Is(err, service.ErrInvalidUsername) || errors.Is(err, service.ErrInvalidEmail) ||
+		errors.Is(err, service.ErrInvalidToken) || errors.Is(err, service.ErrWeakPassword) ||
+		errors.Is(err, service.ErrInvalidInviteOptions) || errors.Is(err, service.ErrInvalidPasskeyName) ||
+		errors.Is(err, service.ErrInvalidAPITokenOptions) {
+		return http.StatusBadRequest
+	}
+	if errors.Is(err, service.ErrConflict) {
+		return http.StatusConflict
+	}
+	if errors.Is(err, service.ErrUploadTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if errors.Is(err, service.ErrAccessDenied) {
+		return http.StatusForbidden
+	}
+	if errors.Is(err, service.ErrUserNotFound) || errors.Is(err, service.ErrGroupNotFound) ||
+		errors.Is(err, service.ErrInviteNotFound) || errors.Is(err, service.ErrPasskeyNotFound) ||
+		errors.Is(err, service.ErrAPITokenNotFound) || errors.Is(err, service.ErrSessionNotFound) ||
+		errors.Is(err, service.ErrArchiveSourceNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, service.ErrAccountNotReady) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
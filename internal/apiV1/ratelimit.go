@@ -0,0 +1,207 @@
+package apiv1
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ограничения запросов по умолчанию
+const (
+	defaultMaxJSONBytes = 1 << 20 // 1 МБ
+	// multipartOverhead запас на заголовки частей и поля формы сверх размера самого файла
+	multipartOverhead = 1 << 20
+)
+
+// RateLimit ограничение частоты запросов по алгоритму token bucket
+type RateLimit struct {
+	PerMinute int // Сколько запросов в минуту восполняется, 0 — без ограничений
+	Burst     int // Сколько запросов можно выполнить подряд; по умолчанию PerMinute
+}
+
+// Limits ограничения запросов к API
+type Limits struct {
+	IP           RateLimit // Для каждого адреса клиента, включая запросы без авторизации
+	User         RateLimit // Для каждого вошедшего пользователя, с какого бы адреса он ни обращался
+	MaxJSONBytes int64     // Максимальный размер тела запроса, кроме загрузки файлов; 0 — 1 МБ
+}
+
+// SetLimits задает ограничения частоты и размера запросов. Вызывается до Run.
+func (a *APIV1) SetLimits(limits Limits) {
+	a.ipLimiter = newRateLimiter(limits.IP)
+	a.userLimiter = newRateLimiter(limits.User)
+	a.maxJSONBytes = limits.MaxJSONBytes
+	if a.maxJSONBytes <= 0 {
+		a.maxJSONBytes = defaultMaxJSONBytes
+	}
+}
+
+// bucket корзина токенов одного клиента
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter корзины токенов по ключу клиента
+type rateLimiter struct {
+	rate  float64 // Токенов в секунду
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// newRateLimiter создает ограничитель; nil означает отсутствие ограничений
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	if limit.PerMinute <= 0 {
+		return nil
+	}
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = limit.PerMinute
+	}
+	return &rateLimiter{
+		rate:    float64(limit.PerMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// allow списывает токен с корзины клиента. Возвращает, разрешен ли запрос, сколько запросов осталось
+// и через сколько появится следующий токен, если корзина пуста.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Корзины, которые успели наполниться, ничем не отличаются от новых и удаляются не чаще раза в минуту
+	if now.Sub(l.lastSweep) > time.Minute {
+		full := time.Duration(l.burst / l.rate * float64(time.Second))
+		for k, b := range l.buckets {
+			if now.Sub(b.updated) > full {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, 0, wait
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// rateLimit возвращает middleware, которое ограничивает частоту запросов клиента с ключом из key.
+// Ответ содержит заголовки X-RateLimit-Limit и X-RateLimit-Remaining, а отказ — еще и Retry-After.
+func rateLimit(limiter func() *rateLimiter, key func(c *gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		l := limiter()
+		if l == nil {
+			c.Next()
+			return
+		}
+
+		allowed, remaining, wait := l.allow(key(c), time.Now())
+		c.Header("X-RateLimit-Limit", strconv.Itoa(int(l.burst)))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if !allowed {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":       "слишком много запросов, повторите позже",
+				"retry_after": retryAfter,
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// ipRateLimit ограничивает частоту запросов с одного адреса
+func (a *APIV1) ipRateLimit() gin.HandlerFunc {
+	return rateLimit(func() *rateLimiter { return a.ipLimiter }, func(c *gin.Context) string {
+		return c.ClientIP()
+	})
+}
+
+// userRateLimit ограничивает частоту запросов одного пользователя. Подключается после authMiddleware.
+func (a *APIV1) userRateLimit() gin.HandlerFunc {
+	return rateLimit(func() *rateLimiter { return a.userLimiter }, func(c *gin.Context) string {
+		return strconv.Itoa(c.GetInt("userID"))
+	})
+}
+
+// bodyLimit ограничивает размер тела запросов. Маршруты загрузки файлов, зарегистрированные через uploadRoute,
+// ограничиваются отдельно в uploadLimit, по размеру, допустимому для пользователя. Маршрут определяется
+// по шаблону пути, а не по Content-Type: JSON разбирается независимо от заголовка.
+func (a *APIV1) bodyLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || a.uploadRoutes[c.FullPath()] {
+			c.Next()
+			return
+		}
+		if !limitBody(c, a.maxJSONBytes) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// uploadRoute регистрирует маршрут загрузки файла: вместо bodyLimit на нем действует uploadLimit
+func (a *APIV1) uploadRoute(group *gin.RouterGroup, path string, handler gin.HandlerFunc) {
+	if a.uploadRoutes == nil {
+		a.uploadRoutes = make(map[string]bool)
+	}
+	a.uploadRoutes[group.BasePath()+path] = true
+	group.POST(path, a.uploadLimit(), handler)
+}
+
+// uploadLimit ограничивает размер тела запроса загрузки файла размером, допустимым для пользователя
+func (a *APIV1) uploadLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, err := a.service.UploadLimit(c.GetInt("userID"))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "ошибка загрузки файла"})
+			return
+		}
+		if limit > 0 && !limitBody(c, limit+multipartOverhead) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// limitBody отвечает 413, если заявленный размер тела больше limit, и не дает прочитать больше limit байт.
+// Возвращает false, если запрос уже отклонен.
+func limitBody(c *gin.Context, limit int64) bool {
+	if c.Request.ContentLength > limit {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("размер запроса превышает %d байт", limit),
+		})
+		return false
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	return true
+}
+
+// bodyTooLarge проверяет, что чтение тела прервано ограничением размера
+func bodyTooLarge(err error) bool {
+	var maxBytes *http.MaxBytesError
+	return errors.As(err, &maxBytes)
+}
@@ -0,0 +1,47 @@
+package apiv1
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestBodyLimitIgnoresContentType проверяет, что заголовок multipart/form-data не снимает ограничение
+// размера с JSON маршрутов, а маршруты загрузки ограничиваются только uploadLimit
+func TestBodyLimitIgnoresContentType(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := &APIV1{router: gin.New(), service: &service.Service{}, maxJSONBytes: 16}
+
+	read := func(c *gin.Context) {
+		if _, err := io.ReadAll(c.Request.Body); err != nil {
+			c.Status(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+	group := a.router.Group("/api/v1", a.bodyLimit())
+	group.POST("/users/login", read)
+	files := group.Group("/files")
+	a.uploadRoute(files, "/upload", read)
+
+	send := func(path, contentType string, size int) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(strings.Repeat("a", size)))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		a.router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send("/api/v1/users/login", "application/json", 16))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, send("/api/v1/users/login", "application/json", 17))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, send("/api/v1/users/login", "multipart/form-data; boundary=x", 1024),
+		"Подмененный Content-Type не снимает ограничение")
+	assert.Equal(t, http.StatusOK, send("/api/v1/files/upload", "multipart/form-data; boundary=x", 1024),
+		"Загрузка файлов ограничивается uploadLimit, а не размером JSON")
+}
@@ -0,0 +1,70 @@
+package apiv1
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// clientContext возвращает контекст запроса с адресом и браузером клиента для сеанса.
+// Название устройства берется из deviceName или заголовка X-Device-Name, иначе определяется по User-Agent.
+func clientContext(c *gin.Context, deviceName string) context.Context {
+	if deviceName == "" {
+		deviceName = c.GetHeader("X-Device-Name")
+	}
+	return service.WithClient(c.Request.Context(), service.ClientInfo{
+		IP:         c.ClientIP(),
+		UserAgent:  c.Request.UserAgent(),
+		DeviceName: deviceName,
+	})
+}
+
+// ListSessions обработчик для получения сеансов текущего пользователя. Текущий сеанс отмечен полем current.
+func (a *APIV1) ListSessions(c *gin.Context) {
+	userID := c.GetInt("userID")
+	currentID := c.GetInt("sessionID")
+
+	sessions, err := a.service.ListSessions(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	result := make([]gin.H, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, gin.H{
+			"id":             session.ID,
+			"device_name":    session.DeviceName,
+			"ip":             session.IP,
+			"user_agent":     session.UserAgent,
+			"created_at":     session.CreatedAt,
+			"last_active_at": session.LastActiveAt,
+			"expires_at":     session.ExpiresAt,
+			"current":        session.ID == currentID,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": result})
+}
+
+// DeleteSession обработчик для завершения сеанса текущего пользователя. Refresh и access токены
+// сеанса перестают приниматься.
+func (a *APIV1) DeleteSession(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	sessionID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID сеанса"})
+		return
+	}
+
+	if err := a.service.DeleteSession(c.Request.Context(), userID, sessionID); err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "сеанс завершен"})
+}
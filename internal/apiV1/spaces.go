@@ -0,0 +1,240 @@
+package apiv1
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// spaceContext middleware для маршрутов файлов общего пространства: файловые обработчики
+// работают без изменений, а сервис выполняет операции в бакете пространства после проверки доступа
+func (a *APIV1) spaceContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		spaceID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID пространства"})
+			c.Abort()
+			return
+		}
+
+		c.Request = c.Request.WithContext(service.WithSpace(c.Request.Context(), spaceID))
+		c.Next()
+	}
+}
+
+// spaceIDParam возвращает ID пространства из пути
+func spaceIDParam(c *gin.Context) (int, bool) {
+	spaceID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID пространства"})
+		return 0, false
+	}
+	return spaceID, true
+}
+
+// ListSpaces обработчик для получения общих пространств пользователя
+func (a *APIV1) ListSpaces(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	spaces, err := a.service.ListSharedSpaces(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ошибка получения списка пространств"})
+		return
+	}
+
+	result := make([]gin.H, 0, len(spaces))
+	for _, space := range spaces {
+		result = append(result, gin.H{
+			"id":         space.ID,
+			"name":       space.Name,
+			"role":       space.Role,
+			"created_at": space.CreatedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"spaces": result})
+}
+
+// CreateSpace обработчик для создания общего пространства
+func (a *APIV1) CreateSpace(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req struct {
+		Name string `json:"name" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	space, err := a.service.CreateSharedSpace(c.Request.Context(), userID, req.Name)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "пространство успешно создано",
+		"id":      space.ID,
+		"name":    space.Name,
+		"role":    space.Role,
+	})
+}
+
+// DeleteSpace обработчик для удаления общего пространства вместе с файлами
+func (a *APIV1) DeleteSpace(c *gin.Context) {
+	userID := c.GetInt("userID")
+	spaceID, ok := spaceIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := a.service.DeleteSharedSpace(c.Request.Context(), userID, spaceID); err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "пространство успешно удалено"})
+}
+
+// ListSpaceMembers обработчик для получения участников и групп общего пространства
+func (a *APIV1) ListSpaceMembers(c *gin.Context) {
+	userID := c.GetInt("userID")
+	spaceID, ok := spaceIDParam(c)
+	if !ok {
+		return
+	}
+
+	members, err := a.service.ListSpaceMembers(c.Request.Context(), userID, spaceID)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	grants, err := a.service.ListSpaceGroups(c.Request.Context(), userID, spaceID)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	users := make([]gin.H, 0, len(members))
+	for _, member := range members {
+		users = append(users, gin.H{
+			"user_id":  member.UserID,
+			"username": member.UserName,
+			"role":     member.Role,
+		})
+	}
+	groups := make([]gin.H, 0, len(grants))
+	for _, grant := range grants {
+		groups = append(groups, gin.H{
+			"group_id": grant.GroupID,
+			"group":    grant.GroupName,
+			"role":     grant.Role,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"members": users,
+		"groups":  groups,
+	})
+}
+
+// GrantSpaceAccess обработчик для выдачи роли viewer или editor пользователю (username) или группе (group)
+func (a *APIV1) GrantSpaceAccess(c *gin.Context) {
+	userID := c.GetInt("userID")
+	spaceID, ok := spaceIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Group    string `json:"group"`
+		Role     string `json:"role" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.Username == "") == (req.Group == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "нужно указать либо username, либо group"})
+		return
+	}
+
+	if req.Group != "" {
+		grant, err := a.service.GrantSpaceGroupAccess(c.Request.Context(), userID, spaceID, req.Group, req.Role)
+		if err != nil {
+			c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":  "доступ выдан",
+			"group_id": grant.GroupID,
+			"group":    grant.GroupName,
+			"role":     grant.Role,
+		})
+		return
+	}
+
+	member, err := a.service.GrantSpaceAccess(c.Request.Context(), userID, spaceID, req.Username, req.Role)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "доступ выдан",
+		"user_id":  member.UserID,
+		"username": member.UserName,
+		"role":     member.Role,
+	})
+}
+
+// RevokeSpaceAccess обработчик для отзыва доступа участника (или выхода из пространства)
+func (a *APIV1) RevokeSpaceAccess(c *gin.Context) {
+	userID := c.GetInt("userID")
+	spaceID, ok := spaceIDParam(c)
+	if !ok {
+		return
+	}
+
+	memberID, err := strconv.Atoi(c.Param("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID пользователя"})
+		return
+	}
+
+	if err := a.service.RevokeSpaceAccess(c.Request.Context(), userID, spaceID, memberID); err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "доступ отозван"})
+}
+
+// RevokeSpaceGroupAccess обработчик для отзыва доступа группы к общему пространству
+func (a *APIV1) RevokeSpaceGroupAccess(c *gin.Context) {
+	userID := c.GetInt("userID")
+	spaceID, ok := spaceIDParam(c)
+	if !ok {
+		return
+	}
+
+	groupID, err := strconv.Atoi(c.Param("groupID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный ID группы"})
+		return
+	}
+
+	if err := a.service.RevokeSpaceGroupAccess(c.Request.Context(), userID, spaceID, groupID); err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "доступ отозван"})
+}
@@ -0,0 +1,113 @@
+package apiv1
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// ListChanges обработчик для получения изменений файлов пользователя после номера since
+func (a *APIV1) ListChanges(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	since, err := strconv.ParseInt(c.DefaultQuery("since", "0"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный параметр since"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "0"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "некорректный параметр limit"})
+		return
+	}
+
+	set, err := a.service.ListChanges(c.Request.Context(), userID, since, limit)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	changes := make([]gin.H, 0, len(set.Changes))
+	for _, change := range set.Changes {
+		item := gin.H{
+			"seq":        change.Seq,
+			"type":       change.Type,
+			"key":        change.Key,
+			"created_at": change.CreatedAt,
+		}
+		if change.OldKey != "" {
+			item["old_key"] = change.OldKey
+		}
+		if change.ETag != "" {
+			item["etag"] = change.ETag
+			item["size"] = change.Size
+		}
+		changes = append(changes, item)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"changes":    changes,
+		"next_since": set.NextSince,
+		"has_more":   set.HasMore,
+	})
+}
+
+// uploadCondition читает условия загрузки: версию, на которой основаны изменения клиента синхронизации
+// (поля формы base_etag и base_seq), заголовки If-Match / If-None-Match и поле conflict_copy
+func uploadCondition(c *gin.Context) (service.UploadCondition, bool) {
+	cond := service.UploadCondition{
+		IfMatch:      c.PostForm("base_etag"),
+		ConflictCopy: c.DefaultPostForm("conflict_copy", "false") == "true",
+	}
+	if raw := c.PostForm("base_seq"); raw != "" {
+		seq, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || seq < 0 {
+			return cond, false
+		}
+		cond.BaseSeq = seq
+	}
+
+	ifMatch, ifNoneMatch, ok := writePreconditions(c)
+	if !ok {
+		return cond, false
+	}
+	if ifMatch != "" {
+		cond.IfMatch = ifMatch
+	}
+	cond.IfNoneMatch = ifNoneMatch
+
+	return cond, true
+}
+
+// writePreconditions читает заголовки If-Match и If-None-Match запроса на изменение файла.
+// Поддерживается один ETag в If-Match и только "*" в If-None-Match.
+func writePreconditions(c *gin.Context) (ifMatch string, ifNoneMatch bool, ok bool) {
+	if header := strings.TrimSpace(c.GetHeader("If-Match")); header != "" {
+		// Для изменения файла допустимо только сильное сравнение ETag
+		if strings.Contains(header, ",") || strings.HasPrefix(header, "W/") {
+			return "", false, false
+		}
+		ifMatch = strings.Trim(header, `"`)
+	}
+
+	if header := strings.TrimSpace(c.GetHeader("If-None-Match")); header != "" {
+		if header != "*" {
+			return "", false, false
+		}
+		ifNoneMatch = true
+	}
+
+	return ifMatch, ifNoneMatch, true
+}
+
+// conflictStatus возвращает статус ответа при конфликте: 412 для условий из заголовков HTTP,
+// 409 для конфликта версий клиента синхронизации
+func conflictStatus(c *gin.Context) int {
+	if c.GetHeader("If-Match") != "" || c.GetHeader("If-None-Match") != "" {
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusConflict
+}
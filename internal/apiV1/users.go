@@ -0,0 +1,181 @@
+package apiv1
+
+import (
+	"net/http"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// UpdateCurrentUser обработчик для изменения имени и адреса почты текущего пользователя.
+// Новый адрес вступает в силу после подтверждения кодом, отправленным на него.
+func (a *APIV1) UpdateCurrentUser(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req struct {
+		Username *string `json:"username"`
+		Email    *string `json:"email"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Username == nil && req.Email == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "не указаны изменения"})
+		return
+	}
+
+	user, pendingEmail, err := a.service.UpdateProfile(c.Request.Context(), userID, service.ProfileUpdate{
+		Username: req.Username,
+		Email:    req.Email,
+	})
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{
+		"id":       user.ID,
+		"username": user.UserName,
+		"email":    user.Email,
+	}
+	if pendingEmail != "" {
+		response["pending_email"] = pendingEmail
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// ConfirmEmail обработчик для подтверждения нового адреса почты кодом из письма
+func (a *APIV1) ConfirmEmail(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := a.service.ConfirmEmailChange(c.Request.Context(), req.Token)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":       user.ID,
+		"username": user.UserName,
+		"email":    user.Email,
+	})
+}
+
+// VerifyEmail обработчик для подтверждения адреса почты, указанного при регистрации.
+// После подтверждения создается хранилище пользователя.
+func (a *APIV1) VerifyEmail(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := a.service.VerifyEmail(c.Request.Context(), req.Token)
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":             user.ID,
+		"username":       user.UserName,
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+	})
+}
+
+// ResendEmailVerification обработчик для повторной отправки кода подтверждения адреса
+func (a *APIV1) ResendEmailVerification(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	if err := a.service.ResendEmailVerification(c.Request.Context(), userID); err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "код подтверждения отправлен"})
+}
+
+// ChangePassword обработчик для смены пароля текущего пользователя.
+// Все сессии отзываются, а текущий клиент получает новую пару токенов.
+func (a *APIV1) ChangePassword(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req struct {
+		OldPassword string `json:"old_password" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, err := a.service.ChangePassword(clientContext(c, ""), userID, req.OldPassword, req.NewPassword)
+	if loginThrottled(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.SetCookie("refresh_token", tokens.RefreshToken, tokens.RefreshTTL, "/", "", true, true)
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "пароль изменен",
+		"access_token": tokens.AccessToken,
+		"expires_in":   tokens.ExpiresIn,
+	})
+}
+
+// ForgotPassword обработчик для запроса кода сброса пароля.
+// Ответ не зависит от того, зарегистрирован ли адрес.
+func (a *APIV1) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.service.RequestPasswordReset(c.Request.Context(), req.Email); err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": "ошибка отправки кода сброса пароля"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "если адрес зарегистрирован, на него отправлен код сброса пароля"})
+}
+
+// ResetPassword обработчик для установки нового пароля по коду из письма
+func (a *APIV1) ResetPassword(c *gin.Context) {
+	var req struct {
+		Token       string `json:"token" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := a.service.ResetPassword(c.Request.Context(), req.Token, req.NewPassword); err != nil {
+		c.JSON(fileErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "пароль изменен, войдите с новым паролем"})
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+)
+
+const (
+	// APITokenPrefix начало персонального токена API, по которому его можно отличить от JWT
+	APITokenPrefix = "nas_"
+
+	apiTokenLength         = 32 // Случайных байт в токене
+	maxAPITokenNameLength  = 100
+	maxAPITokenTTL         = 5 * 365 * 24 * time.Hour
+	maxAPITokensPerAccount = 50
+)
+
+// Ошибки персональных токенов API
+var (
+	ErrAPITokenNotFound        = errors.New("токен API не найден")
+	ErrInvalidAPITokenOptions  = errors.New("неверные параметры токена API")
+	ErrAPITokenScope           = fmt.Errorf("%w: у токена API нет прав на это действие", ErrAccessDenied)
+	ErrAPITokenSessionRequired = fmt.Errorf("%w: действие доступно только после входа по паролю", ErrAccessDenied)
+)
+
+// APITokenOptions параметры нового токена API
+type APITokenOptions struct {
+	Name   string
+	Scopes []string      // read, write, admin
+	TTL    time.Duration // Срок действия, 0 — бессрочный
+}
+
+// CreateAPIToken создает персональный токен API. Токен возвращается один раз: в БД хранится только его хеш.
+// Право admin доступно только администраторам.
+func (s *Service) CreateAPIToken(ctx context.Context, userID int, opts APITokenOptions) (*models.APIToken, string, error) {
+	name := strings.TrimSpace(opts.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxAPITokenNameLength {
+		return nil, "", fmt.Errorf("%w: название должно быть от 1 до %d символов", ErrInvalidAPITokenOptions, maxAPITokenNameLength)
+	}
+	if opts.TTL < 0 || opts.TTL > maxAPITokenTTL {
+		return nil, "", fmt.Errorf("%w: срок действия должен быть не больше %d дней", ErrInvalidAPITokenOptions, int(maxAPITokenTTL.Hours()/24))
+	}
+	scopes, err := normalizeAPITokenScopes(opts.Scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	if slices.Contains(scopes, models.APITokenScopeAdmin) {
+		if err := s.requireAdmin(userID); err != nil {
+			return nil, "", err
+		}
+	}
+
+	existing, err := s.Storagedb.ListAPITokens(userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(existing) >= maxAPITokensPerAccount {
+		return nil, "", fmt.Errorf("%w: не больше %d токенов на пользователя", ErrInvalidAPITokenOptions, maxAPITokensPerAccount)
+	}
+
+	secret, err := s.generateSecretKey(apiTokenLength)
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка генерации токена API: %w", err)
+	}
+	raw := APITokenPrefix + secret
+
+	token := &models.APIToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashToken(raw),
+		Scopes:    scopes,
+	}
+	if opts.TTL > 0 {
+		expiresAt := time.Now().Add(opts.TTL)
+		token.ExpiresAt = &expiresAt
+	}
+	if err := s.Storagedb.CreateAPIToken(token); err != nil {
+		return nil, "", err
+	}
+	return token, raw, nil
+}
+
+// ListAPITokens возвращает токены API пользователя
+func (s *Service) ListAPITokens(ctx context.Context, userID int) ([]models.APIToken, error) {
+	return s.Storagedb.ListAPITokens(userID)
+}
+
+// DeleteAPIToken отзывает токен API пользователя
+func (s *Service) DeleteAPIToken(ctx context.Context, userID, id int) error {
+	ok, err := s.Storagedb.DeleteAPIToken(userID, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+// AuthenticateAPIToken проверяет персональный токен API и отмечает его использование
+func (s *Service) AuthenticateAPIToken(raw string) (*models.APIToken, error) {
+	if !strings.HasPrefix(raw, APITokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+	token, err := s.Storagedb.UseAPIToken(hashToken(raw))
+	if err != nil {
+		return nil, err
+	}
+	if token == nil {
+		return nil, ErrInvalidToken
+	}
+	return token, nil
+}
+
+// APITokenAllows проверяет, есть ли у токена право scope. Право admin включает write, а write — read.
+func APITokenAllows(token *models.APIToken, scope string) bool {
+	want := apiTokenScopeRank(scope)
+	if want == 0 {
+		return false
+	}
+	for _, granted := range token.Scopes {
+		if apiTokenScopeRank(granted) >= want {
+			return true
+		}
+	}
+	return false
+}
+
+// apiTokenScopeRank возвращает уровень права; 0 — неизвестное право
+func apiTokenScopeRank(scope string) int {
+	switch scope {
+	case models.APITokenScopeRead:
+		return 1
+	case models.APITokenScopeWrite:
+		return 2
+	case models.APITokenScopeAdmin:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// normalizeAPITokenScopes проверяет права токена и убирает повторы
+func normalizeAPITokenScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w: нужно указать хотя бы одно право", ErrInvalidAPITokenOptions)
+	}
+
+	var result []string
+	for _, scope := range scopes {
+		switch scope {
+		case models.APITokenScopeRead, models.APITokenScopeWrite, models.APITokenScopeAdmin:
+		default:
+			return nil, fmt.Errorf("%w: неизвестное право %q", ErrInvalidAPITokenOptions, scope)
+		}
+		if !slices.Contains(result, scope) {
+			result = append(result, scope)
+		}
+	}
+	return result, nil
+}
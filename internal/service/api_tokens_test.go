@@ -0,0 +1,105 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// TestAPITokens проверяет создание токена API, хранение только хеша и проверку токена
+func TestAPITokens(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	srv := &service.Service{Storagedb: mockStorage}
+	ctx := context.Background()
+
+	var stored *models.APIToken
+	mockStorage.On("ListAPITokens", 1).Return(nil, nil).Once()
+	mockStorage.On("CreateAPIToken", mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(0).(*models.APIToken)
+		stored.ID = 3
+	}).Return(nil).Once()
+
+	token, raw, err := srv.CreateAPIToken(ctx, 1, service.APITokenOptions{
+		Name:   " backup ",
+		Scopes: []string{models.APITokenScopeWrite, models.APITokenScopeWrite},
+		TTL:    30 * 24 * time.Hour,
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(raw, service.APITokenPrefix))
+	assert.Equal(t, "backup", token.Name)
+	assert.Equal(t, []string{models.APITokenScopeWrite}, token.Scopes)
+	require.NotNil(t, token.ExpiresAt)
+	assert.NotContains(t, stored.TokenHash, raw, "В БД хранится только хеш токена")
+
+	// Токен находится по хешу, право write включает read, но не admin
+	mockStorage.On("UseAPIToken", stored.TokenHash).Return(stored, nil).Once()
+	found, err := srv.AuthenticateAPIToken(raw)
+	require.NoError(t, err)
+	assert.Equal(t, 1, found.UserID)
+	assert.True(t, service.APITokenAllows(found, models.APITokenScopeRead))
+	assert.True(t, service.APITokenAllows(found, models.APITokenScopeWrite))
+	assert.False(t, service.APITokenAllows(found, models.APITokenScopeAdmin))
+
+	// Право admin включает write и read
+	admin := &models.APIToken{Scopes: []string{models.APITokenScopeAdmin}}
+	assert.True(t, service.APITokenAllows(admin, models.APITokenScopeRead))
+	assert.True(t, service.APITokenAllows(admin, models.APITokenScopeWrite))
+	assert.True(t, service.APITokenAllows(admin, models.APITokenScopeAdmin))
+	assert.False(t, service.APITokenAllows(admin, "delete"), "Неизвестное право не выдается")
+
+	// Отозванный или истекший токен не принимается
+	mockStorage.On("UseAPIToken", mock.Anything).Return(nil, nil).Once()
+	_, err = srv.AuthenticateAPIToken(service.APITokenPrefix + "unknown")
+	assert.ErrorIs(t, err, service.ErrInvalidToken)
+
+	// JWT не проверяется как токен API
+	_, err = srv.AuthenticateAPIToken("eyJhbGciOiJIUzI1NiJ9.e30.sig")
+	assert.ErrorIs(t, err, service.ErrInvalidToken)
+
+	mockStorage.AssertExpectations(t)
+}
+
+// TestCreateAPITokenValidation проверяет параметры токена и право admin только для администраторов
+func TestCreateAPITokenValidation(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	srv := &service.Service{Storagedb: mockStorage}
+	ctx := context.Background()
+
+	_, _, err := srv.CreateAPIToken(ctx, 1, service.APITokenOptions{Name: "backup"})
+	assert.ErrorIs(t, err, service.ErrInvalidAPITokenOptions, "Нужно хотя бы одно право")
+
+	_, _, err = srv.CreateAPIToken(ctx, 1, service.APITokenOptions{Name: "backup", Scopes: []string{"delete"}})
+	assert.ErrorIs(t, err, service.ErrInvalidAPITokenOptions)
+
+	_, _, err = srv.CreateAPIToken(ctx, 1, service.APITokenOptions{Name: "", Scopes: []string{"read"}})
+	assert.ErrorIs(t, err, service.ErrInvalidAPITokenOptions)
+
+	_, _, err = srv.CreateAPIToken(ctx, 1, service.APITokenOptions{Name: "backup", Scopes: []string{"read"}, TTL: -time.Hour})
+	assert.ErrorIs(t, err, service.ErrInvalidAPITokenOptions)
+
+	mockStorage.On("GetUserByID", 1).Return(&models.User{ID: 1}, nil).Once()
+	_, _, err = srv.CreateAPIToken(ctx, 1, service.APITokenOptions{Name: "admin", Scopes: []string{"admin"}})
+	assert.ErrorIs(t, err, service.ErrAccessDenied)
+
+	mockStorage.AssertExpectations(t)
+}
+
+// TestDeleteAPIToken проверяет отзыв токена и ошибку для чужого или несуществующего токена
+func TestDeleteAPIToken(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	srv := &service.Service{Storagedb: mockStorage}
+
+	mockStorage.On("DeleteAPIToken", 1, 3).Return(true, nil).Once()
+	mockStorage.On("DeleteAPIToken", 2, 3).Return(false, nil).Once()
+
+	assert.NoError(t, srv.DeleteAPIToken(context.Background(), 1, 3))
+	assert.ErrorIs(t, srv.DeleteAPIToken(context.Background(), 2, 3), service.ErrAPITokenNotFound)
+	mockStorage.AssertExpectations(t)
+}
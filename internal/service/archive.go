@@ -0,0 +1,306 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ErrArchiveSourceNotFound возвращается, если для архива не нашлось ни одного объекта
+var ErrArchiveSourceNotFound = errors.New("файлы для архива не найдены")
+
+// ArchiveFormat формат архива для скачивания
+type ArchiveFormat string
+
+// Поддерживаемые форматы архивов
+const (
+	ArchiveZip   ArchiveFormat = "zip"
+	ArchiveTarGz ArchiveFormat = "tar.gz"
+)
+
+// ParseArchiveFormat проверяет формат архива, пустая строка означает zip
+func ParseArchiveFormat(format string) (ArchiveFormat, error) {
+	switch ArchiveFormat(format) {
+	case "", ArchiveZip:
+		return ArchiveZip, nil
+	case ArchiveTarGz, "tgz":
+		return ArchiveTarGz, nil
+	default:
+		return "", fmt.Errorf("неподдерживаемый формат архива: %s", format)
+	}
+}
+
+// Extension возвращает расширение файла архива
+func (f ArchiveFormat) Extension() string {
+	return "." + string(f)
+}
+
+// ContentType возвращает MIME-тип архива
+func (f ArchiveFormat) ContentType() string {
+	if f == ArchiveTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// archiveEntry описывает один объект, попадающий в архив
+type archiveEntry struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+	IsDir    bool
+}
+
+// archiveWriter общий интерфейс для zip и tar.gz
+type archiveWriter interface {
+	// Add добавляет запись; для папок open не вызывается
+	Add(entry archiveEntry, open func() (io.ReadCloser, error)) error
+	Close() error
+}
+
+// ArchiveUserFolder пишет в w архив со всеми объектами под prefix.
+// Пути внутри архива считаются от родительской папки prefix, чтобы сама папка попала в архив.
+func (s *Service) ArchiveUserFolder(ctx context.Context, userID int, w io.Writer, format ArchiveFormat, prefix string) error {
+	if err := ValidatePrefix(prefix); err != nil {
+		return err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return s.archiveUserObjects(ctx, userID, w, format, archiveBase(prefix), []string{prefix})
+}
+
+// ArchiveUserSelection пишет в w архив с выбранными объектами.
+// Ключи, заканчивающиеся на "/", раскрываются рекурсивно как папки.
+func (s *Service) ArchiveUserSelection(ctx context.Context, userID int, w io.Writer, format ArchiveFormat, keys []string) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("не выбрано ни одного файла")
+	}
+	for _, key := range keys {
+		if err := ValidateObjectKey(key); err != nil {
+			return err
+		}
+	}
+	return s.archiveUserObjects(ctx, userID, w, format, commonDir(keys), keys)
+}
+
+// archiveUserObjects читает объекты из бакета пользователя по одному и сразу пишет их в архив,
+// не сохраняя ни сам архив, ни объекты во временные файлы.
+// Пока в w ничего не записано, ошибки листинга и отсутствие объектов еще можно вернуть клиенту статусом.
+func (s *Service) archiveUserObjects(ctx context.Context, userID int, w io.Writer, format ArchiveFormat, base string, keys []string) error {
+	_, err := s.ExecuteFileOperation(ctx, userID, func(ctx context.Context, minioClient MinioClientInterface, bucketName string) (any, error) {
+		aw, err := newArchiveWriter(w, format)
+		if err != nil {
+			return nil, err
+		}
+
+		open := func(key string) func() (io.ReadCloser, error) {
+			return func() (io.ReadCloser, error) {
+				return minioClient.GetObject(ctx, bucketName, key, minio.GetObjectOptions{})
+			}
+		}
+
+		added := 0
+		for _, key := range keys {
+			if key != "" && !strings.HasSuffix(key, "/") {
+				object, err := minioClient.GetObject(ctx, bucketName, key, minio.GetObjectOptions{})
+				if err != nil {
+					return nil, fmt.Errorf("ошибка получения файла %s: %w", key, err)
+				}
+				stat, err := object.Stat()
+				if err != nil {
+					object.Close()
+					if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+						return nil, fmt.Errorf("%w: %s", ErrArchiveSourceNotFound, key)
+					}
+					return nil, fmt.Errorf("ошибка получения информации о файле %s: %w", key, err)
+				}
+				entry := archiveEntry{Name: strings.TrimPrefix(key, base), Size: stat.Size, Modified: stat.LastModified}
+				// copyEntry закрывает объект сам, но если запись заголовка не удалась, до него дело не доходит
+				opened := false
+				err = aw.Add(entry, func() (io.ReadCloser, error) {
+					opened = true
+					return object, nil
+				})
+				if !opened {
+					object.Close()
+				}
+				if err != nil {
+					return nil, err
+				}
+				added++
+				continue
+			}
+
+			objectCh := minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+				Prefix:    key,
+				Recursive: true,
+			})
+			for obj := range objectCh {
+				if obj.Err != nil {
+					return nil, obj.Err
+				}
+
+				name := strings.TrimPrefix(obj.Key, base)
+				if name == "" {
+					continue
+				}
+
+				entry := archiveEntry{
+					Name:     name,
+					Size:     obj.Size,
+					Modified: obj.LastModified,
+					IsDir:    strings.HasSuffix(obj.Key, "/"),
+				}
+				if err := aw.Add(entry, open(obj.Key)); err != nil {
+					return nil, err
+				}
+				added++
+			}
+		}
+
+		// Пустой архив не отдаем: скорее всего, папки не существует
+		if added == 0 {
+			return nil, ErrArchiveSourceNotFound
+		}
+
+		return nil, aw.Close()
+	})
+
+	return err
+}
+
+// newArchiveWriter создает писатель архива нужного формата поверх w
+func newArchiveWriter(w io.Writer, format ArchiveFormat) (archiveWriter, error) {
+	switch format {
+	case ArchiveZip:
+		return &zipArchiveWriter{zw: zip.NewWriter(w)}, nil
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		return &tarArchiveWriter{gz: gz, tw: tar.NewWriter(gz)}, nil
+	default:
+		return nil, fmt.Errorf("неподдерживаемый формат архива: %s", format)
+	}
+}
+
+// zipArchiveWriter пишет zip в потоковом режиме; ZIP64 включается автоматически для файлов больше 4 ГБ
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+// Add добавляет запись в zip
+func (a *zipArchiveWriter) Add(entry archiveEntry, open func() (io.ReadCloser, error)) error {
+	header := &zip.FileHeader{
+		Name:     entry.Name,
+		Method:   zip.Deflate,
+		Modified: entry.Modified,
+	}
+	if entry.IsDir {
+		header.Method = zip.Store
+	}
+
+	dst, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("ошибка записи заголовка %s: %w", entry.Name, err)
+	}
+	if entry.IsDir {
+		return nil
+	}
+
+	return copyEntry(dst, entry, open)
+}
+
+// Close завершает zip и записывает центральный каталог
+func (a *zipArchiveWriter) Close() error {
+	return a.zw.Close()
+}
+
+// tarArchiveWriter пишет tar, сжатый gzip
+type tarArchiveWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+// Add добавляет запись в tar
+func (a *tarArchiveWriter) Add(entry archiveEntry, open func() (io.ReadCloser, error)) error {
+	header := &tar.Header{
+		Name:    entry.Name,
+		Mode:    0o644,
+		Size:    entry.Size,
+		ModTime: entry.Modified,
+	}
+	if entry.IsDir {
+		header.Typeflag = tar.TypeDir
+		header.Mode = 0o755
+		header.Size = 0
+	}
+
+	if err := a.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("ошибка записи заголовка %s: %w", entry.Name, err)
+	}
+	if entry.IsDir {
+		return nil
+	}
+
+	return copyEntry(a.tw, entry, open)
+}
+
+// Close завершает tar и gzip потоки
+func (a *tarArchiveWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// copyEntry копирует содержимое объекта в архив
+func copyEntry(dst io.Writer, entry archiveEntry, open func() (io.ReadCloser, error)) error {
+	src, err := open()
+	if err != nil {
+		return fmt.Errorf("ошибка получения файла %s: %w", entry.Name, err)
+	}
+	defer src.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return fmt.Errorf("ошибка записи файла %s в архив: %w", entry.Name, err)
+	}
+	return nil
+}
+
+// archiveBase возвращает родительскую папку prefix (с "/" на конце или пустую строку)
+func archiveBase(prefix string) string {
+	dir := path.Dir(strings.TrimSuffix(prefix, "/"))
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir + "/"
+}
+
+// commonDir возвращает общую родительскую папку для всех ключей
+func commonDir(keys []string) string {
+	base := archiveBase(keys[0])
+	for _, key := range keys[1:] {
+		for !strings.HasPrefix(key, base) {
+			base = archiveBase(base)
+		}
+	}
+	return base
+}
+
+// ArchiveName возвращает имя файла архива для папки или выбора
+func ArchiveName(prefix string, format ArchiveFormat) string {
+	name := path.Base(strings.TrimSuffix(prefix, "/"))
+	if name == "." || name == "/" || name == "" {
+		name = "files"
+	}
+	return name + format.Extension()
+}
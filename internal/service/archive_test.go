@@ -0,0 +1,106 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// archiveTestEntries тестовые записи: папка и два файла
+var archiveTestEntries = []struct {
+	entry   archiveEntry
+	content string
+}{
+	{archiveEntry{Name: "vacation/", IsDir: true}, ""},
+	{archiveEntry{Name: "vacation/a.txt", Size: 5}, "hello"},
+	{archiveEntry{Name: "vacation/sea/b.txt", Size: 5}, "world"},
+}
+
+// writeTestArchive пишет тестовые записи в архив нужного формата
+func writeTestArchive(t *testing.T, format ArchiveFormat) *bytes.Buffer {
+	var buf bytes.Buffer
+	aw, err := newArchiveWriter(&buf, format)
+	require.NoError(t, err)
+
+	for _, e := range archiveTestEntries {
+		content := e.content
+		e.entry.Modified = time.Now()
+		err := aw.Add(e.entry, func() (io.ReadCloser, error) {
+			return io.NopCloser(strings.NewReader(content)), nil
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, aw.Close())
+
+	return &buf
+}
+
+// TestZipArchiveWriter проверяет потоковую запись zip
+func TestZipArchiveWriter(t *testing.T) {
+	buf := writeTestArchive(t, ArchiveZip)
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err, "Архив должен читаться")
+	require.Len(t, zr.File, len(archiveTestEntries))
+
+	for i, f := range zr.File {
+		assert.Equal(t, archiveTestEntries[i].entry.Name, f.Name)
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		assert.Equal(t, archiveTestEntries[i].content, string(data))
+	}
+}
+
+// TestTarGzArchiveWriter проверяет потоковую запись tar.gz
+func TestTarGzArchiveWriter(t *testing.T) {
+	buf := writeTestArchive(t, ArchiveTarGz)
+
+	gz, err := gzip.NewReader(buf)
+	require.NoError(t, err, "Архив должен быть в формате gzip")
+	tr := tar.NewReader(gz)
+
+	for _, e := range archiveTestEntries {
+		header, err := tr.Next()
+		require.NoError(t, err)
+		assert.Equal(t, e.entry.Name, header.Name)
+		assert.Equal(t, e.entry.IsDir, header.Typeflag == tar.TypeDir)
+
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		assert.Equal(t, e.content, string(data))
+	}
+
+	_, err = tr.Next()
+	assert.Equal(t, io.EOF, err, "Лишних записей быть не должно")
+}
+
+// TestArchivePaths проверяет вычисление путей внутри архива
+func TestArchivePaths(t *testing.T) {
+	assert.Equal(t, "", archiveBase("vacation/"))
+	assert.Equal(t, "photos/", archiveBase("photos/vacation/"))
+	assert.Equal(t, "photos/", archiveBase("photos/a.jpg"))
+
+	assert.Equal(t, "photos/", commonDir([]string{"photos/a.jpg", "photos/b.jpg"}))
+	assert.Equal(t, "photos/", commonDir([]string{"photos/a.jpg", "photos/vacation/"}))
+	assert.Equal(t, "", commonDir([]string{"photos/a.jpg", "docs/b.pdf"}))
+
+	assert.Equal(t, "vacation.zip", ArchiveName("photos/vacation/", ArchiveZip))
+	assert.Equal(t, "files.tar.gz", ArchiveName("", ArchiveTarGz))
+
+	_, err := ParseArchiveFormat("rar")
+	assert.Error(t, err, "Неизвестный формат должен вызывать ошибку")
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com.Vova4o/nasforhome/pkg/storagedb"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Authenticator внешний каталог учетных записей (например, LDAP), который проверяет пароль при входе
+type Authenticator interface {
+	// Authenticate возвращает пользователя каталога или nil, если имя или пароль не подходят
+	Authenticate(ctx context.Context, username, password string) (*models.DirectoryUser, error)
+}
+
+// ErrDirectoryEmailMissing у пользователя каталога нет адреса почты, без которого нельзя создать пользователя NAS
+var ErrDirectoryEmailMissing = fmt.Errorf("%w: в каталоге не указан адрес почты пользователя", ErrAccessDenied)
+
+// ErrDirectoryUserConflict имя пользователя каталога уже занято пользователем NAS, не связанным с каталогом
+var ErrDirectoryUserConflict = fmt.Errorf("%w: имя пользователя каталога занято другой учетной записью", ErrAccessDenied)
+
+// ErrDirectoryManagedPassword пароль пользователей каталога меняется в самом каталоге
+var ErrDirectoryManagedPassword = fmt.Errorf("%w: пароль задается во внешнем каталоге", ErrAccessDenied)
+
+// directoryIssuer издатель, под которым записи каталога связываются с пользователями в user_identities
+const directoryIssuer = "ldap"
+
+// ErrInvalidCredentials неизвестное имя пользователя или неверный пароль; такие попытки учитываются при задержке входа
+var ErrInvalidCredentials = fmt.Errorf("%w: неверное имя пользователя или пароль", ErrAccessDenied)
+
+// dummyPasswordHash хеш, с которым сверяется пароль неизвестного пользователя, чтобы по времени ответа
+// нельзя было понять, существует ли имя
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("nas-dummy-password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(fmt.Sprintf("ошибка хеширования пароля: %v", err))
+	}
+	return hash
+})
+
+// authenticate проверяет имя и пароль. Без внешнего каталога пароль сверяется с хешем в БД,
+// а с каталогом вход локальным паролем невозможен: каталог решает, кто может войти.
+func (s *Service) authenticate(ctx context.Context, username, password string) (*models.User, error) {
+	if s.Authenticator == nil {
+		user, err := s.Storagedb.GetUserByUsername(username)
+		if errors.Is(err, storagedb.ErrUserNotFound) {
+			s.VerifyPassword(password, string(dummyPasswordHash()))
+			return nil, ErrInvalidCredentials
+		}
+		if err != nil {
+			return nil, fmt.Errorf("ошибка аутентификации: %w", err)
+		}
+		if !s.VerifyPassword(password, user.PasswordHash) {
+			return nil, ErrInvalidCredentials
+		}
+		return user, nil
+	}
+
+	entry, err := s.Authenticator.Authenticate(ctx, username, password)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка аутентификации: %w", err)
+	}
+	if entry == nil {
+		return nil, ErrInvalidCredentials
+	}
+	return s.directoryUser(ctx, entry)
+}
+
+// directoryUser возвращает пользователя NAS для пользователя каталога. Пользователь находится по неизменному
+// идентификатору записи каталога, как при входе через OpenID Connect, а не по имени, которое можно сменить.
+// При первом входе пользователь создается вместе с хранилищем, а роль администратора при каждом входе
+// приводится к группам каталога.
+func (s *Service) directoryUser(ctx context.Context, entry *models.DirectoryUser) (*models.User, error) {
+	if entry.ID == "" {
+		return nil, fmt.Errorf("%w: у записи каталога нет идентификатора", ErrAccessDenied)
+	}
+	username := strings.ToLower(entry.Username)
+	if err := ValidateUsername(username); err != nil {
+		return nil, err
+	}
+
+	userID, err := s.Storagedb.GetUserIDByIdentity(directoryIssuer, entry.ID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка аутентификации: %w", err)
+	}
+
+	var user *models.User
+	if userID != 0 {
+		if user, err = s.Storagedb.GetUserByID(userID); err != nil {
+			return nil, fmt.Errorf("ошибка аутентификации: %w", err)
+		}
+	} else {
+		if user, err = s.provisionDirectoryUser(ctx, username, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	if entry.Role != "" {
+		isAdmin := entry.Role == models.UserRoleAdmin
+		if user.IsAdmin != isAdmin {
+			if err := s.Storagedb.SetUserAdmin(user.ID, isAdmin); err != nil {
+				return nil, err
+			}
+			user.IsAdmin = isAdmin
+		}
+	}
+	return user, nil
+}
+
+// provisionDirectoryUser создает пользователя NAS для записи каталога и связывает их.
+// Несвязанного пользователя с тем же именем не присваивает: это имя мог выбрать себе кто угодно.
+func (s *Service) provisionDirectoryUser(ctx context.Context, username string, entry *models.DirectoryUser) (*models.User, error) {
+	_, err := s.Storagedb.GetUserByUsername(username)
+	if err == nil {
+		return nil, ErrDirectoryUserConflict
+	}
+	if !errors.Is(err, storagedb.ErrUserNotFound) {
+		return nil, fmt.Errorf("ошибка аутентификации: %w", err)
+	}
+
+	if entry.Email == "" {
+		return nil, ErrDirectoryEmailMissing
+	}
+	email, err := normalizeEmail(entry.Email)
+	if err != nil {
+		return nil, err
+	}
+	// Адрес подтверждать не нужно: его указал администратор каталога
+	user, err := s.provisionVerifiedUser(ctx, username, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Storagedb.LinkUserIdentity(directoryIssuer, entry.ID, user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// checkPassword проверяет пароль уже вошедшего пользователя тем же способом, что и вход:
+// у пользователей каталога локального пароля нет, поэтому его проверяет каталог.
+func (s *Service) checkPassword(ctx context.Context, user *models.User, password string) (bool, error) {
+	if s.Authenticator == nil {
+		return s.VerifyPassword(password, user.PasswordHash), nil
+	}
+
+	entry, err := s.Authenticator.Authenticate(ctx, user.UserName, password)
+	if err != nil {
+		return false, fmt.Errorf("ошибка проверки пароля: %w", err)
+	}
+	if entry == nil || entry.ID == "" {
+		return false, nil
+	}
+
+	// Пароль должен принадлежать той же записи каталога, с которой связан пользователь
+	userID, err := s.Storagedb.GetUserIDByIdentity(directoryIssuer, entry.ID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка проверки пароля: %w", err)
+	}
+	return userID == user.ID, nil
+}
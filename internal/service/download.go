@@ -0,0 +1,73 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteRange диапазон байт файла, границы включительно
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// Length возвращает длину диапазона в байтах
+func (r ByteRange) Length() int64 {
+	return r.End - r.Start + 1
+}
+
+// ContentRange возвращает значение заголовка Content-Range для файла размером size
+func (r ByteRange) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.End, size)
+}
+
+// ErrRangeNotSatisfiable возвращается, если диапазон лежит за пределами файла
+var ErrRangeNotSatisfiable = errors.New("запрошенный диапазон недоступен")
+
+// ParseByteRange разбирает заголовок Range для файла размером size.
+// Возвращает nil, если заголовок пустой, задан в неизвестных единицах или содержит
+// несколько диапазонов — в этих случаях файл отдается целиком.
+func ParseByteRange(header string, size int64) (*ByteRange, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+
+	startStr, endStr, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, fmt.Errorf("неверный формат Range: %s", header)
+	}
+
+	// Суффиксный диапазон: последние N байт
+	if startStr == "" {
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("неверный формат Range: %s", header)
+		}
+		if n == 0 || size == 0 {
+			return nil, ErrRangeNotSatisfiable
+		}
+		return &ByteRange{Start: max(size-n, 0), End: size - 1}, nil
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 {
+		return nil, fmt.Errorf("неверный формат Range: %s", header)
+	}
+	if start >= size {
+		return nil, ErrRangeNotSatisfiable
+	}
+
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return nil, fmt.Errorf("неверный формат Range: %s", header)
+		}
+		end = min(end, size-1)
+	}
+
+	return &ByteRange{Start: start, End: end}, nil
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseByteRange проверяет разбор заголовка Range
+func TestParseByteRange(t *testing.T) {
+	const size = 1000
+
+	tests := []struct {
+		name    string
+		header  string
+		want    *ByteRange
+		wantErr error
+	}{
+		{"Пустой заголовок", "", nil, nil},
+		{"Полный диапазон", "bytes=0-499", &ByteRange{0, 499}, nil},
+		{"Открытый конец", "bytes=500-", &ByteRange{500, 999}, nil},
+		{"Суффикс", "bytes=-100", &ByteRange{900, 999}, nil},
+		{"Суффикс больше файла", "bytes=-5000", &ByteRange{0, 999}, nil},
+		{"Конец за пределами файла", "bytes=900-2000", &ByteRange{900, 999}, nil},
+		{"Несколько диапазонов", "bytes=0-1,5-6", nil, nil},
+		{"Другие единицы", "items=0-1", nil, nil},
+		{"Начало за пределами файла", "bytes=1000-", nil, ErrRangeNotSatisfiable},
+		{"Нулевой суффикс", "bytes=-0", nil, ErrRangeNotSatisfiable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseByteRange(tt.header, size)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := ParseByteRange("bytes=5-3", size)
+	assert.Error(t, err, "Конец раньше начала должен вызывать ошибку")
+
+	r := ByteRange{Start: 10, End: 19}
+	assert.Equal(t, int64(10), r.Length())
+	assert.Equal(t, "bytes 10-19/1000", r.ContentRange(size))
+}
@@ -0,0 +1,252 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/notification"
+)
+
+// FileEventType тип события с файлом
+type FileEventType string
+
+// Типы событий с файлами
+const (
+	FileCreated FileEventType = "created"
+	FileDeleted FileEventType = "deleted"
+	FileMoved   FileEventType = "moved"
+)
+
+// Источники событий
+const (
+	EventSourceAPI   = "api"
+	EventSourceMinIO = "minio"
+)
+
+// FileEvent событие изменения файла в бакете пользователя или в общем пространстве
+type FileEvent struct {
+	Seq    int64         `json:"seq,omitempty"`   // Номер в журнале изменений; 0 для изменений в обход API и в общих пространствах
+	Space  int           `json:"space,omitempty"` // ID общего пространства; 0 для личного бакета
+	Type   FileEventType `json:"type"`
+	Key    string        `json:"key"`
+	OldKey string        `json:"old_key,omitempty"` // Для перемещений
+	Size   int64         `json:"size,omitempty"`
+	ETag   string        `json:"etag,omitempty"`
+	Source string        `json:"source"`
+	Time   time.Time     `json:"time"`
+}
+
+// Параметры шины событий
+const (
+	eventBufferSize = 64
+	// Окно, в течение которого уведомление MinIO считается дублем события из сервиса
+	eventDedupWindow = 10 * time.Second
+)
+
+// eventBus рассылает события подписчикам пользователя; нулевое значение готово к работе
+type eventBus struct {
+	mu          sync.Mutex
+	subscribers map[int]map[chan FileEvent]struct{}
+	listeners   map[int]context.CancelFunc // Слушатели уведомлений MinIO по пользователям
+	recent      map[string]time.Time       // События из сервиса для отсева дублей от MinIO
+}
+
+// SubscribeEvents подписывает на события бакета пользователя.
+// Возвращает канал событий и функцию отписки, которую нужно вызвать по завершении.
+func (s *Service) SubscribeEvents(ctx context.Context, userID int) (<-chan FileEvent, func(), error) {
+	ch := make(chan FileEvent, eventBufferSize)
+
+	b := &s.events
+	b.mu.Lock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[int]map[chan FileEvent]struct{})
+	}
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan FileEvent]struct{})
+	}
+	b.subscribers[userID][ch] = struct{}{}
+	first := len(b.subscribers[userID]) == 1
+	b.mu.Unlock()
+
+	// Один слушатель MinIO на пользователя, пока есть хотя бы один подписчик
+	if first {
+		if err := s.startBucketListener(ctx, userID); err != nil {
+			s.unsubscribeEvents(userID, ch)
+			return nil, nil, err
+		}
+	}
+
+	var once sync.Once
+	return ch, func() { once.Do(func() { s.unsubscribeEvents(userID, ch) }) }, nil
+}
+
+// unsubscribeEvents удаляет подписчика и останавливает слушатель MinIO, если подписчиков не осталось
+func (s *Service) unsubscribeEvents(userID int, ch chan FileEvent) {
+	b := &s.events
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subscribers[userID], ch)
+	close(ch)
+
+	if len(b.subscribers[userID]) == 0 {
+		delete(b.subscribers, userID)
+		if cancel, ok := b.listeners[userID]; ok {
+			cancel()
+			delete(b.listeners, userID)
+		}
+	}
+}
+
+// publishEvent отправляет событие, возникшее в сервисе, всем подписчикам пользователя
+func (s *Service) publishEvent(userID int, event FileEvent) {
+	event.Source = EventSourceAPI
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b := &s.events
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.subscribers[userID]) == 0 {
+		return
+	}
+
+	// Запоминаем событие, чтобы не повторять его, когда придет уведомление MinIO
+	if b.recent == nil {
+		b.recent = make(map[string]time.Time)
+	}
+	for key, at := range b.recent {
+		if event.Time.Sub(at) > eventDedupWindow {
+			delete(b.recent, key)
+		}
+	}
+	b.recent[dedupKey(userID, event)] = event.Time
+	if event.Type == FileMoved {
+		// MinIO сообщает о перемещении как о создании нового объекта и удалении старого
+		b.recent[dedupKey(userID, FileEvent{Space: event.Space, Type: FileCreated, Key: event.Key})] = event.Time
+		b.recent[dedupKey(userID, FileEvent{Space: event.Space, Type: FileDeleted, Key: event.OldKey})] = event.Time
+	}
+
+	b.broadcast(userID, event)
+}
+
+// publishBucketEvent отправляет событие из уведомления MinIO, если сервис уже сообщил о нем
+func (s *Service) publishBucketEvent(userID int, event FileEvent) {
+	event.Source = EventSourceMinIO
+
+	b := &s.events
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := dedupKey(userID, event)
+	if at, ok := b.recent[key]; ok && event.Time.Sub(at) <= eventDedupWindow {
+		delete(b.recent, key)
+		return
+	}
+
+	b.broadcast(userID, event)
+}
+
+// broadcast рассылает событие без блокировки: медленный подписчик теряет события, а не тормозит остальных.
+// Вызывается под b.mu.
+func (b *eventBus) broadcast(userID int, event FileEvent) {
+	for ch := range b.subscribers[userID] {
+		select {
+		case ch <- event:
+		default:
+			log.Printf("подписчик пользователя %d не успевает читать события, событие пропущено", userID)
+		}
+	}
+}
+
+// dedupKey ключ для сопоставления событий сервиса и MinIO. Уведомления MinIO приходят только
+// о личном бакете, поэтому события общих пространств их не отсеивают.
+func dedupKey(userID int, event FileEvent) string {
+	return fmt.Sprintf("%d|%d|%s|%s", userID, event.Space, event.Type, event.Key)
+}
+
+// startBucketListener запускает чтение уведомлений MinIO о бакете пользователя,
+// чтобы подписчики видели и изменения, сделанные в обход API
+func (s *Service) startBucketListener(ctx context.Context, userID int) error {
+	if s.MinioAdmin == nil || s.MinioAdmin.Client == nil {
+		return nil
+	}
+
+	bucketName, _, _, err := s.Storagedb.GetMinIOCredentials(userID)
+	if err != nil {
+		return fmt.Errorf("ошибка получения данных хранилища: %w", err)
+	}
+
+	// Слушатель живет дольше запроса, открывшего подписку, и останавливается при отписке последнего клиента
+	listenCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	b := &s.events
+	b.mu.Lock()
+	if b.listeners == nil {
+		b.listeners = make(map[int]context.CancelFunc)
+	}
+	b.listeners[userID] = cancel
+	b.mu.Unlock()
+
+	notifications := s.MinioAdmin.Client.ListenBucketNotification(listenCtx, bucketName, "", "", []string{
+		"s3:ObjectCreated:*",
+		"s3:ObjectRemoved:*",
+	})
+
+	go func() {
+		for info := range notifications {
+			if info.Err != nil {
+				if listenCtx.Err() == nil {
+					log.Printf("ошибка получения уведомлений MinIO для %s: %v", bucketName, info.Err)
+				}
+				continue
+			}
+			for _, record := range info.Records {
+				if event, ok := eventFromNotification(record); ok {
+					s.publishBucketEvent(userID, event)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// eventFromNotification преобразует запись уведомления MinIO в FileEvent
+func eventFromNotification(record notification.Event) (FileEvent, bool) {
+	var eventType FileEventType
+	switch {
+	case strings.HasPrefix(record.EventName, "s3:ObjectCreated:"):
+		eventType = FileCreated
+	case strings.HasPrefix(record.EventName, "s3:ObjectRemoved:"):
+		eventType = FileDeleted
+	default:
+		return FileEvent{}, false
+	}
+
+	// Ключ в уведомлениях закодирован как в URL
+	key, err := url.QueryUnescape(record.S3.Object.Key)
+	if err != nil {
+		key = record.S3.Object.Key
+	}
+
+	eventTime, err := time.Parse(time.RFC3339Nano, record.EventTime)
+	if err != nil {
+		eventTime = time.Now()
+	}
+
+	return FileEvent{
+		Type: eventType,
+		Key:  key,
+		Size: record.S3.Object.Size,
+		ETag: record.S3.Object.ETag,
+		Time: eventTime,
+	}, true
+}
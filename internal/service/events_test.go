@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/notification"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// receiveEvent читает событие из канала с таймаутом
+func receiveEvent(t *testing.T, ch <-chan FileEvent) (FileEvent, bool) {
+	t.Helper()
+	select {
+	case event, ok := <-ch:
+		return event, ok
+	case <-time.After(100 * time.Millisecond):
+		return FileEvent{}, false
+	}
+}
+
+// TestEventsSubscribe проверяет доставку событий только подписчикам нужного пользователя
+func TestEventsSubscribe(t *testing.T) {
+	srv := &Service{}
+
+	events, unsubscribe, err := srv.SubscribeEvents(context.Background(), 1)
+	require.NoError(t, err)
+	other, unsubscribeOther, err := srv.SubscribeEvents(context.Background(), 2)
+	require.NoError(t, err)
+	defer unsubscribeOther()
+
+	srv.publishEvent(1, FileEvent{Type: FileCreated, Key: "a.txt"})
+
+	event, ok := receiveEvent(t, events)
+	require.True(t, ok, "Подписчик должен получить событие")
+	assert.Equal(t, FileCreated, event.Type)
+	assert.Equal(t, "a.txt", event.Key)
+	assert.Equal(t, EventSourceAPI, event.Source)
+
+	_, ok = receiveEvent(t, other)
+	assert.False(t, ok, "Другой пользователь не должен получать чужие события")
+
+	unsubscribe()
+	unsubscribe()
+	_, ok = <-events
+	assert.False(t, ok, "После отписки канал должен быть закрыт")
+}
+
+// TestEventsDedup проверяет, что уведомление MinIO о действии через API не дублируется
+func TestEventsDedup(t *testing.T) {
+	srv := &Service{}
+
+	events, unsubscribe, err := srv.SubscribeEvents(context.Background(), 1)
+	require.NoError(t, err)
+	defer unsubscribe()
+
+	srv.publishEvent(1, FileEvent{Type: FileDeleted, Key: "a.txt"})
+	_, ok := receiveEvent(t, events)
+	require.True(t, ok)
+
+	srv.publishBucketEvent(1, FileEvent{Type: FileDeleted, Key: "a.txt", Time: time.Now()})
+	_, ok = receiveEvent(t, events)
+	assert.False(t, ok, "Дубль от MinIO должен отсеиваться")
+
+	srv.publishBucketEvent(1, FileEvent{Type: FileCreated, Key: "b.txt", Time: time.Now()})
+	event, ok := receiveEvent(t, events)
+	require.True(t, ok, "Изменение в обход API должно доставляться")
+	assert.Equal(t, EventSourceMinIO, event.Source)
+}
+
+// TestEventFromNotification проверяет разбор уведомлений MinIO
+func TestEventFromNotification(t *testing.T) {
+	record := notification.Event{
+		EventName: "s3:ObjectCreated:Put",
+		EventTime: "2024-05-01T10:00:00.000Z",
+	}
+	record.S3.Object.Key = "photos%2Fsea+view.jpg"
+	record.S3.Object.Size = 42
+
+	event, ok := eventFromNotification(record)
+	require.True(t, ok)
+	assert.Equal(t, FileCreated, event.Type)
+	assert.Equal(t, "photos/sea view.jpg", event.Key)
+	assert.Equal(t, int64(42), event.Size)
+
+	record.EventName = "s3:ObjectAccessed:Get"
+	_, ok = eventFromNotification(record)
+	assert.False(t, ok, "Чтение объекта не является изменением")
+}
@@ -0,0 +1,513 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Limits ограничения на использование хранилища и распаковку архивов
+type Limits struct {
+	QuotaBytes          int64 // Квота на пользователя в байтах, 0 — без ограничений
+	MaxExtractEntries   int   // Максимальное количество записей в распаковываемом архиве
+	MaxExtractBytes     int64 // Максимальный суммарный размер распакованных данных
+	MaxCompressionRatio int64 // Максимальная степень сжатия одной записи (защита от zip-бомб)
+	MaxUploadBytes      int64 // Максимальный размер загружаемого файла, 0 — без ограничений
+	MaxAdminUploadBytes int64 // То же для администраторов, 0 — без ограничений
+}
+
+// Значения ограничений по умолчанию
+const (
+	defaultMaxExtractEntries   = 100000
+	defaultMaxExtractBytes     = 2 << 30 // 2 ГБ
+	defaultMaxCompressionRatio = 200
+)
+
+// extractJobTTL время, в течение которого состояние завершенной задачи распаковки доступно клиенту
+const extractJobTTL = time.Hour
+
+// ExtractStatus состояние задачи распаковки
+type ExtractStatus string
+
+// Возможные состояния задачи распаковки
+const (
+	ExtractPending ExtractStatus = "pending"
+	ExtractRunning ExtractStatus = "running"
+	ExtractDone    ExtractStatus = "done"
+	ExtractFailed  ExtractStatus = "failed"
+)
+
+// ExtractJob фоновая задача распаковки архива
+type ExtractJob struct {
+	ID             string        `json:"id"`
+	UserID         int           `json:"-"`
+	Archive        string        `json:"archive"`
+	Target         string        `json:"target"`
+	Status         ExtractStatus `json:"status"`
+	TotalEntries   int           `json:"total_entries"` // 0, если заранее неизвестно (tar)
+	DoneEntries    int           `json:"done_entries"`
+	BytesExtracted int64         `json:"bytes_extracted"`
+	Error          string        `json:"error,omitempty"`
+	StartedAt      time.Time     `json:"started_at"`
+	FinishedAt     time.Time     `json:"finished_at,omitempty"`
+
+	mu sync.Mutex
+}
+
+// snapshot возвращает копию состояния задачи для безопасного чтения
+func (j *ExtractJob) snapshot() *ExtractJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return &ExtractJob{
+		ID:             j.ID,
+		UserID:         j.UserID,
+		Archive:        j.Archive,
+		Target:         j.Target,
+		Status:         j.Status,
+		TotalEntries:   j.TotalEntries,
+		DoneEntries:    j.DoneEntries,
+		BytesExtracted: j.BytesExtracted,
+		Error:          j.Error,
+		StartedAt:      j.StartedAt,
+		FinishedAt:     j.FinishedAt,
+	}
+}
+
+// update изменяет состояние задачи под мьютексом
+func (j *ExtractJob) update(fn func(j *ExtractJob)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(j)
+}
+
+// ValidateExtract проверяет имя архива и папку распаковки. Позволяет отклонить запрос
+// на загрузку с распаковкой до того, как архив будет сохранен.
+func ValidateExtract(archiveKey, target string) error {
+	if err := ValidateObjectKey(archiveKey); err != nil {
+		return err
+	}
+	if err := ValidatePrefix(target); err != nil {
+		return err
+	}
+	_, err := detectArchiveKind(archiveKey)
+	return err
+}
+
+// StartExtractArchive запускает фоновую распаковку архива из бакета пользователя в папку target
+func (s *Service) StartExtractArchive(ctx context.Context, userID int, archiveKey, target string) (*ExtractJob, error) {
+	if err := ValidateExtract(archiveKey, target); err != nil {
+		return nil, err
+	}
+	if target != "" && !strings.HasSuffix(target, "/") {
+		target += "/"
+	}
+
+	s.pruneExtractJobs(time.Now())
+
+	id, err := s.generateSecretKey(12)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка генерации ID задачи: %w", err)
+	}
+
+	job := &ExtractJob{
+		ID:        id,
+		UserID:    userID,
+		Archive:   archiveKey,
+		Target:    target,
+		Status:    ExtractPending,
+		StartedAt: time.Now(),
+	}
+	s.extractJobs.Store(id, job)
+
+	// Задача не должна зависеть от контекста HTTP-запроса, который завершится раньше
+	go s.runExtractJob(context.WithoutCancel(ctx), job)
+
+	return job.snapshot(), nil
+}
+
+// GetExtractJob возвращает состояние задачи распаковки пользователя
+func (s *Service) GetExtractJob(userID int, id string) (*ExtractJob, error) {
+	value, ok := s.extractJobs.Load(id)
+	if !ok {
+		return nil, fmt.Errorf("задача %s не найдена", id)
+	}
+
+	job := value.(*ExtractJob).snapshot()
+	if job.UserID != userID {
+		return nil, fmt.Errorf("задача %s не найдена", id)
+	}
+
+	return job, nil
+}
+
+// pruneExtractJobs удаляет задачи, завершившиеся раньше чем extractJobTTL назад
+func (s *Service) pruneExtractJobs(now time.Time) {
+	s.extractJobs.Range(func(key, value any) bool {
+		job := value.(*ExtractJob).snapshot()
+		if !job.FinishedAt.IsZero() && now.Sub(job.FinishedAt) > extractJobTTL {
+			s.extractJobs.Delete(key)
+		}
+		return true
+	})
+}
+
+// runExtractJob выполняет распаковку и сохраняет итог в задаче
+func (s *Service) runExtractJob(ctx context.Context, job *ExtractJob) {
+	job.update(func(j *ExtractJob) { j.Status = ExtractRunning })
+
+	err := s.extractArchive(ctx, job)
+
+	job.update(func(j *ExtractJob) {
+		j.FinishedAt = time.Now()
+		if err != nil {
+			j.Status = ExtractFailed
+			j.Error = err.Error()
+			return
+		}
+		j.Status = ExtractDone
+	})
+	if err != nil {
+		log.Printf("ошибка распаковки архива %s: %v", job.Archive, err)
+	}
+}
+
+// extractArchive читает архив из MinIO и записывает каждую запись отдельным объектом
+func (s *Service) extractArchive(ctx context.Context, job *ExtractJob) error {
+	kind, err := detectArchiveKind(job.Archive)
+	if err != nil {
+		return err
+	}
+
+	quota, err := s.userQuota(job.UserID)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.ExecuteFileOperation(ctx, job.UserID, func(ctx context.Context, minioClient MinioClientInterface, bucketName string) (any, error) {
+		budget, err := s.extractBudget(ctx, minioClient, bucketName, quota)
+		if err != nil {
+			return nil, err
+		}
+
+		object, err := minioClient.GetObject(ctx, bucketName, job.Archive, minio.GetObjectOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("ошибка получения архива: %w", err)
+		}
+		defer object.Close()
+
+		stat, err := object.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("ошибка получения информации об архиве: %w", err)
+		}
+
+		x := &extractor{
+			limits: s.extractLimits(),
+			budget: budget,
+			job:    job,
+			put: func(name string, r io.Reader, size int64) error {
+				info, err := minioClient.PutObject(ctx, bucketName, job.Target+name, r, size, minio.PutObjectOptions{})
+				if err != nil {
+					return err
+				}
+				s.recordChange(ctx, job.UserID, FileEvent{Type: FileCreated, Key: info.Key, Size: info.Size, ETag: info.ETag})
+				return nil
+			},
+		}
+
+		if kind == archiveKindZip {
+			return nil, x.extractZip(object, stat.Size)
+		}
+		return nil, x.extractTar(object, kind == archiveKindTarGz)
+	})
+
+	return err
+}
+
+// extractLimits возвращает ограничения с подставленными значениями по умолчанию
+func (s *Service) extractLimits() Limits {
+	limits := s.Limits
+	if limits.MaxExtractEntries <= 0 {
+		limits.MaxExtractEntries = defaultMaxExtractEntries
+	}
+	if limits.MaxExtractBytes <= 0 {
+		limits.MaxExtractBytes = defaultMaxExtractBytes
+	}
+	if limits.MaxCompressionRatio <= 0 {
+		limits.MaxCompressionRatio = defaultMaxCompressionRatio
+	}
+	return limits
+}
+
+// userQuota возвращает квоту пользователя: назначенную ему при приглашении, а если ее нет — общую
+func (s *Service) userQuota(userID int) (int64, error) {
+	user, err := s.Storagedb.GetUserByID(userID)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения данных пользователя: %w", err)
+	}
+	if user.QuotaBytes > 0 {
+		return user.QuotaBytes, nil
+	}
+	return s.Limits.QuotaBytes, nil
+}
+
+// extractBudget возвращает, сколько байт можно распаковать с учетом квоты и общего лимита
+func (s *Service) extractBudget(ctx context.Context, minioClient MinioClientInterface, bucketName string, quota int64) (int64, error) {
+	budget := s.extractLimits().MaxExtractBytes
+	if quota <= 0 {
+		return budget, nil
+	}
+
+	used, err := bucketUsage(ctx, minioClient, bucketName)
+	if err != nil {
+		return 0, err
+	}
+
+	free := quota - used
+	if free <= 0 {
+		return 0, ErrQuotaExceeded
+	}
+
+	return min(budget, free), nil
+}
+
+// ErrQuotaExceeded возвращается, если операция превысит квоту пользователя
+var ErrQuotaExceeded = errors.New("превышена квота хранилища")
+
+// bucketUsage считает суммарный размер объектов в бакете
+func bucketUsage(ctx context.Context, minioClient MinioClientInterface, bucketName string) (int64, error) {
+	var used int64
+	for obj := range minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Recursive: true}) {
+		if obj.Err != nil {
+			return 0, obj.Err
+		}
+		used += obj.Size
+	}
+	return used, nil
+}
+
+// archiveKind тип распаковываемого архива
+type archiveKind int
+
+const (
+	archiveKindZip archiveKind = iota
+	archiveKindTar
+	archiveKindTarGz
+)
+
+// detectArchiveKind определяет тип архива по расширению
+func detectArchiveKind(key string) (archiveKind, error) {
+	lower := strings.ToLower(key)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return archiveKindZip, nil
+	case strings.HasSuffix(lower, ".tar"):
+		return archiveKindTar, nil
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return archiveKindTarGz, nil
+	default:
+		return 0, fmt.Errorf("файл %s не является поддерживаемым архивом", key)
+	}
+}
+
+// extractor распаковывает записи архива с проверкой ограничений
+type extractor struct {
+	limits  Limits
+	budget  int64 // Оставшийся объем в байтах
+	entries int
+	job     *ExtractJob
+	put     func(name string, r io.Reader, size int64) error
+}
+
+// extractZip распаковывает zip; minio.Object поддерживает ReaderAt, поэтому архив не скачивается целиком
+func (x *extractor) extractZip(r io.ReaderAt, size int64) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("ошибка чтения zip: %w", err)
+	}
+
+	if len(zr.File) > x.limits.MaxExtractEntries {
+		return fmt.Errorf("архив содержит слишком много записей: %d", len(zr.File))
+	}
+	x.job.update(func(j *ExtractJob) { j.TotalEntries = len(zr.File) })
+
+	for _, f := range zr.File {
+		if err := x.checkRatio(f.Name, f.UncompressedSize64, f.CompressedSize64); err != nil {
+			return err
+		}
+
+		isDir := f.FileInfo().IsDir()
+		err := x.extractEntry(f.Name, int64(f.UncompressedSize64), isDir, func() (io.ReadCloser, error) {
+			return f.Open()
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extractTar распаковывает tar или tar.gz потоково. У tar.gz размер сжатой записи заранее неизвестен,
+// поэтому степень сжатия проверяется по ходу чтения для всего потока.
+func (x *extractor) extractTar(r io.Reader, gzipped bool) error {
+	if gzipped {
+		compressed := &countingReader{r: r}
+		gz, err := gzip.NewReader(compressed)
+		if err != nil {
+			return fmt.Errorf("ошибка чтения gzip: %w", err)
+		}
+		defer gz.Close()
+		r = &ratioReader{r: gz, compressed: compressed, maxRatio: x.limits.MaxCompressionRatio}
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("ошибка чтения tar: %w", err)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeDir:
+		default:
+			// Ссылки и специальные файлы не распаковываем
+			continue
+		}
+
+		if x.entries >= x.limits.MaxExtractEntries {
+			return fmt.Errorf("архив содержит слишком много записей")
+		}
+
+		err = x.extractEntry(header.Name, header.Size, header.Typeflag == tar.TypeDir, func() (io.ReadCloser, error) {
+			return io.NopCloser(tr), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// checkRatio отклоняет записи с подозрительно высокой степенью сжатия
+func (x *extractor) checkRatio(name string, uncompressed, compressed uint64) error {
+	if uncompressed == 0 {
+		return nil
+	}
+	if compressed == 0 || uncompressed/compressed > uint64(x.limits.MaxCompressionRatio) {
+		return fmt.Errorf("подозрительная степень сжатия у %s", name)
+	}
+	return nil
+}
+
+// ratioGraceBytes объем распакованных данных, до которого степень сжатия потока не проверяется:
+// в начале потока сжатых байт прочитано слишком мало для честной оценки
+const ratioGraceBytes = 1 << 20
+
+// errSuspiciousRatio степень сжатия потока превысила допустимую
+var errSuspiciousRatio = errors.New("подозрительная степень сжатия архива")
+
+// countingReader считает прочитанные байты
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read читает из r и увеличивает счетчик
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ratioReader прерывает чтение распакованного потока, если он вырос больше чем в maxRatio раз
+// по сравнению с прочитанными сжатыми данными (защита от gzip-бомб)
+type ratioReader struct {
+	r            io.Reader
+	compressed   *countingReader
+	uncompressed int64
+	maxRatio     int64
+}
+
+// Read читает распакованные данные и проверяет степень сжатия
+func (rr *ratioReader) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	rr.uncompressed += int64(n)
+	if rr.uncompressed > ratioGraceBytes && rr.uncompressed/max(rr.compressed.n, 1) > rr.maxRatio {
+		return n, errSuspiciousRatio
+	}
+	return n, err
+}
+
+// extractEntry проверяет путь и размер записи и записывает ее в бакет
+func (x *extractor) extractEntry(name string, size int64, isDir bool, open func() (io.ReadCloser, error)) error {
+	clean, err := sanitizeArchivePath(name)
+	if err != nil {
+		return err
+	}
+
+	x.entries++
+	if isDir {
+		if err := x.put(clean+"/", strings.NewReader(""), 0); err != nil {
+			return fmt.Errorf("ошибка создания папки %s: %w", clean, err)
+		}
+		x.progress(0)
+		return nil
+	}
+
+	if size < 0 || size > x.budget {
+		return fmt.Errorf("распаковка %s превысит допустимый объем: %w", clean, ErrQuotaExceeded)
+	}
+	x.budget -= size
+
+	src, err := open()
+	if err != nil {
+		return fmt.Errorf("ошибка чтения %s: %w", clean, err)
+	}
+	defer src.Close()
+
+	// Читаем не больше заявленного размера, чтобы не доверять заголовку архива
+	if err := x.put(clean, io.LimitReader(src, size), size); err != nil {
+		return fmt.Errorf("ошибка записи %s: %w", clean, err)
+	}
+	x.progress(size)
+
+	return nil
+}
+
+// progress обновляет счетчики задачи
+func (x *extractor) progress(size int64) {
+	x.job.update(func(j *ExtractJob) {
+		j.DoneEntries++
+		j.BytesExtracted += size
+	})
+}
+
+// sanitizeArchivePath нормализует путь записи и отклоняет попытки выйти за пределы папки
+func sanitizeArchivePath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("%w: абсолютный путь в архиве %s", ErrInvalidPath, name)
+	}
+
+	// После Clean путь, выходящий за пределы папки, начинается с ".." и отклоняется проверкой ключа
+	clean := path.Clean(name)
+	if err := ValidateObjectKey(clean); err != nil {
+		return "", err
+	}
+
+	return clean, nil
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestExtractor создает extractor, складывающий записи в map
+func newTestExtractor(budget int64) (*extractor, map[string]string) {
+	written := map[string]string{}
+	return &extractor{
+		limits: (&Service{}).extractLimits(),
+		budget: budget,
+		job:    &ExtractJob{},
+		put: func(name string, r io.Reader, size int64) error {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			written[name] = string(data)
+			return nil
+		},
+	}, written
+}
+
+// buildZip создает zip-архив из пар имя/содержимое
+func buildZip(t *testing.T, files map[string]string) *bytes.Reader {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return bytes.NewReader(buf.Bytes())
+}
+
+// TestSanitizeArchivePath проверяет защиту от выхода за пределы папки
+func TestSanitizeArchivePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"photos/a.jpg", "photos/a.jpg", false},
+		{"photos/./a.jpg", "photos/a.jpg", false},
+		{"photos\\a.jpg", "photos/a.jpg", false},
+		{"../etc/passwd", "", true},
+		{"photos/../../a.jpg", "", true},
+		{"/etc/passwd", "", true},
+		{"bad\x00name", "", true},
+		{"./", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sanitizeArchivePath(tt.name)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// TestExtractZip проверяет распаковку zip и подсчет прогресса
+func TestExtractZip(t *testing.T) {
+	archive := buildZip(t, map[string]string{
+		"a.txt":     "hello",
+		"dir/b.txt": "world",
+	})
+
+	x, written := newTestExtractor(1 << 20)
+	err := x.extractZip(archive, archive.Size())
+
+	require.NoError(t, err, "Распаковка должна пройти успешно")
+	assert.Equal(t, map[string]string{"a.txt": "hello", "dir/b.txt": "world"}, written)
+	assert.Equal(t, 2, x.job.TotalEntries)
+	assert.Equal(t, 2, x.job.DoneEntries)
+	assert.Equal(t, int64(10), x.job.BytesExtracted)
+}
+
+// TestExtractZipTraversal проверяет, что записи с ".." отклоняются
+func TestExtractZipTraversal(t *testing.T) {
+	archive := buildZip(t, map[string]string{"../evil.txt": "x"})
+
+	x, written := newTestExtractor(1 << 20)
+	err := x.extractZip(archive, archive.Size())
+
+	assert.Error(t, err, "Путь с .. должен отклоняться")
+	assert.Empty(t, written)
+}
+
+// TestExtractZipBomb проверяет отказ при слишком высокой степени сжатия
+func TestExtractZipBomb(t *testing.T) {
+	archive := buildZip(t, map[string]string{"zeros.bin": strings.Repeat("0", 1<<20)})
+
+	x, written := newTestExtractor(1 << 30)
+	err := x.extractZip(archive, archive.Size())
+
+	assert.ErrorContains(t, err, "степень сжатия")
+	assert.Empty(t, written)
+}
+
+// TestExtractTarGzBomb проверяет, что степень сжатия проверяется и для tar.gz
+func TestExtractTarGzBomb(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	const size = 8 << 20
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "zeros.bin", Mode: 0o644, Size: size, Typeflag: tar.TypeReg}))
+	_, err := tw.Write(make([]byte, size))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	x, written := newTestExtractor(1 << 30)
+	err = x.extractTar(&buf, true)
+
+	assert.ErrorContains(t, err, "степень сжатия")
+	assert.Empty(t, written)
+}
+
+// TestExtractTarQuota проверяет, что распаковка не превышает оставшуюся квоту
+func TestExtractTarQuota(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: 5, Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte("12345"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	x, written := newTestExtractor(7)
+	err := x.extractTar(&buf, false)
+
+	assert.True(t, errors.Is(err, ErrQuotaExceeded), "Должна вернуться ошибка превышения квоты")
+	assert.Equal(t, map[string]string{"a.txt": "12345"}, written)
+}
+
+// TestDetectArchiveKind проверяет определение типа архива
+func TestDetectArchiveKind(t *testing.T) {
+	kind, err := detectArchiveKind("photos.ZIP")
+	require.NoError(t, err)
+	assert.Equal(t, archiveKindZip, kind)
+
+	kind, err = detectArchiveKind("backup.tgz")
+	require.NoError(t, err)
+	assert.Equal(t, archiveKindTarGz, kind)
+
+	_, err = detectArchiveKind("notes.txt")
+	assert.Error(t, err)
+}
+
+// TestPruneExtractJobs проверяет, что состояние завершенных задач удаляется через extractJobTTL
+func TestPruneExtractJobs(t *testing.T) {
+	srv := &Service{}
+	now := time.Now()
+
+	srv.extractJobs.Store("old", &ExtractJob{ID: "old", UserID: 1, Status: ExtractDone, FinishedAt: now.Add(-2 * extractJobTTL)})
+	srv.extractJobs.Store("recent", &ExtractJob{ID: "recent", UserID: 1, Status: ExtractFailed, FinishedAt: now.Add(-time.Minute)})
+	srv.extractJobs.Store("running", &ExtractJob{ID: "running", UserID: 1, Status: ExtractRunning, StartedAt: now.Add(-2 * extractJobTTL)})
+
+	srv.pruneExtractJobs(now)
+
+	_, err := srv.GetExtractJob(1, "old")
+	assert.Error(t, err, "Давно завершенная задача удаляется")
+	_, err = srv.GetExtractJob(1, "recent")
+	assert.NoError(t, err)
+	_, err = srv.GetExtractJob(1, "running")
+	assert.NoError(t, err, "Выполняющаяся задача не удаляется")
+}
+
+// TestValidateExtract проверяет отклонение распаковки до загрузки архива
+func TestValidateExtract(t *testing.T) {
+	assert.NoError(t, ValidateExtract("backup.tar.gz", "restore"))
+	assert.Error(t, ValidateExtract("notes.txt", ""), "Не архив")
+	assert.Error(t, ValidateExtract("backup.zip", "../escape"), "Некорректная папка")
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/minio/madmin-go/v3"
+)
+
+// Ошибки групп
+var (
+	ErrInvalidGroupName   = errors.New("имя группы может содержать только строчные латинские буквы, цифры и дефис (3-56 символов)")
+	ErrInvalidGroupMember = errors.New("некорректный участник группы")
+)
+
+// noSuchGroup код ошибки MinIO для несуществующей группы
+const noSuchGroup = "XMinioAdminNoSuchGroup"
+
+// minioGroupName имя группы в MinIO
+func minioGroupName(groupName string) string {
+	return "group-" + groupName
+}
+
+// CreateGroup создает группу; создатель становится ее владельцем и первым участником
+func (s *Service) CreateGroup(ctx context.Context, ownerID int, name string) (*models.Group, error) {
+	if !resourceNamePattern.MatchString(name) {
+		return nil, ErrInvalidGroupName
+	}
+
+	// Владелец записывается в участники в одной транзакции с группой
+	group := &models.Group{Name: name, OwnerID: ownerID}
+	if err := s.Storagedb.CreateGroup(group); err != nil {
+		return nil, err
+	}
+
+	// Группа MinIO синхронизируется повторно при каждом изменении состава, поэтому сбой здесь не откатывает создание
+	s.refreshGroupMembers(ctx, group)
+	return group, nil
+}
+
+// ListGroups возвращает группы, которыми пользователь владеет или в которых состоит
+func (s *Service) ListGroups(ctx context.Context, userID int) ([]models.Group, error) {
+	return s.Storagedb.ListUserGroups(userID)
+}
+
+// ListGroupMembers возвращает участников группы. Доступно любому участнику.
+func (s *Service) ListGroupMembers(ctx context.Context, userID, groupID int) ([]models.GroupMember, error) {
+	if _, err := s.groupAccess(userID, groupID); err != nil {
+		return nil, err
+	}
+	return s.Storagedb.ListGroupMembers(groupID)
+}
+
+// AddGroupMember добавляет пользователя в группу. Доступно только владельцу группы.
+func (s *Service) AddGroupMember(ctx context.Context, ownerID, groupID int, username string) (*models.GroupMember, error) {
+	group, err := s.groupAccess(ownerID, groupID)
+	if err != nil {
+		return nil, err
+	}
+	if group.OwnerID != ownerID {
+		return nil, fmt.Errorf("%w: управлять участниками может только владелец группы", ErrAccessDenied)
+	}
+
+	user, err := s.grantee(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Storagedb.AddGroupMember(groupID, user.ID); err != nil {
+		return nil, err
+	}
+	s.refreshGroupMembers(ctx, group)
+
+	return &models.GroupMember{GroupID: groupID, UserID: user.ID, UserName: user.UserName}, nil
+}
+
+// RemoveGroupMember исключает участника из группы. Владелец может исключить любого участника,
+// участник — только себя (выйти из группы).
+func (s *Service) RemoveGroupMember(ctx context.Context, userID, groupID, memberID int) error {
+	group, err := s.groupAccess(userID, groupID)
+	if err != nil {
+		return err
+	}
+	if group.OwnerID != userID && userID != memberID {
+		return fmt.Errorf("%w: управлять участниками может только владелец группы", ErrAccessDenied)
+	}
+	if memberID == group.OwnerID {
+		return fmt.Errorf("%w: владельца нельзя исключить из группы", ErrInvalidGroupMember)
+	}
+
+	if err := s.Storagedb.RemoveGroupMember(groupID, memberID); err != nil {
+		return err
+	}
+	s.refreshGroupMembers(ctx, group)
+
+	return nil
+}
+
+// DeleteGroup удаляет группу вместе с выданным ей доступом. Доступно только владельцу группы.
+func (s *Service) DeleteGroup(ctx context.Context, ownerID, groupID int) error {
+	group, err := s.groupAccess(ownerID, groupID)
+	if err != nil {
+		return err
+	}
+	if group.OwnerID != ownerID {
+		return fmt.Errorf("%w: удалить группу может только владелец", ErrAccessDenied)
+	}
+
+	if err := s.Storagedb.DeleteGroup(groupID); err != nil {
+		return err
+	}
+
+	// Без доступа к пространствам политика группы удаляется, после чего удаляется и сама группа MinIO
+	s.refreshGroupPolicy(ctx, group)
+	if err := s.removeMinioGroup(ctx, group.Name); err != nil {
+		log.Printf("ошибка удаления группы MinIO %s: %v", group.Name, err)
+	}
+
+	return nil
+}
+
+// groupAccess возвращает группу, если пользователь ее владелец или участник.
+// Для несуществующей группы и группы без доступа возвращается одна и та же ошибка.
+func (s *Service) groupAccess(userID, groupID int) (*models.Group, error) {
+	group, err := s.Storagedb.GetGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, ErrGroupNotFound
+	}
+	if group.OwnerID == userID {
+		return group, nil
+	}
+
+	members, err := s.Storagedb.ListGroupMembers(groupID)
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		if member.UserID == userID {
+			return group, nil
+		}
+	}
+
+	return nil, ErrGroupNotFound
+}
+
+// refreshGroupMembers приводит состав группы MinIO к составу в БД; ошибка только логируется,
+// так как доступ уже проверяется сервисом, а состав будет выровнен при следующем изменении
+func (s *Service) refreshGroupMembers(ctx context.Context, group *models.Group) {
+	if err := s.syncGroupMembers(ctx, group); err != nil {
+		log.Printf("ошибка синхронизации участников группы %s с MinIO: %v", group.Name, err)
+	}
+}
+
+// refreshUserGroups выравнивает группы MinIO, в которых состоит пользователь. Вызывается, когда хранилище
+// пользователя готово: до этого у него нет пользователя MinIO и синхронизация его пропускала.
+func (s *Service) refreshUserGroups(ctx context.Context, userID int) {
+	groups, err := s.Storagedb.ListUserGroups(userID)
+	if err != nil {
+		log.Printf("ошибка получения групп пользователя %d: %v", userID, err)
+		return
+	}
+	for i := range groups {
+		s.refreshGroupMembers(ctx, &groups[i])
+	}
+}
+
+// syncGroupMembers добавляет в группу MinIO недостающих участников и исключает лишних.
+// Участники, чье хранилище еще не создано, пропускаются: пользователя MinIO у них нет,
+// и MinIO отклонил бы изменение состава целиком.
+func (s *Service) syncGroupMembers(ctx context.Context, group *models.Group) error {
+	if s.Admin == nil {
+		return nil
+	}
+	admin := s.Admin
+	name := minioGroupName(group.Name)
+
+	members, err := s.Storagedb.ListGroupMembers(group.ID)
+	if err != nil {
+		return err
+	}
+	want := make(map[string]bool, len(members))
+	for _, member := range members {
+		user, err := s.Storagedb.GetUserByID(member.UserID)
+		if err != nil {
+			return fmt.Errorf("ошибка получения данных MinIO: %w", err)
+		}
+		if storageReady(user) != nil {
+			continue
+		}
+		want[user.MinioAccessKey] = true
+	}
+
+	have := make(map[string]bool)
+	desc, err := admin.GetGroupDescription(ctx, name)
+	if err != nil && madmin.ToErrorResponse(err).Code != noSuchGroup {
+		return fmt.Errorf("ошибка получения группы MinIO: %w", err)
+	}
+	if desc != nil {
+		for _, accessKey := range desc.Members {
+			have[accessKey] = true
+		}
+	}
+
+	var add, remove []string
+	for accessKey := range want {
+		if !have[accessKey] {
+			add = append(add, accessKey)
+		}
+	}
+	for accessKey := range have {
+		if !want[accessKey] {
+			remove = append(remove, accessKey)
+		}
+	}
+
+	// Группа без участников создается запросом с пустым списком
+	if len(add) > 0 || desc == nil {
+		if err := admin.UpdateGroupMembers(ctx, madmin.GroupAddRemove{Group: name, Members: add}); err != nil {
+			return fmt.Errorf("ошибка добавления участников в группу MinIO: %w", err)
+		}
+	}
+	if len(remove) > 0 {
+		if err := admin.UpdateGroupMembers(ctx, madmin.GroupAddRemove{Group: name, Members: remove, IsRemove: true}); err != nil {
+			return fmt.Errorf("ошибка исключения участников из группы MinIO: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// removeMinioGroup удаляет группу MinIO: MinIO удаляет только пустые группы, поэтому сначала исключаются участники
+func (s *Service) removeMinioGroup(ctx context.Context, groupName string) error {
+	if s.Admin == nil {
+		return nil
+	}
+	admin := s.Admin
+	name := minioGroupName(groupName)
+
+	desc, err := admin.GetGroupDescription(ctx, name)
+	if madmin.ToErrorResponse(err).Code == noSuchGroup {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка получения группы MinIO: %w", err)
+	}
+
+	if len(desc.Members) > 0 {
+		if err := admin.UpdateGroupMembers(ctx, madmin.GroupAddRemove{Group: name, Members: desc.Members, IsRemove: true}); err != nil {
+			return fmt.Errorf("ошибка исключения участников из группы MinIO: %w", err)
+		}
+	}
+	return admin.UpdateGroupMembers(ctx, madmin.GroupAddRemove{Group: name, IsRemove: true})
+}
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -26,20 +28,28 @@ type TokenPair struct {
 
 // Claims стандартные данные для JWT токена
 type Claims struct {
-	UserID int    `json:"user_id"`
-	Role   string `json:"role"`
+	UserID    int    `json:"user_id"`
+	Role      string `json:"role"`
+	Version   int    `json:"ver,omitempty"` // Версия токенов пользователя на момент выдачи
+	SessionID int    `json:"sid,omitempty"` // Сеанс, в котором выдан токен
 	jwt.StandardClaims
 }
 
-// GenerateTokenPair создает новую пару токенов для пользователя
-func (s *Service) GenerateTokenPair(user *models.User) (*TokenPair, error) {
+// ErrTokenRevoked возвращается для токена, выданного до смены пароля
+var ErrTokenRevoked = errors.New("токен отозван")
+
+// GenerateTokenPair создает новую пару токенов для пользователя в сеансе sessionID.
+// refreshID записывается в refresh токен и по его хешу сеанс узнает свой текущий токен.
+func (s *Service) GenerateTokenPair(user *models.User, sessionID int, refreshID string) (*TokenPair, error) {
 	// Текущее время для расчета времени истечения токенов
 	now := time.Now()
 
 	// Claims для access токена
 	accessClaims := &Claims{
-		UserID: user.ID,
-		Role:   "user", // Можно добавить роли для разграничения прав
+		UserID:    user.ID,
+		Role:      "user", // Можно добавить роли для разграничения прав
+		Version:   user.TokenVersion,
+		SessionID: sessionID,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: now.Add(time.Duration(s.JWTConfig.AccessTTL) * time.Second).Unix(),
 			IssuedAt:  now.Unix(),
@@ -56,8 +66,11 @@ func (s *Service) GenerateTokenPair(user *models.User) (*TokenPair, error) {
 
 	// Claims для refresh токена
 	refreshClaims := &Claims{
-		UserID: user.ID,
+		UserID:    user.ID,
+		Version:   user.TokenVersion,
+		SessionID: sessionID,
 		StandardClaims: jwt.StandardClaims{
+			Id:        refreshID,
 			ExpiresAt: now.Add(time.Duration(s.JWTConfig.RefreshTTL) * time.Second).Unix(),
 			IssuedAt:  now.Unix(),
 			Subject:   user.UserName,
@@ -81,6 +94,19 @@ func (s *Service) GenerateTokenPair(user *models.User) (*TokenPair, error) {
 
 // VerifyAccessToken проверяет валидность access токена
 func (s *Service) VerifyAccessToken(tokenString string) (*Claims, error) {
+	claims, err := s.parseAccessToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	// Токен первого шага входа подтверждает только пароль и доступа не дает
+	if claims.Role == mfaChallengeRole {
+		return nil, fmt.Errorf("недействительный токен")
+	}
+	return claims, nil
+}
+
+// parseAccessToken проверяет подпись и срок действия токена, подписанного ключом access токенов
+func (s *Service) parseAccessToken(tokenString string) (*Claims, error) {
 	// Парсим токен
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		// Проверяем алгоритм подписи
@@ -102,8 +128,39 @@ func (s *Service) VerifyAccessToken(tokenString string) (*Claims, error) {
 	return nil, fmt.Errorf("недействительный токен")
 }
 
-// RefreshTokens обновляет пару токенов с помощью refresh токена
-func (s *Service) RefreshTokens(refreshTokenString string) (*TokenPair, error) {
+// AuthenticateAccessToken проверяет access токен и то, что он не отозван сменой пароля или завершением сеанса
+func (s *Service) AuthenticateAccessToken(tokenString string) (*Claims, error) {
+	claims, err := s.VerifyAccessToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.Storagedb.GetUserByID(claims.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("пользователь не найден: %w", err)
+	}
+	if claims.Version != user.TokenVersion {
+		return nil, ErrTokenRevoked
+	}
+
+	// Все токены выдаются в сеансе; токен завершенного сеанса не принимается до истечения срока
+	if claims.SessionID == 0 {
+		return nil, ErrTokenRevoked
+	}
+	active, err := s.sessionActive(claims.UserID, claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, ErrTokenRevoked
+	}
+
+	return claims, nil
+}
+
+// RefreshTokens обновляет пару токенов с помощью refresh токена. Каждый refresh токен принимается один раз:
+// сеанс запоминает только последний выданный, а завершенный сеанс обновить нельзя.
+func (s *Service) RefreshTokens(ctx context.Context, refreshTokenString string) (*TokenPair, error) {
 	// Парсим refresh токен
 	token, err := jwt.ParseWithClaims(refreshTokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -122,9 +179,16 @@ func (s *Service) RefreshTokens(refreshTokenString string) (*TokenPair, error) {
 		if err != nil {
 			return nil, fmt.Errorf("пользователь не найден: %w", err)
 		}
+		if claims.Version != user.TokenVersion {
+			return nil, ErrTokenRevoked
+		}
+		// Токены, выданные без сеанса, больше не обновляются
+		if claims.SessionID == 0 || claims.Id == "" {
+			return nil, ErrTokenRevoked
+		}
 
-		// Генерируем новую пару токенов
-		return s.GenerateTokenPair(user)
+		// Генерируем новую пару токенов в том же сеансе
+		return s.rotateSession(ctx, user, claims.SessionID, claims.Id)
 	}
 
 	return nil, fmt.Errorf("недействительный refresh токен")
@@ -1,6 +1,7 @@
 package service
 
 import (
+    "context"
     "testing"
     "time"
 
@@ -29,9 +30,90 @@ func (m *MockStorageDB) Close() error { return nil }
 func (m *MockStorageDB) CreateUser(username, passwordHash, email string, config *models.MinioConfig) (int, error) {
     return 0, nil
 }
+func (m *MockStorageDB) CreateFirstUser(username, passwordHash, email string, config *models.MinioConfig) (int, error) {
+    return 0, nil
+}
 func (m *MockStorageDB) GetUserByUsername(username string) (*models.User, error) { return nil, nil }
+func (m *MockStorageDB) ListUsers() ([]models.User, error) { return nil, nil }
 func (m *MockStorageDB) UpdateUser(user *models.User) error { return nil }
+func (m *MockStorageDB) UpdateProfile(user *models.User, token *models.UserToken, deliver func() error) error {
+    return nil
+}
 func (m *MockStorageDB) DeleteUser(id int) error { return nil }
+func (m *MockStorageDB) SetProvisioningState(userID int, from, to string) (bool, error) {
+    return false, nil
+}
+func (m *MockStorageDB) AddProvisioningFailure(userID int) (int, error) { return 0, nil }
+func (m *MockStorageDB) ListStaleProvisioning(staleBefore time.Time) ([]models.User, error) {
+    return nil, nil
+}
+func (m *MockStorageDB) MarkEmailVerified(userID int) (bool, error) { return false, nil }
+func (m *MockStorageDB) DeleteUnverifiedUsers(createdBefore time.Time) (int64, error) {
+    return 0, nil
+}
+func (m *MockStorageDB) CountUsers() (int, error) { return 0, nil }
+func (m *MockStorageDB) CreateInvite(invite *models.Invite) error { return nil }
+func (m *MockStorageDB) ListInvites() ([]models.Invite, error) { return nil, nil }
+func (m *MockStorageDB) DeleteInvite(id int) (bool, error) { return false, nil }
+func (m *MockStorageDB) RedeemInvite(codeHash string) (*models.Invite, error) { return nil, nil }
+func (m *MockStorageDB) ReleaseInvite(id int) error { return nil }
+func (m *MockStorageDB) SaveTOTPSecret(userID int, secret string) (bool, error) { return false, nil }
+func (m *MockStorageDB) GetTOTP(userID int) (*models.TOTP, error) { return nil, nil }
+func (m *MockStorageDB) EnableTOTP(userID int, counter int64, recoveryCodeHashes []string) (bool, error) {
+    return false, nil
+}
+func (m *MockStorageDB) UseTOTPCounter(userID int, counter int64) (bool, error) { return false, nil }
+func (m *MockStorageDB) DeleteTOTP(userID int) error { return nil }
+func (m *MockStorageDB) ReplaceRecoveryCodes(userID int, codeHashes []string) error { return nil }
+func (m *MockStorageDB) UseRecoveryCode(userID int, codeHash string) (bool, error) { return false, nil }
+func (m *MockStorageDB) CreateWebAuthnCredential(cred *models.WebAuthnCredential) error { return nil }
+func (m *MockStorageDB) ListWebAuthnCredentials(userID int) ([]models.WebAuthnCredential, error) {
+    return nil, nil
+}
+func (m *MockStorageDB) GetWebAuthnCredential(credentialID []byte) (*models.WebAuthnCredential, error) {
+    return nil, nil
+}
+func (m *MockStorageDB) UpdateWebAuthnSignCount(id int, signCount uint32) (bool, error) { return false, nil }
+func (m *MockStorageDB) DeleteWebAuthnCredential(userID, id int) (bool, error)          { return false, nil }
+func (m *MockStorageDB) CreateWebAuthnChallenge(challenge *models.WebAuthnChallenge) error { return nil }
+func (m *MockStorageDB) ConsumeWebAuthnChallenge(challengeHash, purpose string) (*models.WebAuthnChallenge, error) {
+    return nil, nil
+}
+func (m *MockStorageDB) GetUserIDByIdentity(issuer, subject string) (int, error)     { return 0, nil }
+func (m *MockStorageDB) LinkUserIdentity(issuer, subject string, userID int) error     { return nil }
+func (m *MockStorageDB) CreateOIDCState(state *models.OIDCState) error                  { return nil }
+func (m *MockStorageDB) ConsumeOIDCState(stateHash string) (*models.OIDCState, error) { return nil, nil }
+func (m *MockStorageDB) RecordLoginFailure(failure *models.LoginFailure) error { return nil }
+func (m *MockStorageDB) ListLoginFailures(limit int) ([]models.LoginFailure, error) { return nil, nil }
+func (m *MockStorageDB) CreateAPIToken(token *models.APIToken) error { return nil }
+func (m *MockStorageDB) ListAPITokens(userID int) ([]models.APIToken, error) { return nil, nil }
+func (m *MockStorageDB) UseAPIToken(tokenHash string) (*models.APIToken, error) { return nil, nil }
+func (m *MockStorageDB) DeleteAPIToken(userID, id int) (bool, error) { return false, nil }
+func (m *MockStorageDB) CreateSession(session *models.Session) error {
+    args := m.Called(session)
+    return args.Error(0)
+}
+func (m *MockStorageDB) ListSessions(userID int) ([]models.Session, error) { return nil, nil }
+func (m *MockStorageDB) RotateSession(id, userID int, oldHash, newHash, ip, userAgent string, expiresAt time.Time) (bool, error) {
+    args := m.Called(id, userID, oldHash, newHash, ip, userAgent, expiresAt)
+    return args.Bool(0), args.Error(1)
+}
+func (m *MockStorageDB) SessionActive(userID, id int) (bool, error) {
+    args := m.Called(userID, id)
+    return args.Bool(0), args.Error(1)
+}
+func (m *MockStorageDB) DeleteSession(userID, id int) (bool, error) { return false, nil }
+func (m *MockStorageDB) CreateUserToken(token *models.UserToken) error { return nil }
+func (m *MockStorageDB) ConsumeUserToken(tokenHash, purpose string) (*models.UserToken, error) {
+    return nil, nil
+}
+func (m *MockStorageDB) ConfirmEmailChange(tokenHash string) (*models.UserToken, error) {
+    return nil, nil
+}
+func (m *MockStorageDB) DeleteUserTokens(userID int, purpose string) error { return nil }
+func (m *MockStorageDB) GetUserByEmail(email string) (*models.User, error) { return nil, nil }
+func (m *MockStorageDB) UpdatePassword(userID int, passwordHash string) error { return nil }
+func (m *MockStorageDB) SetUserAdmin(userID int, isAdmin bool) error { return nil }
 func (m *MockStorageDB) CreateMinIOUser(userID int, bucketName, accessKey, secretKey string) error {
     return nil
 }
@@ -41,6 +123,52 @@ func (m *MockStorageDB) GetMinIOCredentials(userID int) (string, string, string,
 func (m *MockStorageDB) InitDB() error { return nil }
 func (m *MockStorageDB) GetCurrentDBVersion() (int, error) { return 0, nil }
 func (m *MockStorageDB) MigrateTo(version int) error { return nil }
+func (m *MockStorageDB) AppendChange(change *models.Change) error { return nil }
+func (m *MockStorageDB) ListChanges(userID int, since int64, limit int) ([]models.Change, error) {
+    return nil, nil
+}
+func (m *MockStorageDB) GetLatestChange(userID int, key string) (*models.Change, error) { return nil, nil }
+func (m *MockStorageDB) GetLatestChangeSeq(userID int) (int64, error) { return 0, nil }
+func (m *MockStorageDB) CreateSharedSpace(space *models.SharedSpace) error { return nil }
+func (m *MockStorageDB) GetSharedSpace(id int) (*models.SharedSpace, error) { return nil, nil }
+func (m *MockStorageDB) ListUserSharedSpaces(userID int) ([]models.SharedSpace, error) {
+    return nil, nil
+}
+func (m *MockStorageDB) DeleteSharedSpace(id int) error { return nil }
+func (m *MockStorageDB) SetSpaceMember(spaceID, userID int, role string) error { return nil }
+func (m *MockStorageDB) RemoveSpaceMember(spaceID, userID int) error { return nil }
+func (m *MockStorageDB) ListSpaceMembers(spaceID int) ([]models.SpaceMember, error) {
+    return nil, nil
+}
+func (m *MockStorageDB) ListSpaceUserIDs(spaceID int) ([]int, error) {
+    args := m.Called(spaceID)
+    if args.Get(0) == nil {
+        return nil, args.Error(1)
+    }
+    return args.Get(0).([]int), args.Error(1)
+}
+func (m *MockStorageDB) GetSpaceMemberRole(spaceID, userID int) (string, error) { return "", nil }
+func (m *MockStorageDB) ListUserDirectSharedSpaces(userID int) ([]models.SharedSpace, error) {
+    return nil, nil
+}
+func (m *MockStorageDB) ListGroupSharedSpaces(groupID int) ([]models.SharedSpace, error) {
+    return nil, nil
+}
+func (m *MockStorageDB) SetSpaceGroupGrant(spaceID, groupID int, role string) error { return nil }
+func (m *MockStorageDB) RemoveSpaceGroupGrant(spaceID, groupID int) error { return nil }
+func (m *MockStorageDB) ListSpaceGroupGrants(spaceID int) ([]models.SpaceGroupGrant, error) {
+    return nil, nil
+}
+func (m *MockStorageDB) CreateGroup(group *models.Group) error { return nil }
+func (m *MockStorageDB) GetGroup(id int) (*models.Group, error) { return nil, nil }
+func (m *MockStorageDB) GetGroupByName(name string) (*models.Group, error) { return nil, nil }
+func (m *MockStorageDB) ListUserGroups(userID int) ([]models.Group, error) { return nil, nil }
+func (m *MockStorageDB) DeleteGroup(id int) error { return nil }
+func (m *MockStorageDB) AddGroupMember(groupID, userID int) error { return nil }
+func (m *MockStorageDB) RemoveGroupMember(groupID, userID int) error { return nil }
+func (m *MockStorageDB) ListGroupMembers(groupID int) ([]models.GroupMember, error) {
+    return nil, nil
+}
 
 // TestGenerateTokenPair проверяет генерацию пары токенов
 func TestGenerateTokenPair(t *testing.T) {
@@ -60,7 +188,7 @@ func TestGenerateTokenPair(t *testing.T) {
     }
 
     // Действие
-    tokens, err := service.GenerateTokenPair(user)
+    tokens, err := service.GenerateTokenPair(user, 4, "refresh-id")
 
     // Проверки
     require.NoError(t, err, "Ошибка при генерации токенов")
@@ -76,6 +204,7 @@ func TestGenerateTokenPair(t *testing.T) {
     assert.Equal(t, 1, claims.UserID, "Неправильный ID пользователя в токене")
     assert.Equal(t, "user", claims.Role, "Неправильная роль в токене")
     assert.Equal(t, "testuser", claims.Subject, "Неправильный subject в токене")
+    assert.Equal(t, 4, claims.SessionID, "Неправильный сеанс в токене")
 }
 
 // TestVerifyAccessToken проверяет проверку access-токена
@@ -136,7 +265,11 @@ func TestRefreshTokens(t *testing.T) {
         Storagedb: mockStorage,
     }
 
-    validRefreshToken := createTestToken(t, 1, "", time.Now().Add(time.Hour).Unix(), service.JWTConfig.RefreshSecret)
+    issued, err := service.GenerateTokenPair(&models.User{ID: 1, UserName: "testuser"}, 4, "refresh-id")
+    require.NoError(t, err)
+    validRefreshToken := issued.RefreshToken
+    // Токен без сеанса выдан до появления сеансов и не обновляется
+    sessionlessToken := createTestToken(t, 1, "", time.Now().Add(time.Hour).Unix(), service.JWTConfig.RefreshSecret)
     expiredRefreshToken := createTestToken(t, 1, "", time.Now().Add(-time.Hour).Unix(), service.JWTConfig.RefreshSecret)
     
     // Настраиваем мок для GetUserByID
@@ -144,20 +277,23 @@ func TestRefreshTokens(t *testing.T) {
         ID:       1,
         UserName: "testuser",
     }, nil)
+    mockStorage.On("RotateSession", 4, 1, hashToken("refresh-id"), mock.Anything, "192.0.2.1", "Mozilla/5.0", mock.Anything).Return(true, nil).Once()
 
+    ctx := WithClient(context.Background(), ClientInfo{IP: "192.0.2.1", UserAgent: "Mozilla/5.0"})
     tests := []struct {
         name          string
         token         string
         expectedError bool
     }{
         {"Валидный refresh-токен", validRefreshToken, false},
+        {"Токен без сеанса", sessionlessToken, true},
         {"Истекший refresh-токен", expiredRefreshToken, true},
         {"Пустой токен", "", true},
     }
 
     for _, test := range tests {
         t.Run(test.name, func(t *testing.T) {
-            tokens, err := service.RefreshTokens(test.token)
+            tokens, err := service.RefreshTokens(ctx, test.token)
             
             if test.expectedError {
                 assert.Error(t, err, "Ожидалась ошибка")
@@ -174,6 +310,63 @@ func TestRefreshTokens(t *testing.T) {
     mockStorage.AssertExpectations(t)
 }
 
+// TestTokenRevocation проверяет, что после смены пароля старые токены не принимаются
+func TestTokenRevocation(t *testing.T) {
+    mockStorage := new(MockStorageDB)
+    service := &Service{
+        JWTConfig: JWTConfig{
+            AccessSecret:  "test-access-secret",
+            RefreshSecret: "test-refresh-secret",
+            AccessTTL:     900,
+            RefreshTTL:    604800,
+        },
+        Storagedb: mockStorage,
+    }
+
+    // Токены выданы до смены пароля, когда версия была 0
+    tokens, err := service.GenerateTokenPair(&models.User{ID: 1, UserName: "testuser"}, 4, "refresh-id")
+    require.NoError(t, err)
+
+    mockStorage.On("GetUserByID", 1).Return(&models.User{ID: 1, UserName: "testuser"}, nil).Once()
+    mockStorage.On("SessionActive", 1, 4).Return(true, nil).Once()
+    _, err = service.AuthenticateAccessToken(tokens.AccessToken)
+    assert.NoError(t, err, "Действующий токен должен приниматься")
+
+    mockStorage.On("GetUserByID", 1).Return(&models.User{ID: 1, UserName: "testuser", TokenVersion: 1}, nil)
+    _, err = service.AuthenticateAccessToken(tokens.AccessToken)
+    assert.ErrorIs(t, err, ErrTokenRevoked)
+    _, err = service.RefreshTokens(context.Background(), tokens.RefreshToken)
+    assert.ErrorIs(t, err, ErrTokenRevoked)
+
+    mockStorage.AssertExpectations(t)
+}
+
+// TestRefreshTokenReuse проверяет, что refresh токен принимается один раз, а завершенный сеанс не обновляется
+func TestRefreshTokenReuse(t *testing.T) {
+    mockStorage := new(MockStorageDB)
+    service := &Service{
+        JWTConfig: JWTConfig{
+            AccessSecret:  "test-access-secret",
+            RefreshSecret: "test-refresh-secret",
+            AccessTTL:     900,
+            RefreshTTL:    604800,
+        },
+        Storagedb: mockStorage,
+    }
+
+    tokens, err := service.GenerateTokenPair(&models.User{ID: 1, UserName: "testuser"}, 4, "refresh-id")
+    require.NoError(t, err)
+
+    mockStorage.On("GetUserByID", 1).Return(&models.User{ID: 1, UserName: "testuser"}, nil)
+    // Сеанс уже получил новый токен или был завершен
+    mockStorage.On("RotateSession", 4, 1, hashToken("refresh-id"), mock.Anything, "", "", mock.Anything).Return(false, nil).Once()
+
+    _, err = service.RefreshTokens(context.Background(), tokens.RefreshToken)
+    assert.ErrorIs(t, err, ErrTokenRevoked)
+
+    mockStorage.AssertExpectations(t)
+}
+
 // createTestToken вспомогательная функция для создания тестовых токенов
 func createTestToken(t *testing.T, userID int, role string, expiresAt int64, secret string) string {
     claims := &Claims{
@@ -0,0 +1,351 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Размеры страницы листинга
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// maxSortedListing наибольшее число объектов, которое листинг с сортировкой читает целиком
+const maxSortedListing = 10000
+
+// ErrInvalidListOptions возвращается для неверных limit, cursor или sort
+var ErrInvalidListOptions = errors.New("неверные параметры листинга")
+
+// ListOptions параметры постраничного листинга
+type ListOptions struct {
+	Prefix    string
+	Recursive bool
+	Limit     int    // Размер страницы, 0 — DefaultListLimit
+	Cursor    string // Непрозрачный курсор из NextCursor предыдущей страницы
+	Sort      string // name, size, modified; "-" в начале — по убыванию
+}
+
+// ListPage одна страница листинга
+type ListPage struct {
+	Folders    []string
+	Files      []minio.ObjectInfo
+	NextCursor string // Пустой, если страница последняя
+}
+
+// ListUserFiles возвращает страницу файлов пользователя
+func (s *Service) ListUserFiles(ctx context.Context, userID int, opts ListOptions) (*ListPage, error) {
+	return s.listPage(ctx, userID, opts, true, false)
+}
+
+// ListUserFolders возвращает страницу папок пользователя
+func (s *Service) ListUserFolders(ctx context.Context, userID int, opts ListOptions) (*ListPage, error) {
+	opts.Recursive = false
+	return s.listPage(ctx, userID, opts, false, true)
+}
+
+// ListUserDirectory возвращает страницу содержимого папки: и папки, и файлы
+func (s *Service) ListUserDirectory(ctx context.Context, userID int, opts ListOptions) (*ListPage, error) {
+	opts.Recursive = false
+	return s.listPage(ctx, userID, opts, true, true)
+}
+
+// listPage читает канал ListObjects, начиная после курсора, и прекращает чтение, как только страница заполнена.
+// S3 отдает ключи только в лексикографическом порядке, поэтому другие сортировки читают листинг целиком.
+func (s *Service) listPage(ctx context.Context, userID int, opts ListOptions, withFiles, withFolders bool) (*ListPage, error) {
+	if err := ValidatePrefix(opts.Prefix); err != nil {
+		return nil, err
+	}
+
+	limit, err := listLimit(opts.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := parseListSort(opts.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	if !order.keyOrder() {
+		return s.listSortedPage(ctx, userID, opts, limit, order, withFiles, withFolders)
+	}
+
+	startAfter, err := DecodeListCursor(opts.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.ExecuteFileOperation(ctx, userID, func(ctx context.Context, minioClient MinioClientInterface, bucketName string) (interface{}, error) {
+		// Отмена контекста останавливает горутину листинга MinIO после выхода из цикла
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		objectCh := minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+			Prefix:     opts.Prefix,
+			Recursive:  opts.Recursive,
+			StartAfter: startAfter,
+		})
+
+		page := &ListPage{}
+		count := 0
+		lastKey := ""
+		for obj := range objectCh {
+			if obj.Err != nil {
+				return nil, obj.Err
+			}
+
+			// С разделителем папка-курсор может вернуться повторно, пропускаем ее
+			if startAfter != "" && obj.Key <= startAfter {
+				continue
+			}
+
+			entry, ok := newListEntry(obj, opts.Prefix, withFiles, withFolders)
+			if !ok {
+				continue
+			}
+
+			if count == limit {
+				page.NextCursor = EncodeListCursor(lastKey)
+				break
+			}
+
+			entry.addTo(page)
+			count++
+			lastKey = obj.Key
+		}
+
+		return page, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*ListPage), nil
+}
+
+// listSortedPage читает листинг целиком, сортирует его и возвращает страницу после курсора.
+// Курсор хранит значения сортировки последней записи, поэтому удаление файлов между запросами не сбивает страницы.
+func (s *Service) listSortedPage(ctx context.Context, userID int, opts ListOptions, limit int, order listSort, withFiles, withFolders bool) (*ListPage, error) {
+	after, err := decodeSortCursor(opts.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.ExecuteFileOperation(ctx, userID, func(ctx context.Context, minioClient MinioClientInterface, bucketName string) (interface{}, error) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		objectCh := minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+			Prefix:    opts.Prefix,
+			Recursive: opts.Recursive,
+		})
+
+		var entries []listEntry
+		for obj := range objectCh {
+			if obj.Err != nil {
+				return nil, obj.Err
+			}
+
+			entry, ok := newListEntry(obj, opts.Prefix, withFiles, withFolders)
+			if !ok {
+				continue
+			}
+
+			if len(entries) == maxSortedListing {
+				return nil, fmt.Errorf("%w: сортировка доступна для листинга не больше %d объектов", ErrInvalidListOptions, maxSortedListing)
+			}
+			entries = append(entries, entry)
+		}
+
+		sort.Slice(entries, func(i, j int) bool {
+			return order.less(entries[i], entries[j])
+		})
+
+		first := 0
+		if after != nil {
+			first = sort.Search(len(entries), func(i int) bool {
+				return order.less(*after, entries[i])
+			})
+		}
+
+		page := &ListPage{}
+		rest := entries[first:]
+		if len(rest) > limit {
+			rest = rest[:limit]
+			page.NextCursor = encodeSortCursor(rest[limit-1])
+		}
+		for _, entry := range rest {
+			entry.addTo(page)
+		}
+
+		return page, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*ListPage), nil
+}
+
+// listEntry объект листинга: папка или файл
+type listEntry struct {
+	folder string // Имя папки для отображения, пустое для файла
+	obj    minio.ObjectInfo
+}
+
+// newListEntry отбирает объект для листинга; false — объект в страницу не попадает
+func newListEntry(obj minio.ObjectInfo, prefix string, withFiles, withFolders bool) (listEntry, bool) {
+	isFolder := obj.Size == 0 && strings.HasSuffix(obj.Key, "/")
+	switch {
+	case isFolder && !withFolders, !isFolder && !withFiles:
+		return listEntry{}, false
+	case isFolder:
+		// Удаляем префикс и trailing slash для красивого отображения
+		folderName := strings.TrimSuffix(strings.TrimPrefix(obj.Key, prefix), "/")
+		if folderName == "" {
+			return listEntry{}, false
+		}
+		return listEntry{folder: folderName, obj: obj}, true
+	default:
+		return listEntry{obj: obj}, true
+	}
+}
+
+// addTo добавляет запись в страницу
+func (e listEntry) addTo(page *ListPage) {
+	if e.folder != "" {
+		page.Folders = append(page.Folders, e.folder)
+	} else {
+		page.Files = append(page.Files, e.obj)
+	}
+}
+
+// EncodeListCursor кодирует ключ, после которого продолжается листинг
+func EncodeListCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+// DecodeListCursor декодирует курсор в ключ для StartAfter
+func DecodeListCursor(cursor string) (string, error) {
+	if cursor == "" {
+		return "", nil
+	}
+
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", fmt.Errorf("%w: курсор", ErrInvalidListOptions)
+	}
+
+	return string(key), nil
+}
+
+// listLimit проверяет размер страницы
+func listLimit(limit int) (int, error) {
+	switch {
+	case limit == 0:
+		return DefaultListLimit, nil
+	case limit < 0 || limit > MaxListLimit:
+		return 0, fmt.Errorf("%w: limit должен быть от 1 до %d", ErrInvalidListOptions, MaxListLimit)
+	default:
+		return limit, nil
+	}
+}
+
+// listSort описывает сортировку листинга
+type listSort struct {
+	field string
+	desc  bool
+}
+
+// parseListSort разбирает параметр sort
+func parseListSort(value string) (listSort, error) {
+	field, desc := strings.CutPrefix(value, "-")
+	switch field {
+	case "", "name", "size", "modified":
+		return listSort{field: field, desc: desc}, nil
+	default:
+		return listSort{}, fmt.Errorf("%w: неизвестная сортировка %s", ErrInvalidListOptions, value)
+	}
+}
+
+// keyOrder сообщает, совпадает ли сортировка с порядком ключей S3
+func (ls listSort) keyOrder() bool {
+	return (ls.field == "" || ls.field == "name") && !ls.desc
+}
+
+// less сравнивает записи: папки идут первыми, ключ различает записи с равными значениями
+func (ls listSort) less(a, b listEntry) bool {
+	aFolder, bFolder := a.folder != "", b.folder != ""
+	if aFolder != bFolder {
+		return aFolder
+	}
+
+	byName := ls.field == "" || ls.field == "name"
+	if ls.desc && (byName || !aFolder) {
+		a, b = b, a
+	}
+
+	// У папок нет размера и даты, поэтому они упорядочиваются только по имени
+	if !aFolder {
+		switch {
+		case ls.field == "size" && a.obj.Size != b.obj.Size:
+			return a.obj.Size < b.obj.Size
+		case ls.field == "modified" && !a.obj.LastModified.Equal(b.obj.LastModified):
+			return a.obj.LastModified.Before(b.obj.LastModified)
+		}
+	}
+
+	return a.obj.Key < b.obj.Key
+}
+
+// sortCursor позиция в отсортированном листинге: значения сортировки последней записи страницы
+type sortCursor struct {
+	Folder   bool      `json:"f,omitempty"`
+	Key      string    `json:"k"`
+	Size     int64     `json:"s,omitempty"`
+	Modified time.Time `json:"m"`
+}
+
+// encodeSortCursor кодирует позицию после записи
+func encodeSortCursor(entry listEntry) string {
+	data, _ := json.Marshal(sortCursor{
+		Folder:   entry.folder != "",
+		Key:      entry.obj.Key,
+		Size:     entry.obj.Size,
+		Modified: entry.obj.LastModified,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeSortCursor восстанавливает запись, после которой продолжается отсортированный листинг
+func decodeSortCursor(cursor string) (*listEntry, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	var pos sortCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &pos)
+	}
+	if err != nil || pos.Key == "" {
+		return nil, fmt.Errorf("%w: курсор", ErrInvalidListOptions)
+	}
+
+	entry := &listEntry{obj: minio.ObjectInfo{Key: pos.Key, Size: pos.Size, LastModified: pos.Modified}}
+	if pos.Folder {
+		// Для сравнения важен только признак папки, имя берется из ключа
+		entry.folder = pos.Key
+	}
+
+	return entry, nil
+}
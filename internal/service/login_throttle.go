@@ -0,0 +1,311 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+)
+
+// Значения ограничений попыток входа по умолчанию
+const (
+	defaultLoginFreeFailures    = 3
+	defaultLoginBaseDelay       = time.Second
+	defaultLoginMaxDelay        = 5 * time.Minute
+	defaultLoginUserLockout     = 10
+	defaultLoginIPLockout       = 30
+	defaultLoginLockoutDuration = 15 * time.Minute
+	defaultLoginFailureWindow   = time.Hour
+
+	maxLoginNameLength = 255 // Длиннее имя в счетчики и журнал не попадает
+)
+
+// ErrLoginThrottled слишком много неудачных попыток входа
+var ErrLoginThrottled = errors.New("слишком много неудачных попыток входа")
+
+// LoginThrottledError возвращается, пока вход с этим именем или адресом задержан после неудачных попыток
+type LoginThrottledError struct {
+	RetryAfter time.Duration // Через сколько можно повторить попытку
+}
+
+// Error возвращает текст ошибки
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("%s, повторите через %d с", ErrLoginThrottled.Error(), e.RetryAfterSeconds())
+}
+
+// Unwrap позволяет проверять ошибку через errors.Is(err, ErrLoginThrottled)
+func (e *LoginThrottledError) Unwrap() error { return ErrLoginThrottled }
+
+// RetryAfterSeconds время до следующей попытки в целых секундах с округлением вверх
+func (e *LoginThrottledError) RetryAfterSeconds() int {
+	return int((e.RetryAfter + time.Second - 1) / time.Second)
+}
+
+// LoginLimits ограничения неудачных попыток входа. Нулевые значения заменяются значениями по умолчанию.
+type LoginLimits struct {
+	FreeFailures    int           // Неудач подряд без задержки
+	BaseDelay       time.Duration // Задержка после первой неудачи сверх FreeFailures; дальше удваивается
+	MaxDelay        time.Duration // Предел задержки
+	UserLockout     int           // Неудач с одним именем, после которых вход блокируется на LockoutDuration
+	IPLockout       int           // Неудач с одного IP-адреса, после которых вход с него блокируется
+	LockoutDuration time.Duration
+	FailureWindow   time.Duration // Неудачи забываются, если новых не было столько времени
+}
+
+// LoginThrottleStore хранилище счетчиков неудачных попыток входа: в памяти или в БД
+type LoginThrottleStore interface {
+	// GetLoginThrottle возвращает счетчик или nil, если неудач не было
+	GetLoginThrottle(key string) (*models.LoginThrottle, error)
+	// ReserveLoginAttempt одним шагом передает check счетчик до попытки (nil, если неудач не было) и, если check
+	// не вернул ошибку, заранее учитывает попытку как неудачу. Если прошлая неудача была раньше resetBefore,
+	// счетчик начинается заново. Параллельные попытки поэтому не проходят проверку раньше, чем учтены предыдущие.
+	ReserveLoginAttempt(key string, at, resetBefore time.Time, check func(*models.LoginThrottle) error) error
+	// ReleaseLoginAttempt снимает со счетчика попытку, которая не оказалась неудачной
+	ReleaseLoginAttempt(key string) error
+	ResetLoginThrottle(key string) error
+}
+
+// withDefaults возвращает ограничения с заполненными значениями по умолчанию
+func (l LoginLimits) withDefaults() LoginLimits {
+	if l.FreeFailures <= 0 {
+		l.FreeFailures = defaultLoginFreeFailures
+	}
+	if l.BaseDelay <= 0 {
+		l.BaseDelay = defaultLoginBaseDelay
+	}
+	if l.MaxDelay <= 0 {
+		l.MaxDelay = defaultLoginMaxDelay
+	}
+	if l.UserLockout <= 0 {
+		l.UserLockout = defaultLoginUserLockout
+	}
+	if l.IPLockout <= 0 {
+		l.IPLockout = defaultLoginIPLockout
+	}
+	if l.LockoutDuration <= 0 {
+		l.LockoutDuration = defaultLoginLockoutDuration
+	}
+	if l.FailureWindow <= 0 {
+		l.FailureWindow = defaultLoginFailureWindow
+	}
+	return l
+}
+
+// blockedUntil возвращает время, до которого попытки входа отклоняются. После FreeFailures неудач
+// задержка растет вдвое с каждой неудачей до MaxDelay, а после lockout неудач вход блокируется на LockoutDuration.
+func (l LoginLimits) blockedUntil(throttle *models.LoginThrottle, lockout int, now time.Time) time.Time {
+	if throttle == nil || throttle.LastFailureAt.Before(now.Add(-l.FailureWindow)) {
+		return time.Time{}
+	}
+	if throttle.Failures >= lockout {
+		return throttle.LastFailureAt.Add(l.LockoutDuration)
+	}
+	if throttle.Failures <= l.FreeFailures {
+		return time.Time{}
+	}
+
+	delay := l.BaseDelay
+	for i := l.FreeFailures + 1; i < throttle.Failures && delay < l.MaxDelay; i++ {
+		delay *= 2
+	}
+	return throttle.LastFailureAt.Add(min(delay, l.MaxDelay))
+}
+
+// loginThrottleKey счетчик неудачных попыток и порог его блокировки
+type loginThrottleKey struct {
+	key     string
+	lockout int
+}
+
+// loginThrottleKeys возвращает ключи счетчиков для IP-адреса и имени пользователя с их порогами блокировки.
+// Адрес идет первым: попытка с заблокированного адреса не должна увеличивать счетчик имени.
+func loginThrottleKeys(username, clientIP string, limits LoginLimits) []loginThrottleKey {
+	var keys []loginThrottleKey
+	if clientIP != "" {
+		keys = append(keys, loginThrottleKey{"ip:" + clientIP, limits.IPLockout})
+	}
+	return append(keys, loginThrottleKey{"user:" + loginName(username), limits.UserLockout})
+}
+
+// loginName приводит имя из запроса входа к виду для счетчиков и журнала
+func loginName(username string) string {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if len(username) > maxLoginNameLength {
+		username = username[:maxLoginNameLength]
+	}
+	return username
+}
+
+// reserveLoginAttempt возвращает LoginThrottledError, если вход с этим именем или адреса еще задержан,
+// а иначе заранее учитывает попытку как неудачную. Попытку с верным паролем или кодом снимает releaseLoginAttempt.
+func (s *Service) reserveLoginAttempt(username, clientIP string) error {
+	if s.LoginThrottle == nil {
+		return nil
+	}
+	limits := s.LoginLimits.withDefaults()
+	now := time.Now()
+
+	var reserved []string
+	for _, k := range loginThrottleKeys(username, clientIP, limits) {
+		err := s.LoginThrottle.ReserveLoginAttempt(k.key, now, now.Add(-limits.FailureWindow), func(throttle *models.LoginThrottle) error {
+			if until := limits.blockedUntil(throttle, k.lockout, now); until.After(now) {
+				return &LoginThrottledError{RetryAfter: until.Sub(now)}
+			}
+			return nil
+		})
+		if err != nil {
+			// Отклоненная попытка не считается неудачей и для уже учтенных счетчиков
+			s.releaseLoginKeys(reserved)
+			return err
+		}
+		reserved = append(reserved, k.key)
+	}
+	return nil
+}
+
+// releaseLoginAttempt снимает учтенную заранее попытку: пароль или код оказался верным,
+// либо проверка не состоялась по причине, не связанной с подбором
+func (s *Service) releaseLoginAttempt(username, clientIP string) {
+	if s.LoginThrottle == nil {
+		return
+	}
+	var keys []string
+	for _, k := range loginThrottleKeys(username, clientIP, s.LoginLimits.withDefaults()) {
+		keys = append(keys, k.key)
+	}
+	s.releaseLoginKeys(keys)
+}
+
+// releaseLoginKeys снимает попытку со счетчиков keys; ошибки только логируются
+func (s *Service) releaseLoginKeys(keys []string) {
+	for _, key := range keys {
+		if err := s.LoginThrottle.ReleaseLoginAttempt(key); err != nil {
+			log.Printf("ошибка снятия попытки входа со счетчика: %v", err)
+		}
+	}
+}
+
+// recordLoginFailure записывает неудачную попытку в журнал; в счетчиках она уже учтена reserveLoginAttempt.
+// Ошибки только логируются: ответ на неудачный вход от них не меняется.
+func (s *Service) recordLoginFailure(username, clientIP, reason string) {
+	err := s.Storagedb.RecordLoginFailure(&models.LoginFailure{
+		Username: loginName(username),
+		IP:       clientIP,
+		Reason:   reason,
+	})
+	if err != nil {
+		log.Printf("ошибка записи неудачной попытки входа: %v", err)
+	}
+}
+
+// resetLoginThrottle сбрасывает счетчик имени после успешного входа. Счетчик адреса не сбрасывается:
+// иначе, входя в свою учетную запись, можно было бы подбирать пароли к чужим без задержек.
+func (s *Service) resetLoginThrottle(username string) {
+	if s.LoginThrottle == nil {
+		return
+	}
+	if err := s.LoginThrottle.ResetLoginThrottle("user:" + loginName(username)); err != nil {
+		log.Printf("ошибка сброса счетчика попыток входа: %v", err)
+	}
+}
+
+// ListLoginFailures возвращает журнал последних неудачных попыток входа (только для администратора)
+func (s *Service) ListLoginFailures(ctx context.Context, adminID, limit int) ([]models.LoginFailure, error) {
+	if err := s.requireAdmin(adminID); err != nil {
+		return nil, err
+	}
+	limit, err := listLimit(limit)
+	if err != nil {
+		return nil, err
+	}
+	return s.Storagedb.ListLoginFailures(limit)
+}
+
+// MemoryLoginThrottle хранит счетчики неудачных попыток входа в памяти процесса.
+// После перезапуска счетчики обнуляются; для их сохранения используется БД.
+type MemoryLoginThrottle struct {
+	mu        sync.Mutex
+	counters  map[string]models.LoginThrottle
+	lastSweep time.Time
+}
+
+// NewMemoryLoginThrottle создает хранилище счетчиков в памяти
+func NewMemoryLoginThrottle() *MemoryLoginThrottle {
+	return &MemoryLoginThrottle{counters: make(map[string]models.LoginThrottle)}
+}
+
+// GetLoginThrottle возвращает счетчик или nil, если неудач не было
+func (m *MemoryLoginThrottle) GetLoginThrottle(key string) (*models.LoginThrottle, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	throttle, ok := m.counters[key]
+	if !ok {
+		return nil, nil
+	}
+	return &throttle, nil
+}
+
+// ReserveLoginAttempt под мьютексом проверяет счетчик и учитывает попытку как неудачу
+func (m *MemoryLoginThrottle) ReserveLoginAttempt(key string, at, resetBefore time.Time, check func(*models.LoginThrottle) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Забытые счетчики удаляются не чаще раза в минуту, иначе адреса сканеров копились бы в памяти
+	if at.Sub(m.lastSweep) > time.Minute {
+		for k, throttle := range m.counters {
+			if throttle.LastFailureAt.Before(resetBefore) {
+				delete(m.counters, k)
+			}
+		}
+		m.lastSweep = at
+	}
+
+	throttle, ok := m.counters[key]
+	if !ok || throttle.LastFailureAt.Before(resetBefore) {
+		throttle = models.LoginThrottle{Key: key}
+	}
+
+	var current *models.LoginThrottle
+	if throttle.Failures > 0 {
+		current = &models.LoginThrottle{Key: key, Failures: throttle.Failures, LastFailureAt: throttle.LastFailureAt}
+	}
+	if err := check(current); err != nil {
+		return err
+	}
+
+	throttle.Failures++
+	throttle.LastFailureAt = at
+	m.counters[key] = throttle
+	return nil
+}
+
+// ReleaseLoginAttempt уменьшает счетчик на одну попытку
+func (m *MemoryLoginThrottle) ReleaseLoginAttempt(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	throttle, ok := m.counters[key]
+	if !ok {
+		return nil
+	}
+	throttle.Failures--
+	if throttle.Failures <= 0 {
+		delete(m.counters, key)
+		return nil
+	}
+	m.counters[key] = throttle
+	return nil
+}
+
+// ResetLoginThrottle сбрасывает счетчик
+func (m *MemoryLoginThrottle) ResetLoginThrottle(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.counters, key)
+	return nil
+}
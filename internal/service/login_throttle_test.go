@@ -0,0 +1,257 @@
+package service_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com.Vova4o/nasforhome/pkg/storagedb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newThrottledService создает сервис с пользователем alice и счетчиками попыток входа в памяти
+func newThrottledService(t *testing.T, limits service.LoginLimits) (*service.Service, *MockStorageDB) {
+	mockStorage := new(MockStorageDB)
+	srv := &service.Service{
+		Storagedb: mockStorage,
+		JWTConfig: service.JWTConfig{
+			AccessSecret:  "test-access-secret",
+			RefreshSecret: "test-refresh-secret",
+			AccessTTL:     900,
+			RefreshTTL:    604800,
+		},
+		LoginThrottle: service.NewMemoryLoginThrottle(),
+		LoginLimits:   limits,
+	}
+
+	hash, err := srv.PasswordHash("secret")
+	require.NoError(t, err)
+	mockStorage.On("GetUserByUsername", "alice").Return(&models.User{
+		ID: 1, UserName: "alice", PasswordHash: hash, ProvisioningState: models.ProvisioningReady,
+	}, nil)
+	mockStorage.On("GetTOTP", 1).Return(nil, nil).Maybe()
+	return srv, mockStorage
+}
+
+// TestLoginThrottleBackoff проверяет задержку после нескольких неверных паролей и запись попыток в журнал
+func TestLoginThrottleBackoff(t *testing.T) {
+	srv, mockStorage := newThrottledService(t, service.LoginLimits{FreeFailures: 2, BaseDelay: time.Minute})
+	ctx := context.Background()
+	mockStorage.On("RecordLoginFailure", &models.LoginFailure{
+		Username: "alice", IP: "192.0.2.1", Reason: models.LoginFailurePassword,
+	}).Return(nil).Times(3)
+
+	// Первые неудачи не задерживают следующую попытку
+	for i := 0; i < 2; i++ {
+		_, _, err := srv.LoginUser(ctx, "alice", "wrong", "192.0.2.1")
+		assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+	}
+	_, tokens, err := srv.LoginUser(ctx, "alice", "wrong", "192.0.2.1")
+	assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+	assert.Nil(t, tokens)
+
+	// После третьей неудачи даже верный пароль не проверяется до истечения задержки
+	_, tokens, err = srv.LoginUser(ctx, "ALICE", "secret", "192.0.2.1")
+	var throttled *service.LoginThrottledError
+	require.ErrorAs(t, err, &throttled)
+	assert.ErrorIs(t, err, service.ErrLoginThrottled)
+	assert.Nil(t, tokens)
+	assert.InDelta(t, 60, throttled.RetryAfterSeconds(), 1)
+
+	// С другого адреса вход с этим именем тоже задержан
+	_, _, err = srv.LoginUser(ctx, "alice", "secret", "198.51.100.7")
+	assert.ErrorIs(t, err, service.ErrLoginThrottled)
+
+	mockStorage.AssertExpectations(t)
+}
+
+// TestLoginThrottleIPLockout проверяет блокировку адреса, с которого перебирают разные имена
+func TestLoginThrottleIPLockout(t *testing.T) {
+	srv, mockStorage := newThrottledService(t, service.LoginLimits{
+		FreeFailures: 10, UserLockout: 10, IPLockout: 3, LockoutDuration: time.Hour,
+	})
+	mockStorage.On("CreateSession", mock.Anything).Return(nil).Maybe()
+	ctx := context.Background()
+	mockStorage.On("RecordLoginFailure", mock.Anything).Return(nil)
+
+	for i := 0; i < 3; i++ {
+		_, _, err := srv.LoginUser(ctx, "alice", "wrong", "192.0.2.1")
+		assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+	}
+
+	var throttled *service.LoginThrottledError
+	_, _, err := srv.LoginUser(ctx, "alice", "secret", "192.0.2.1")
+	require.ErrorAs(t, err, &throttled)
+	assert.InDelta(t, 3600, throttled.RetryAfterSeconds(), 1)
+
+	// С другого адреса верный пароль принимается: имя заблокировано еще не было
+	_, tokens, err := srv.LoginUser(ctx, "alice", "secret", "198.51.100.7")
+	require.NoError(t, err)
+	assert.NotNil(t, tokens)
+
+	mockStorage.AssertExpectations(t)
+}
+
+// TestLoginThrottleUnknownUsers проверяет, что перебор несуществующих имен тоже учитывается для адреса
+func TestLoginThrottleUnknownUsers(t *testing.T) {
+	srv, mockStorage := newThrottledService(t, service.LoginLimits{
+		FreeFailures: 10, UserLockout: 10, IPLockout: 3, LockoutDuration: time.Hour,
+	})
+	ctx := context.Background()
+	var recorded []*models.LoginFailure
+	mockStorage.On("RecordLoginFailure", mock.Anything).Run(func(args mock.Arguments) {
+		recorded = append(recorded, args.Get(0).(*models.LoginFailure))
+	}).Return(nil)
+
+	for _, name := range []string{"bob", "carol", "dave"} {
+		mockStorage.On("GetUserByUsername", name).Return(nil, fmt.Errorf("ошибка получения пользователя: %w", storagedb.ErrUserNotFound)).Once()
+		_, _, err := srv.LoginUser(ctx, name, "secret", "192.0.2.1")
+		assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+	}
+	require.Len(t, recorded, 3, "Попытки с несуществующими именами записываются в журнал")
+	assert.Equal(t, "carol", recorded[1].Username)
+
+	// Адрес заблокирован до проверки пароля даже для существующего пользователя
+	var throttled *service.LoginThrottledError
+	_, _, err := srv.LoginUser(ctx, "alice", "secret", "192.0.2.1")
+	require.ErrorAs(t, err, &throttled)
+	mockStorage.AssertNotCalled(t, "GetUserByUsername", "alice")
+}
+
+// TestLoginThrottleReset проверяет, что успешный вход сбрасывает счетчик имени
+func TestLoginThrottleReset(t *testing.T) {
+	srv, mockStorage := newThrottledService(t, service.LoginLimits{FreeFailures: 2, BaseDelay: time.Minute})
+	mockStorage.On("CreateSession", mock.Anything).Return(nil).Maybe()
+	ctx := context.Background()
+	mockStorage.On("RecordLoginFailure", mock.Anything).Return(nil)
+
+	for i := 0; i < 2; i++ {
+		_, _, err := srv.LoginUser(ctx, "alice", "wrong", "192.0.2.1")
+		assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+	}
+	_, _, err := srv.LoginUser(ctx, "alice", "secret", "198.51.100.7")
+	require.NoError(t, err)
+
+	// Счетчик имени начат заново, поэтому две новые неудачи снова без задержки
+	for i := 0; i < 2; i++ {
+		_, _, err := srv.LoginUser(ctx, "alice", "wrong", "198.51.100.7")
+		assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+	}
+	_, _, err = srv.LoginUser(ctx, "alice", "secret", "198.51.100.7")
+	assert.NoError(t, err)
+}
+
+// TestChangePasswordThrottle проверяет, что подбор текущего пароля при его смене задерживается как подбор при входе
+func TestChangePasswordThrottle(t *testing.T) {
+	srv, mockStorage := newThrottledService(t, service.LoginLimits{
+		FreeFailures: 1, BaseDelay: time.Minute, MaxDelay: time.Hour, FailureWindow: time.Hour,
+	})
+	hash, err := srv.PasswordHash("secret")
+	require.NoError(t, err)
+	mockStorage.On("GetUserByID", 1).Return(&models.User{ID: 1, UserName: "alice", PasswordHash: hash}, nil)
+	mockStorage.On("RecordLoginFailure", &models.LoginFailure{
+		Username: "alice", IP: "192.0.2.1", Reason: models.LoginFailurePassword,
+	}).Return(nil).Twice()
+
+	ctx := service.WithClient(context.Background(), service.ClientInfo{IP: "192.0.2.1"})
+	for i := 0; i < 2; i++ {
+		_, err := srv.ChangePassword(ctx, 1, "wrong", "new-password")
+		assert.ErrorIs(t, err, service.ErrAccessDenied)
+	}
+
+	// Даже верный пароль не проверяется, пока действует задержка
+	_, err = srv.ChangePassword(ctx, 1, "secret", "new-password")
+	assert.ErrorIs(t, err, service.ErrLoginThrottled)
+
+	// Задержка общая со входом
+	_, _, err = srv.LoginUser(ctx, "alice", "secret", "198.51.100.7")
+	assert.ErrorIs(t, err, service.ErrLoginThrottled)
+	mockStorage.AssertNumberOfCalls(t, "RecordLoginFailure", 2)
+}
+
+// TestMemoryLoginThrottle проверяет счетчик в памяти: резервирование, снятие, сброс и начало заново после окна
+func TestMemoryLoginThrottle(t *testing.T) {
+	store := service.NewMemoryLoginThrottle()
+	now := time.Now()
+	allow := func(*models.LoginThrottle) error { return nil }
+
+	throttle, err := store.GetLoginThrottle("ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.Nil(t, throttle)
+
+	require.NoError(t, store.ReserveLoginAttempt("ip:192.0.2.1", now, now.Add(-time.Hour), allow))
+	require.NoError(t, store.ReserveLoginAttempt("ip:192.0.2.1", now, now.Add(-time.Hour), allow))
+	throttle, err = store.GetLoginThrottle("ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, throttle.Failures)
+
+	// Отклоненная проверкой попытка счетчик не меняет
+	errBlocked := fmt.Errorf("вход задержан")
+	err = store.ReserveLoginAttempt("ip:192.0.2.1", now, now.Add(-time.Hour), func(throttle *models.LoginThrottle) error {
+		assert.Equal(t, 2, throttle.Failures, "Проверка видит счетчик до попытки")
+		return errBlocked
+	})
+	assert.ErrorIs(t, err, errBlocked)
+
+	require.NoError(t, store.ReleaseLoginAttempt("ip:192.0.2.1"))
+	throttle, err = store.GetLoginThrottle("ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, throttle.Failures)
+
+	// Прошлая неудача вне окна: счетчик начинается заново
+	later := now.Add(2 * time.Hour)
+	err = store.ReserveLoginAttempt("ip:192.0.2.1", later, later.Add(-time.Hour), func(throttle *models.LoginThrottle) error {
+		assert.Nil(t, throttle)
+		return nil
+	})
+	require.NoError(t, err)
+	throttle, err = store.GetLoginThrottle("ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, throttle.Failures)
+
+	require.NoError(t, store.ResetLoginThrottle("ip:192.0.2.1"))
+	throttle, err = store.GetLoginThrottle("ip:192.0.2.1")
+	require.NoError(t, err)
+	assert.Nil(t, throttle)
+}
+
+// TestLoginThrottleBurst проверяет, что параллельные попытки не проходят проверку все сразу
+func TestLoginThrottleBurst(t *testing.T) {
+	srv, mockStorage := newThrottledService(t, service.LoginLimits{
+		FreeFailures: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, FailureWindow: time.Hour,
+	})
+	mockStorage.On("RecordLoginFailure", mock.Anything).Return(nil)
+
+	const attempts = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := srv.LoginUser(context.Background(), "alice", "wrong", "192.0.2.1")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	checked := 0
+	for err := range errs {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			checked++
+			continue
+		}
+		assert.ErrorIs(t, err, service.ErrLoginThrottled)
+	}
+	// Бесплатные неудачи и одна попытка после них, следующая уже ждет задержку
+	assert.Equal(t, 3, checked, "Пароль проверяется только для попыток, прошедших счетчик")
+	mockStorage.AssertNumberOfCalls(t, "RecordLoginFailure", 3)
+}
@@ -0,0 +1,286 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com.Vova4o/nasforhome/pkg/totp"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Параметры второго фактора
+const (
+	totpIssuer        = "NASForHome"    // Название сервиса в приложении-аутентификаторе
+	totpSkew          = 1               // Допустимое расхождение часов в шагах
+	mfaChallengeTTL   = 5 * time.Minute // Время на ввод кода после проверки пароля
+	mfaChallengeRole  = "mfa"           // Роль токена, подтверждающего только пароль
+	recoveryCodeCount = 10
+	recoveryCodeSize  = 10 // Символов в коде восстановления без дефиса
+)
+
+// Ошибки второго фактора
+var (
+	ErrMFARequired       = errors.New("требуется код второго фактора")
+	ErrInvalidMFACode    = fmt.Errorf("%w: неверный или уже использованный код", ErrAccessDenied)
+	ErrMFAAlreadyEnabled = fmt.Errorf("%w: двухфакторная аутентификация уже включена", ErrConflict)
+	ErrMFANotEnabled     = fmt.Errorf("%w: двухфакторная аутентификация не включена", ErrConflict)
+)
+
+// MFARequiredError возвращается при входе пользователя со вторым фактором вместо пары токенов.
+// Token подтверждает проверку пароля и обменивается на пару токенов вместе с кодом.
+type MFARequiredError struct {
+	Token     string
+	ExpiresIn int // Время жизни токена в секундах
+}
+
+// Error возвращает текст ошибки
+func (e *MFARequiredError) Error() string { return ErrMFARequired.Error() }
+
+// Unwrap позволяет проверять ошибку через errors.Is(err, ErrMFARequired)
+func (e *MFARequiredError) Unwrap() error { return ErrMFARequired }
+
+// TOTPEnrollment данные для добавления секрета в приложение-аутентификатор
+type TOTPEnrollment struct {
+	Secret string
+	URI    string // otpauth URI для QR-кода
+}
+
+// recoveryCodeEncoding строчный base32: коды восстановления удобно вводить вручную
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// MFAEnabled сообщает, включен ли у пользователя второй фактор
+func (s *Service) MFAEnabled(userID int) (bool, error) {
+	secret, err := s.Storagedb.GetTOTP(userID)
+	if err != nil {
+		return false, err
+	}
+	return secret != nil && secret.Enabled, nil
+}
+
+// BeginTOTPEnrollment создает новый секрет. Второй фактор включается только после подтверждения кодом,
+// поэтому повторный вызов до подтверждения просто заменяет секрет.
+func (s *Service) BeginTOTPEnrollment(ctx context.Context, userID int) (*TOTPEnrollment, error) {
+	user, err := s.Storagedb.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := s.Storagedb.SaveTOTPSecret(userID, secret)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	return &TOTPEnrollment{Secret: secret, URI: totp.URI(totpIssuer, user.UserName, secret)}, nil
+}
+
+// ConfirmTOTPEnrollment включает второй фактор по коду из приложения и возвращает коды восстановления.
+// Коды показываются один раз: в БД хранятся только их хеши.
+func (s *Service) ConfirmTOTPEnrollment(ctx context.Context, userID int, code string) ([]string, error) {
+	secret, err := s.Storagedb.GetTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("%w: сначала получите секрет", ErrMFANotEnabled)
+	}
+	if secret.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	counter, ok := totp.Validate(secret.Secret, strings.TrimSpace(code), time.Now(), totpSkew)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	enabled, err := s.Storagedb.EnableTOTP(userID, counter, hashes)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	return codes, nil
+}
+
+// DisableTOTP отключает второй фактор; для этого нужен текущий пароль
+func (s *Service) DisableTOTP(ctx context.Context, userID int, password string) error {
+	user, err := s.Storagedb.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	ok, err := s.checkPassword(ctx, user, password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: неверный пароль", ErrAccessDenied)
+	}
+
+	enabled, err := s.MFAEnabled(userID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrMFANotEnabled
+	}
+	return s.Storagedb.DeleteTOTP(userID)
+}
+
+// RegenerateRecoveryCodes заменяет коды восстановления новыми; для этого нужен действующий код второго фактора
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID int, code string) ([]string, error) {
+	if err := s.verifySecondFactor(userID, code); err != nil {
+		return nil, err
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Storagedb.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CompleteMFALogin завершает вход: проверяет токен первого шага и код второго фактора и выдает пару токенов
+func (s *Service) CompleteMFALogin(ctx context.Context, challenge, code, clientIP string) (*models.User, *TokenPair, error) {
+	claims, err := s.parseAccessToken(challenge)
+	if err != nil || claims.Role != mfaChallengeRole {
+		return nil, nil, fmt.Errorf("%w: недействительный или истекший токен входа", ErrInvalidToken)
+	}
+
+	user, err := s.Storagedb.GetUserByID(claims.UserID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка аутентификации: %w", err)
+	}
+	// Смена пароля после первого шага делает токен недействительным
+	if claims.Version != user.TokenVersion {
+		return nil, nil, ErrTokenRevoked
+	}
+
+	// Подбор кода задерживается так же, как подбор пароля
+	if err := s.reserveLoginAttempt(user.UserName, clientIP); err != nil {
+		return nil, nil, err
+	}
+	if err := s.verifySecondFactor(user.ID, code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			s.recordLoginFailure(user.UserName, clientIP, models.LoginFailureMFA)
+		} else {
+			s.releaseLoginAttempt(user.UserName, clientIP)
+		}
+		return nil, nil, err
+	}
+	s.releaseLoginAttempt(user.UserName, clientIP)
+	s.resetLoginThrottle(user.UserName)
+
+	tokens, err := s.startSession(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, tokens, nil
+}
+
+// mfaChallenge возвращает ошибку с токеном первого шага, если у пользователя включен второй фактор
+func (s *Service) mfaChallenge(user *models.User) error {
+	enabled, err := s.MFAEnabled(user.ID)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+
+	now := time.Now()
+	claims := &Claims{
+		UserID:  user.ID,
+		Role:    mfaChallengeRole,
+		Version: user.TokenVersion,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: now.Add(mfaChallengeTTL).Unix(),
+			IssuedAt:  now.Unix(),
+			Subject:   user.UserName,
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(s.JWTConfig.AccessSecret))
+	if err != nil {
+		return fmt.Errorf("ошибка создания токена входа: %w", err)
+	}
+
+	return &MFARequiredError{Token: token, ExpiresIn: int(mfaChallengeTTL.Seconds())}
+}
+
+// verifySecondFactor проверяет код из приложения или код восстановления. Каждый код принимается один раз.
+func (s *Service) verifySecondFactor(userID int, code string) error {
+	secret, err := s.Storagedb.GetTOTP(userID)
+	if err != nil {
+		return err
+	}
+	if secret == nil || !secret.Enabled {
+		return ErrMFANotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		counter, ok := totp.Validate(secret.Secret, code, time.Now(), totpSkew)
+		if !ok {
+			return ErrInvalidMFACode
+		}
+		fresh, err := s.Storagedb.UseTOTPCounter(userID, counter)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	used, err := s.Storagedb.UseRecoveryCode(userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// generateRecoveryCodes возвращает новые коды восстановления вида xxxxx-xxxxx и их хеши
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		raw := make([]byte, recoveryCodeSize*5/8)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, fmt.Errorf("ошибка генерации кода восстановления: %w", err)
+		}
+		code := recoveryCodeEncoding.EncodeToString(raw)
+		codes = append(codes, code[:recoveryCodeSize/2]+"-"+code[recoveryCodeSize/2:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode приводит введенный код восстановления к виду, от которого считается хеш
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// MoveCondition условия перемещения файла
+type MoveCondition struct {
+	IfMatch string // Ожидаемый ETag перемещаемого файла; пустая строка — без проверки
+	// Не перезаписывать существующий файл в месте назначения. В отличие от IfMatch проверка не атомарна:
+	// файл, созданный в месте назначения между проверкой и копированием, будет перезаписан.
+	IfNoneMatch bool
+}
+
+// MoveUserFile перемещает (переименовывает) файл пользователя.
+// Если условия не выполнены, возвращает ErrConflict и ничего не меняет.
+// Если исходный файл не удалось удалить, копия удаляется, чтобы файл не оказался в двух местах.
+func (s *Service) MoveUserFile(ctx context.Context, userID int, from, to string, cond MoveCondition) (minio.UploadInfo, error) {
+	if err := ValidateObjectKey(from); err != nil {
+		return minio.UploadInfo{}, err
+	}
+	if err := ValidateObjectKey(to); err != nil {
+		return minio.UploadInfo{}, err
+	}
+	if from == to {
+		return minio.UploadInfo{}, fmt.Errorf("%w: файл уже находится по этому пути", ErrInvalidPath)
+	}
+
+	result, err := s.ExecuteFileOperation(ctx, userID, func(ctx context.Context, minioClient MinioClientInterface, bucketName string) (any, error) {
+		src, err := minioClient.StatObject(ctx, bucketName, from, minio.StatObjectOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("ошибка получения информации о файле: %w", err)
+		}
+
+		// MinIO-клиент не передает условие на место назначения при копировании, поэтому проверяем заранее.
+		// Между проверкой и копированием остается окно, в которое другой клиент может создать файл.
+		if cond.IfNoneMatch {
+			_, err := minioClient.StatObject(ctx, bucketName, to, minio.StatObjectOptions{})
+			if err == nil {
+				return nil, fmt.Errorf("%w: файл %s уже существует", ErrConflict, to)
+			}
+			if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+				return nil, fmt.Errorf("ошибка получения информации о файле: %w", err)
+			}
+		}
+
+		// Условие на ETag источника проверяется самим MinIO при копировании
+		info, err := minioClient.CopyObject(ctx,
+			minio.CopyDestOptions{Bucket: bucketName, Object: to},
+			minio.CopySrcOptions{Bucket: bucketName, Object: from, MatchETag: cond.IfMatch},
+		)
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+				return nil, fmt.Errorf("%w: файл %s был изменен", ErrConflict, from)
+			}
+			return nil, fmt.Errorf("ошибка копирования файла: %w", err)
+		}
+
+		if err := minioClient.RemoveObject(ctx, bucketName, from, minio.RemoveObjectOptions{}); err != nil {
+			// Откат не должен зависеть от отмены запроса, из-за которой удаление могло не пройти
+			rollbackErr := minioClient.RemoveObject(context.WithoutCancel(ctx), bucketName, to, minio.RemoveObjectOptions{})
+			if rollbackErr != nil {
+				return nil, fmt.Errorf("ошибка удаления исходного файла: %w; копия %s не удалена: %v", err, to, rollbackErr)
+			}
+			return nil, fmt.Errorf("ошибка удаления исходного файла: %w", err)
+		}
+
+		// Ответ на копирование не содержит размера
+		info.Size = src.Size
+		return info, nil
+	})
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+
+	info, ok := result.(minio.UploadInfo)
+	if !ok {
+		return minio.UploadInfo{}, fmt.Errorf("не удалось преобразовать результат в minio.UploadInfo")
+	}
+
+	s.recordChange(ctx, userID, FileEvent{Type: FileMoved, Key: to, OldKey: from, Size: info.Size, ETag: info.ETag})
+	return info, nil
+}
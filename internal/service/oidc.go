@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// oidcStateTTL время на вход у поставщика учетных записей
+const oidcStateTTL = 10 * time.Minute
+
+// Ошибки входа через OpenID Connect
+var (
+	ErrOIDCDisabled      = fmt.Errorf("%w: вход через поставщика учетных записей не настроен", ErrAccessDenied)
+	ErrInvalidOIDCLogin  = fmt.Errorf("%w: вход через поставщика учетных записей не подтвержден", ErrAccessDenied)
+	ErrOIDCUserNotFound  = fmt.Errorf("%w: учетная запись поставщика не связана с пользователем", ErrAccessDenied)
+	ErrOIDCUsernameTaken = fmt.Errorf("%w: имя пользователя из учетной записи поставщика уже занято", ErrConflict)
+)
+
+// OIDCConfig параметры входа через поставщика OpenID Connect (Authelia, Keycloak и т. п.)
+type OIDCConfig struct {
+	Issuer       string // Адрес поставщика; пустое значение отключает вход
+	ClientID     string
+	ClientSecret string // Пусто для публичного клиента: обмен кода защищен только PKCE
+	RedirectURL  string // Страница веб-интерфейса, на которую поставщик возвращает код
+	// AutoProvision создает пользователя при первом входе, если учетную запись не удалось связать
+	// с существующим. Имя берется из preferred_username, адрес почты должен быть подтвержден поставщиком.
+	AutoProvision bool
+}
+
+// Validate проверяет, что для включенного входа через поставщика указаны все параметры
+func (c OIDCConfig) Validate() error {
+	if c.Issuer == "" {
+		return nil
+	}
+	var missing []string
+	if c.ClientID == "" {
+		missing = append(missing, "ClientID")
+	}
+	if c.RedirectURL == "" {
+		missing = append(missing, "RedirectURL")
+	}
+	if len(missing) > 0 {
+		return errors.New("для входа через поставщика учетных записей не указаны " + strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// oidcClient клиент поставщика, настроенный по его документу обнаружения
+type oidcClient struct {
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// oidcClaims данные пользователя из ID токена
+type oidcClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// oidcClientCache клиент поставщика создается при первом входе: недоступный поставщик
+// не должен мешать запуску сервера, а при ошибке обнаружение повторяется
+type oidcClientCache struct {
+	mu     sync.Mutex
+	client *oidcClient
+}
+
+// getOIDCClient возвращает клиент поставщика, при необходимости загружая документ обнаружения
+func (s *Service) getOIDCClient(ctx context.Context) (*oidcClient, error) {
+	if s.OIDC.Issuer == "" {
+		return nil, ErrOIDCDisabled
+	}
+
+	s.oidcCache.mu.Lock()
+	defer s.oidcCache.mu.Unlock()
+	if s.oidcCache.client != nil {
+		return s.oidcCache.client, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, s.OIDC.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения настроек поставщика учетных записей: %w", err)
+	}
+	s.oidcCache.client = &oidcClient{
+		oauth2: oauth2.Config{
+			ClientID:     s.OIDC.ClientID,
+			ClientSecret: s.OIDC.ClientSecret,
+			RedirectURL:  s.OIDC.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "profile", "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: s.OIDC.ClientID}),
+	}
+	return s.oidcCache.client, nil
+}
+
+// BeginOIDCLogin начинает вход через поставщика и возвращает адрес, на который нужно перейти, и ключ браузера.
+// Параметр state, секрет PKCE и nonce сохраняются на сервере и проверяются при завершении входа.
+// Ключ браузера сохраняется в cookie: без него вход не завершится, поэтому ссылку с кодом
+// из чужого входа нельзя подсунуть другому человеку.
+func (s *Service) BeginOIDCLogin(ctx context.Context) (authURL, browserKey string, err error) {
+	client, err := s.getOIDCClient(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := s.generateSecretKey(userTokenLength)
+	if err != nil {
+		return "", "", fmt.Errorf("ошибка генерации состояния входа: %w", err)
+	}
+	nonce, err := s.generateSecretKey(userTokenLength)
+	if err != nil {
+		return "", "", fmt.Errorf("ошибка генерации состояния входа: %w", err)
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	err = s.Storagedb.CreateOIDCState(&models.OIDCState{
+		StateHash:    hashToken(state),
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		ExpiresAt:    time.Now().Add(oidcStateTTL),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	authURL = client.oauth2.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oidc.Nonce(nonce))
+	return authURL, hashToken(state), nil
+}
+
+// FinishOIDCLogin обменивает код поставщика на ID токен, находит по нему пользователя и выдает
+// пару токенов сервиса, как вход по паролю. Второй фактор здесь не запрашивается: его проверяет поставщик.
+// browserKey — ключ из BeginOIDCLogin, сохраненный в браузере, который начал вход.
+func (s *Service) FinishOIDCLogin(ctx context.Context, code, state, browserKey string) (*models.User, *TokenPair, error) {
+	client, err := s.getOIDCClient(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if code == "" || state == "" {
+		return nil, nil, ErrInvalidOIDCLogin
+	}
+	// Проверяется до использования state, чтобы чужой запрос не мог сорвать вход владельца
+	if subtle.ConstantTimeCompare([]byte(browserKey), []byte(hashToken(state))) != 1 {
+		return nil, nil, fmt.Errorf("%w: вход начат в другом браузере", ErrInvalidOIDCLogin)
+	}
+
+	stored, err := s.Storagedb.ConsumeOIDCState(hashToken(state))
+	if err != nil {
+		return nil, nil, err
+	}
+	if stored == nil {
+		return nil, nil, fmt.Errorf("%w: вход истек или уже завершен", ErrInvalidOIDCLogin)
+	}
+
+	token, err := client.oauth2.Exchange(ctx, code, oauth2.VerifierOption(stored.CodeVerifier))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: ошибка обмена кода: %v", ErrInvalidOIDCLogin, err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: поставщик не вернул ID токен", ErrInvalidOIDCLogin)
+	}
+	idToken, err := client.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidOIDCLogin, err)
+	}
+	if idToken.Nonce != stored.Nonce {
+		return nil, nil, fmt.Errorf("%w: ID токен выдан для другого входа", ErrInvalidOIDCLogin)
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidOIDCLogin, err)
+	}
+
+	user, err := s.oidcUser(ctx, idToken.Issuer, idToken.Subject, claims)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Те же правила, что и при входе по паролю
+	if user.ProvisioningState != models.ProvisioningReady && user.ProvisioningState != models.ProvisioningUnverified {
+		return nil, nil, ErrAccountNotReady
+	}
+
+	tokens, err := s.startSession(ctx, user)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка создания токенов: %w", err)
+	}
+	return user, tokens, nil
+}
+
+// oidcUser находит пользователя по учетной записи поставщика. Учетная запись, с которой еще не входили,
+// связывается с пользователем по адресу почты, если поставщик его подтвердил, а при AutoProvision
+// для нее создается новый пользователь.
+func (s *Service) oidcUser(ctx context.Context, issuer, subject string, claims oidcClaims) (*models.User, error) {
+	userID, err := s.Storagedb.GetUserIDByIdentity(issuer, subject)
+	if err != nil {
+		return nil, err
+	}
+	if userID != 0 {
+		return s.Storagedb.GetUserByID(userID)
+	}
+
+	// Неподтвержденному адресу доверять нельзя: иначе можно войти в чужую учетную запись, указав ее адрес
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, fmt.Errorf("%w: поставщик не подтвердил адрес почты", ErrOIDCUserNotFound)
+	}
+	email, err := normalizeEmail(claims.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.Storagedb.GetUserByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		if !s.OIDC.AutoProvision {
+			return nil, ErrOIDCUserNotFound
+		}
+		if user, err = s.provisionOIDCUser(ctx, claims.PreferredUsername, email); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.Storagedb.LinkUserIdentity(issuer, subject, user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// provisionOIDCUser создает пользователя для учетной записи поставщика
+func (s *Service) provisionOIDCUser(ctx context.Context, username, email string) (*models.User, error) {
+	username = strings.ToLower(username)
+	if err := ValidateUsername(username); err != nil {
+		return nil, err
+	}
+	if _, err := s.Storagedb.GetUserByUsername(username); err == nil {
+		return nil, ErrOIDCUsernameTaken
+	}
+	return s.provisionVerifiedUser(ctx, username, email)
+}
@@ -0,0 +1,353 @@
+package service_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com.Vova4o/nasforhome/pkg/storagedb"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/minio/madmin-go/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// stubIdP поставщик OpenID Connect для тестов: документ обнаружения, ключи и обмен кода с проверкой PKCE
+type stubIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]stubGrant // Выданные коды авторизации
+}
+
+// stubGrant код авторизации с вызовом PKCE и данными будущего ID токена
+type stubGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idp := &stubIdP{key: key, grants: make(map[string]stubGrant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// authorize проходит вход у поставщика по адресу из BeginOIDCLogin и возвращает код и state для FinishOIDCLogin
+func (idp *stubIdP) authorize(t *testing.T, authURL string, claims jwt.MapClaims) (code, state string) {
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+	query := u.Query()
+	require.Equal(t, "S256", query.Get("code_challenge_method"), "Вход должен использовать PKCE")
+	require.NotEmpty(t, query.Get("nonce"))
+
+	token := jwt.MapClaims{
+		"iss":   idp.server.URL,
+		"aud":   query.Get("client_id"),
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for name, value := range claims {
+		token[name] = value
+	}
+
+	code = base64.RawURLEncoding.EncodeToString([]byte(time.Now().String()))
+	idp.mu.Lock()
+	idp.grants[code] = stubGrant{challenge: query.Get("code_challenge"), claims: token}
+	idp.mu.Unlock()
+	return code, query.Get("state")
+}
+
+// token обменивает код на ID токен, если секрет PKCE соответствует вызову
+func (idp *stubIdP) token(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	grant, ok := idp.grants[r.PostFormValue("code")]
+	delete(idp.grants, r.PostFormValue("code"))
+	idp.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.claims)
+	token.Header["kid"] = "test"
+	idToken, err := token.SignedString(idp.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": "idp-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// expectOIDCState сохраняет состояние входа в моке, чтобы его можно было погасить один раз
+func expectOIDCState(mockStorage *MockStorageDB) {
+	var mu sync.Mutex
+	states := make(map[string]*models.OIDCState)
+	mockStorage.On("CreateOIDCState", mock.Anything).Run(func(args mock.Arguments) {
+		state := args.Get(0).(*models.OIDCState)
+		mu.Lock()
+		states[state.StateHash] = state
+		mu.Unlock()
+	}).Return(nil)
+	consume := mockStorage.On("ConsumeOIDCState", mock.Anything)
+	consume.Run(func(args mock.Arguments) {
+		mu.Lock()
+		defer mu.Unlock()
+		stateHash := args.String(0)
+		consume.ReturnArguments = mock.Arguments{states[stateHash], nil}
+		delete(states, stateHash)
+	})
+}
+
+func newOIDCService(idp *stubIdP) (*service.Service, *MockStorageDB) {
+	mockStorage := new(MockStorageDB)
+	srv := &service.Service{
+		Storagedb: mockStorage,
+		JWTConfig: service.JWTConfig{
+			AccessSecret:  "test-access-secret",
+			RefreshSecret: "test-refresh-secret",
+			AccessTTL:     900,
+			RefreshTTL:    604800,
+		},
+		OIDC: service.OIDCConfig{
+			Issuer:       idp.server.URL,
+			ClientID:     "nas",
+			ClientSecret: "nas-secret",
+			RedirectURL:  "https://nas.example.com/login/oidc",
+		},
+	}
+	// Каждый вход создает сеанс
+	// Токены выдаются в сеансе 1, который остается действующим
+	mockStorage.On("CreateSession", mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(0).(*models.Session).ID = 1
+	}).Return(nil).Maybe()
+	mockStorage.On("SessionActive", mock.Anything, 1).Return(true, nil).Maybe()
+	expectOIDCState(mockStorage)
+	return srv, mockStorage
+}
+
+// TestOIDCLogin проверяет вход через поставщика по связанной учетной записи и по подтвержденному адресу
+func TestOIDCLogin(t *testing.T) {
+	idp := newStubIdP(t)
+	srv, mockStorage := newOIDCService(idp)
+	ctx := context.Background()
+
+	login := func(claims jwt.MapClaims) (*models.User, *service.TokenPair, error) {
+		authURL, browserKey, err := srv.BeginOIDCLogin(ctx)
+		require.NoError(t, err)
+		code, state := idp.authorize(t, authURL, claims)
+		return srv.FinishOIDCLogin(ctx, code, state, browserKey)
+	}
+
+	// Учетная запись, с которой уже входили, находится по sub
+	alice := &models.User{ID: 1, UserName: "alice", ProvisioningState: models.ProvisioningReady}
+	mockStorage.On("GetUserIDByIdentity", idp.server.URL, "sub-alice").Return(1, nil)
+	mockStorage.On("GetUserByID", 1).Return(alice, nil)
+
+	user, tokens, err := login(jwt.MapClaims{"sub": "sub-alice"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, user.ID)
+	claims, err := srv.AuthenticateAccessToken(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, 1, claims.UserID)
+
+	// Код и state действуют один раз
+	authURL, browserKey, err := srv.BeginOIDCLogin(ctx)
+	require.NoError(t, err)
+	code, state := idp.authorize(t, authURL, jwt.MapClaims{"sub": "sub-alice"})
+	_, _, err = srv.FinishOIDCLogin(ctx, code, state, browserKey)
+	require.NoError(t, err)
+	_, _, err = srv.FinishOIDCLogin(ctx, code, state, browserKey)
+	assert.ErrorIs(t, err, service.ErrInvalidOIDCLogin)
+
+	// Новая учетная запись связывается с пользователем по адресу, подтвержденному поставщиком
+	bob := &models.User{ID: 2, UserName: "bob", Email: "bob@example.com", ProvisioningState: models.ProvisioningReady}
+	mockStorage.On("GetUserIDByIdentity", idp.server.URL, "sub-bob").Return(0, nil)
+	mockStorage.On("GetUserByEmail", "bob@example.com").Return(bob, nil)
+	mockStorage.On("LinkUserIdentity", idp.server.URL, "sub-bob", 2).Return(nil).Once()
+
+	user, _, err = login(jwt.MapClaims{"sub": "sub-bob", "email": "bob@example.com", "email_verified": true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, user.ID)
+
+	// Неподтвержденному адресу не доверяем
+	mockStorage.On("GetUserIDByIdentity", idp.server.URL, "sub-mallory").Return(0, nil)
+	_, _, err = login(jwt.MapClaims{"sub": "sub-mallory", "email": "bob@example.com", "email_verified": false})
+	assert.ErrorIs(t, err, service.ErrOIDCUserNotFound)
+
+	// Без автоматического создания незнакомый пользователь не входит
+	mockStorage.On("GetUserIDByIdentity", idp.server.URL, "sub-carol").Return(0, nil)
+	mockStorage.On("GetUserByEmail", "carol@example.com").Return(nil, nil)
+	_, _, err = login(jwt.MapClaims{"sub": "sub-carol", "email": "carol@example.com", "email_verified": true})
+	assert.ErrorIs(t, err, service.ErrOIDCUserNotFound)
+
+	mockStorage.AssertExpectations(t)
+	mockStorage.AssertNumberOfCalls(t, "LinkUserIdentity", 1)
+}
+
+// TestOIDCLoginRejectsForeignVerifier проверяет, что код не обменивается без секрета PKCE этого входа
+func TestOIDCLoginRejectsForeignVerifier(t *testing.T) {
+	idp := newStubIdP(t)
+	srv, mockStorage := newOIDCService(idp)
+	ctx := context.Background()
+
+	authURL, _, err := srv.BeginOIDCLogin(ctx)
+	require.NoError(t, err)
+	code, _ := idp.authorize(t, authURL, jwt.MapClaims{"sub": "sub-alice"})
+
+	// Код перехвачен и предъявлен с state другого входа
+	otherURL, otherKey, err := srv.BeginOIDCLogin(ctx)
+	require.NoError(t, err)
+	_, otherState := idp.authorize(t, otherURL, jwt.MapClaims{"sub": "sub-alice"})
+
+	_, _, err = srv.FinishOIDCLogin(ctx, code, otherState, otherKey)
+	assert.ErrorIs(t, err, service.ErrInvalidOIDCLogin)
+	mockStorage.AssertNotCalled(t, "GetUserIDByIdentity", mock.Anything, mock.Anything)
+}
+
+// TestOIDCLoginRequiresBrowserKey проверяет, что вход завершается только в браузере, который его начал:
+// ссылку с кодом из своего входа нельзя подсунуть другому человеку
+func TestOIDCLoginRequiresBrowserKey(t *testing.T) {
+	idp := newStubIdP(t)
+	srv, mockStorage := newOIDCService(idp)
+	ctx := context.Background()
+
+	authURL, browserKey, err := srv.BeginOIDCLogin(ctx)
+	require.NoError(t, err)
+	code, state := idp.authorize(t, authURL, jwt.MapClaims{"sub": "sub-mallory"})
+
+	// У жертвы нет cookie или в нем ключ ее собственного входа
+	_, otherKey, err := srv.BeginOIDCLogin(ctx)
+	require.NoError(t, err)
+	for _, key := range []string{"", otherKey} {
+		_, _, err = srv.FinishOIDCLogin(ctx, code, state, key)
+		assert.ErrorIs(t, err, service.ErrInvalidOIDCLogin)
+	}
+	mockStorage.AssertNotCalled(t, "GetUserIDByIdentity", mock.Anything, mock.Anything)
+
+	// Неудачные попытки не расходуют state: владелец входа завершает его
+	mockStorage.On("GetUserIDByIdentity", idp.server.URL, "sub-mallory").Return(3, nil)
+	mockStorage.On("GetUserByID", 3).Return(&models.User{ID: 3, UserName: "mallory", ProvisioningState: models.ProvisioningReady}, nil)
+	user, _, err := srv.FinishOIDCLogin(ctx, code, state, browserKey)
+	require.NoError(t, err)
+	assert.Equal(t, 3, user.ID)
+}
+
+// TestOIDCAutoProvision проверяет создание пользователя при первом входе теми же шагами, что и при регистрации
+func TestOIDCAutoProvision(t *testing.T) {
+	idp := newStubIdP(t)
+	srv, mockStorage, mockAdmin, mockBuckets := newProvisioningService()
+	srv.OIDC = service.OIDCConfig{
+		Issuer:        idp.server.URL,
+		ClientID:      "nas",
+		RedirectURL:   "https://nas.example.com/login/oidc",
+		AutoProvision: true,
+	}
+	expectOIDCState(mockStorage)
+	ctx := context.Background()
+
+	mockStorage.On("GetUserIDByIdentity", idp.server.URL, "sub-alice").Return(0, nil)
+	mockStorage.On("GetUserByEmail", "a@example.com").Return(nil, nil)
+	mockStorage.On("GetUserByUsername", "alice").Return(nil, storagedb.ErrUserNotFound)
+	storageName, _ := expectCreateUser(mockStorage, "alice", 7)
+
+	// Адрес подтвержден поставщиком, поэтому хранилище создается сразу
+	mockStorage.On("SetProvisioningState", 7, models.ProvisioningPending, models.ProvisioningUnverified).Return(true, nil).Once()
+	mockStorage.On("MarkEmailVerified", 7).Return(true, nil).Once()
+	mockAdmin.On("AddUser", mock.Anything, storageName, mock.Anything).Return(nil).Once()
+	mockAdmin.On("AddCannedPolicy", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+	mockAdmin.On("AttachPolicy", mock.Anything, mock.Anything).Return(madmin.PolicyAssociationResp{}, nil).Once()
+	mockBuckets.On("MakeBucket", mock.Anything, storageName, mock.Anything).Return(nil).Once()
+	mockStorage.On("SetProvisioningState", 7, models.ProvisioningPending, models.ProvisioningMinioUser).Return(true, nil).Once()
+	mockStorage.On("SetProvisioningState", 7, models.ProvisioningMinioUser, models.ProvisioningPolicy).Return(true, nil).Once()
+	mockStorage.On("SetProvisioningState", 7, models.ProvisioningPolicy, models.ProvisioningReady).Return(true, nil).Once()
+	mockStorage.On("GetUserByID", 7).Return(&models.User{ID: 7, UserName: "alice", Email: "a@example.com",
+		EmailVerified: true, ProvisioningState: models.ProvisioningReady}, nil).Once()
+	mockStorage.On("LinkUserIdentity", idp.server.URL, "sub-alice", 7).Return(nil).Once()
+
+	authURL, browserKey, err := srv.BeginOIDCLogin(ctx)
+	require.NoError(t, err)
+	code, state := idp.authorize(t, authURL, jwt.MapClaims{
+		"sub":                "sub-alice",
+		"email":              "a@example.com",
+		"email_verified":     true,
+		"preferred_username": "Alice",
+	})
+	user, tokens, err := srv.FinishOIDCLogin(ctx, code, state, browserKey)
+	require.NoError(t, err)
+	assert.Equal(t, 7, user.ID)
+	assert.True(t, user.EmailVerified)
+	assert.NotNil(t, tokens)
+
+	// Занятое имя не отдается другой учетной записи поставщика
+	mockStorage.On("GetUserIDByIdentity", idp.server.URL, "sub-bob").Return(0, nil)
+	mockStorage.On("GetUserByEmail", "bob@example.com").Return(nil, nil)
+	mockStorage.On("GetUserByUsername", "bob").Return(&models.User{ID: 2, UserName: "bob"}, nil)
+
+	authURL, browserKey, err = srv.BeginOIDCLogin(ctx)
+	require.NoError(t, err)
+	code, state = idp.authorize(t, authURL, jwt.MapClaims{
+		"sub":                "sub-bob",
+		"email":              "bob@example.com",
+		"email_verified":     true,
+		"preferred_username": "bob",
+	})
+	_, _, err = srv.FinishOIDCLogin(ctx, code, state, browserKey)
+	assert.ErrorIs(t, err, service.ErrOIDCUsernameTaken)
+
+	mockStorage.AssertExpectations(t)
+	mockAdmin.AssertExpectations(t)
+	mockBuckets.AssertExpectations(t)
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+	"unicode/utf8"
+
+	"github.com.Vova4o/nasforhome/pkg/mailer"
+	"github.com.Vova4o/nasforhome/pkg/models"
+)
+
+// Ограничения длины пароля; bcrypt учитывает только первые 72 байта
+const (
+	minPasswordLength = 8
+	maxPasswordBytes  = 72
+)
+
+// passwordResetTokenTTL срок действия кода сброса пароля
+const passwordResetTokenTTL = time.Hour
+
+// ErrWeakPassword возвращается для пароля, не подходящего под ограничения длины
+var ErrWeakPassword = errors.New("недопустимый пароль")
+
+// ValidatePassword проверяет длину нового пароля
+func ValidatePassword(password string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return fmt.Errorf("%w: пароль должен быть не короче %d символов", ErrWeakPassword, minPasswordLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("%w: пароль должен быть не длиннее %d байт", ErrWeakPassword, maxPasswordBytes)
+	}
+	return nil
+}
+
+// ChangePassword меняет пароль по текущему паролю. Все выданные ранее токены, включая токены API, отзываются,
+// а для текущего клиента возвращается новая пара, чтобы он остался в системе.
+// Подбор текущего пароля по украденному сеансу задерживается так же, как подбор пароля при входе.
+// С внешним каталогом пароль меняется только в каталоге.
+func (s *Service) ChangePassword(ctx context.Context, userID int, oldPassword, newPassword string) (*TokenPair, error) {
+	if s.Authenticator != nil {
+		return nil, ErrDirectoryManagedPassword
+	}
+
+	user, err := s.Storagedb.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	clientIP := clientFromContext(ctx).IP
+	if err := s.reserveLoginAttempt(user.UserName, clientIP); err != nil {
+		return nil, err
+	}
+	if !s.VerifyPassword(oldPassword, user.PasswordHash) {
+		s.recordLoginFailure(user.UserName, clientIP, models.LoginFailurePassword)
+		return nil, fmt.Errorf("%w: неверный текущий пароль", ErrAccessDenied)
+	}
+	s.releaseLoginAttempt(user.UserName, clientIP)
+	s.resetLoginThrottle(user.UserName)
+
+	if err := s.setPassword(userID, newPassword); err != nil {
+		return nil, err
+	}
+
+	// Перечитываем пользователя, чтобы новые токены получили новую версию
+	user, err = s.Storagedb.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения данных пользователя: %w", err)
+	}
+	return s.startSession(ctx, user)
+}
+
+// RequestPasswordReset отправляет код сброса пароля на адрес пользователя.
+// Ответ не зависит от того, есть ли такой адрес, ни по содержанию, ни по времени: поиск пользователя
+// и отправка письма идут в фоне, а их ошибки только логируются. Иначе перебором можно было бы узнать адреса.
+func (s *Service) RequestPasswordReset(ctx context.Context, email string) error {
+	if s.Authenticator != nil {
+		return ErrDirectoryManagedPassword
+	}
+	if s.Mailer == nil {
+		return errMailNotConfigured
+	}
+
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := s.sendPasswordReset(context.WithoutCancel(ctx), email); err != nil {
+			log.Printf("ошибка отправки кода сброса пароля: %v", err)
+		}
+	}()
+	return nil
+}
+
+// sendPasswordReset отправляет код сброса пароля, если адрес принадлежит пользователю
+func (s *Service) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := s.Storagedb.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	token, err := s.issueUserToken(user.ID, models.TokenPurposePasswordReset, user.Email, passwordResetTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.sendMail(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Сброс пароля",
+		Body: fmt.Sprintf("Для сброса пароля пользователя %s введите код:\n\n%s\n\n"+
+			"Код действует %d мин. и может быть использован один раз. "+
+			"Если вы не запрашивали сброс, просто проигнорируйте это письмо.",
+			user.UserName, token, int(passwordResetTokenTTL.Minutes())),
+	})
+}
+
+// ResetPassword устанавливает новый пароль по коду из письма и отзывает все выданные токены, включая токены API
+func (s *Service) ResetPassword(ctx context.Context, token, newPassword string) error {
+	if s.Authenticator != nil {
+		return ErrDirectoryManagedPassword
+	}
+
+	// Пароль проверяется до погашения кода, чтобы из-за слабого пароля не пришлось запрашивать код заново
+	if err := ValidatePassword(newPassword); err != nil {
+		return err
+	}
+
+	stored, err := s.consumeUserToken(token, models.TokenPurposePasswordReset)
+	if err != nil {
+		return err
+	}
+
+	return s.setPassword(stored.UserID, newPassword)
+}
+
+// setPassword проверяет и сохраняет новый пароль; версия токенов пользователя при этом увеличивается
+func (s *Service) setPassword(userID int, password string) error {
+	if err := ValidatePassword(password); err != nil {
+		return err
+	}
+
+	passwordHash, err := s.PasswordHash(password)
+	if err != nil {
+		return err
+	}
+	return s.Storagedb.UpdatePassword(userID, passwordHash)
+}
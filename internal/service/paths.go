@@ -0,0 +1,65 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxObjectKeyLength максимальная длина ключа объекта в S3
+const maxObjectKeyLength = 1024
+
+// ErrInvalidPath возвращается для небезопасных или некорректных путей
+var ErrInvalidPath = errors.New("недопустимый путь")
+
+// ValidateObjectKey проверяет ключ файла или папки (папка заканчивается на "/").
+// Отклоняет пустой ключ, "." и "..", пустые сегменты и управляющие символы.
+func ValidateObjectKey(key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: пустой путь", ErrInvalidPath)
+	}
+	return ValidatePrefix(key)
+}
+
+// ValidatePrefix проверяет префикс для листинга; в отличие от ключа, он может быть пустым
+func ValidatePrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if len(prefix) > maxObjectKeyLength {
+		return fmt.Errorf("%w: путь длиннее %d байт", ErrInvalidPath, maxObjectKeyLength)
+	}
+	if !utf8.ValidString(prefix) {
+		return fmt.Errorf("%w: путь не в UTF-8", ErrInvalidPath)
+	}
+
+	for _, r := range prefix {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("%w: управляющий символ в %q", ErrInvalidPath, prefix)
+		}
+	}
+
+	// Завершающий "/" обозначает папку и не считается пустым сегментом
+	for _, segment := range strings.Split(strings.TrimSuffix(prefix, "/"), "/") {
+		switch segment {
+		case "":
+			return fmt.Errorf("%w: пустой сегмент в %q", ErrInvalidPath, prefix)
+		case ".", "..":
+			return fmt.Errorf("%w: сегмент %q в %q", ErrInvalidPath, segment, prefix)
+		}
+	}
+
+	return nil
+}
+
+// folderKey проверяет имя папки и добавляет завершающий "/"
+func folderKey(folderName string) (string, error) {
+	if err := ValidateObjectKey(folderName); err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(folderName, "/") {
+		folderName += "/"
+	}
+	return folderName, nil
+}
@@ -0,0 +1,43 @@
+package service_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestValidateObjectKey проверяет общий валидатор путей
+func TestValidateObjectKey(t *testing.T) {
+	valid := []string{
+		"file.txt",
+		"photos/2024/sea.jpg",
+		"photos/2024/",
+		"Фото/отпуск.jpg",
+		"name with spaces.txt",
+	}
+	for _, key := range valid {
+		assert.NoError(t, service.ValidateObjectKey(key), "Путь %q должен быть допустимым", key)
+	}
+
+	invalid := []string{
+		"",
+		"/etc/passwd",
+		"photos//sea.jpg",
+		"../secret",
+		"photos/../../secret",
+		"photos/./sea.jpg",
+		"bad\nname",
+		"bad\x7fname",
+		"\xff\xfe",
+		strings.Repeat("a", 1025),
+	}
+	for _, key := range invalid {
+		err := service.ValidateObjectKey(key)
+		assert.ErrorIs(t, err, service.ErrInvalidPath, "Путь %q должен отклоняться", key)
+	}
+
+	assert.NoError(t, service.ValidatePrefix(""), "Пустой префикс допустим для листинга")
+	assert.Error(t, service.ValidatePrefix("a//"), "Пустой сегмент в префиксе недопустим")
+}
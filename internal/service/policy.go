@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/minio/madmin-go/v3"
+)
+
+// Действия S3, разрешаемые ролям в общих пространствах
+var (
+	spaceBucketActions = []string{"s3:ListBucket", "s3:GetBucketLocation", "s3:ListBucketMultipartUploads"}
+	spaceReadActions   = []string{"s3:GetObject"}
+	spaceWriteActions  = []string{"s3:GetObject", "s3:PutObject", "s3:DeleteObject", "s3:AbortMultipartUpload", "s3:ListMultipartUploadParts"}
+)
+
+// Действия S3, разрешаемые пользователю в его бакете. Настройки бакета, в том числе s3:PutBucketPolicy,
+// не разрешаются: иначе своими ключами MinIO пользователь мог бы открыть бакет для чтения без входа.
+var (
+	userBucketActions = []string{"s3:ListBucket", "s3:GetBucketLocation"}
+	userObjectActions = spaceWriteActions
+)
+
+// legacyUserPolicy встроенная политика MinIO, которую раньше получали все пользователи.
+// Она открывает доступ ко всем бакетам, поэтому заменяется политикой с доступом только к своему бакету.
+const legacyUserPolicy = "readwrite"
+
+// policyAlreadyApplied код ошибки MinIO, когда политика уже привязана или уже отвязана
+const policyAlreadyApplied = "XMinioAdminPolicyChangeAlreadyApplied"
+
+// policyDocument IAM-политика MinIO
+type policyDocument struct {
+	Version   string            `json:"Version"`
+	Statement []policyStatement `json:"Statement"`
+}
+
+// policyStatement правило IAM-политики
+type policyStatement struct {
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+// bucketARN возвращает ARN бакета или объектов в нем
+func bucketARN(bucketName string, objects bool) string {
+	if objects {
+		return "arn:aws:s3:::" + bucketName + "/*"
+	}
+	return "arn:aws:s3:::" + bucketName
+}
+
+// userPolicyName имя политики MinIO с доступом пользователя к его бакету
+func userPolicyName(accessKey string) string {
+	return "nas-user-" + accessKey
+}
+
+// userPolicy формирует политику, разрешающую листинг и работу с объектами только в бакете пользователя.
+// При запуске ReconcileUserPolicies заменяет ею политики, выданные раньше.
+func userPolicy(bucketName string) ([]byte, error) {
+	return json.Marshal(policyDocument{
+		Version: "2012-10-17",
+		Statement: []policyStatement{
+			{Effect: "Allow", Action: userBucketActions, Resource: []string{bucketARN(bucketName, false)}},
+			{Effect: "Allow", Action: userObjectActions, Resource: []string{bucketARN(bucketName, true)}},
+		},
+	})
+}
+
+// applyUserPolicy сохраняет политику пользователя и привязывает ее к его ключу MinIO. Операция идемпотентна.
+func (s *Service) applyUserPolicy(ctx context.Context, bucketName, accessKey string) error {
+	admin := s.Admin
+	name := userPolicyName(accessKey)
+
+	policy, err := userPolicy(bucketName)
+	if err != nil {
+		return fmt.Errorf("ошибка формирования политики: %w", err)
+	}
+	if err := admin.AddCannedPolicy(ctx, name, policy); err != nil {
+		return fmt.Errorf("ошибка сохранения политики %s: %w", name, err)
+	}
+	_, err = admin.AttachPolicy(ctx, madmin.PolicyAssociationReq{Policies: []string{name}, User: accessKey})
+	if err != nil && madmin.ToErrorResponse(err).Code != policyAlreadyApplied {
+		return fmt.Errorf("ошибка привязки политики %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// ReconcileUserPolicies приводит политики MinIO всех пользователей к ожидаемым: привязывает политику
+// с доступом только к своему бакету, отвязывает старую глобальную readwrite и пересобирает
+// политики общих пространств и групп. Вызывается при запуске сервера.
+func (s *Service) ReconcileUserPolicies(ctx context.Context) error {
+	if s.Admin == nil {
+		return nil
+	}
+	admin := s.Admin
+
+	users, err := s.Storagedb.ListUsers()
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, user := range users {
+		// Пользователь, для которого еще не создано хранилище, получит политику при создании
+		if user.MinioBucketName == "" || user.MinioAccessKey == "" || user.ProvisioningState != models.ProvisioningReady {
+			continue
+		}
+
+		// Новая политика привязывается до отвязки старой, чтобы пользователь не остался без доступа к своему бакету
+		if err := s.applyUserPolicy(ctx, user.MinioBucketName, user.MinioAccessKey); err != nil {
+			log.Printf("ошибка применения политики пользователя %s: %v", user.UserName, err)
+			failed++
+			continue
+		}
+		_, err := admin.DetachPolicy(ctx, madmin.PolicyAssociationReq{Policies: []string{legacyUserPolicy}, User: user.MinioAccessKey})
+		if err != nil && madmin.ToErrorResponse(err).Code != policyAlreadyApplied {
+			log.Printf("ошибка отвязки политики %s от пользователя %s: %v", legacyUserPolicy, user.UserName, err)
+			failed++
+			continue
+		}
+
+		s.refreshSharesPolicies(ctx, user.ID)
+		groups, err := s.Storagedb.ListUserGroups(user.ID)
+		if err != nil {
+			log.Printf("ошибка получения групп пользователя %s: %v", user.UserName, err)
+			continue
+		}
+		for _, group := range groups {
+			// Политика группы пересобирается при обходе ее владельца
+			if group.OwnerID == user.ID {
+				s.refreshGroupPolicy(ctx, &group)
+				s.refreshGroupMembers(ctx, &group)
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("не удалось обновить политики %d из %d пользователей", failed, len(users))
+	}
+	return nil
+}
+
+// sharesPolicyName имя политики MinIO с доступом пользователя к общим пространствам
+func sharesPolicyName(accessKey string) string {
+	return "nas-shares-" + accessKey
+}
+
+// sharesPolicy формирует политику доступа к общим пространствам в соответствии с ролями пользователя
+func sharesPolicy(spaces []models.SharedSpace) ([]byte, error) {
+	var buckets, readable, writable []string
+	for _, space := range spaces {
+		buckets = append(buckets, bucketARN(space.BucketName, false))
+		if space.Role == models.SpaceRoleViewer {
+			readable = append(readable, bucketARN(space.BucketName, true))
+		} else {
+			writable = append(writable, bucketARN(space.BucketName, true))
+		}
+	}
+
+	doc := policyDocument{Version: "2012-10-17"}
+	if len(buckets) > 0 {
+		doc.Statement = append(doc.Statement, policyStatement{Effect: "Allow", Action: spaceBucketActions, Resource: buckets})
+	}
+	if len(readable) > 0 {
+		doc.Statement = append(doc.Statement, policyStatement{Effect: "Allow", Action: spaceReadActions, Resource: readable})
+	}
+	if len(writable) > 0 {
+		doc.Statement = append(doc.Statement, policyStatement{Effect: "Allow", Action: spaceWriteActions, Resource: writable})
+	}
+
+	return json.Marshal(doc)
+}
+
+// groupPolicyName имя политики MinIO с доступом группы к общим пространствам
+func groupPolicyName(groupName string) string {
+	return "nas-shares-group-" + groupName
+}
+
+// syncSharesPolicy пересобирает и привязывает к пользователю MinIO политику доступа к общим пространствам.
+// В политику попадает только прямой доступ: доступ через группы дает политика группы.
+func (s *Service) syncSharesPolicy(ctx context.Context, userID int) error {
+	if s.Admin == nil {
+		return nil
+	}
+
+	_, accessKey, _, err := s.Storagedb.GetMinIOCredentials(userID)
+	if err != nil {
+		return fmt.Errorf("ошибка получения данных MinIO: %w", err)
+	}
+	spaces, err := s.Storagedb.ListUserDirectSharedSpaces(userID)
+	if err != nil {
+		return err
+	}
+
+	return s.applySharesPolicy(ctx, sharesPolicyName(accessKey), madmin.PolicyAssociationReq{User: accessKey}, spaces)
+}
+
+// syncGroupPolicy пересобирает и привязывает к группе MinIO политику доступа к общим пространствам
+func (s *Service) syncGroupPolicy(ctx context.Context, group *models.Group) error {
+	if s.Admin == nil {
+		return nil
+	}
+
+	spaces, err := s.Storagedb.ListGroupSharedSpaces(group.ID)
+	if err != nil {
+		return err
+	}
+
+	return s.applySharesPolicy(ctx, groupPolicyName(group.Name), madmin.PolicyAssociationReq{Group: minioGroupName(group.Name)}, spaces)
+}
+
+// applySharesPolicy сохраняет политику и привязывает ее к пользователю или группе из assoc.
+// Операция идемпотентна; если пространств не осталось, политика отвязывается и удаляется.
+func (s *Service) applySharesPolicy(ctx context.Context, name string, assoc madmin.PolicyAssociationReq, spaces []models.SharedSpace) error {
+	admin := s.Admin
+	assoc.Policies = []string{name}
+
+	if len(spaces) == 0 {
+		_, err := admin.DetachPolicy(ctx, assoc)
+		if err != nil && madmin.ToErrorResponse(err).Code != policyAlreadyApplied {
+			// Политики могло и не быть, поэтому ошибки отвязки и удаления не мешают работе
+			log.Printf("ошибка отвязки политики %s: %v", name, err)
+		}
+		if err := admin.RemoveCannedPolicy(ctx, name); err != nil {
+			log.Printf("ошибка удаления политики %s: %v", name, err)
+		}
+		return nil
+	}
+
+	policy, err := sharesPolicy(spaces)
+	if err != nil {
+		return fmt.Errorf("ошибка формирования политики: %w", err)
+	}
+	if err := admin.AddCannedPolicy(ctx, name, policy); err != nil {
+		return fmt.Errorf("ошибка сохранения политики %s: %w", name, err)
+	}
+	_, err = admin.AttachPolicy(ctx, assoc)
+	if err != nil && madmin.ToErrorResponse(err).Code != policyAlreadyApplied {
+		return fmt.Errorf("ошибка привязки политики %s: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSharesPolicy проверяет, что роль viewer получает только чтение, а editor — запись
+func TestSharesPolicy(t *testing.T) {
+	raw, err := sharesPolicy([]models.SharedSpace{
+		{BucketName: "shared-family", Role: models.SpaceRoleEditor},
+		{BucketName: "shared-photos", Role: models.SpaceRoleViewer},
+	})
+	require.NoError(t, err)
+
+	var doc policyDocument
+	require.NoError(t, json.Unmarshal(raw, &doc))
+	require.Len(t, doc.Statement, 3)
+
+	assert.Equal(t, []string{"arn:aws:s3:::shared-family", "arn:aws:s3:::shared-photos"}, doc.Statement[0].Resource)
+	assert.Equal(t, []string{"arn:aws:s3:::shared-photos/*"}, doc.Statement[1].Resource)
+	assert.NotContains(t, doc.Statement[1].Action, "s3:PutObject", "Viewer не должен получать запись")
+	assert.Equal(t, []string{"arn:aws:s3:::shared-family/*"}, doc.Statement[2].Resource)
+	assert.Contains(t, doc.Statement[2].Action, "s3:PutObject")
+}
+
+// TestUserPolicy проверяет, что политика пользователя открывает только его бакет
+func TestUserPolicy(t *testing.T) {
+	raw, err := userPolicy("user-alice")
+	require.NoError(t, err)
+
+	var doc policyDocument
+	require.NoError(t, json.Unmarshal(raw, &doc))
+	require.Len(t, doc.Statement, 2)
+
+	assert.Equal(t, []string{"arn:aws:s3:::user-alice"}, doc.Statement[0].Resource)
+	assert.Equal(t, []string{"s3:ListBucket", "s3:GetBucketLocation"}, doc.Statement[0].Action)
+	assert.Equal(t, []string{"arn:aws:s3:::user-alice/*"}, doc.Statement[1].Resource)
+	assert.Contains(t, doc.Statement[1].Action, "s3:PutObject")
+	for _, statement := range doc.Statement {
+		assert.NotContains(t, statement.Action, "s3:*")
+		assert.NotContains(t, statement.Action, "s3:PutBucketPolicy", "Пользователь не должен открывать свой бакет для всех")
+	}
+	assert.Equal(t, "nas-user-user-alice", userPolicyName("user-alice"))
+}
@@ -0,0 +1,320 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/minio/madmin-go/v3"
+	"github.com/minio/minio-go/v7"
+)
+
+// provisioningStaleAfter время без продвижения, после которого создание пользователя считается прерванным.
+// Меньшее значение позволило бы реконсилеру вмешаться в регистрацию, которая еще выполняется.
+const provisioningStaleAfter = 5 * time.Minute
+
+// provisioningReconcileInterval период повторной проверки прерванных созданий пользователей
+const provisioningReconcileInterval = provisioningStaleAfter
+
+// Создание хранилища в запросе повторяется provisioningAttempts раз; пауза перед повтором начинается
+// с provisioningRetryDelay и удваивается. Не созданное за эти попытки доводит реконсилер.
+const (
+	provisioningAttempts   = 3
+	provisioningRetryDelay = 500 * time.Millisecond
+)
+
+// maxProvisioningFailures число неудачных проходов реконсилера, после которого создание откатывается
+// и при временных сбоях. Проходы идут не чаще раза в provisioningStaleAfter, то есть откат наступает примерно через час.
+const maxProvisioningFailures = 12
+
+// transientMinioCodes коды ошибок MinIO, после которых запрос можно повторить
+var transientMinioCodes = map[string]bool{
+	"InternalError":              true,
+	"RequestTimeout":             true,
+	"ServiceUnavailable":         true,
+	"SlowDown":                   true,
+	"XMinioServerNotInitialized": true,
+}
+
+// Коды ошибок MinIO, означающие, что удаляемого объекта уже нет
+const (
+	noSuchUser   = "XMinioAdminNoSuchUser"
+	noSuchPolicy = "XMinioAdminNoSuchPolicy"
+)
+
+// ErrProvisioningConflict возвращается, если состояние создания пользователя изменил другой процесс
+var ErrProvisioningConflict = errors.New("создание пользователя выполняется другим процессом")
+
+// ErrAccountNotReady возвращается при входе пользователя, хранилище которого еще не создано
+var ErrAccountNotReady = errors.New("учетная запись еще не готова")
+
+// provisioningStep шаг создания хранилища: выполняется в состоянии from и переводит в состояние to.
+// Шаги идемпотентны, поэтому прерванное создание можно повторить с последнего сохраненного состояния.
+type provisioningStep struct {
+	from, to string
+	run      func(ctx context.Context, user *models.User) error
+}
+
+// provisioningSteps возвращает шаги создания хранилища в порядке выполнения
+func (s *Service) provisioningSteps() []provisioningStep {
+	return []provisioningStep{
+		{models.ProvisioningPending, models.ProvisioningMinioUser, s.provisionMinioUser},
+		{models.ProvisioningMinioUser, models.ProvisioningPolicy, s.provisionPolicy},
+		{models.ProvisioningPolicy, models.ProvisioningReady, s.provisionBucket},
+	}
+}
+
+// provision выполняет оставшиеся шаги создания хранилища, сохраняя состояние после каждого
+func (s *Service) provision(ctx context.Context, user *models.User) error {
+	if s.Admin == nil || s.Buckets == nil {
+		return fmt.Errorf("административное подключение к MinIO не настроено")
+	}
+
+	for _, step := range s.provisioningSteps() {
+		if user.ProvisioningState != step.from {
+			continue
+		}
+		if err := step.run(ctx, user); err != nil {
+			return err
+		}
+		if err := s.advanceProvisioning(user, step.to); err != nil {
+			return err
+		}
+	}
+
+	if user.ProvisioningState != models.ProvisioningReady {
+		return fmt.Errorf("неизвестное состояние создания пользователя: %s", user.ProvisioningState)
+	}
+
+	// Пользователя могли добавить в группы до создания хранилища; теперь он попадает и в группы MinIO
+	s.refreshUserGroups(ctx, user.ID)
+	return nil
+}
+
+// provisionWithRetry выполняет provision, повторяя его с растущей паузой после временных сбоев
+func (s *Service) provisionWithRetry(ctx context.Context, user *models.User) error {
+	delay := provisioningRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := s.provision(ctx, user)
+		if err == nil || attempt == provisioningAttempts || !transientProvisioningError(err) {
+			return err
+		}
+
+		log.Printf("сбой создания хранилища пользователя %s, повтор через %v: %v", user.UserName, delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// transientProvisioningError сообщает, можно ли повторить создание после ошибки err.
+// Окончательными считаются только явные отказы MinIO: сетевые сбои, ошибки БД и перегрузка MinIO проходят сами.
+func transientProvisioningError(err error) bool {
+	if errors.Is(err, ErrProvisioningConflict) {
+		return false
+	}
+
+	var s3Err minio.ErrorResponse
+	if errors.As(err, &s3Err) {
+		if transientMinioCodes[s3Err.Code] {
+			return true
+		}
+		switch {
+		case s3Err.StatusCode >= http.StatusInternalServerError,
+			s3Err.StatusCode == http.StatusRequestTimeout,
+			s3Err.StatusCode == http.StatusTooManyRequests:
+			return true
+		}
+		return false
+	}
+
+	var adminErr madmin.ErrorResponse
+	if errors.As(err, &adminErr) {
+		return transientMinioCodes[adminErr.Code]
+	}
+	return true
+}
+
+// advanceProvisioning сохраняет переход в состояние to; переход выполняется, только если
+// состояние в БД совпадает с известным, поэтому два процесса не продвигают одно создание одновременно
+func (s *Service) advanceProvisioning(user *models.User, to string) error {
+	ok, err := s.Storagedb.SetProvisioningState(user.ID, user.ProvisioningState, to)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w: пользователь %s", ErrProvisioningConflict, user.UserName)
+	}
+
+	user.ProvisioningState = to
+	return nil
+}
+
+// provisionMinioUser создает пользователя MinIO; для существующего AddUser только обновляет ключ
+func (s *Service) provisionMinioUser(ctx context.Context, user *models.User) error {
+	if err := s.Admin.AddUser(ctx, user.MinioAccessKey, user.MinioSecretKey); err != nil {
+		return fmt.Errorf("ошибка создания пользователя в MinIO: %w", err)
+	}
+	return nil
+}
+
+// provisionPolicy привязывает политику с доступом только к бакету пользователя
+func (s *Service) provisionPolicy(ctx context.Context, user *models.User) error {
+	if err := s.applyUserPolicy(ctx, user.MinioBucketName, user.MinioAccessKey); err != nil {
+		return fmt.Errorf("ошибка привязки политики к пользователю: %w", err)
+	}
+	return nil
+}
+
+// provisionBucket создает бакет пользователя и задает его квоту; уже созданный при прошлой попытке бакет не считается ошибкой
+func (s *Service) provisionBucket(ctx context.Context, user *models.User) error {
+	err := s.Buckets.MakeBucket(ctx, user.MinioBucketName, minio.MakeBucketOptions{})
+	if err != nil && minio.ToErrorResponse(err).Code != "BucketAlreadyOwnedByYou" {
+		return fmt.Errorf("ошибка создания бакета: %w", err)
+	}
+
+	if user.QuotaBytes > 0 {
+		// Quota оставлен для серверов MinIO, которые еще не знают поле Size
+		err := s.Admin.SetBucketQuota(ctx, user.MinioBucketName, &madmin.BucketQuota{
+			Quota: uint64(user.QuotaBytes),
+			Size:  uint64(user.QuotaBytes),
+			Type:  madmin.HardQuota,
+		})
+		if err != nil {
+			return fmt.Errorf("ошибка установки квоты бакета: %w", err)
+		}
+	}
+	return nil
+}
+
+// rollbackProvisioning удаляет созданное в MinIO и запись пользователя.
+// Состояние rollback сохраняется до удаления, поэтому при ошибке откат будет повторен реконсилером.
+func (s *Service) rollbackProvisioning(ctx context.Context, user *models.User) error {
+	if user.ProvisioningState != models.ProvisioningRollback {
+		if err := s.advanceProvisioning(user, models.ProvisioningRollback); err != nil {
+			return err
+		}
+	}
+
+	// Удаляем в обратном порядке; отсутствие удаляемого не ошибка, так как шаг мог не выполниться.
+	// Бакет удаляется без ForceDelete: непустой бакет создан не этой регистрацией.
+	var errs []error
+	err := s.Buckets.RemoveBucket(ctx, user.MinioBucketName)
+	if err != nil && minio.ToErrorResponse(err).Code != "NoSuchBucket" {
+		errs = append(errs, fmt.Errorf("ошибка удаления бакета: %w", err))
+	}
+	err = s.Admin.RemoveUser(ctx, user.MinioAccessKey)
+	if err != nil && madmin.ToErrorResponse(err).Code != noSuchUser {
+		errs = append(errs, fmt.Errorf("ошибка удаления пользователя MinIO: %w", err))
+	}
+	err = s.Admin.RemoveCannedPolicy(ctx, userPolicyName(user.MinioAccessKey))
+	if err != nil && madmin.ToErrorResponse(err).Code != noSuchPolicy {
+		errs = append(errs, fmt.Errorf("ошибка удаления политики: %w", err))
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	if err := s.Storagedb.DeleteUser(user.ID); err != nil {
+		return fmt.Errorf("ошибка удаления пользователя: %w", err)
+	}
+	return nil
+}
+
+// ReconcileProvisioning доводит до конца или откатывает создание пользователей, прерванное
+// перезапуском или сбоем. Вызывается при запуске сервера и затем периодически из RunProvisioningReconciler.
+func (s *Service) ReconcileProvisioning(ctx context.Context) error {
+	if s.Admin == nil || s.Buckets == nil {
+		return nil
+	}
+
+	users, err := s.Storagedb.ListStaleProvisioning(time.Now().Add(-provisioningStaleAfter))
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for i := range users {
+		user := &users[i]
+		if err := s.reconcileUser(ctx, user); err != nil {
+			log.Printf("ошибка восстановления создания пользователя %s: %v", user.UserName, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("не удалось восстановить создание %d из %d пользователей", failed, len(users))
+	}
+	return nil
+}
+
+// RunProvisioningReconciler повторяет ReconcileProvisioning до отмены ctx.
+// Создание, прерванное незадолго до перезапуска, при запуске еще не считается устаревшим
+// и будет восстановлено одним из следующих проходов.
+func (s *Service) RunProvisioningReconciler(ctx context.Context) {
+	s.runProvisioningReconciler(ctx, provisioningReconcileInterval)
+}
+
+// runProvisioningReconciler повторяет ReconcileProvisioning каждые interval до отмены ctx
+func (s *Service) runProvisioningReconciler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.ReconcileProvisioning(ctx); err != nil {
+				log.Printf("ошибка восстановления создания пользователей: %v", err)
+			}
+		}
+	}
+}
+
+// reconcileUser продолжает создание хранилища пользователя. Создание откатывается после окончательной ошибки
+// или maxProvisioningFailures неудачных проходов; после временного сбоя оно повторяется следующим проходом.
+func (s *Service) reconcileUser(ctx context.Context, user *models.User) error {
+	if user.ProvisioningState != models.ProvisioningRollback {
+		err := s.provision(ctx, user)
+		if err == nil {
+			log.Printf("создание пользователя %s завершено", user.UserName)
+			return nil
+		}
+		if errors.Is(err, ErrProvisioningConflict) {
+			// Создание продолжил другой процесс
+			return nil
+		}
+
+		if transientProvisioningError(err) {
+			failures, recErr := s.Storagedb.AddProvisioningFailure(user.ID)
+			if recErr != nil {
+				return errors.Join(err, recErr)
+			}
+			if failures < maxProvisioningFailures {
+				return fmt.Errorf("создание будет повторено, неудачных попыток %d из %d: %w", failures, maxProvisioningFailures, err)
+			}
+			log.Printf("создание пользователя %s не удалось за %d попыток, выполняется откат: %v", user.UserName, failures, err)
+		} else {
+			log.Printf("не удалось завершить создание пользователя %s, выполняется откат: %v", user.UserName, err)
+		}
+	}
+
+	err := s.rollbackProvisioning(ctx, user)
+	if errors.Is(err, ErrProvisioningConflict) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("создание пользователя %s отменено", user.UserName)
+	return nil
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+)
+
+// Режимы регистрации
+const (
+	RegistrationOpen   = "open"   // Зарегистрироваться может любой
+	RegistrationInvite = "invite" // Только по коду приглашения
+	RegistrationClosed = "closed" // Регистрация отключена
+)
+
+// Ограничения приглашений
+const (
+	maxInviteTTL  = 90 * 24 * time.Hour
+	maxInviteUses = 1000
+)
+
+// Ошибки регистрации и приглашений
+var (
+	ErrRegistrationClosed   = fmt.Errorf("%w: регистрация закрыта", ErrAccessDenied)
+	ErrInvalidInvite        = fmt.Errorf("%w: недействительный, истекший или исчерпанный код приглашения", ErrAccessDenied)
+	ErrInvalidInviteOptions = errors.New("некорректные параметры приглашения")
+	ErrInviteNotFound       = errors.New("приглашение не найдено")
+)
+
+// RegistrationConfig правила регистрации пользователей
+type RegistrationConfig struct {
+	// Mode режим регистрации; пустое значение равносильно RegistrationOpen
+	Mode string
+	// RequireEmailVerification откладывает создание хранилища до подтверждения адреса почты,
+	// чтобы регистрации с ошибкой в адресе не создавали бакеты
+	RequireEmailVerification bool
+}
+
+// Validate проверяет режим регистрации
+func (c RegistrationConfig) Validate() error {
+	switch c.Mode {
+	case "", RegistrationOpen, RegistrationInvite, RegistrationClosed:
+		return nil
+	default:
+		return fmt.Errorf("неизвестный режим регистрации %q, допустимы %s, %s и %s",
+			c.Mode, RegistrationOpen, RegistrationInvite, RegistrationClosed)
+	}
+}
+
+// InviteOptions параметры нового приглашения
+type InviteOptions struct {
+	TTL        time.Duration // Срок действия
+	MaxUses    int           // Сколько пользователей может зарегистрироваться по приглашению
+	QuotaBytes int64         // Квота зарегистрированных по приглашению, 0 — общая квота
+}
+
+// admitRegistration проверяет, разрешена ли регистрация, и засчитывает использование приглашения.
+// Первый пользователь регистрируется в любом режиме: он становится администратором и сможет приглашать остальных.
+// Возвращает использованное приглашение или nil, если регистрация прошла без него, и first, если регистрация
+// идет как первая: тогда пользователь создается через CreateFirstUser, который откажет,
+// если кто-то успел зарегистрироваться раньше. Других путей стать администратором при создании нет.
+func (s *Service) admitRegistration(inviteCode string) (invite *models.Invite, first bool, err error) {
+	mode := s.registrationMode()
+
+	count, err := s.Storagedb.CountUsers()
+	if err != nil {
+		return nil, false, err
+	}
+	if count == 0 {
+		return nil, true, nil
+	}
+
+	switch mode {
+	case RegistrationOpen:
+		// Приглашение необязательно, но если указано, должно действовать: от него зависит квота
+		if inviteCode == "" {
+			return nil, false, nil
+		}
+		invite, err = s.redeemInvite(inviteCode)
+	case RegistrationInvite:
+		invite, err = s.redeemInvite(inviteCode)
+	default:
+		err = ErrRegistrationClosed
+	}
+	return invite, false, err
+}
+
+// registrationMode возвращает режим регистрации; пустой режим равносилен RegistrationOpen
+func (s *Service) registrationMode() string {
+	if s.Registration.Mode == "" {
+		return RegistrationOpen
+	}
+	return s.Registration.Mode
+}
+
+// notFirstUserError возвращает ошибку допуска для регистрации, которая рассчитывала стать первой,
+// но опоздала: теперь в этом режиме нужно приглашение или регистрация закрыта
+func (s *Service) notFirstUserError() error {
+	if s.registrationMode() == RegistrationClosed {
+		return ErrRegistrationClosed
+	}
+	return ErrInvalidInvite
+}
+
+// redeemInvite засчитывает использование приглашения по коду
+func (s *Service) redeemInvite(code string) (*models.Invite, error) {
+	if code == "" {
+		return nil, ErrInvalidInvite
+	}
+
+	invite, err := s.Storagedb.RedeemInvite(hashToken(code))
+	if err != nil {
+		return nil, err
+	}
+	if invite == nil {
+		return nil, ErrInvalidInvite
+	}
+	return invite, nil
+}
+
+// releaseInvite возвращает использование приглашения, если пользователя создать не удалось
+func (s *Service) releaseInvite(invite *models.Invite) {
+	if invite == nil {
+		return
+	}
+	if err := s.Storagedb.ReleaseInvite(invite.ID); err != nil {
+		log.Printf("ошибка возврата использования приглашения %d: %v", invite.ID, err)
+	}
+}
+
+// requireAdmin проверяет, что пользователь администратор
+func (s *Service) requireAdmin(userID int) error {
+	user, err := s.Storagedb.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if !user.IsAdmin {
+		return fmt.Errorf("%w: действие доступно только администратору", ErrAccessDenied)
+	}
+	return nil
+}
+
+// CreateInvite создает приглашение. Доступно только администратору.
+// Код возвращается один раз: в БД хранится только его хеш.
+func (s *Service) CreateInvite(ctx context.Context, adminID int, opts InviteOptions) (*models.Invite, string, error) {
+	if err := s.requireAdmin(adminID); err != nil {
+		return nil, "", err
+	}
+	if opts.TTL <= 0 || opts.TTL > maxInviteTTL {
+		return nil, "", fmt.Errorf("%w: срок действия должен быть от 1 секунды до %d дней", ErrInvalidInviteOptions, int(maxInviteTTL.Hours()/24))
+	}
+	if opts.MaxUses <= 0 || opts.MaxUses > maxInviteUses {
+		return nil, "", fmt.Errorf("%w: число использований должно быть от 1 до %d", ErrInvalidInviteOptions, maxInviteUses)
+	}
+	if opts.QuotaBytes < 0 {
+		return nil, "", fmt.Errorf("%w: квота не может быть отрицательной", ErrInvalidInviteOptions)
+	}
+
+	code, err := s.generateSecretKey(userTokenLength)
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка генерации кода приглашения: %w", err)
+	}
+
+	invite := &models.Invite{
+		CodeHash:   hashToken(code),
+		CreatedBy:  adminID,
+		MaxUses:    opts.MaxUses,
+		QuotaBytes: opts.QuotaBytes,
+		ExpiresAt:  time.Now().Add(opts.TTL),
+	}
+	if err := s.Storagedb.CreateInvite(invite); err != nil {
+		return nil, "", err
+	}
+	return invite, code, nil
+}
+
+// ListInvites возвращает все приглашения. Доступно только администратору.
+func (s *Service) ListInvites(ctx context.Context, adminID int) ([]models.Invite, error) {
+	if err := s.requireAdmin(adminID); err != nil {
+		return nil, err
+	}
+	return s.Storagedb.ListInvites()
+}
+
+// DeleteInvite отзывает приглашение. Доступно только администратору.
+func (s *Service) DeleteInvite(ctx context.Context, adminID, inviteID int) error {
+	if err := s.requireAdmin(adminID); err != nil {
+		return err
+	}
+
+	ok, err := s.Storagedb.DeleteInvite(inviteID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInviteNotFound
+	}
+	return nil
+}
@@ -4,13 +4,17 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"strings"
+	"sync"
+	"time"
 
+	"github.com.Vova4o/nasforhome/pkg/mailer"
 	intminio "github.com.Vova4o/nasforhome/pkg/minio"
 	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com.Vova4o/nasforhome/pkg/storagedb"
+	"github.com.Vova4o/nasforhome/pkg/webauthn"
 	"github.com/minio/madmin-go/v3"
 	"github.com/minio/minio-go/v7"
 	"golang.org/x/crypto/bcrypt"
@@ -20,34 +24,171 @@ import (
 type Service struct {
 	Storagedb      StoragerDB
 	MinioAdmin     *intminio.MinIO
-	MinioConfig    MinioConfig // Конфигурация для пользовательских клиентов
-	JWTConfig      JWTConfig   // Конфигурация для JWT токенов
+	Admin          MinioAdminInterface  // Административные операции MinIO; New берет их из MinioAdmin
+	Buckets        MinioBucketInterface // Операции с бакетами от имени администратора; New берет их из MinioAdmin
+	MinioConfig    MinioConfig          // Конфигурация для пользовательских клиентов
+	JWTConfig      JWTConfig            // Конфигурация для JWT токенов
+	Limits         Limits               // Квоты и ограничения распаковки архивов
+	Mailer         mailer.Mailer        // Отправка писем пользователям; по умолчанию письма пишутся в лог
+	Registration   RegistrationConfig   // Правила регистрации пользователей
+	WebAuthn       webauthn.Config      // Параметры входа по ключам доступа; пустой RPID отключает их
+	OIDC           OIDCConfig           // Вход через поставщика OpenID Connect
+	Authenticator  Authenticator        // Проверка пароля во внешнем каталоге; nil — по хешу в БД
+	LoginThrottle  LoginThrottleStore   // Счетчики неудачных попыток входа; nil отключает задержки
+	LoginLimits    LoginLimits          // Задержки и блокировка после неудачных попыток входа
 	ExecFileOpFunc func(ctx context.Context, userID int, operation FileOperationFunc) (any, error)
+
+	extractJobs sync.Map        // Фоновые задачи распаковки по ID; завершенные хранятся extractJobTTL
+	events      eventBus        // Подписчики на события с файлами
+	sessions    sessionCache    // Недавно подтвержденные сеансы
+	oidcCache   oidcClientCache // Клиент поставщика OpenID Connect
 }
 
 // StoragerDB интерфейс для работы с базой данных
 type StoragerDB interface {
 	// Операции с пользователями
 	CreateUser(username, passwordHash, email string, config *models.MinioConfig) (int, error)
+	CreateFirstUser(username, passwordHash, email string, config *models.MinioConfig) (int, error)
 	GetUserByUsername(username string) (*models.User, error)
 	GetUserByID(id int) (*models.User, error)
+	GetUserByEmail(email string) (*models.User, error)
+	ListUsers() ([]models.User, error)
 	UpdateUser(user *models.User) error
+	UpdateProfile(user *models.User, token *models.UserToken, deliver func() error) error
+	UpdatePassword(userID int, passwordHash string) error
+	SetUserAdmin(userID int, isAdmin bool) error
 	DeleteUser(id int) error
+	CountUsers() (int, error)
+
+	// Состояние создания хранилища пользователя
+	SetProvisioningState(userID int, from, to string) (bool, error)
+	ListStaleProvisioning(staleBefore time.Time) ([]models.User, error)
+	AddProvisioningFailure(userID int) (int, error)
+	MarkEmailVerified(userID int) (bool, error)
+	DeleteUnverifiedUsers(createdBefore time.Time) (int64, error)
+
+	// Приглашения
+	CreateInvite(invite *models.Invite) error
+	ListInvites() ([]models.Invite, error)
+	DeleteInvite(id int) (bool, error)
+	RedeemInvite(codeHash string) (*models.Invite, error)
+	ReleaseInvite(id int) error
+
+	// Второй фактор и коды восстановления
+	SaveTOTPSecret(userID int, secret string) (bool, error)
+	GetTOTP(userID int) (*models.TOTP, error)
+	EnableTOTP(userID int, counter int64, recoveryCodeHashes []string) (bool, error)
+	UseTOTPCounter(userID int, counter int64) (bool, error)
+	DeleteTOTP(userID int) error
+	ReplaceRecoveryCodes(userID int, codeHashes []string) error
+	UseRecoveryCode(userID int, codeHash string) (bool, error)
+
+	// Ключи доступа WebAuthn
+	CreateWebAuthnCredential(cred *models.WebAuthnCredential) error
+	ListWebAuthnCredentials(userID int) ([]models.WebAuthnCredential, error)
+	GetWebAuthnCredential(credentialID []byte) (*models.WebAuthnCredential, error)
+	UpdateWebAuthnSignCount(id int, signCount uint32) (bool, error)
+	DeleteWebAuthnCredential(userID, id int) (bool, error)
+	CreateWebAuthnChallenge(challenge *models.WebAuthnChallenge) error
+	ConsumeWebAuthnChallenge(challengeHash, purpose string) (*models.WebAuthnChallenge, error)
+
+	// Вход через OpenID Connect
+	GetUserIDByIdentity(issuer, subject string) (int, error)
+	LinkUserIdentity(issuer, subject string, userID int) error
+	CreateOIDCState(state *models.OIDCState) error
+	ConsumeOIDCState(stateHash string) (*models.OIDCState, error)
+
+	// Журнал неудачных попыток входа
+	RecordLoginFailure(failure *models.LoginFailure) error
+	ListLoginFailures(limit int) ([]models.LoginFailure, error)
+
+	// Персональные токены API
+	CreateAPIToken(token *models.APIToken) error
+	ListAPITokens(userID int) ([]models.APIToken, error)
+	UseAPIToken(tokenHash string) (*models.APIToken, error)
+	DeleteAPIToken(userID, id int) (bool, error)
+
+	// Сеансы пользователей
+	CreateSession(session *models.Session) error
+	ListSessions(userID int) ([]models.Session, error)
+	RotateSession(id, userID int, oldHash, newHash, ip, userAgent string, expiresAt time.Time) (bool, error)
+	SessionActive(userID, id int) (bool, error)
+	DeleteSession(userID, id int) (bool, error)
+
+	// Одноразовые токены, отправляемые по почте
+	CreateUserToken(token *models.UserToken) error
+	ConsumeUserToken(tokenHash, purpose string) (*models.UserToken, error)
+	ConfirmEmailChange(tokenHash string) (*models.UserToken, error)
+	DeleteUserTokens(userID int, purpose string) error
 
 	// Операции с MinIO для пользователя
 	CreateMinIOUser(userID int, bucketName, accessKey, secretKey string) error
 	GetMinIOCredentials(userID int) (string, string, string, error)
+
+	// Журнал изменений для синхронизации
+	AppendChange(change *models.Change) error
+	ListChanges(userID int, since int64, limit int) ([]models.Change, error)
+	GetLatestChange(userID int, key string) (*models.Change, error)
+	GetLatestChangeSeq(userID int) (int64, error)
+
+	// Общие пространства и доступ к ним
+	CreateSharedSpace(space *models.SharedSpace) error
+	GetSharedSpace(id int) (*models.SharedSpace, error)
+	ListUserSharedSpaces(userID int) ([]models.SharedSpace, error)
+	ListUserDirectSharedSpaces(userID int) ([]models.SharedSpace, error)
+	ListGroupSharedSpaces(groupID int) ([]models.SharedSpace, error)
+	DeleteSharedSpace(id int) error
+	SetSpaceMember(spaceID, userID int, role string) error
+	RemoveSpaceMember(spaceID, userID int) error
+	ListSpaceMembers(spaceID int) ([]models.SpaceMember, error)
+	ListSpaceUserIDs(spaceID int) ([]int, error)
+	GetSpaceMemberRole(spaceID, userID int) (string, error)
+	SetSpaceGroupGrant(spaceID, groupID int, role string) error
+	RemoveSpaceGroupGrant(spaceID, groupID int) error
+	ListSpaceGroupGrants(spaceID int) ([]models.SpaceGroupGrant, error)
+
+	// Группы пользователей
+	CreateGroup(group *models.Group) error
+	GetGroup(id int) (*models.Group, error)
+	GetGroupByName(name string) (*models.Group, error)
+	ListUserGroups(userID int) ([]models.Group, error)
+	DeleteGroup(id int) error
+	AddGroupMember(groupID, userID int) error
+	RemoveGroupMember(groupID, userID int) error
+	ListGroupMembers(groupID int) ([]models.GroupMember, error)
 }
 
 // MinioClientInterface интерфейс для работы с MinIO
 type MinioClientInterface interface {
 	ListObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo
 	GetObject(ctx context.Context, bucketName, objectName string, opts minio.GetObjectOptions) (*minio.Object, error)
+	StatObject(ctx context.Context, bucketName, objectName string, opts minio.StatObjectOptions) (minio.ObjectInfo, error)
 	RemoveObject(ctx context.Context, bucketName, objectName string, opts minio.RemoveObjectOptions) error
 	PutObject(ctx context.Context, bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error)
+	CopyObject(ctx context.Context, dst minio.CopyDestOptions, src minio.CopySrcOptions) (minio.UploadInfo, error)
 	// Добавьте другие используемые методы
 }
 
+// MinioAdminInterface интерфейс административных операций MinIO с пользователями, политиками и группами
+type MinioAdminInterface interface {
+	AddUser(ctx context.Context, accessKey, secretKey string) error
+	RemoveUser(ctx context.Context, accessKey string) error
+	AddCannedPolicy(ctx context.Context, policyName string, policy []byte) error
+	RemoveCannedPolicy(ctx context.Context, policyName string) error
+	AttachPolicy(ctx context.Context, r madmin.PolicyAssociationReq) (madmin.PolicyAssociationResp, error)
+	DetachPolicy(ctx context.Context, r madmin.PolicyAssociationReq) (madmin.PolicyAssociationResp, error)
+	GetGroupDescription(ctx context.Context, group string) (*madmin.GroupDesc, error)
+	UpdateGroupMembers(ctx context.Context, g madmin.GroupAddRemove) error
+	SetBucketQuota(ctx context.Context, bucket string, quota *madmin.BucketQuota) error
+}
+
+// MinioBucketInterface интерфейс создания и удаления бакетов
+type MinioBucketInterface interface {
+	MakeBucket(ctx context.Context, bucketName string, opts minio.MakeBucketOptions) error
+	RemoveBucket(ctx context.Context, bucketName string) error
+	RemoveBucketWithOptions(ctx context.Context, bucketName string, opts minio.RemoveBucketOptions) error
+}
+
 // MinioConfig структура для создания пользовательских клиентов
 type MinioConfig struct {
 	Endpoint string
@@ -58,13 +199,26 @@ type MinioConfig struct {
 type FileOperationFunc func(ctx context.Context, minioClient MinioClientInterface, bucketName string) (any, error)
 
 // New создает сервис с админским подключением
-func New(storagedb StoragerDB, minioAdmin *intminio.MinIO, minioConfig MinioConfig, jwtConfig JWTConfig) *Service {
-	return &Service{
+func New(storagedb StoragerDB, minioAdmin *intminio.MinIO, minioConfig MinioConfig, jwtConfig JWTConfig, limits Limits) *Service {
+	s := &Service{
 		Storagedb:   storagedb,
 		MinioAdmin:  minioAdmin,
 		MinioConfig: minioConfig,
 		JWTConfig:   jwtConfig,
+		Limits:      limits,
+		Mailer:      mailer.LogMailer{},
 	}
+	s.LoginThrottle = NewMemoryLoginThrottle()
+
+	// Пустые указатели не присваиваем, чтобы проверки на nil в интерфейсах работали
+	if minioAdmin != nil && minioAdmin.AdminClient != nil {
+		s.Admin = minioAdmin.AdminClient
+	}
+	if minioAdmin != nil && minioAdmin.Client != nil {
+		s.Buckets = minioAdmin.Client
+	}
+
+	return s
 }
 
 // PasswordHash возвращает хеш пароля
@@ -84,118 +238,165 @@ func (s *Service) VerifyPassword(password, hash string) bool {
 	return err == nil
 }
 
-// RegisterUser регистрирует нового пользователя со всеми необходимыми данными
-func (s *Service) RegisterUser(ctx context.Context, username, password, email string) (*models.User, *TokenPair, error) {
-	// Хешируем пароль
-	passwordHash, err := s.PasswordHash(password)
-	if err != nil {
-		return nil, nil, fmt.Errorf("ошибка хеширования пароля: %w", err)
+// RegisterUser регистрирует нового пользователя со всеми необходимыми данными.
+// Код приглашения обязателен в режиме RegistrationInvite и задает квоту пользователя.
+func (s *Service) RegisterUser(ctx context.Context, username, password, email, inviteCode string) (*models.User, *TokenPair, error) {
+	// Имя проверяется до создания записи, чтобы не оставлять в БД пользователей, которых нельзя создать
+	if err := ValidateUsername(username); err != nil {
+		return nil, nil, err
 	}
-
-	// Формируем данные для MinIO
-	bucketName := "user-" + username
-	accessKey := "user-" + username
-
-	// Генерируем безопасный секретный ключ
-	secretKey, err := s.generateSecretKey(32)
-	if err != nil {
-		return nil, nil, fmt.Errorf("ошибка генерации ключа: %w", err)
+	if err := ValidatePassword(password); err != nil {
+		return nil, nil, err
 	}
-
-	// Сначала создаем пользователя в БД со всеми данными MinIO
-	userID, err := s.Storagedb.CreateUser(username, passwordHash, email, &models.MinioConfig{
-		BucketName: bucketName,
-		AccessKey:  accessKey,
-		SecretKey:  secretKey,
-	})
+	email, err := normalizeEmail(email)
 	if err != nil {
-		return nil, nil, fmt.Errorf("ошибка создания пользователя: %w", err)
+		return nil, nil, err
 	}
 
-	// 1. Создаем пользователя в MinIO
-	err = s.MinioAdmin.AdminClient.AddUser(ctx, accessKey, secretKey)
+	// Приглашение засчитывается после проверки данных и возвращается, если пользователя создать не удалось
+	invite, first, err := s.admitRegistration(inviteCode)
 	if err != nil {
-		err := s.Storagedb.DeleteUser(userID)
-		if err != nil {
-			log.Printf("error deleting user: %v", err)
+		return nil, nil, err
+	}
+	registered := false
+	defer func() {
+		if !registered {
+			s.releaseInvite(invite)
 		}
-		return nil, nil, fmt.Errorf("ошибка создания пользователя в MinIO: %w", err)
+	}()
+	var quotaBytes int64
+	if invite != nil {
+		quotaBytes = invite.QuotaBytes
 	}
 
-	// 2. Привязываем политику (AttachPolicy вместо устаревшей SetPolicy)
-	_, err = s.MinioAdmin.AdminClient.AttachPolicy(ctx, madmin.PolicyAssociationReq{
-		Policies: []string{"readwrite"},
-		User:     accessKey, // Используем accessKey как имя пользователя в MinIO
-	})
+	// Хешируем пароль
+	passwordHash, err := s.PasswordHash(password)
 	if err != nil {
-		// Откат: если не удалось привязать политику, удаляем пользователя
-		err = s.MinioAdmin.AdminClient.RemoveUser(ctx, accessKey)
-		if err != nil {
-			log.Printf("error MinIo admin client: %v", err)
-		}
-		return nil, nil, fmt.Errorf("ошибка привязки политики к пользователю: %w", err)
+		return nil, nil, fmt.Errorf("ошибка хеширования пароля: %w", err)
 	}
 
-	// 2. Создаем бакет в MinIO (с правами администратора)
-	err = s.MinioAdmin.Client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{})
+	pending, err := s.createUser(username, passwordHash, email, quotaBytes, first)
+	if errors.Is(err, storagedb.ErrNotFirstUser) && s.registrationMode() == RegistrationOpen {
+		// В открытом режиме опоздавшая первая регистрация проходит как обычная
+		pending, err = s.createUser(username, passwordHash, email, quotaBytes, false)
+	}
+	if errors.Is(err, storagedb.ErrNotFirstUser) {
+		return nil, nil, s.notFirstUserError()
+	}
 	if err != nil {
-		if minio.ToErrorResponse(err).Code != "BucketAlreadyOwnedByYou" {
-			// Удаляем созданного пользователя MinIO
-			err = s.MinioAdmin.AdminClient.RemoveUser(ctx, accessKey)
-			if err != nil {
-				log.Printf("error MinIo admin client: %v", err)
-			}
-			err := s.Storagedb.DeleteUser(userID)
-			if err != nil {
-				log.Printf("error deleting user: %v", err)
-			}
-			return nil, nil, fmt.Errorf("ошибка создания бакета: %w", err)
-		}
+		return nil, nil, err
 	}
 
-	// Сохраняем данные MinIO в БД
-	err = s.Storagedb.CreateMinIOUser(userID, bucketName, accessKey, secretKey)
-	if err != nil {
-		// Если не удалось сохранить данные, удаляем пользователя
-		err := s.Storagedb.DeleteUser(userID)
+	// До подтверждения адреса хранилище не создается
+	if s.Registration.RequireEmailVerification {
+		user, err := s.registerUnverified(ctx, pending.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		registered = true
+		tokens, err := s.startSession(ctx, user)
 		if err != nil {
-			log.Printf("error deleting user: %v", err)
+			return nil, nil, fmt.Errorf("ошибка создания токенов: %w", err)
 		}
-		return nil, nil, fmt.Errorf("ошибка создания хранилища: %w", err)
+		return user, tokens, nil
 	}
 
+	if err := s.provisionOrRollback(ctx, pending); err != nil {
+		// Пользователь создан, хранилище доведет реконсилер: приглашение уже использовано
+		registered = errors.Is(err, ErrAccountNotReady)
+		return nil, nil, err
+	}
+	registered = true
+
 	// Получаем данные пользователя для генерации токенов
-	user, err := s.Storagedb.GetUserByID(userID)
+	user, err := s.Storagedb.GetUserByID(pending.ID)
 	if err != nil {
 		return nil, nil, fmt.Errorf("ошибка получения данных пользователя: %w", err)
 	}
 
 	// Генерируем токены
-	tokens, err := s.GenerateTokenPair(user)
+	tokens, err := s.startSession(ctx, user)
 	if err != nil {
 		return nil, nil, fmt.Errorf("ошибка создания токенов: %w", err)
 	}
 
-	fmt.Printf("Tokens: %-v", tokens)
-
 	return user, tokens, nil
 }
 
+// createUser создает запись пользователя со всеми данными MinIO в состоянии pending.
+// Запись резервирует имя и позволяет довести или откатить создание хранилища, если процесс прервется;
+// само хранилище создает вызывающий. При first запись создается, только если других пользователей нет.
+func (s *Service) createUser(username, passwordHash, email string, quotaBytes int64, first bool) (*models.User, error) {
+	// Бакет и ключ не зависят от имени пользователя
+	bucketName := newStorageName()
+	accessKey := bucketName
+
+	// Генерируем безопасный секретный ключ
+	secretKey, err := s.generateSecretKey(32)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка генерации ключа: %w", err)
+	}
+
+	create := s.Storagedb.CreateUser
+	if first {
+		create = s.Storagedb.CreateFirstUser
+	}
+	userID, err := create(username, passwordHash, email, &models.MinioConfig{
+		BucketName: bucketName,
+		AccessKey:  accessKey,
+		SecretKey:  secretKey,
+		QuotaBytes: quotaBytes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания пользователя: %w", err)
+	}
+
+	return &models.User{
+		ID:                userID,
+		UserName:          username,
+		Email:             email,
+		MinioBucketName:   bucketName,
+		MinioAccessKey:    accessKey,
+		MinioSecretKey:    secretKey,
+		ProvisioningState: models.ProvisioningPending,
+		QuotaBytes:        quotaBytes,
+	}, nil
+}
+
 // LoginUser выполняет вход пользователя и генерирует токены
-func (s *Service) LoginUser(ctx context.Context, username, password string) (*models.User, *TokenPair, error) {
-	// Получаем пользователя из БД
-	user, err := s.Storagedb.GetUserByUsername(username)
+func (s *Service) LoginUser(ctx context.Context, username, password, clientIP string) (*models.User, *TokenPair, error) {
+	// После нескольких неудач подряд попытки с этим именем или адреса отклоняются до проверки пароля.
+	// Попытка учитывается заранее, чтобы параллельные запросы не проходили проверку все сразу.
+	if err := s.reserveLoginAttempt(username, clientIP); err != nil {
+		return nil, nil, err
+	}
+
+	user, err := s.authenticate(ctx, username, password)
 	if err != nil {
-		return nil, nil, fmt.Errorf("ошибка аутентификации: %w", err)
+		if errors.Is(err, ErrInvalidCredentials) {
+			s.recordLoginFailure(username, clientIP, models.LoginFailurePassword)
+		} else {
+			s.releaseLoginAttempt(username, clientIP)
+		}
+		return nil, nil, err
 	}
+	s.releaseLoginAttempt(username, clientIP)
 
-	// Проверяем пароль
-	if !s.VerifyPassword(password, user.PasswordHash) {
-		return nil, nil, fmt.Errorf("неверный пароль")
+	// Пока хранилище не создано до конца, работать с ним нельзя. До подтверждения адреса
+	// вход разрешен, чтобы можно было исправить адрес и запросить код повторно.
+	if user.ProvisioningState != models.ProvisioningReady && user.ProvisioningState != models.ProvisioningUnverified {
+		return nil, nil, ErrAccountNotReady
 	}
 
+	// Со вторым фактором вместо пары токенов возвращается токен для ввода кода
+	if err := s.mfaChallenge(user); err != nil {
+		return nil, nil, err
+	}
+	// Со вторым фактором счетчик сбрасывается только после верного кода, иначе верный пароль обнулял бы подбор кода
+	s.resetLoginThrottle(username)
+
 	// Генерируем токены
-	tokens, err := s.GenerateTokenPair(user)
+	tokens, err := s.startSession(ctx, user)
 	if err != nil {
 		return nil, nil, fmt.Errorf("ошибка создания токенов: %w", err)
 	}
@@ -223,71 +424,90 @@ func (s *Service) GetUserMinioClient(ctx context.Context, userID int) (*minio.Cl
 
 // ExecuteFileOperation универсальная функция для выполнения операций с файлами
 func (s *Service) ExecuteFileOperation(ctx context.Context, userID int, operation FileOperationFunc) (any, error) {
+	// В общем пространстве операция выполняется в его бакете после проверки доступа
+	if spaceID, ok := spaceFromContext(ctx); ok {
+		space, role, err := s.spaceAccess(userID, spaceID)
+		if err != nil {
+			return nil, err
+		}
+		operation = spaceOperation(space, role, operation)
+	}
+
 	// Если установлена тестовая функция, используем её
 	if s.ExecFileOpFunc != nil {
 		return s.ExecFileOpFunc(ctx, userID, operation)
 	}
 
 	// Оригинальная реализация
-	minioClient, err := s.GetUserMinioClient(ctx, userID)
+	user, err := s.Storagedb.GetUserByID(userID)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка подключения к хранилищу: %w", err)
+		return nil, fmt.Errorf("ошибка получения данных хранилища: %w", err)
+	}
+	// Хранилища может еще не быть, например до подтверждения адреса почты
+	if err := storageReady(user); err != nil {
+		return nil, err
 	}
 
-	bucketName, _, _, err := s.Storagedb.GetMinIOCredentials(userID)
+	minioClient, err := s.GetUserMinioClient(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка получения данных хранилища: %w", err)
+		return nil, fmt.Errorf("ошибка подключения к хранилищу: %w", err)
 	}
 
 	// *minio.Client автоматически подходит под интерфейс MinioClientInterface
-	return operation(ctx, minioClient, bucketName)
+	return operation(ctx, minioClient, user.MinioBucketName)
 }
 
-// ListUserFiles возвращает список файлов пользователя
-func (s *Service) ListUserFiles(ctx context.Context, userID int, prefix string, recursive bool) ([]minio.ObjectInfo, error) {
-	result, err := s.ExecuteFileOperation(ctx, userID, func(ctx context.Context, minioClient MinioClientInterface, bucketName string) (interface{}, error) {
-		// Получаем список объектов
-		objectCh := minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
-			Prefix:    prefix,
-			Recursive: recursive,
-		})
-
-		var files []minio.ObjectInfo
-		for obj := range objectCh {
-			if obj.Err != nil {
-				return nil, obj.Err
-			}
-
-			// Пропускаем объекты, представляющие папки
-			if obj.Size == 0 && len(obj.Key) > 0 && obj.Key[len(obj.Key)-1] == '/' {
-				continue
-			}
+// StatUserFile возвращает информацию о файле пользователя без чтения содержимого
+func (s *Service) StatUserFile(ctx context.Context, userID int, filename string) (*minio.ObjectInfo, error) {
+	if err := ValidateObjectKey(filename); err != nil {
+		return nil, err
+	}
 
-			files = append(files, obj)
+	result, err := s.ExecuteFileOperation(ctx, userID, func(ctx context.Context, minioClient MinioClientInterface, bucketName string) (interface{}, error) {
+		stat, err := minioClient.StatObject(ctx, bucketName, filename, minio.StatObjectOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("ошибка получения информации о файле: %w", err)
 		}
 
-		return files, nil
+		return stat, nil
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return result.([]minio.ObjectInfo), nil
+	stat := result.(minio.ObjectInfo)
+	return &stat, nil
 }
 
-// GetUserFile возвращает файл пользователя
-func (s *Service) GetUserFile(ctx context.Context, userID int, filename string) (*minio.Object, *minio.ObjectInfo, error) {
+// GetUserFile возвращает файл пользователя.
+// Если задан byteRange, из MinIO запрашивается только этот диапазон, а stat описывает файл целиком.
+func (s *Service) GetUserFile(ctx context.Context, userID int, filename string, byteRange *ByteRange) (*minio.Object, *minio.ObjectInfo, error) {
+	if err := ValidateObjectKey(filename); err != nil {
+		return nil, nil, err
+	}
+
 	result, err := s.ExecuteFileOperation(ctx, userID, func(ctx context.Context, minioClient MinioClientInterface, bucketName string) (interface{}, error) {
-		// Получаем объект
-		object, err := minioClient.GetObject(ctx, bucketName, filename, minio.GetObjectOptions{})
+		// Информацию получаем отдельным запросом: Stat у minio.Object сбрасывает Range
+		stat, err := minioClient.StatObject(ctx, bucketName, filename, minio.StatObjectOptions{})
 		if err != nil {
-			return nil, fmt.Errorf("ошибка получения файла: %w", err)
+			return nil, fmt.Errorf("ошибка получения информации о файле: %w", err)
+		}
+
+		opts := minio.GetObjectOptions{}
+		if byteRange != nil {
+			if err := opts.SetRange(byteRange.Start, byteRange.End); err != nil {
+				return nil, fmt.Errorf("неверный диапазон: %w", err)
+			}
+			// Диапазон должен относиться к той же версии файла, что и stat
+			if err := opts.SetMatchETag(stat.ETag); err != nil {
+				return nil, fmt.Errorf("ошибка установки ETag: %w", err)
+			}
 		}
 
-		// Получаем информацию об объекте
-		stat, err := object.Stat()
+		// Получаем объект
+		object, err := minioClient.GetObject(ctx, bucketName, filename, opts)
 		if err != nil {
-			return nil, fmt.Errorf("ошибка получения информации о файле: %w", err)
+			return nil, fmt.Errorf("ошибка получения файла: %w", err)
 		}
 
 		return []interface{}{object, stat}, nil
@@ -305,22 +525,113 @@ func (s *Service) GetUserFile(ctx context.Context, userID int, filename string)
 
 // DeleteUserFile удаляет файл пользователя
 func (s *Service) DeleteUserFile(ctx context.Context, userID int, filename string) error {
+	if err := ValidateObjectKey(filename); err != nil {
+		return err
+	}
+
 	_, err := s.ExecuteFileOperation(ctx, userID, func(ctx context.Context, minioClient MinioClientInterface, bucketName string) (interface{}, error) {
 		// Удаляем объект
 		return nil, minioClient.RemoveObject(ctx, bucketName, filename, minio.RemoveObjectOptions{})
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
+	s.recordChange(ctx, userID, FileEvent{Type: FileDeleted, Key: filename})
+	return nil
+}
+
+// ErrUploadTooLarge возвращается, если файл больше допустимого для пользователя размера загрузки
+var ErrUploadTooLarge = errors.New("файл превышает допустимый размер загрузки")
+
+// UploadLimit возвращает максимальный размер загружаемого пользователем файла, 0 — без ограничений.
+// Администраторы и обычные пользователи ограничиваются по-разному.
+func (s *Service) UploadLimit(userID int) (int64, error) {
+	if s.Limits.MaxUploadBytes <= 0 && s.Limits.MaxAdminUploadBytes <= 0 {
+		return 0, nil
+	}
+	user, err := s.Storagedb.GetUserByID(userID)
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения пользователя: %w", err)
+	}
+	if user.IsAdmin {
+		return max(s.Limits.MaxAdminUploadBytes, 0), nil
+	}
+	return max(s.Limits.MaxUploadBytes, 0), nil
 }
 
 // UploadUserFile function to uplad files to bucket.
 func (s *Service) UploadUserFile(ctx context.Context, userID int, objectName string, reader io.Reader, size int64, contentType string) (minio.UploadInfo, error) {
+	return s.UploadUserFileConditional(ctx, userID, objectName, reader, size, contentType, UploadCondition{})
+}
+
+// UploadUserFileConditional загружает файл, если он не изменился с версии, на которой основана загрузка клиента.
+// При несовпадении возвращает ErrConflict, а если запрошено cond.ConflictCopy — сохраняет файл
+// под именем конфликтующей копии; ключ сохраненного файла возвращается в UploadInfo.Key.
+func (s *Service) UploadUserFileConditional(ctx context.Context, userID int, objectName string, reader io.Reader, size int64, contentType string, cond UploadCondition) (minio.UploadInfo, error) {
+	if err := ValidateObjectKey(objectName); err != nil {
+		return minio.UploadInfo{}, err
+	}
+
+	limit, err := s.UploadLimit(userID)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	if limit > 0 && (size < 0 || size > limit) {
+		return minio.UploadInfo{}, fmt.Errorf("%w: %d байт", ErrUploadTooLarge, limit)
+	}
+
+	conflicted := false
+	base, err := s.checkSyncBase(ctx, userID, objectName, cond)
+	if err != nil {
+		if !cond.ConflictCopy || !errors.Is(err, ErrConflict) {
+			return minio.UploadInfo{}, err
+		}
+		conflicted = true
+	}
+
 	result, err := s.ExecuteFileOperation(ctx, userID, func(ctx context.Context, minioClient MinioClientInterface, bucketName string) (any, error) {
-		// Загрузка файла в MinIO
-		uploadInfo, err := minioClient.PutObject(ctx, bucketName, objectName, reader, size, minio.PutObjectOptions{
+		cond := cond
+		if !conflicted {
+			var err error
+			cond, err = syncBaseCondition(ctx, minioClient, bucketName, objectName, base, cond)
+			if errors.Is(err, ErrConflict) && cond.ConflictCopy {
+				conflicted = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		if cond.ConflictCopy && !conflicted {
+			var err error
+			if conflicted, err = etagConflict(ctx, minioClient, bucketName, objectName, cond); err != nil {
+				return nil, err
+			}
+		}
+
+		key := objectName
+		opts := minio.PutObjectOptions{
 			ContentType: contentType,
-		})
+		}
+		if conflicted {
+			// Копия никогда не перезаписывает существующий файл
+			key = conflictCopyName(objectName, time.Now())
+			opts.SetMatchETagExcept("*")
+		} else {
+			// Проверка ETag выполняется самим MinIO, поэтому между проверкой и записью нет гонки
+			if cond.IfMatch != "" {
+				opts.SetMatchETag(cond.IfMatch)
+			}
+			if cond.IfNoneMatch {
+				opts.SetMatchETagExcept("*")
+			}
+		}
+
+		// Загрузка файла в MinIO
+		uploadInfo, err := minioClient.PutObject(ctx, bucketName, key, reader, size, opts)
 		if err != nil {
+			if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+				return nil, fmt.Errorf("%w: условие загрузки %s не выполнено", ErrConflict, key)
+			}
 			return nil, fmt.Errorf("ошибка загрузки файла: %w", err)
 		}
 		return uploadInfo, nil
@@ -335,17 +646,19 @@ func (s *Service) UploadUserFile(ctx context.Context, userID int, objectName str
 		return minio.UploadInfo{}, fmt.Errorf("не удалось преобразовать результат в minio.UploadInfo")
 	}
 
+	s.recordChange(ctx, userID, FileEvent{Type: FileCreated, Key: uploadInfo.Key, Size: uploadInfo.Size, ETag: uploadInfo.ETag})
 	return uploadInfo, nil
 }
 
 // CreateUserFolder создает папку пользователя
 func (s *Service) CreateUserFolder(ctx context.Context, userID int, folderName string) error {
-	_, err := s.ExecuteFileOperation(ctx, userID, func(ctx context.Context, minioClient MinioClientInterface, bucketName string) (interface{}, error) {
-		// Убеждаемся, что folderName заканчивается на "/"
-		if folderName[len(folderName)-1] != '/' {
-			folderName += "/"
-		}
+	// Проверяем имя и убеждаемся, что folderName заканчивается на "/"
+	folderName, err := folderKey(folderName)
+	if err != nil {
+		return err
+	}
 
+	_, err = s.ExecuteFileOperation(ctx, userID, func(ctx context.Context, minioClient MinioClientInterface, bucketName string) (interface{}, error) {
 		// Создаем папку
 		_, err := minioClient.PutObject(ctx, bucketName, folderName, nil, 0, minio.PutObjectOptions{})
 		if err != nil {
@@ -354,18 +667,23 @@ func (s *Service) CreateUserFolder(ctx context.Context, userID int, folderName s
 
 		return nil, nil
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
+	s.recordChange(ctx, userID, FileEvent{Type: FileCreated, Key: folderName})
+	return nil
 }
 
 // DeleteUserFolder удаляет папку пользователя
 func (s *Service) DeleteUserFolder(ctx context.Context, userID int, folderName string) error {
-	_, err := s.ExecuteFileOperation(ctx, userID, func(ctx context.Context, minioClient MinioClientInterface, bucketName string) (interface{}, error) {
-		// Убеждаемся, что folderName заканчивается на "/"
-		if folderName[len(folderName)-1] != '/' {
-			folderName += "/"
-		}
+	// Проверяем имя и убеждаемся, что folderName заканчивается на "/"
+	folderName, err := folderKey(folderName)
+	if err != nil {
+		return err
+	}
 
+	_, err = s.ExecuteFileOperation(ctx, userID, func(ctx context.Context, minioClient MinioClientInterface, bucketName string) (interface{}, error) {
 		// Для рекурсивного удаления папки:
 		objectsCh := minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
 			Prefix:    folderName,
@@ -380,55 +698,22 @@ func (s *Service) DeleteUserFolder(ctx context.Context, userID int, folderName s
 			if err != nil {
 				return nil, err
 			}
+			// О самой папке сообщаем один раз после удаления маркера ниже
+			if obj.Key != folderName {
+				s.recordChange(ctx, userID, FileEvent{Type: FileDeleted, Key: obj.Key})
+			}
 		}
 
 		// Удаляем саму папку (без параметра Recursive)
 		err := minioClient.RemoveObject(ctx, bucketName, folderName, minio.RemoveObjectOptions{})
-		return nil, err
-	})
-
-	return err
-}
-
-// ListUserFolders возвращает список папок пользователя
-func (s *Service) ListUserFolders(ctx context.Context, userID int, prefix string) ([]string, error) {
-	result, err := s.ExecuteFileOperation(ctx, userID, func(ctx context.Context, minioClient MinioClientInterface, bucketName string) (interface{}, error) {
-		// Получаем список объектов
-		objectCh := minioClient.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
-			Prefix:    prefix,
-			Recursive: false,
-		})
-
-		var folders []string
-		for obj := range objectCh {
-			if obj.Err != nil {
-				return nil, obj.Err
-			}
-
-			// Проверяем, является ли объект папкой (размер 0 и заканчивается на '/')
-			if obj.Size == 0 && len(obj.Key) > 0 && obj.Key[len(obj.Key)-1] == '/' {
-				// Удаляем trailing slash для красивого отображения
-				folderName := obj.Key[:len(obj.Key)-1]
-
-				// Если указан префикс, удаляем его из имени папки
-				if prefix != "" && strings.HasPrefix(folderName, prefix) {
-					folderName = folderName[len(prefix):]
-				}
-
-				// Если в результате получилась непустая строка, добавляем в список
-				if folderName != "" {
-					folders = append(folders, folderName)
-				}
-			}
+		if err != nil {
+			return nil, err
 		}
-
-		return folders, nil
+		s.recordChange(ctx, userID, FileEvent{Type: FileDeleted, Key: folderName})
+		return nil, nil
 	})
-	if err != nil {
-		return nil, err
-	}
 
-	return result.([]string), nil
+	return err
 }
 
 // generateSecretKey генерирует криптографически стойкий случайный ключ
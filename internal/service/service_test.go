@@ -3,12 +3,20 @@ package service_test
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com.Vova4o/nasforhome/pkg/mailer"
 	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com.Vova4o/nasforhome/pkg/storagedb"
+	"github.com.Vova4o/nasforhome/pkg/totp"
+	"github.com.Vova4o/nasforhome/pkg/webauthn"
+	"github.com.Vova4o/nasforhome/pkg/webauthn/webauthntest"
 	"github.com/minio/madmin-go/v3"
 	"github.com/minio/minio-go/v7"
 	"github.com/stretchr/testify/assert"
@@ -25,6 +33,11 @@ func (m *MockStorageDB) CreateUser(username, passwordHash, email string, config
 	return args.Int(0), args.Error(1)
 }
 
+func (m *MockStorageDB) CreateFirstUser(username, passwordHash, email string, config *models.MinioConfig) (int, error) {
+	args := m.Called(username, passwordHash, email, config)
+	return args.Int(0), args.Error(1)
+}
+
 func (m *MockStorageDB) GetUserByUsername(username string) (*models.User, error) {
 	args := m.Called(username)
 	if args.Get(0) == nil {
@@ -41,225 +54,2376 @@ func (m *MockStorageDB) GetUserByID(id int) (*models.User, error) {
 	return args.Get(0).(*models.User), args.Error(1)
 }
 
-func (m *MockStorageDB) UpdateUser(user *models.User) error {
-	args := m.Called(user)
-	return args.Error(0)
+func (m *MockStorageDB) ListUsers() ([]models.User, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.User), args.Error(1)
 }
 
-func (m *MockStorageDB) DeleteUser(id int) error {
-	args := m.Called(id)
-	return args.Error(0)
+func (m *MockStorageDB) SetProvisioningState(userID int, from, to string) (bool, error) {
+	args := m.Called(userID, from, to)
+	return args.Bool(0), args.Error(1)
 }
 
-func (m *MockStorageDB) CreateMinIOUser(userID int, bucketName, accessKey, secretKey string) error {
-	args := m.Called(userID, bucketName, accessKey, secretKey)
-	return args.Error(0)
+func (m *MockStorageDB) AddProvisioningFailure(userID int) (int, error) {
+	args := m.Called(userID)
+	return args.Int(0), args.Error(1)
 }
 
-func (m *MockStorageDB) GetMinIOCredentials(userID int) (string, string, string, error) {
+func (m *MockStorageDB) ListStaleProvisioning(staleBefore time.Time) ([]models.User, error) {
+	args := m.Called(staleBefore)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.User), args.Error(1)
+}
+
+func (m *MockStorageDB) MarkEmailVerified(userID int) (bool, error) {
 	args := m.Called(userID)
-	return args.String(0), args.String(1), args.String(2), args.Error(3)
+	return args.Bool(0), args.Error(1)
 }
 
-// MockMinIO мок для MinIO
-type MockMinIO struct {
-	mock.Mock
-	Client      *MockMinioClient
-	AdminClient *MockAdminClient
+func (m *MockStorageDB) DeleteUnverifiedUsers(createdBefore time.Time) (int64, error) {
+	args := m.Called(createdBefore)
+	return args.Get(0).(int64), args.Error(1)
 }
 
-// MockMinioClient мок для minio.Client
-type MockMinioClient struct {
-	mock.Mock
+func (m *MockStorageDB) CountUsers() (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
 }
 
-func (m *MockMinioClient) PutObject(ctx context.Context, bucketName, objectName string,
-	reader io.Reader, size int64, opts minio.PutObjectOptions,
-) (minio.UploadInfo, error) {
-	args := m.Called(ctx, bucketName, objectName, reader, size, opts)
-	return args.Get(0).(minio.UploadInfo), args.Error(1)
+func (m *MockStorageDB) CreateInvite(invite *models.Invite) error {
+	args := m.Called(invite)
+	return args.Error(0)
 }
 
-func (m *MockMinioClient) GetObject(ctx context.Context, bucketName, objectName string,
-	opts minio.GetObjectOptions,
-) (*minio.Object, error) {
-	args := m.Called(ctx, bucketName, objectName, opts)
+func (m *MockStorageDB) ListInvites() ([]models.Invite, error) {
+	args := m.Called()
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).(*minio.Object), args.Error(1)
+	return args.Get(0).([]models.Invite), args.Error(1)
 }
 
-func (m *MockMinioClient) ListObjects(ctx context.Context, bucketName string,
-	opts minio.ListObjectsOptions,
-) <-chan minio.ObjectInfo {
-	args := m.Called(ctx, bucketName, opts)
-	return args.Get(0).(<-chan minio.ObjectInfo)
+func (m *MockStorageDB) DeleteInvite(id int) (bool, error) {
+	args := m.Called(id)
+	return args.Bool(0), args.Error(1)
 }
 
-func (m *MockMinioClient) RemoveObject(ctx context.Context, bucketName, objectName string,
-	opts minio.RemoveObjectOptions,
-) error {
-	args := m.Called(ctx, bucketName, objectName, opts)
-	return args.Error(0)
+func (m *MockStorageDB) RedeemInvite(codeHash string) (*models.Invite, error) {
+	args := m.Called(codeHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Invite), args.Error(1)
 }
 
-func (m *MockMinioClient) MakeBucket(ctx context.Context, bucketName string,
-	opts minio.MakeBucketOptions,
-) error {
-	args := m.Called(ctx, bucketName, opts)
+func (m *MockStorageDB) ReleaseInvite(id int) error {
+	args := m.Called(id)
 	return args.Error(0)
 }
 
-// MockAdminClient мок для madmin.AdminClient
-type MockAdminClient struct {
-	mock.Mock
+func (m *MockStorageDB) SaveTOTPSecret(userID int, secret string) (bool, error) {
+	args := m.Called(userID, secret)
+	return args.Bool(0), args.Error(1)
 }
 
-func (m *MockAdminClient) AddUser(ctx context.Context, accessKey, secretKey string) error {
-	args := m.Called(ctx, accessKey, secretKey)
+func (m *MockStorageDB) GetTOTP(userID int) (*models.TOTP, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.TOTP), args.Error(1)
+}
+
+func (m *MockStorageDB) EnableTOTP(userID int, counter int64, recoveryCodeHashes []string) (bool, error) {
+	args := m.Called(userID, counter, recoveryCodeHashes)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorageDB) UseTOTPCounter(userID int, counter int64) (bool, error) {
+	args := m.Called(userID, counter)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorageDB) DeleteTOTP(userID int) error {
+	args := m.Called(userID)
 	return args.Error(0)
 }
 
-func (m *MockAdminClient) AttachPolicy(ctx context.Context, req madmin.PolicyAssociationReq) (madmin.PolicyAssociationResp, error) {
-	args := m.Called(ctx, req)
-	return args.Get(0).(madmin.PolicyAssociationResp), args.Error(1)
+func (m *MockStorageDB) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	args := m.Called(userID, codeHashes)
+	return args.Error(0)
 }
 
-func (m *MockAdminClient) RemoveUser(ctx context.Context, accessKey string) error {
-	args := m.Called(ctx, accessKey)
+func (m *MockStorageDB) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	args := m.Called(userID, codeHash)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorageDB) CreateWebAuthnCredential(cred *models.WebAuthnCredential) error {
+	args := m.Called(cred)
 	return args.Error(0)
 }
 
-// TestPasswordHash проверяет хеширование пароля
-func TestPasswordHash(t *testing.T) {
-	srv := &service.Service{}
-	password := "testpassword"
+func (m *MockStorageDB) ListWebAuthnCredentials(userID int) ([]models.WebAuthnCredential, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.WebAuthnCredential), args.Error(1)
+}
 
-	// Проверяем хеширование пароля
-	hash, err := srv.PasswordHash(password)
-	require.NoError(t, err, "Ошибка хеширования пароля")
-	assert.NotEmpty(t, hash, "Хеш не должен быть пустым")
-	assert.NotEqual(t, password, hash, "Хеш должен отличаться от пароля")
+func (m *MockStorageDB) GetWebAuthnCredential(credentialID []byte) (*models.WebAuthnCredential, error) {
+	args := m.Called(credentialID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.WebAuthnCredential), args.Error(1)
+}
 
-	// Проверяем, что разные вызовы дают разные хеши (соль)
-	hash2, err := srv.PasswordHash(password)
-	require.NoError(t, err)
-	assert.NotEqual(t, hash, hash2, "Разные вызовы должны давать разные хеши")
+func (m *MockStorageDB) UpdateWebAuthnSignCount(id int, signCount uint32) (bool, error) {
+	args := m.Called(id, signCount)
+	return args.Bool(0), args.Error(1)
+}
 
-	// Проверяем верификацию пароля
-	assert.True(t, srv.VerifyPassword(password, hash), "Пароль должен проходить проверку")
-	assert.False(t, srv.VerifyPassword("wrongpassword", hash), "Неверный пароль не должен проходить проверку")
+func (m *MockStorageDB) DeleteWebAuthnCredential(userID, id int) (bool, error) {
+	args := m.Called(userID, id)
+	return args.Bool(0), args.Error(1)
 }
 
-// TestLoginUser проверяет вход пользователя
-func TestLoginUser(t *testing.T) {
-	// Создаем моки и сервис
-	mockStorage := new(MockStorageDB)
-	srv := &service.Service{
-		Storagedb: mockStorage,
-		JWTConfig: service.JWTConfig{
-			AccessSecret:  "test-access-secret",
-			RefreshSecret: "test-refresh-secret",
-			AccessTTL:     900,
-			RefreshTTL:    604800,
-		},
+func (m *MockStorageDB) CreateWebAuthnChallenge(challenge *models.WebAuthnChallenge) error {
+	args := m.Called(challenge)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) ConsumeWebAuthnChallenge(challengeHash, purpose string) (*models.WebAuthnChallenge, error) {
+	args := m.Called(challengeHash, purpose)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
 	}
+	return args.Get(0).(*models.WebAuthnChallenge), args.Error(1)
+}
 
-	// Создаем тестовые данные
-	username := "testuser"
-	password := "testpassword"
+func (m *MockStorageDB) GetUserIDByIdentity(issuer, subject string) (int, error) {
+	args := m.Called(issuer, subject)
+	return args.Int(0), args.Error(1)
+}
 
-	// Хешируем пароль для теста
-	hash, err := srv.PasswordHash(password)
-	require.NoError(t, err)
+func (m *MockStorageDB) LinkUserIdentity(issuer, subject string, userID int) error {
+	args := m.Called(issuer, subject, userID)
+	return args.Error(0)
+}
 
-	// Настраиваем мок для успешного входа
-	mockStorage.On("GetUserByUsername", username).Return(&models.User{
-		ID:           1,
-		UserName:     username,
-		PasswordHash: hash,
-		Email:        "test@example.com",
-	}, nil)
+func (m *MockStorageDB) CreateOIDCState(state *models.OIDCState) error {
+	args := m.Called(state)
+	return args.Error(0)
+}
 
-	// Проверяем успешный вход
-	user, tokens, err := srv.LoginUser(context.Background(), username, password)
-	assert.NoError(t, err, "Вход должен быть успешным")
-	assert.NotNil(t, user, "Пользователь не должен быть nil")
-	assert.NotNil(t, tokens, "Токены не должны быть nil")
-	assert.NotEmpty(t, tokens.AccessToken, "Access токен не должен быть пустым")
-	assert.NotEmpty(t, tokens.RefreshToken, "Refresh токен не должен быть пустым")
+func (m *MockStorageDB) ConsumeOIDCState(stateHash string) (*models.OIDCState, error) {
+	args := m.Called(stateHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.OIDCState), args.Error(1)
+}
 
-	// Проверяем неверный пароль
-	_, _, err = srv.LoginUser(context.Background(), username, "wrongpassword")
-	assert.Error(t, err, "Неверный пароль должен вызывать ошибку")
+func (m *MockStorageDB) RecordLoginFailure(failure *models.LoginFailure) error {
+	args := m.Called(failure)
+	return args.Error(0)
+}
 
-	// Настраиваем мок для несуществующего пользователя
-	mockStorage.On("GetUserByUsername", "nonexistent").Return(nil, errors.New("пользователь не найден"))
+func (m *MockStorageDB) ListLoginFailures(limit int) ([]models.LoginFailure, error) {
+	args := m.Called(limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.LoginFailure), args.Error(1)
+}
 
-	// Проверяем несуществующего пользователя
-	_, _, err = srv.LoginUser(context.Background(), "nonexistent", password)
-	assert.Error(t, err, "Несуществующий пользователь должен вызывать ошибку")
+func (m *MockStorageDB) CreateAPIToken(token *models.APIToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
 
-	// Проверяем ожидания мока
-	mockStorage.AssertExpectations(t)
+func (m *MockStorageDB) ListAPITokens(userID int) ([]models.APIToken, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.APIToken), args.Error(1)
 }
 
-// TestUploadUserFile проверяет загрузку файла
-func TestUploadUserFile(t *testing.T) {
-	// Создаем моки
-	mockStorage := new(MockStorageDB)
-	mockMinioClient := new(MockMinioClient)
+func (m *MockStorageDB) UseAPIToken(tokenHash string) (*models.APIToken, error) {
+	args := m.Called(tokenHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.APIToken), args.Error(1)
+}
 
-	// Настраиваем сервис с моками
-	srv := &service.Service{
-		Storagedb: mockStorage,
-		MinioConfig: service.MinioConfig{
-			Endpoint: "localhost:9000",
-			Secure:   false,
-		},
+func (m *MockStorageDB) DeleteAPIToken(userID, id int) (bool, error) {
+	args := m.Called(userID, id)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockStorageDB) CreateSession(session *models.Session) error {
+	args := m.Called(session)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) ListSessions(userID int) ([]models.Session, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
 	}
+	return args.Get(0).([]models.Session), args.Error(1)
+}
 
-	// Мокируем интерфейс для GetUserMinioClient
-	userID := 1
-	bucketName := "test-bucket"
-	accessKey := "test-access"
-	secretKey := "test-secret"
+func (m *MockStorageDB) RotateSession(id, userID int, oldHash, newHash, ip, userAgent string, expiresAt time.Time) (bool, error) {
+	args := m.Called(id, userID, oldHash, newHash, ip, userAgent, expiresAt)
+	return args.Bool(0), args.Error(1)
+}
 
-	// Настраиваем мок для получения учетных данных
-	mockStorage.On("GetMinIOCredentials", userID).Return(bucketName, accessKey, secretKey, nil)
+func (m *MockStorageDB) SessionActive(userID, id int) (bool, error) {
+	args := m.Called(userID, id)
+	return args.Bool(0), args.Error(1)
+}
 
-	// Настраиваем данные для загрузки
-	objectName := "test-file.txt"
-	content := "test content"
-	reader := strings.NewReader(content)
-	size := int64(len(content))
-	contentType := "text/plain"
+func (m *MockStorageDB) DeleteSession(userID, id int) (bool, error) {
+	args := m.Called(userID, id)
+	return args.Bool(0), args.Error(1)
+}
 
-	// Создаем ожидаемый результат
-	expectedUploadInfo := minio.UploadInfo{
-		Bucket: bucketName,
-		Key:    objectName,
-		ETag:   "test-etag",
-		Size:   size,
+func (m *MockStorageDB) CreateUserToken(token *models.UserToken) error {
+	args := m.Called(token)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) ConsumeUserToken(tokenHash, purpose string) (*models.UserToken, error) {
+	args := m.Called(tokenHash, purpose)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
 	}
+	return args.Get(0).(*models.UserToken), args.Error(1)
+}
 
-	// Устанавливаем мок-функцию вместо сохранения старой
-	srv.ExecFileOpFunc = func(ctx context.Context, userID int, operation service.FileOperationFunc) (any, error) {
-		// Вызываем операцию с мок-клиентом
-		mockMinioClient.On("PutObject", ctx, bucketName, objectName, reader, size,
-			minio.PutObjectOptions{ContentType: contentType}).Return(expectedUploadInfo, nil)
+func (m *MockStorageDB) ConfirmEmailChange(tokenHash string) (*models.UserToken, error) {
+	args := m.Called(tokenHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.UserToken), args.Error(1)
+}
 
-		return operation(ctx, mockMinioClient, bucketName)
+func (m *MockStorageDB) DeleteUserTokens(userID int, purpose string) error {
+	args := m.Called(userID, purpose)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) GetUserByEmail(email string) (*models.User, error) {
+	args := m.Called(email)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
 	}
+	return args.Get(0).(*models.User), args.Error(1)
+}
 
-	// Выполняем тест
-	uploadInfo, err := srv.UploadUserFile(context.Background(), userID, objectName, reader, size, contentType)
+func (m *MockStorageDB) UpdatePassword(userID int, passwordHash string) error {
+	args := m.Called(userID, passwordHash)
+	return args.Error(0)
+}
 
-	// Проверяем результаты
-	assert.NoError(t, err, "Загрузка файла должна быть успешной")
-	assert.Equal(t, expectedUploadInfo, uploadInfo, "Информация о загрузке должна совпадать")
+func (m *MockStorageDB) SetUserAdmin(userID int, isAdmin bool) error {
+	args := m.Called(userID, isAdmin)
+	return args.Error(0)
+}
 
-	// Проверяем ожидания моков
-	mockMinioClient.AssertExpectations(t)
+func (m *MockStorageDB) UpdateUser(user *models.User) error {
+	args := m.Called(user)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) UpdateProfile(user *models.User, token *models.UserToken, deliver func() error) error {
+	args := m.Called(user, token)
+	if err := args.Error(0); err != nil {
+		return err
+	}
+	if deliver != nil {
+		return deliver()
+	}
+	return nil
+}
+
+func (m *MockStorageDB) DeleteUser(id int) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) CreateMinIOUser(userID int, bucketName, accessKey, secretKey string) error {
+	args := m.Called(userID, bucketName, accessKey, secretKey)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) GetMinIOCredentials(userID int) (string, string, string, error) {
+	args := m.Called(userID)
+	return args.String(0), args.String(1), args.String(2), args.Error(3)
+}
+
+func (m *MockStorageDB) AppendChange(change *models.Change) error {
+	args := m.Called(change)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) ListChanges(userID int, since int64, limit int) ([]models.Change, error) {
+	args := m.Called(userID, since, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Change), args.Error(1)
+}
+
+func (m *MockStorageDB) GetLatestChange(userID int, key string) (*models.Change, error) {
+	args := m.Called(userID, key)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Change), args.Error(1)
+}
+
+func (m *MockStorageDB) GetLatestChangeSeq(userID int) (int64, error) {
+	args := m.Called(userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockStorageDB) CreateSharedSpace(space *models.SharedSpace) error {
+	args := m.Called(space)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) GetSharedSpace(id int) (*models.SharedSpace, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.SharedSpace), args.Error(1)
+}
+
+func (m *MockStorageDB) ListUserSharedSpaces(userID int) ([]models.SharedSpace, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.SharedSpace), args.Error(1)
+}
+
+func (m *MockStorageDB) DeleteSharedSpace(id int) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) SetSpaceMember(spaceID, userID int, role string) error {
+	args := m.Called(spaceID, userID, role)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) RemoveSpaceMember(spaceID, userID int) error {
+	args := m.Called(spaceID, userID)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) ListSpaceMembers(spaceID int) ([]models.SpaceMember, error) {
+	args := m.Called(spaceID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.SpaceMember), args.Error(1)
+}
+
+func (m *MockStorageDB) ListSpaceUserIDs(spaceID int) ([]int, error) {
+	args := m.Called(spaceID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]int), args.Error(1)
+}
+
+func (m *MockStorageDB) GetSpaceMemberRole(spaceID, userID int) (string, error) {
+	args := m.Called(spaceID, userID)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockStorageDB) ListUserDirectSharedSpaces(userID int) ([]models.SharedSpace, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.SharedSpace), args.Error(1)
+}
+
+func (m *MockStorageDB) ListGroupSharedSpaces(groupID int) ([]models.SharedSpace, error) {
+	args := m.Called(groupID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.SharedSpace), args.Error(1)
+}
+
+func (m *MockStorageDB) SetSpaceGroupGrant(spaceID, groupID int, role string) error {
+	args := m.Called(spaceID, groupID, role)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) RemoveSpaceGroupGrant(spaceID, groupID int) error {
+	args := m.Called(spaceID, groupID)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) ListSpaceGroupGrants(spaceID int) ([]models.SpaceGroupGrant, error) {
+	args := m.Called(spaceID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.SpaceGroupGrant), args.Error(1)
+}
+
+func (m *MockStorageDB) CreateGroup(group *models.Group) error {
+	args := m.Called(group)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) GetGroup(id int) (*models.Group, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Group), args.Error(1)
+}
+
+func (m *MockStorageDB) GetGroupByName(name string) (*models.Group, error) {
+	args := m.Called(name)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Group), args.Error(1)
+}
+
+func (m *MockStorageDB) ListUserGroups(userID int) ([]models.Group, error) {
+	args := m.Called(userID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Group), args.Error(1)
+}
+
+func (m *MockStorageDB) DeleteGroup(id int) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) AddGroupMember(groupID, userID int) error {
+	args := m.Called(groupID, userID)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) RemoveGroupMember(groupID, userID int) error {
+	args := m.Called(groupID, userID)
+	return args.Error(0)
+}
+
+func (m *MockStorageDB) ListGroupMembers(groupID int) ([]models.GroupMember, error) {
+	args := m.Called(groupID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.GroupMember), args.Error(1)
+}
+
+// MockMinIO мок для MinIO
+type MockMinIO struct {
+	mock.Mock
+	Client      *MockMinioClient
+	AdminClient *MockAdminClient
+}
+
+// MockMinioClient мок для minio.Client
+type MockMinioClient struct {
+	mock.Mock
+}
+
+func (m *MockMinioClient) PutObject(ctx context.Context, bucketName, objectName string,
+	reader io.Reader, size int64, opts minio.PutObjectOptions,
+) (minio.UploadInfo, error) {
+	args := m.Called(ctx, bucketName, objectName, reader, size, opts)
+	return args.Get(0).(minio.UploadInfo), args.Error(1)
+}
+
+func (m *MockMinioClient) GetObject(ctx context.Context, bucketName, objectName string,
+	opts minio.GetObjectOptions,
+) (*minio.Object, error) {
+	args := m.Called(ctx, bucketName, objectName, opts)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*minio.Object), args.Error(1)
+}
+
+func (m *MockMinioClient) StatObject(ctx context.Context, bucketName, objectName string,
+	opts minio.StatObjectOptions,
+) (minio.ObjectInfo, error) {
+	args := m.Called(ctx, bucketName, objectName, opts)
+	return args.Get(0).(minio.ObjectInfo), args.Error(1)
+}
+
+func (m *MockMinioClient) ListObjects(ctx context.Context, bucketName string,
+	opts minio.ListObjectsOptions,
+) <-chan minio.ObjectInfo {
+	args := m.Called(ctx, bucketName, opts)
+	return args.Get(0).(<-chan minio.ObjectInfo)
+}
+
+func (m *MockMinioClient) RemoveObject(ctx context.Context, bucketName, objectName string,
+	opts minio.RemoveObjectOptions,
+) error {
+	args := m.Called(ctx, bucketName, objectName, opts)
+	return args.Error(0)
+}
+
+func (m *MockMinioClient) CopyObject(ctx context.Context, dst minio.CopyDestOptions,
+	src minio.CopySrcOptions,
+) (minio.UploadInfo, error) {
+	args := m.Called(ctx, dst, src)
+	return args.Get(0).(minio.UploadInfo), args.Error(1)
+}
+
+func (m *MockMinioClient) MakeBucket(ctx context.Context, bucketName string,
+	opts minio.MakeBucketOptions,
+) error {
+	args := m.Called(ctx, bucketName, opts)
+	return args.Error(0)
+}
+
+func (m *MockMinioClient) RemoveBucket(ctx context.Context, bucketName string) error {
+	args := m.Called(ctx, bucketName)
+	return args.Error(0)
+}
+
+func (m *MockMinioClient) RemoveBucketWithOptions(ctx context.Context, bucketName string,
+	opts minio.RemoveBucketOptions,
+) error {
+	args := m.Called(ctx, bucketName, opts)
+	return args.Error(0)
+}
+
+// MockAdminClient мок для madmin.AdminClient
+type MockAdminClient struct {
+	mock.Mock
+}
+
+func (m *MockAdminClient) AddUser(ctx context.Context, accessKey, secretKey string) error {
+	args := m.Called(ctx, accessKey, secretKey)
+	return args.Error(0)
+}
+
+func (m *MockAdminClient) AttachPolicy(ctx context.Context, req madmin.PolicyAssociationReq) (madmin.PolicyAssociationResp, error) {
+	args := m.Called(ctx, req)
+	return args.Get(0).(madmin.PolicyAssociationResp), args.Error(1)
+}
+
+func (m *MockAdminClient) RemoveUser(ctx context.Context, accessKey string) error {
+	args := m.Called(ctx, accessKey)
+	return args.Error(0)
+}
+
+func (m *MockAdminClient) DetachPolicy(ctx context.Context, req madmin.PolicyAssociationReq) (madmin.PolicyAssociationResp, error) {
+	args := m.Called(ctx, req)
+	return args.Get(0).(madmin.PolicyAssociationResp), args.Error(1)
+}
+
+func (m *MockAdminClient) AddCannedPolicy(ctx context.Context, policyName string, policy []byte) error {
+	args := m.Called(ctx, policyName, policy)
+	return args.Error(0)
+}
+
+func (m *MockAdminClient) RemoveCannedPolicy(ctx context.Context, policyName string) error {
+	args := m.Called(ctx, policyName)
+	return args.Error(0)
+}
+
+func (m *MockAdminClient) GetGroupDescription(ctx context.Context, group string) (*madmin.GroupDesc, error) {
+	args := m.Called(ctx, group)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*madmin.GroupDesc), args.Error(1)
+}
+
+func (m *MockAdminClient) UpdateGroupMembers(ctx context.Context, g madmin.GroupAddRemove) error {
+	args := m.Called(ctx, g)
+	return args.Error(0)
+}
+
+func (m *MockAdminClient) SetBucketQuota(ctx context.Context, bucket string, quota *madmin.BucketQuota) error {
+	args := m.Called(ctx, bucket, quota)
+	return args.Error(0)
+}
+
+// MockMailer мок для отправки писем
+type MockMailer struct {
+	mock.Mock
+}
+
+func (m *MockMailer) Send(ctx context.Context, msg mailer.Message) error {
+	args := m.Called(ctx, msg)
+	return args.Error(0)
+}
+
+// MockAuthenticator мок внешнего каталога учетных записей
+type MockAuthenticator struct {
+	mock.Mock
+}
+
+func (m *MockAuthenticator) Authenticate(ctx context.Context, username, password string) (*models.DirectoryUser, error) {
+	args := m.Called(username, password)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.DirectoryUser), args.Error(1)
+}
+
+// TestPasswordHash проверяет хеширование пароля
+func TestPasswordHash(t *testing.T) {
+	srv := &service.Service{}
+	password := "testpassword"
+
+	// Проверяем хеширование пароля
+	hash, err := srv.PasswordHash(password)
+	require.NoError(t, err, "Ошибка хеширования пароля")
+	assert.NotEmpty(t, hash, "Хеш не должен быть пустым")
+	assert.NotEqual(t, password, hash, "Хеш должен отличаться от пароля")
+
+	// Проверяем, что разные вызовы дают разные хеши (соль)
+	hash2, err := srv.PasswordHash(password)
+	require.NoError(t, err)
+	assert.NotEqual(t, hash, hash2, "Разные вызовы должны давать разные хеши")
+
+	// Проверяем верификацию пароля
+	assert.True(t, srv.VerifyPassword(password, hash), "Пароль должен проходить проверку")
+	assert.False(t, srv.VerifyPassword("wrongpassword", hash), "Неверный пароль не должен проходить проверку")
+}
+
+// TestLoginUser проверяет вход пользователя
+func TestLoginUser(t *testing.T) {
+	// Создаем моки и сервис
+	mockStorage := new(MockStorageDB)
+	srv := &service.Service{
+		Storagedb: mockStorage,
+		JWTConfig: service.JWTConfig{
+			AccessSecret:  "test-access-secret",
+			RefreshSecret: "test-refresh-secret",
+			AccessTTL:     900,
+			RefreshTTL:    604800,
+		},
+	}
+	mockStorage.On("CreateSession", mock.Anything).Return(nil).Maybe()
+
+	// Создаем тестовые данные
+	username := "testuser"
+	password := "testpassword"
+
+	// Хешируем пароль для теста
+	hash, err := srv.PasswordHash(password)
+	require.NoError(t, err)
+
+	// Настраиваем мок для успешного входа
+	mockStorage.On("GetUserByUsername", username).Return(&models.User{
+		ID:                1,
+		UserName:          username,
+		PasswordHash:      hash,
+		Email:             "test@example.com",
+		ProvisioningState: models.ProvisioningReady,
+	}, nil)
+	mockStorage.On("GetTOTP", 1).Return(nil, nil)
+
+	// Проверяем успешный вход
+	user, tokens, err := srv.LoginUser(context.Background(), username, password, "192.0.2.1")
+	assert.NoError(t, err, "Вход должен быть успешным")
+	assert.NotNil(t, user, "Пользователь не должен быть nil")
+	assert.NotNil(t, tokens, "Токены не должны быть nil")
+	assert.NotEmpty(t, tokens.AccessToken, "Access токен не должен быть пустым")
+	assert.NotEmpty(t, tokens.RefreshToken, "Refresh токен не должен быть пустым")
+
+	// Проверяем неверный пароль: попытка записывается в журнал
+	mockStorage.On("RecordLoginFailure", &models.LoginFailure{Username: username, IP: "192.0.2.1", Reason: models.LoginFailurePassword}).Return(nil).Once()
+	_, _, err = srv.LoginUser(context.Background(), username, "wrongpassword", "192.0.2.1")
+	assert.Error(t, err, "Неверный пароль должен вызывать ошибку")
+
+	// Пользователь, хранилище которого еще создается, войти не может
+	mockStorage.On("GetUserByUsername", "newuser").Return(&models.User{
+		ID:                2,
+		UserName:          "newuser",
+		PasswordHash:      hash,
+		ProvisioningState: models.ProvisioningPolicy,
+	}, nil)
+	_, _, err = srv.LoginUser(context.Background(), "newuser", password, "192.0.2.1")
+	assert.ErrorIs(t, err, service.ErrAccountNotReady)
+
+	// Настраиваем мок для несуществующего пользователя
+	mockStorage.On("GetUserByUsername", "nonexistent").Return(nil, storagedb.ErrUserNotFound)
+
+	// Несуществующий пользователь неотличим от неверного пароля
+	mockStorage.On("RecordLoginFailure", &models.LoginFailure{Username: "nonexistent", IP: "192.0.2.1", Reason: models.LoginFailurePassword}).Return(nil).Once()
+	_, _, err = srv.LoginUser(context.Background(), "nonexistent", password, "192.0.2.1")
+	assert.ErrorIs(t, err, service.ErrInvalidCredentials)
+
+	// Сбой БД не выдается за неверный пароль
+	mockStorage.On("GetUserByUsername", "broken").Return(nil, errors.New("соединение разорвано"))
+	_, _, err = srv.LoginUser(context.Background(), "broken", password, "192.0.2.1")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, service.ErrInvalidCredentials)
+
+	// Проверяем ожидания мока
+	mockStorage.AssertExpectations(t)
+}
+
+// TestUploadUserFile проверяет загрузку файла
+func TestUploadUserFile(t *testing.T) {
+	// Создаем моки
+	mockStorage := new(MockStorageDB)
+	mockMinioClient := new(MockMinioClient)
+
+	// Настраиваем сервис с моками
+	srv := &service.Service{
+		Storagedb: mockStorage,
+		MinioConfig: service.MinioConfig{
+			Endpoint: "localhost:9000",
+			Secure:   false,
+		},
+	}
+
+	// Мокируем интерфейс для GetUserMinioClient
+	userID := 1
+	bucketName := "test-bucket"
+	accessKey := "test-access"
+	secretKey := "test-secret"
+
+	// Настраиваем мок для получения учетных данных
+	mockStorage.On("GetMinIOCredentials", userID).Return(bucketName, accessKey, secretKey, nil)
+	mockStorage.On("AppendChange", mock.Anything).Return(nil)
+
+	// Настраиваем данные для загрузки
+	objectName := "test-file.txt"
+	content := "test content"
+	reader := strings.NewReader(content)
+	size := int64(len(content))
+	contentType := "text/plain"
+
+	// Создаем ожидаемый результат
+	expectedUploadInfo := minio.UploadInfo{
+		Bucket: bucketName,
+		Key:    objectName,
+		ETag:   "test-etag",
+		Size:   size,
+	}
+
+	// Устанавливаем мок-функцию вместо сохранения старой
+	srv.ExecFileOpFunc = func(ctx context.Context, userID int, operation service.FileOperationFunc) (any, error) {
+		// Вызываем операцию с мок-клиентом
+		mockMinioClient.On("PutObject", ctx, bucketName, objectName, reader, size,
+			minio.PutObjectOptions{ContentType: contentType}).Return(expectedUploadInfo, nil)
+
+		return operation(ctx, mockMinioClient, bucketName)
+	}
+
+	// Выполняем тест
+	uploadInfo, err := srv.UploadUserFile(context.Background(), userID, objectName, reader, size, contentType)
+
+	// Проверяем результаты
+	assert.NoError(t, err, "Загрузка файла должна быть успешной")
+	assert.Equal(t, expectedUploadInfo, uploadInfo, "Информация о загрузке должна совпадать")
+
+	// Проверяем ожидания моков
+	mockMinioClient.AssertExpectations(t)
+}
+
+// objectsChan возвращает закрытый канал с объектами для мока ListObjects
+func objectsChan(keys ...string) <-chan minio.ObjectInfo {
+	ch := make(chan minio.ObjectInfo, len(keys))
+	for i, key := range keys {
+		size := int64(i + 1)
+		if strings.HasSuffix(key, "/") {
+			size = 0
+		}
+		ch <- minio.ObjectInfo{Key: key, Size: size}
+	}
+	close(ch)
+	return ch
+}
+
+// TestListUserDirectoryPagination проверяет постраничный листинг с курсором
+func TestListUserDirectoryPagination(t *testing.T) {
+	mockMinioClient := new(MockMinioClient)
+	bucketName := "test-bucket"
+
+	srv := &service.Service{
+		ExecFileOpFunc: func(ctx context.Context, userID int, operation service.FileOperationFunc) (any, error) {
+			return operation(ctx, mockMinioClient, bucketName)
+		},
+	}
+
+	// Первая страница: читаем с начала
+	mockMinioClient.On("ListObjects", mock.Anything, bucketName, minio.ListObjectsOptions{
+		Prefix: "photos/",
+	}).Return(objectsChan("photos/", "photos/2023/", "photos/a.jpg", "photos/b.jpg")).Once()
+
+	page, err := srv.ListUserDirectory(context.Background(), 1, service.ListOptions{Prefix: "photos/", Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2023"}, page.Folders, "Маркер самой папки не должен попадать в листинг")
+	require.Len(t, page.Files, 1)
+	assert.Equal(t, "photos/a.jpg", page.Files[0].Key)
+	require.NotEmpty(t, page.NextCursor, "Должен вернуться курсор следующей страницы")
+
+	// Вторая страница: курсор превращается в StartAfter
+	mockMinioClient.On("ListObjects", mock.Anything, bucketName, minio.ListObjectsOptions{
+		Prefix:     "photos/",
+		StartAfter: "photos/a.jpg",
+	}).Return(objectsChan("photos/b.jpg")).Once()
+
+	page, err = srv.ListUserDirectory(context.Background(), 1, service.ListOptions{
+		Prefix: "photos/",
+		Limit:  2,
+		Cursor: page.NextCursor,
+	})
+	require.NoError(t, err)
+	require.Len(t, page.Files, 1)
+	assert.Equal(t, "photos/b.jpg", page.Files[0].Key)
+	assert.Empty(t, page.NextCursor, "Последняя страница не должна возвращать курсор")
+
+	mockMinioClient.AssertExpectations(t)
+}
+
+// TestListUserFilesSort проверяет сортировку всего листинга и проверку параметров
+func TestListUserFilesSort(t *testing.T) {
+	mockMinioClient := new(MockMinioClient)
+	bucketName := "test-bucket"
+
+	srv := &service.Service{
+		ExecFileOpFunc: func(ctx context.Context, userID int, operation service.FileOperationFunc) (any, error) {
+			return operation(ctx, mockMinioClient, bucketName)
+		},
+	}
+
+	mockMinioClient.On("ListObjects", mock.Anything, bucketName, mock.Anything).
+		Return(objectsChan("a.txt", "b.txt", "c.txt")).Once()
+
+	page, err := srv.ListUserFiles(context.Background(), 1, service.ListOptions{Sort: "-size"})
+	require.NoError(t, err)
+	require.Len(t, page.Files, 3)
+	assert.Equal(t, "c.txt", page.Files[0].Key, "Самый большой файл должен быть первым")
+
+	// Сортировка охватывает весь листинг, а не только первую страницу
+	mockMinioClient.On("ListObjects", mock.Anything, bucketName, mock.Anything).
+		Return(objectsChan("a.txt", "b.txt", "c.txt")).Once()
+
+	page, err = srv.ListUserFiles(context.Background(), 1, service.ListOptions{Sort: "-size", Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page.Files, 2)
+	assert.Equal(t, "c.txt", page.Files[0].Key)
+	assert.Equal(t, "b.txt", page.Files[1].Key)
+	require.NotEmpty(t, page.NextCursor)
+
+	// Вторая страница продолжается после b.txt, даже если он уже удален
+	mockMinioClient.On("ListObjects", mock.Anything, bucketName, mock.Anything).
+		Return(objectsChan("a.txt")).Once()
+
+	page, err = srv.ListUserFiles(context.Background(), 1, service.ListOptions{Sort: "-size", Limit: 2, Cursor: page.NextCursor})
+	require.NoError(t, err)
+	require.Len(t, page.Files, 1)
+	assert.Equal(t, "a.txt", page.Files[0].Key)
+	assert.Empty(t, page.NextCursor)
+
+	_, err = srv.ListUserFiles(context.Background(), 1, service.ListOptions{Sort: "-size", Cursor: service.EncodeListCursor("a.txt")})
+	assert.ErrorIs(t, err, service.ErrInvalidListOptions, "Курсор другой сортировки должен отклоняться")
+
+	_, err = srv.ListUserFiles(context.Background(), 1, service.ListOptions{Sort: "color"})
+	assert.ErrorIs(t, err, service.ErrInvalidListOptions)
+
+	_, err = srv.ListUserFiles(context.Background(), 1, service.ListOptions{Limit: service.MaxListLimit + 1})
+	assert.ErrorIs(t, err, service.ErrInvalidListOptions)
+}
+
+// TestArchiveUserFolderNotFound проверяет, что архив пустой папки не начинает передачу
+func TestArchiveUserFolderNotFound(t *testing.T) {
+	mockMinioClient := new(MockMinioClient)
+	bucketName := "test-bucket"
+
+	srv := &service.Service{
+		ExecFileOpFunc: func(ctx context.Context, userID int, operation service.FileOperationFunc) (any, error) {
+			return operation(ctx, mockMinioClient, bucketName)
+		},
+	}
+
+	mockMinioClient.On("ListObjects", mock.Anything, bucketName, minio.ListObjectsOptions{
+		Prefix:    "missing/",
+		Recursive: true,
+	}).Return(objectsChan()).Once()
+
+	var buf strings.Builder
+	err := srv.ArchiveUserFolder(context.Background(), 1, &buf, service.ArchiveZip, "missing")
+	assert.ErrorIs(t, err, service.ErrArchiveSourceNotFound)
+	assert.Zero(t, buf.Len(), "До ошибки в ответ не должно быть записано ни байта")
+
+	mockMinioClient.AssertExpectations(t)
+}
+
+// TestListChanges проверяет постраничное чтение журнала изменений
+func TestListChanges(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	srv := &service.Service{Storagedb: mockStorage}
+
+	// Запрашивается на одну запись больше лимита, чтобы определить наличие следующей страницы
+	mockStorage.On("ListChanges", 1, int64(10), 3).Return([]models.Change{
+		{Seq: 11, Key: "a.txt"},
+		{Seq: 12, Key: "b.txt"},
+		{Seq: 15, Key: "c.txt"},
+	}, nil).Once()
+
+	set, err := srv.ListChanges(context.Background(), 1, 10, 2)
+	require.NoError(t, err)
+	require.Len(t, set.Changes, 2)
+	assert.True(t, set.HasMore)
+	assert.Equal(t, int64(12), set.NextSince)
+
+	// Клиент все получил: since не должен откатываться назад
+	mockStorage.On("ListChanges", 1, int64(15), 3).Return(nil, nil).Once()
+	mockStorage.On("GetLatestChangeSeq", 1).Return(int64(15), nil).Once()
+
+	set, err = srv.ListChanges(context.Background(), 1, 15, 2)
+	require.NoError(t, err)
+	assert.Empty(t, set.Changes)
+	assert.False(t, set.HasMore)
+	assert.Equal(t, int64(15), set.NextSince)
+
+	_, err = srv.ListChanges(context.Background(), 1, -1, 0)
+	assert.ErrorIs(t, err, service.ErrInvalidListOptions)
+
+	mockStorage.AssertExpectations(t)
+}
+
+// TestUploadLimit проверяет ограничение размера загрузки для обычных пользователей и администраторов
+func TestUploadLimit(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	srv := &service.Service{
+		Storagedb: mockStorage,
+		Limits:    service.Limits{MaxUploadBytes: 10},
+		ExecFileOpFunc: func(ctx context.Context, userID int, operation service.FileOperationFunc) (any, error) {
+			t.Fatal("Файл больше допустимого не должен загружаться")
+			return nil, nil
+		},
+	}
+	mockStorage.On("GetUserByID", 1).Return(&models.User{ID: 1}, nil)
+	mockStorage.On("GetUserByID", 2).Return(&models.User{ID: 2, IsAdmin: true}, nil)
+
+	limit, err := srv.UploadLimit(1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), limit)
+
+	// Для администраторов ограничение задается отдельно, 0 — без ограничений
+	limit, err = srv.UploadLimit(2)
+	require.NoError(t, err)
+	assert.Zero(t, limit)
+
+	_, err = srv.UploadUserFile(context.Background(), 1, "big.bin", strings.NewReader("01234567890"), 11, "application/octet-stream")
+	assert.ErrorIs(t, err, service.ErrUploadTooLarge)
+
+	// Без ограничений пользователь из БД не читается
+	srv.Limits = service.Limits{}
+	limit, err = srv.UploadLimit(3)
+	require.NoError(t, err)
+	assert.Zero(t, limit)
+
+	mockStorage.AssertExpectations(t)
+}
+
+// TestUploadUserFileConflict проверяет обнаружение конфликта по журналу и по ETag
+func TestUploadUserFileConflict(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	mockMinioClient := new(MockMinioClient)
+	bucketName := "test-bucket"
+
+	srv := &service.Service{
+		Storagedb: mockStorage,
+		ExecFileOpFunc: func(ctx context.Context, userID int, operation service.FileOperationFunc) (any, error) {
+			return operation(ctx, mockMinioClient, bucketName)
+		},
+	}
+
+	// Файл изменился после версии клиента: MinIO не вызывается
+	mockStorage.On("GetLatestChange", 1, "doc.txt").Return(&models.Change{Seq: 7, Key: "doc.txt"}, nil).Once()
+
+	_, err := srv.UploadUserFileConditional(context.Background(), 1, "doc.txt", strings.NewReader("x"), 1, "text/plain",
+		service.UploadCondition{BaseSeq: 5})
+	assert.ErrorIs(t, err, service.ErrConflict)
+
+	// Журнал не изменился, но запись все равно выполняется с условием на ETag из журнала:
+	// файл, измененный между чтением журнала и записью, не будет перезаписан
+	mockStorage.On("GetLatestChange", 1, "doc.txt").Return(&models.Change{Seq: 5, Key: "doc.txt", Type: "created", ETag: "etag-5"}, nil).Once()
+	mockMinioClient.On("PutObject", mock.Anything, bucketName, "doc.txt", mock.Anything, int64(1),
+		mock.MatchedBy(func(opts minio.PutObjectOptions) bool {
+			return strings.Trim(opts.Header().Get("If-Match"), `"`) == "etag-5"
+		}),
+	).Return(minio.UploadInfo{}, minio.ErrorResponse{Code: "PreconditionFailed", StatusCode: 412}).Once()
+
+	_, err = srv.UploadUserFileConditional(context.Background(), 1, "doc.txt", strings.NewReader("x"), 1, "text/plain",
+		service.UploadCondition{BaseSeq: 5})
+	assert.ErrorIs(t, err, service.ErrConflict)
+
+	// Файл перемещен: загрузка по старому имени создает файл только если его там нет
+	mockStorage.On("GetLatestChange", 1, "old.txt").Return(&models.Change{Seq: 4, Key: "new.txt", OldKey: "old.txt", Type: "moved"}, nil).Once()
+	mockMinioClient.On("PutObject", mock.Anything, bucketName, "old.txt", mock.Anything, int64(1),
+		mock.MatchedBy(func(opts minio.PutObjectOptions) bool { return opts.Header().Get("If-None-Match") == "*" }),
+	).Return(minio.UploadInfo{Key: "old.txt"}, nil).Once()
+	mockStorage.On("AppendChange", mock.Anything).Return(nil).Once()
+
+	_, err = srv.UploadUserFileConditional(context.Background(), 1, "old.txt", strings.NewReader("x"), 1, "text/plain",
+		service.UploadCondition{BaseSeq: 5})
+	assert.NoError(t, err)
+
+	// ETag не совпал: MinIO отвечает PreconditionFailed
+	mockMinioClient.On("PutObject", mock.Anything, bucketName, "doc.txt", mock.Anything, int64(1), mock.Anything).
+		Return(minio.UploadInfo{}, minio.ErrorResponse{Code: "PreconditionFailed", StatusCode: 412}).Once()
+
+	_, err = srv.UploadUserFileConditional(context.Background(), 1, "doc.txt", strings.NewReader("x"), 1, "text/plain",
+		service.UploadCondition{IfMatch: "old-etag"})
+	assert.ErrorIs(t, err, service.ErrConflict)
+
+	mockStorage.AssertExpectations(t)
+	mockMinioClient.AssertExpectations(t)
+}
+
+// TestUploadUserFileConflictCopy проверяет сохранение проигравшей загрузки как конфликтующей копии
+func TestUploadUserFileConflictCopy(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	mockMinioClient := new(MockMinioClient)
+	bucketName := "test-bucket"
+
+	srv := &service.Service{
+		Storagedb: mockStorage,
+		ExecFileOpFunc: func(ctx context.Context, userID int, operation service.FileOperationFunc) (any, error) {
+			return operation(ctx, mockMinioClient, bucketName)
+		},
+	}
+
+	// На сервере уже другая версия файла
+	mockMinioClient.On("StatObject", mock.Anything, bucketName, "docs/report.xlsx", mock.Anything).
+		Return(minio.ObjectInfo{Key: "docs/report.xlsx", ETag: "new-etag"}, nil).Once()
+	mockMinioClient.On("PutObject", mock.Anything, bucketName,
+		mock.MatchedBy(func(key string) bool {
+			return strings.HasPrefix(key, "docs/report (conflicted copy ") && strings.HasSuffix(key, ").xlsx")
+		}),
+		mock.Anything, int64(1), mock.MatchedBy(func(opts minio.PutObjectOptions) bool {
+			// Копия создается только если такого файла еще нет
+			return opts.Header().Get("If-None-Match") == "*"
+		}),
+	).Return(minio.UploadInfo{Key: "docs/report (conflicted copy 2024-05-01 153000).xlsx", ETag: "copy"}, nil).Once()
+	mockStorage.On("AppendChange", mock.Anything).Return(nil).Once()
+
+	info, err := srv.UploadUserFileConditional(context.Background(), 1, "docs/report.xlsx", strings.NewReader("x"), 1, "",
+		service.UploadCondition{IfMatch: "old-etag", ConflictCopy: true})
+	require.NoError(t, err)
+	assert.NotEqual(t, "docs/report.xlsx", info.Key, "Исходный файл не должен перезаписываться")
+
+	mockStorage.AssertExpectations(t)
+	mockMinioClient.AssertExpectations(t)
+}
+
+// TestMoveUserFile проверяет перемещение файла и условие If-None-Match
+func TestMoveUserFile(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	mockMinioClient := new(MockMinioClient)
+	bucketName := "test-bucket"
+
+	srv := &service.Service{
+		Storagedb: mockStorage,
+		ExecFileOpFunc: func(ctx context.Context, userID int, operation service.FileOperationFunc) (any, error) {
+			return operation(ctx, mockMinioClient, bucketName)
+		},
+	}
+
+	mockMinioClient.On("StatObject", mock.Anything, bucketName, "a.txt", mock.Anything).
+		Return(minio.ObjectInfo{Key: "a.txt", ETag: "etag-a", Size: 3}, nil)
+
+	// Место назначения занято: при If-None-Match ничего не копируется
+	mockMinioClient.On("StatObject", mock.Anything, bucketName, "b.txt", mock.Anything).
+		Return(minio.ObjectInfo{Key: "b.txt"}, nil).Once()
+
+	_, err := srv.MoveUserFile(context.Background(), 1, "a.txt", "b.txt", service.MoveCondition{IfNoneMatch: true})
+	assert.ErrorIs(t, err, service.ErrConflict)
+
+	// Успешное перемещение с проверкой ETag источника
+	mockMinioClient.On("CopyObject", mock.Anything,
+		minio.CopyDestOptions{Bucket: bucketName, Object: "c.txt"},
+		minio.CopySrcOptions{Bucket: bucketName, Object: "a.txt", MatchETag: "etag-a"},
+	).Return(minio.UploadInfo{Bucket: bucketName, Key: "c.txt", ETag: "etag-a"}, nil).Once()
+	mockMinioClient.On("RemoveObject", mock.Anything, bucketName, "a.txt", mock.Anything).Return(nil).Once()
+	mockStorage.On("AppendChange", mock.MatchedBy(func(change *models.Change) bool {
+		return change.Type == "moved" && change.Key == "c.txt" && change.OldKey == "a.txt"
+	})).Return(nil).Once()
+
+	info, err := srv.MoveUserFile(context.Background(), 1, "a.txt", "c.txt", service.MoveCondition{IfMatch: "etag-a"})
+	require.NoError(t, err)
+	assert.Equal(t, "c.txt", info.Key)
+	assert.Equal(t, int64(3), info.Size, "Размер берется из исходного файла")
+
+	// Исходный файл не удалился: копия удаляется, файл остается на прежнем месте
+	mockMinioClient.On("CopyObject", mock.Anything,
+		minio.CopyDestOptions{Bucket: bucketName, Object: "d.txt"},
+		minio.CopySrcOptions{Bucket: bucketName, Object: "a.txt"},
+	).Return(minio.UploadInfo{Bucket: bucketName, Key: "d.txt"}, nil).Once()
+	mockMinioClient.On("RemoveObject", mock.Anything, bucketName, "a.txt", mock.Anything).Return(errors.New("minio недоступен")).Once()
+	mockMinioClient.On("RemoveObject", mock.Anything, bucketName, "d.txt", mock.Anything).Return(nil).Once()
+
+	_, err = srv.MoveUserFile(context.Background(), 1, "a.txt", "d.txt", service.MoveCondition{})
+	assert.Error(t, err)
+
+	_, err = srv.MoveUserFile(context.Background(), 1, "a.txt", "a.txt", service.MoveCondition{})
+	assert.ErrorIs(t, err, service.ErrInvalidPath)
+
+	mockStorage.AssertExpectations(t)
+	mockMinioClient.AssertExpectations(t)
+}
+
+// TestSharedSpaceAccess проверяет проверку списка доступа при файловых операциях в общем пространстве
+func TestSharedSpaceAccess(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	mockMinioClient := new(MockMinioClient)
+
+	srv := &service.Service{
+		Storagedb: mockStorage,
+		ExecFileOpFunc: func(ctx context.Context, userID int, operation service.FileOperationFunc) (any, error) {
+			return operation(ctx, mockMinioClient, "user-bucket")
+		},
+	}
+
+	space := &models.SharedSpace{ID: 7, Name: "family", BucketName: "shared-family", OwnerID: 1}
+	mockStorage.On("GetSharedSpace", 7).Return(space, nil)
+	mockStorage.On("GetSpaceMemberRole", 7, 2).Return(models.SpaceRoleViewer, nil)
+	mockStorage.On("GetSpaceMemberRole", 7, 3).Return("", nil)
+
+	ctx := service.WithSpace(context.Background(), 7)
+
+	// Участник с ролью viewer читает файлы из бакета пространства, а не из личного
+	mockMinioClient.On("ListObjects", mock.Anything, "shared-family", mock.Anything).
+		Return(objectsChan("photo.jpg")).Once()
+
+	page, err := srv.ListUserFiles(ctx, 2, service.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Files, 1)
+
+	// Но не может изменять их
+	_, err = srv.UploadUserFile(ctx, 2, "photo.jpg", strings.NewReader("x"), 1, "image/jpeg")
+	assert.ErrorIs(t, err, service.ErrAccessDenied)
+	err = srv.DeleteUserFile(ctx, 2, "photo.jpg")
+	assert.ErrorIs(t, err, service.ErrAccessDenied)
+
+	// Пользователь без доступа не видит пространство вовсе
+	_, err = srv.ListUserFiles(ctx, 3, service.ListOptions{})
+	assert.ErrorIs(t, err, service.ErrAccessDenied)
+
+	// Владелец может загружать файлы; изменения в пространстве не попадают в личный журнал,
+	// а рассылаются событием всем участникам
+	events, unsubscribe, err := srv.SubscribeEvents(context.Background(), 2)
+	require.NoError(t, err)
+	defer unsubscribe()
+	mockStorage.On("ListSpaceUserIDs", 7).Return([]int{1, 2}, nil).Once()
+	mockMinioClient.On("PutObject", mock.Anything, "shared-family", "doc.txt", mock.Anything, int64(1), mock.Anything).
+		Return(minio.UploadInfo{Bucket: "shared-family", Key: "doc.txt"}, nil).Once()
+
+	_, err = srv.UploadUserFile(ctx, 1, "doc.txt", strings.NewReader("x"), 1, "text/plain")
+	require.NoError(t, err)
+
+	select {
+	case event := <-events:
+		assert.Equal(t, 7, event.Space)
+		assert.Equal(t, "doc.txt", event.Key)
+		assert.Zero(t, event.Seq, "У событий общего пространства нет номера в журнале")
+	case <-time.After(time.Second):
+		t.Fatal("Участник пространства должен получить событие")
+	}
+
+	mockStorage.AssertNotCalled(t, "AppendChange", mock.Anything)
+	mockStorage.AssertExpectations(t)
+	mockMinioClient.AssertExpectations(t)
+}
+
+// TestGrantSpaceAccess проверяет, что управлять доступом может только владелец
+func TestGrantSpaceAccess(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	srv := &service.Service{Storagedb: mockStorage}
+
+	space := &models.SharedSpace{ID: 7, Name: "family", BucketName: "shared-family", OwnerID: 1}
+	mockStorage.On("GetSharedSpace", 7).Return(space, nil)
+	mockStorage.On("GetSpaceMemberRole", 7, 2).Return(models.SpaceRoleEditor, nil)
+	mockStorage.On("GetUserByUsername", "kid").Return(&models.User{ID: 3, UserName: "kid"}, nil)
+	mockStorage.On("SetSpaceMember", 7, 3, models.SpaceRoleViewer).Return(nil).Once()
+
+	member, err := srv.GrantSpaceAccess(context.Background(), 1, 7, "kid", models.SpaceRoleViewer)
+	require.NoError(t, err)
+	assert.Equal(t, 3, member.UserID)
+
+	_, err = srv.GrantSpaceAccess(context.Background(), 2, 7, "kid", models.SpaceRoleEditor)
+	assert.ErrorIs(t, err, service.ErrAccessDenied, "Редактор не может выдавать доступ")
+
+	_, err = srv.GrantSpaceAccess(context.Background(), 1, 7, "kid", "admin")
+	assert.ErrorIs(t, err, service.ErrInvalidSpaceRole)
+
+	// Отсутствующий пользователь — ErrUserNotFound, а сбой БД не выдается за него
+	mockStorage.On("GetUserByUsername", "ghost").Return(nil, storagedb.ErrUserNotFound).Once()
+	_, err = srv.GrantSpaceAccess(context.Background(), 1, 7, "ghost", models.SpaceRoleViewer)
+	assert.ErrorIs(t, err, service.ErrUserNotFound)
+	failure := errors.New("соединение с БД потеряно")
+	mockStorage.On("GetUserByUsername", "broken").Return(nil, failure).Once()
+	_, err = srv.GrantSpaceAccess(context.Background(), 1, 7, "broken", models.SpaceRoleViewer)
+	assert.ErrorIs(t, err, failure)
+	assert.NotErrorIs(t, err, service.ErrUserNotFound)
+
+	_, err = srv.CreateSharedSpace(context.Background(), 1, "Family Photos")
+	assert.ErrorIs(t, err, service.ErrInvalidSpaceName)
+
+	mockStorage.AssertExpectations(t)
+}
+
+// TestCreateSharedSpaceRequiresStorage проверяет, что пространство не создается, пока хранилище владельца не готово
+func TestCreateSharedSpaceRequiresStorage(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	mockBuckets := new(MockMinioClient)
+	srv := &service.Service{Storagedb: mockStorage, Buckets: mockBuckets}
+
+	mockStorage.On("GetUserByID", 1).Return(&models.User{ID: 1, ProvisioningState: models.ProvisioningPending}, nil).Once()
+	_, err := srv.CreateSharedSpace(context.Background(), 1, "family")
+	assert.ErrorIs(t, err, service.ErrAccountNotReady)
+
+	mockStorage.On("GetUserByID", 2).Return(&models.User{ID: 2, ProvisioningState: models.ProvisioningUnverified}, nil).Once()
+	_, err = srv.CreateSharedSpace(context.Background(), 2, "family")
+	assert.ErrorIs(t, err, service.ErrEmailNotVerified)
+
+	mockStorage.AssertNotCalled(t, "CreateSharedSpace", mock.Anything)
+	mockBuckets.AssertNotCalled(t, "MakeBucket", mock.Anything, mock.Anything, mock.Anything)
+	mockStorage.AssertExpectations(t)
+}
+
+// TestGroupGrantsSpaceAccess проверяет управление группой и доступ группы к общему пространству
+func TestGroupGrantsSpaceAccess(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	srv := &service.Service{Storagedb: mockStorage}
+
+	group := &models.Group{ID: 4, Name: "family", OwnerID: 1}
+	mockStorage.On("GetGroup", 4).Return(group, nil)
+	mockStorage.On("ListGroupMembers", 4).Return([]models.GroupMember{
+		{GroupID: 4, UserID: 1, UserName: "mom"},
+		{GroupID: 4, UserID: 2, UserName: "kid"},
+	}, nil)
+
+	// Участник группы не может добавлять других
+	_, err := srv.AddGroupMember(context.Background(), 2, 4, "dad")
+	assert.ErrorIs(t, err, service.ErrAccessDenied)
+
+	// Посторонний не видит группу
+	_, err = srv.ListGroupMembers(context.Background(), 3, 4)
+	assert.ErrorIs(t, err, service.ErrGroupNotFound)
+
+	// Владельца нельзя исключить, а участник может выйти сам
+	err = srv.RemoveGroupMember(context.Background(), 2, 4, 1)
+	assert.ErrorIs(t, err, service.ErrAccessDenied)
+	mockStorage.On("RemoveGroupMember", 4, 2).Return(nil).Once()
+	require.NoError(t, srv.RemoveGroupMember(context.Background(), 2, 4, 2))
+
+	// Владелец пространства выдает доступ группе
+	space := &models.SharedSpace{ID: 7, Name: "photos", BucketName: "shared-photos", OwnerID: 1}
+	mockStorage.On("GetSharedSpace", 7).Return(space, nil)
+	mockStorage.On("GetGroupByName", "family").Return(group, nil)
+	mockStorage.On("SetSpaceGroupGrant", 7, 4, models.SpaceRoleEditor).Return(nil).Once()
+
+	grant, err := srv.GrantSpaceGroupAccess(context.Background(), 1, 7, "family", models.SpaceRoleEditor)
+	require.NoError(t, err)
+	assert.Equal(t, "family", grant.GroupName)
+
+	mockStorage.On("GetGroupByName", "strangers").Return(nil, nil)
+	_, err = srv.GrantSpaceGroupAccess(context.Background(), 1, 7, "strangers", models.SpaceRoleViewer)
+	assert.ErrorIs(t, err, service.ErrGroupNotFound)
+
+	mockStorage.AssertExpectations(t)
+}
+
+// TestGroupSyncSkipsUnprovisioned проверяет, что участник без готового хранилища не ломает группу MinIO
+func TestGroupSyncSkipsUnprovisioned(t *testing.T) {
+	srv, mockStorage, mockAdmin, _ := newProvisioningService()
+	ctx := context.Background()
+
+	group := &models.Group{ID: 4, Name: "family", OwnerID: 1}
+	mockStorage.On("GetGroup", 4).Return(group, nil)
+	mockStorage.On("ListGroupMembers", 4).Return([]models.GroupMember{
+		{GroupID: 4, UserID: 1, UserName: "mom"},
+		{GroupID: 4, UserID: 2, UserName: "kid"},
+	}, nil)
+	mockStorage.On("GetUserByUsername", "kid").Return(&models.User{ID: 2, UserName: "kid"}, nil)
+	mockStorage.On("AddGroupMember", 4, 2).Return(nil)
+	mockStorage.On("GetUserByID", 1).Return(&models.User{ID: 1, MinioAccessKey: "ak-mom",
+		ProvisioningState: models.ProvisioningReady}, nil)
+	mockStorage.On("GetUserByID", 2).Return(&models.User{ID: 2, MinioAccessKey: "ak-kid",
+		ProvisioningState: models.ProvisioningUnverified}, nil).Once()
+	mockAdmin.On("GetGroupDescription", mock.Anything, "group-family").
+		Return(&madmin.GroupDesc{Members: []string{"ak-mom"}}, nil).Once()
+
+	// Пользователя MinIO у kid еще нет, поэтому состав группы MinIO не меняется
+	_, err := srv.AddGroupMember(ctx, 1, 4, "kid")
+	require.NoError(t, err)
+	mockAdmin.AssertNotCalled(t, "UpdateGroupMembers", mock.Anything, mock.Anything)
+
+	// Когда хранилище готово, следующая синхронизация добавляет kid в группу MinIO
+	mockStorage.On("GetUserByID", 2).Return(&models.User{ID: 2, MinioAccessKey: "ak-kid",
+		ProvisioningState: models.ProvisioningReady}, nil).Once()
+	mockAdmin.On("GetGroupDescription", mock.Anything, "group-family").
+		Return(&madmin.GroupDesc{Members: []string{"ak-mom"}}, nil).Once()
+	mockAdmin.On("UpdateGroupMembers", mock.Anything, madmin.GroupAddRemove{Group: "group-family", Members: []string{"ak-kid"}}).
+		Return(nil).Once()
+	_, err = srv.AddGroupMember(ctx, 1, 4, "kid")
+	require.NoError(t, err)
+
+	mockStorage.AssertExpectations(t)
+	mockAdmin.AssertExpectations(t)
+}
+
+// newProvisioningService создает сервис с моками БД и административных клиентов MinIO
+func newProvisioningService() (*service.Service, *MockStorageDB, *MockAdminClient, *MockMinioClient) {
+	mockStorage := new(MockStorageDB)
+	mockAdmin := new(MockAdminClient)
+	mockBuckets := new(MockMinioClient)
+	srv := &service.Service{
+		Storagedb: mockStorage,
+		Admin:     mockAdmin,
+		Buckets:   mockBuckets,
+		JWTConfig: service.JWTConfig{
+			AccessSecret:  "test-access-secret",
+			RefreshSecret: "test-refresh-secret",
+			AccessTTL:     900,
+			RefreshTTL:    604800,
+		},
+	}
+	// Каждый вход создает сеанс
+	mockStorage.On("CreateSession", mock.Anything).Return(nil).Maybe()
+	// После создания хранилища выравниваются группы пользователя
+	mockStorage.On("ListUserGroups", mock.Anything).Return(nil, nil).Maybe()
+	return srv, mockStorage, mockAdmin, mockBuckets
+}
+
+// expectCreateUser ожидает создание записи пользователя и возвращает матчеры
+// сгенерированного при регистрации имени хранилища и имени политики пользователя
+func expectCreateUser(mockStorage *MockStorageDB, username string, userID int) (storageName, policyName any) {
+	// Другие пользователи уже есть, поэтому регистрация не становится первой
+	mockStorage.On("CountUsers").Return(1, nil).Maybe()
+	var config *models.MinioConfig
+	mockStorage.On("CreateUser", username, mock.Anything, "a@example.com", mock.Anything).
+		Run(func(args mock.Arguments) { config = args.Get(3).(*models.MinioConfig) }).
+		Return(userID, nil)
+
+	storageName = mock.MatchedBy(func(name string) bool { return config != nil && name == config.BucketName })
+	policyName = mock.MatchedBy(func(name string) bool { return config != nil && name == "nas-user-"+config.AccessKey })
+	return storageName, policyName
+}
+
+// TestRegisterUserProvisioning проверяет сохранение состояния после каждого шага и откат при сбое любого из них
+func TestRegisterUserProvisioning(t *testing.T) {
+	failure := madmin.ErrorResponse{Code: "XMinioAdminInvalidArgument", Message: "отказ MinIO"}
+	noSuchBucket := minio.ErrorResponse{Code: "NoSuchBucket"}
+
+	tests := []struct {
+		name      string
+		failAt    int    // Номер шага, на котором MinIO возвращает ошибку; -1 — без сбоев
+		lastState string // Состояние, из которого начинается откат
+	}{
+		{name: "успешное создание", failAt: -1},
+		{name: "сбой создания пользователя MinIO", failAt: 0, lastState: models.ProvisioningPending},
+		{name: "сбой привязки политики", failAt: 1, lastState: models.ProvisioningMinioUser},
+		{name: "сбой создания бакета", failAt: 2, lastState: models.ProvisioningPolicy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, mockStorage, mockAdmin, mockBuckets := newProvisioningService()
+
+			storageName, policyName := expectCreateUser(mockStorage, "alice", 7)
+
+			// Шаги создания: вызов MinIO и сохранение состояния после него
+			steps := []struct {
+				call     *mock.Call
+				from, to string
+			}{
+				{mockAdmin.On("AddUser", mock.Anything, storageName, mock.Anything), models.ProvisioningPending, models.ProvisioningMinioUser},
+				{mockAdmin.On("AttachPolicy", mock.Anything, mock.Anything), models.ProvisioningMinioUser, models.ProvisioningPolicy},
+				{mockBuckets.On("MakeBucket", mock.Anything, storageName, mock.Anything), models.ProvisioningPolicy, models.ProvisioningReady},
+			}
+			mockAdmin.On("AddCannedPolicy", mock.Anything, policyName, mock.Anything).Return(nil).Maybe()
+			for i, step := range steps {
+				var err error
+				if i == tt.failAt {
+					err = failure
+				}
+				if step.call.Method == "AttachPolicy" {
+					step.call.Return(madmin.PolicyAssociationResp{}, err)
+				} else {
+					step.call.Return(err)
+				}
+				if i == tt.failAt {
+					break
+				}
+				mockStorage.On("SetProvisioningState", 7, step.from, step.to).Return(true, nil).Once()
+			}
+
+			if tt.failAt < 0 {
+				mockStorage.On("GetUserByID", 7).Return(&models.User{ID: 7, UserName: "alice", ProvisioningState: models.ProvisioningReady}, nil)
+
+				user, tokens, err := srv.RegisterUser(context.Background(), "alice", "password", "a@example.com", "")
+				require.NoError(t, err)
+				assert.Equal(t, 7, user.ID)
+				assert.NotNil(t, tokens)
+				mockStorage.AssertNotCalled(t, "DeleteUser", 7)
+			} else {
+				// Откат удаляет все, что могло быть создано, и только затем запись пользователя
+				mockStorage.On("SetProvisioningState", 7, tt.lastState, models.ProvisioningRollback).Return(true, nil).Once()
+				mockBuckets.On("RemoveBucket", mock.Anything, storageName).Return(noSuchBucket)
+				mockAdmin.On("RemoveUser", mock.Anything, storageName).Return(nil)
+				mockAdmin.On("RemoveCannedPolicy", mock.Anything, policyName).Return(nil)
+				mockStorage.On("DeleteUser", 7).Return(nil).Once()
+
+				_, _, err := srv.RegisterUser(context.Background(), "alice", "password", "a@example.com", "")
+				assert.ErrorIs(t, err, failure, "Должна возвращаться исходная ошибка, а не ошибка отката")
+			}
+
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+// TestRegisterUserRollbackFailure проверяет, что при неудачном откате запись остается для реконсилера
+func TestRegisterUserRollbackFailure(t *testing.T) {
+	srv, mockStorage, mockAdmin, mockBuckets := newProvisioningService()
+	failure := madmin.ErrorResponse{Code: "XMinioAdminInvalidArgument", Message: "отказ MinIO"}
+
+	storageName, policyName := expectCreateUser(mockStorage, "alice", 7)
+	mockAdmin.On("AddUser", mock.Anything, storageName, mock.Anything).Return(nil)
+	mockStorage.On("SetProvisioningState", 7, models.ProvisioningPending, models.ProvisioningMinioUser).Return(true, nil)
+	mockAdmin.On("AddCannedPolicy", mock.Anything, policyName, mock.Anything).Return(failure)
+	mockStorage.On("SetProvisioningState", 7, models.ProvisioningMinioUser, models.ProvisioningRollback).Return(true, nil)
+	mockBuckets.On("RemoveBucket", mock.Anything, storageName).Return(nil)
+	mockAdmin.On("RemoveUser", mock.Anything, storageName).Return(failure)
+	mockAdmin.On("RemoveCannedPolicy", mock.Anything, policyName).Return(madmin.ErrorResponse{Code: "XMinioAdminNoSuchPolicy"})
+
+	_, _, err := srv.RegisterUser(context.Background(), "alice", "password", "a@example.com", "")
+	assert.ErrorIs(t, err, failure)
+
+	mockStorage.AssertNotCalled(t, "DeleteUser", 7)
+	mockStorage.AssertExpectations(t)
+}
+
+// TestRegisterUserProvisioningRetry проверяет повтор создания после временного сбоя MinIO
+// и то, что сбой, не прошедший за все попытки, оставляет пользователя реконсилеру
+func TestRegisterUserProvisioningRetry(t *testing.T) {
+	outage := minio.ErrorResponse{Code: "ServiceUnavailable", StatusCode: http.StatusServiceUnavailable}
+
+	t.Run("сбой проходит", func(t *testing.T) {
+		srv, mockStorage, mockAdmin, mockBuckets := newProvisioningService()
+		storageName, policyName := expectCreateUser(mockStorage, "alice", 7)
+		mockAdmin.On("AddUser", mock.Anything, storageName, mock.Anything).Return(errors.New("connection refused")).Once()
+		mockAdmin.On("AddUser", mock.Anything, storageName, mock.Anything).Return(nil).Once()
+		mockAdmin.On("AddCannedPolicy", mock.Anything, policyName, mock.Anything).Return(nil)
+		mockAdmin.On("AttachPolicy", mock.Anything, mock.Anything).Return(madmin.PolicyAssociationResp{}, nil)
+		mockBuckets.On("MakeBucket", mock.Anything, storageName, mock.Anything).Return(nil)
+		mockStorage.On("SetProvisioningState", 7, mock.Anything, mock.Anything).Return(true, nil)
+		mockStorage.On("GetUserByID", 7).Return(&models.User{ID: 7, UserName: "alice", ProvisioningState: models.ProvisioningReady}, nil)
+
+		user, _, err := srv.RegisterUser(context.Background(), "alice", "password", "a@example.com", "")
+		require.NoError(t, err)
+		assert.Equal(t, 7, user.ID)
+		mockAdmin.AssertNumberOfCalls(t, "AddUser", 2)
+	})
+
+	t.Run("сбой не проходит", func(t *testing.T) {
+		srv, mockStorage, mockAdmin, mockBuckets := newProvisioningService()
+		storageName, _ := expectCreateUser(mockStorage, "alice", 7)
+		mockAdmin.On("AddUser", mock.Anything, storageName, mock.Anything).Return(nil)
+		mockStorage.On("SetProvisioningState", 7, models.ProvisioningPending, models.ProvisioningMinioUser).Return(true, nil).Once()
+		mockAdmin.On("AddCannedPolicy", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		mockAdmin.On("AttachPolicy", mock.Anything, mock.Anything).Return(madmin.PolicyAssociationResp{}, nil)
+		mockStorage.On("SetProvisioningState", 7, models.ProvisioningMinioUser, models.ProvisioningPolicy).Return(true, nil).Once()
+		mockBuckets.On("MakeBucket", mock.Anything, storageName, mock.Anything).Return(outage)
+
+		_, _, err := srv.RegisterUser(context.Background(), "alice", "password", "a@example.com", "")
+		assert.ErrorIs(t, err, service.ErrAccountNotReady)
+		mockBuckets.AssertNumberOfCalls(t, "MakeBucket", 3)
+		mockStorage.AssertNotCalled(t, "SetProvisioningState", 7, mock.Anything, models.ProvisioningRollback)
+		mockStorage.AssertNotCalled(t, "DeleteUser", 7)
+	})
+}
+
+// TestRegisterUserProvisioningConflict проверяет, что создание, перехваченное другим процессом, не откатывается
+func TestRegisterUserProvisioningConflict(t *testing.T) {
+	srv, mockStorage, mockAdmin, _ := newProvisioningService()
+
+	storageName, _ := expectCreateUser(mockStorage, "alice", 7)
+	mockAdmin.On("AddUser", mock.Anything, storageName, mock.Anything).Return(nil)
+	mockStorage.On("SetProvisioningState", 7, models.ProvisioningPending, models.ProvisioningMinioUser).Return(false, nil)
+
+	_, _, err := srv.RegisterUser(context.Background(), "alice", "password", "a@example.com", "")
+	assert.ErrorIs(t, err, service.ErrProvisioningConflict)
+
+	mockAdmin.AssertNotCalled(t, "RemoveUser", mock.Anything, mock.Anything)
+	mockStorage.AssertNotCalled(t, "DeleteUser", 7)
+	mockStorage.AssertExpectations(t)
+}
+
+// TestReconcileProvisioning проверяет, что при запуске прерванные создания доводятся до конца или откатываются
+func TestReconcileProvisioning(t *testing.T) {
+	srv, mockStorage, mockAdmin, mockBuckets := newProvisioningService()
+	failure := madmin.ErrorResponse{Code: "XMinioAdminInvalidArgument", Message: "отказ MinIO"}
+	outage := errors.New("connection refused")
+
+	mockStorage.On("ListStaleProvisioning", mock.Anything).Return([]models.User{
+		// Прервано после привязки политики: осталось создать бакет
+		{ID: 1, UserName: "alice", MinioBucketName: "user-alice", MinioAccessKey: "user-alice", ProvisioningState: models.ProvisioningPolicy},
+		// Прервано во время отката: откат повторяется
+		{ID: 2, UserName: "bob", MinioBucketName: "user-bob", MinioAccessKey: "user-bob", ProvisioningState: models.ProvisioningRollback},
+		// Продолжить не удается: создание откатывается
+		{ID: 3, UserName: "carol", MinioBucketName: "user-carol", MinioAccessKey: "user-carol", ProvisioningState: models.ProvisioningMinioUser},
+		// MinIO временно недоступен: создание повторяется следующим проходом
+		{ID: 4, UserName: "dave", MinioBucketName: "user-dave", MinioAccessKey: "user-dave", ProvisioningState: models.ProvisioningPending},
+		// Временный сбой повторяется слишком долго: создание откатывается
+		{ID: 5, UserName: "erin", MinioBucketName: "user-erin", MinioAccessKey: "user-erin", ProvisioningState: models.ProvisioningPending},
+	}, nil)
+
+	mockBuckets.On("MakeBucket", mock.Anything, "user-alice", mock.Anything).Return(minio.ErrorResponse{Code: "BucketAlreadyOwnedByYou"})
+	mockStorage.On("SetProvisioningState", 1, models.ProvisioningPolicy, models.ProvisioningReady).Return(true, nil)
+
+	mockBuckets.On("RemoveBucket", mock.Anything, "user-bob").Return(nil)
+	mockAdmin.On("RemoveUser", mock.Anything, "user-bob").Return(madmin.ErrorResponse{Code: "XMinioAdminNoSuchUser"})
+	mockAdmin.On("RemoveCannedPolicy", mock.Anything, "nas-user-user-bob").Return(nil)
+	mockStorage.On("DeleteUser", 2).Return(nil)
+
+	mockAdmin.On("AddCannedPolicy", mock.Anything, "nas-user-user-carol", mock.Anything).Return(failure)
+	mockStorage.On("SetProvisioningState", 3, models.ProvisioningMinioUser, models.ProvisioningRollback).Return(true, nil)
+	mockBuckets.On("RemoveBucket", mock.Anything, "user-carol").Return(minio.ErrorResponse{Code: "NoSuchBucket"})
+	mockAdmin.On("RemoveUser", mock.Anything, "user-carol").Return(nil)
+	mockAdmin.On("RemoveCannedPolicy", mock.Anything, "nas-user-user-carol").Return(nil)
+	mockStorage.On("DeleteUser", 3).Return(nil)
+
+	mockAdmin.On("AddUser", mock.Anything, "user-dave", mock.Anything).Return(outage)
+	mockStorage.On("AddProvisioningFailure", 4).Return(1, nil)
+
+	mockAdmin.On("AddUser", mock.Anything, "user-erin", mock.Anything).Return(outage)
+	mockStorage.On("AddProvisioningFailure", 5).Return(12, nil)
+	mockStorage.On("SetProvisioningState", 5, models.ProvisioningPending, models.ProvisioningRollback).Return(true, nil)
+	mockBuckets.On("RemoveBucket", mock.Anything, "user-erin").Return(minio.ErrorResponse{Code: "NoSuchBucket"})
+	mockAdmin.On("RemoveUser", mock.Anything, "user-erin").Return(madmin.ErrorResponse{Code: "XMinioAdminNoSuchUser"})
+	mockAdmin.On("RemoveCannedPolicy", mock.Anything, "nas-user-user-erin").Return(madmin.ErrorResponse{Code: "XMinioAdminNoSuchPolicy"})
+	mockStorage.On("DeleteUser", 5).Return(nil)
+
+	// Отложенное создание dave считается неудачей прохода
+	err := srv.ReconcileProvisioning(context.Background())
+	assert.ErrorContains(t, err, "1 из 5")
+
+	mockStorage.AssertNotCalled(t, "DeleteUser", 1)
+	mockStorage.AssertNotCalled(t, "DeleteUser", 4)
+	mockStorage.AssertExpectations(t)
+	mockAdmin.AssertExpectations(t)
+	mockBuckets.AssertExpectations(t)
+}
+
+// tokenFromMail извлекает из письма код: единственную строку без пробелов
+func tokenFromMail(msg mailer.Message) string {
+	for _, line := range strings.Split(msg.Body, "\n") {
+		if line != "" && !strings.Contains(line, " ") {
+			return line
+		}
+	}
+	return ""
+}
+
+// TestUpdateProfile проверяет переименование без смены хранилища и смену почты через подтверждение
+func TestUpdateProfile(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	mockMailer := new(MockMailer)
+	srv := &service.Service{Storagedb: mockStorage, Mailer: mockMailer}
+
+	newUser := func() *models.User {
+		return &models.User{ID: 1, UserName: "alice", Email: "a@example.com", MinioBucketName: "user-1234", MinioAccessKey: "user-1234"}
+	}
+	mockStorage.On("GetUserByID", 1).Return(newUser(), nil).Once()
+	mockStorage.On("GetUserByUsername", "bob").Return(&models.User{ID: 2, UserName: "bob"}, nil)
+
+	// Занятое имя отклоняется
+	bob := "bob"
+	_, _, err := srv.UpdateProfile(context.Background(), 1, service.ProfileUpdate{Username: &bob})
+	assert.ErrorIs(t, err, service.ErrConflict)
+
+	// Переименование не затрагивает бакет и ключи MinIO, а адрес меняется только после подтверждения
+	mockStorage.On("GetUserByID", 1).Return(newUser(), nil).Once()
+	mockStorage.On("GetUserByUsername", "alice.smith").Return(nil, storagedb.ErrUserNotFound)
+	mockStorage.On("GetUserByEmail", "new@example.com").Return(nil, nil)
+	var stored *models.UserToken
+	mockStorage.On("UpdateProfile", mock.MatchedBy(func(user *models.User) bool {
+		return user.UserName == "alice.smith" && user.Email == "a@example.com" && user.MinioBucketName == "user-1234"
+	}), mock.MatchedBy(func(token *models.UserToken) bool {
+		return token.Purpose == models.TokenPurposeEmailChange
+	})).Run(func(args mock.Arguments) {
+		stored = args.Get(1).(*models.UserToken)
+	}).Return(nil).Once()
+	var sent mailer.Message
+	mockMailer.On("Send", mock.Anything, mock.MatchedBy(func(msg mailer.Message) bool { return msg.To == "new@example.com" })).
+		Run(func(args mock.Arguments) { sent = args.Get(1).(mailer.Message) }).
+		Return(nil)
+
+	newName, newEmail := "alice.smith", " new@example.com "
+	user, pending, err := srv.UpdateProfile(context.Background(), 1, service.ProfileUpdate{Username: &newName, Email: &newEmail})
+	require.NoError(t, err)
+	assert.Equal(t, "alice.smith", user.UserName)
+	assert.Equal(t, "a@example.com", user.Email, "Адрес не должен меняться до подтверждения")
+	assert.Equal(t, "new@example.com", pending)
+
+	// В письме код, а в БД только его хеш
+	token := tokenFromMail(sent)
+	require.NotEmpty(t, token)
+	require.NotNil(t, stored)
+	assert.NotEqual(t, token, stored.TokenHash)
+	assert.Equal(t, "new@example.com", stored.Email)
+
+	// Адрес, занятый другим пользователем после отправки кода, не применяется
+	mockStorage.On("ConfirmEmailChange", stored.TokenHash).Return(nil, storagedb.ErrEmailTaken).Once()
+	_, err = srv.ConfirmEmailChange(context.Background(), token)
+	assert.ErrorIs(t, err, service.ErrEmailTaken)
+	assert.ErrorIs(t, err, service.ErrConflict)
+
+	// Подтверждение кодом применяет новый адрес в одной транзакции с погашением кода
+	confirmed := newUser()
+	confirmed.Email = "new@example.com"
+	mockStorage.On("ConfirmEmailChange", stored.TokenHash).Return(stored, nil).Once()
+	mockStorage.On("GetUserByID", 1).Return(confirmed, nil).Once()
+
+	user, err = srv.ConfirmEmailChange(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, "new@example.com", user.Email)
+
+	// Повторно код не действует
+	mockStorage.On("ConfirmEmailChange", stored.TokenHash).Return(nil, nil).Once()
+	_, err = srv.ConfirmEmailChange(context.Background(), token)
+	assert.ErrorIs(t, err, service.ErrInvalidToken)
+
+	// Код не отправляется на адрес другого пользователя
+	taken := "bob@example.com"
+	mockStorage.On("GetUserByID", 1).Return(newUser(), nil).Once()
+	mockStorage.On("GetUserByEmail", taken).Return(&models.User{ID: 2, UserName: "bob", Email: taken}, nil).Once()
+	_, _, err = srv.UpdateProfile(context.Background(), 1, service.ProfileUpdate{Email: &taken})
+	assert.ErrorIs(t, err, service.ErrEmailTaken)
+
+	invalid := "Alice <alice@example.com>"
+	mockStorage.On("GetUserByID", 1).Return(newUser(), nil).Once()
+	_, _, err = srv.UpdateProfile(context.Background(), 1, service.ProfileUpdate{Email: &invalid})
+	assert.ErrorIs(t, err, service.ErrInvalidEmail)
+
+	// Ошибка в адресе не оставляет примененным новое имя: до записи дело не доходит
+	mockStorage.On("GetUserByID", 1).Return(newUser(), nil).Once()
+	_, _, err = srv.UpdateProfile(context.Background(), 1, service.ProfileUpdate{Username: &newName, Email: &invalid})
+	assert.ErrorIs(t, err, service.ErrInvalidEmail)
+
+	// Имя, занятое одновременным переименованием, отсекает уникальный индекс: это конфликт, а не сбой
+	carol := "carol"
+	mockStorage.On("GetUserByID", 1).Return(newUser(), nil).Once()
+	mockStorage.On("GetUserByUsername", "carol").Return(nil, storagedb.ErrUserNotFound)
+	mockStorage.On("UpdateProfile", mock.MatchedBy(func(user *models.User) bool { return user.UserName == "carol" }), (*models.UserToken)(nil)).
+		Return(fmt.Errorf("ошибка: %w", storagedb.ErrUsernameTaken)).Once()
+	_, _, err = srv.UpdateProfile(context.Background(), 1, service.ProfileUpdate{Username: &carol})
+	assert.ErrorIs(t, err, service.ErrConflict)
+
+	mockStorage.AssertNumberOfCalls(t, "UpdateProfile", 2)
+
+	mockStorage.AssertExpectations(t)
+	mockMailer.AssertExpectations(t)
+}
+
+// TestChangePassword проверяет смену пароля по текущему паролю
+func TestChangePassword(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	srv := &service.Service{
+		Storagedb: mockStorage,
+		JWTConfig: service.JWTConfig{AccessSecret: "a", RefreshSecret: "r", AccessTTL: 900, RefreshTTL: 3600},
+	}
+	mockStorage.On("CreateSession", mock.Anything).Return(nil).Maybe()
+
+	hash, err := srv.PasswordHash("old-password")
+	require.NoError(t, err)
+	mockStorage.On("GetUserByID", 1).Return(&models.User{ID: 1, UserName: "alice", PasswordHash: hash}, nil).Times(3)
+
+	// Неверный текущий пароль записывается в журнал неудачных попыток
+	mockStorage.On("RecordLoginFailure", &models.LoginFailure{Username: "alice", Reason: models.LoginFailurePassword}).Return(nil).Once()
+	_, err = srv.ChangePassword(context.Background(), 1, "wrong-password", "new-password")
+	assert.ErrorIs(t, err, service.ErrAccessDenied)
+
+	// Слишком короткий новый пароль
+	_, err = srv.ChangePassword(context.Background(), 1, "old-password", "short")
+	assert.ErrorIs(t, err, service.ErrWeakPassword)
+
+	// Новый пароль сохраняется хешем, а клиент получает новые токены
+	mockStorage.On("UpdatePassword", 1, mock.MatchedBy(func(newHash string) bool {
+		return srv.VerifyPassword("new-password", newHash)
+	})).Return(nil).Once()
+	mockStorage.On("GetUserByID", 1).Return(&models.User{ID: 1, UserName: "alice", TokenVersion: 1}, nil).Once()
+
+	tokens, err := srv.ChangePassword(context.Background(), 1, "old-password", "new-password")
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
+
+	mockStorage.AssertExpectations(t)
+}
+
+// TestPasswordReset проверяет сброс пароля по одноразовому коду из письма
+func TestPasswordReset(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	mockMailer := new(MockMailer)
+	srv := &service.Service{Storagedb: mockStorage, Mailer: mockMailer}
+
+	// Для незарегистрированного адреса письмо не отправляется, но и ошибки нет
+	mockStorage.On("GetUserByEmail", "nobody@example.com").Return(nil, nil)
+	require.NoError(t, srv.RequestPasswordReset(context.Background(), "nobody@example.com"))
+	// Адрес ищется в фоне, чтобы время ответа не зависело от того, зарегистрирован ли он
+	assert.Eventually(t, func() bool {
+		return mockStorage.AssertCalled(new(testing.T), "GetUserByEmail", "nobody@example.com")
+	}, time.Second, 10*time.Millisecond)
+	mockMailer.AssertNotCalled(t, "Send", mock.Anything, mock.Anything)
+
+	mockStorage.On("GetUserByEmail", "a@example.com").Return(&models.User{ID: 1, UserName: "alice", Email: "a@example.com"}, nil)
+	mockStorage.On("DeleteUserTokens", 1, models.TokenPurposePasswordReset).Return(nil)
+	var stored *models.UserToken
+	mockStorage.On("CreateUserToken", mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(0).(*models.UserToken)
+	}).Return(nil)
+	sentCh := make(chan mailer.Message, 1)
+	mockMailer.On("Send", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		sentCh <- args.Get(1).(mailer.Message)
+	}).Return(nil)
+
+	require.NoError(t, srv.RequestPasswordReset(context.Background(), "a@example.com"))
+	var sent mailer.Message
+	select {
+	case sent = <-sentCh:
+	case <-time.After(time.Second):
+		t.Fatal("Письмо со сбросом пароля не отправлено")
+	}
+	require.NotNil(t, stored)
+	assert.Equal(t, "a@example.com", sent.To)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), stored.ExpiresAt, time.Minute, "Код должен действовать час")
+	token := tokenFromMail(sent)
+	require.NotEmpty(t, token)
+
+	// Слабый пароль отклоняется, не погашая код
+	err := srv.ResetPassword(context.Background(), token, "short")
+	assert.ErrorIs(t, err, service.ErrWeakPassword)
+
+	mockStorage.On("ConsumeUserToken", stored.TokenHash, models.TokenPurposePasswordReset).Return(stored, nil).Once()
+	mockStorage.On("UpdatePassword", 1, mock.Anything).Return(nil).Once()
+	require.NoError(t, srv.ResetPassword(context.Background(), token, "new-password"))
+
+	// Повторно код не действует
+	mockStorage.On("ConsumeUserToken", stored.TokenHash, models.TokenPurposePasswordReset).Return(nil, nil).Once()
+	err = srv.ResetPassword(context.Background(), token, "new-password")
+	assert.ErrorIs(t, err, service.ErrInvalidToken)
+
+	mockStorage.AssertExpectations(t)
+}
+
+// TestEmailVerification проверяет, что до подтверждения адреса хранилище не создается,
+// а после подтверждения создается полностью
+func TestEmailVerification(t *testing.T) {
+	srv, mockStorage, mockAdmin, mockBuckets := newProvisioningService()
+	mockMailer := new(MockMailer)
+	srv.Mailer = mockMailer
+	srv.Registration.RequireEmailVerification = true
+
+	unverified := func() *models.User {
+		return &models.User{ID: 7, UserName: "alice", Email: "a@example.com", MinioBucketName: "user-1234",
+			MinioAccessKey: "user-1234", MinioSecretKey: "secret", ProvisioningState: models.ProvisioningUnverified}
+	}
+
+	// Регистрация только создает запись и отправляет код, не обращаясь к MinIO
+	expectCreateUser(mockStorage, "alice", 7)
+	mockStorage.On("SetProvisioningState", 7, models.ProvisioningPending, models.ProvisioningUnverified).Return(true, nil).Once()
+	mockStorage.On("GetUserByID", 7).Return(unverified(), nil).Once()
+	mockStorage.On("DeleteUserTokens", 7, models.TokenPurposeEmailVerify).Return(nil)
+	var stored *models.UserToken
+	mockStorage.On("CreateUserToken", mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(0).(*models.UserToken)
+	}).Return(nil)
+	var sent mailer.Message
+	mockMailer.On("Send", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		sent = args.Get(1).(mailer.Message)
+	}).Return(nil)
+
+	user, tokens, err := srv.RegisterUser(context.Background(), "alice", "password", "a@example.com", "")
+	require.NoError(t, err)
+	assert.NotNil(t, tokens, "Вход до подтверждения разрешен")
+	assert.False(t, user.EmailVerified)
+	assert.Equal(t, "a@example.com", sent.To)
+	require.NotNil(t, stored)
+	assert.WithinDuration(t, time.Now().Add(48*time.Hour), stored.ExpiresAt, time.Minute, "Код должен действовать двое суток")
+	mockAdmin.AssertNotCalled(t, "AddUser", mock.Anything, mock.Anything, mock.Anything)
+	mockBuckets.AssertNotCalled(t, "MakeBucket", mock.Anything, mock.Anything, mock.Anything)
+
+	// С хранилищем до подтверждения работать нельзя
+	mockStorage.On("GetUserByID", 7).Return(unverified(), nil).Once()
+	_, err = srv.ExecuteFileOperation(context.Background(), 7, func(ctx context.Context, minioClient service.MinioClientInterface, bucketName string) (interface{}, error) {
+		t.Fatal("операция не должна выполняться до подтверждения адреса")
+		return nil, nil
+	})
+	assert.ErrorIs(t, err, service.ErrEmailNotVerified)
+	assert.ErrorIs(t, err, service.ErrAccessDenied)
+
+	// Подтверждение создает хранилище
+	token := tokenFromMail(sent)
+	require.NotEmpty(t, token)
+	mockStorage.On("ConsumeUserToken", stored.TokenHash, models.TokenPurposeEmailVerify).Return(stored, nil).Once()
+	mockStorage.On("GetUserByID", 7).Return(unverified(), nil).Once()
+	mockStorage.On("MarkEmailVerified", 7).Return(true, nil).Once()
+	mockAdmin.On("AddUser", mock.Anything, "user-1234", "secret").Return(nil).Once()
+	mockAdmin.On("AddCannedPolicy", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+	mockAdmin.On("AttachPolicy", mock.Anything, mock.Anything).Return(madmin.PolicyAssociationResp{}, nil).Once()
+	mockBuckets.On("MakeBucket", mock.Anything, "user-1234", mock.Anything).Return(nil).Once()
+	mockStorage.On("SetProvisioningState", 7, models.ProvisioningPending, models.ProvisioningMinioUser).Return(true, nil).Once()
+	mockStorage.On("SetProvisioningState", 7, models.ProvisioningMinioUser, models.ProvisioningPolicy).Return(true, nil).Once()
+	mockStorage.On("SetProvisioningState", 7, models.ProvisioningPolicy, models.ProvisioningReady).Return(true, nil).Once()
+	ready := unverified()
+	ready.EmailVerified = true
+	ready.ProvisioningState = models.ProvisioningReady
+	mockStorage.On("GetUserByID", 7).Return(ready, nil).Once()
+
+	user, err = srv.VerifyEmail(context.Background(), token)
+	require.NoError(t, err)
+	assert.True(t, user.EmailVerified)
+
+	// Повторно код не действует, а для подтвержденного адреса новый код не отправляется
+	mockStorage.On("ConsumeUserToken", stored.TokenHash, models.TokenPurposeEmailVerify).Return(nil, nil).Once()
+	_, err = srv.VerifyEmail(context.Background(), token)
+	assert.ErrorIs(t, err, service.ErrInvalidToken)
+
+	mockStorage.On("GetUserByID", 7).Return(ready, nil).Once()
+	err = srv.ResendEmailVerification(context.Background(), 7)
+	assert.ErrorIs(t, err, service.ErrConflict)
+
+	mockStorage.AssertExpectations(t)
+	mockAdmin.AssertExpectations(t)
+	mockBuckets.AssertExpectations(t)
+}
+
+// TestCorrectUnverifiedEmail проверяет, что неподтвержденный адрес заменяется сразу, а код уходит на новый
+func TestCorrectUnverifiedEmail(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	mockMailer := new(MockMailer)
+	srv := &service.Service{Storagedb: mockStorage, Mailer: mockMailer}
+
+	mockStorage.On("GetUserByID", 1).Return(&models.User{ID: 1, UserName: "alice", Email: "a@exmaple.com",
+		ProvisioningState: models.ProvisioningUnverified}, nil)
+	mockStorage.On("GetUserByEmail", "a@example.com").Return(nil, nil).Once()
+	mockStorage.On("UpdateProfile", mock.MatchedBy(func(user *models.User) bool {
+		return user.Email == "a@example.com"
+	}), mock.MatchedBy(func(token *models.UserToken) bool {
+		return token.Purpose == models.TokenPurposeEmailVerify && token.Email == "a@example.com"
+	})).Return(nil).Once()
+	mockMailer.On("Send", mock.Anything, mock.MatchedBy(func(msg mailer.Message) bool { return msg.To == "a@example.com" })).
+		Return(nil).Once()
+
+	email := "a@example.com"
+	user, pendingEmail, err := srv.UpdateProfile(context.Background(), 1, service.ProfileUpdate{Email: &email})
+	require.NoError(t, err)
+	assert.Equal(t, "a@example.com", user.Email)
+	assert.Equal(t, "a@example.com", pendingEmail)
+
+	mockStorage.AssertExpectations(t)
+	mockMailer.AssertExpectations(t)
+}
+
+// TestRegistrationModes проверяет допуск к регистрации в каждом режиме
+func TestRegistrationModes(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		users   int
+		invite  string
+		wantErr error
+	}{
+		{name: "регистрация закрыта", mode: service.RegistrationClosed, users: 1, wantErr: service.ErrRegistrationClosed},
+		{name: "без приглашения", mode: service.RegistrationInvite, users: 1, wantErr: service.ErrInvalidInvite},
+		{name: "недействительное приглашение", mode: service.RegistrationInvite, users: 1, invite: "bad", wantErr: service.ErrInvalidInvite},
+		{name: "недействительное приглашение в открытом режиме", mode: service.RegistrationOpen, users: 1, invite: "bad", wantErr: service.ErrInvalidInvite},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(MockStorageDB)
+			srv := &service.Service{Storagedb: mockStorage, Registration: service.RegistrationConfig{Mode: tt.mode}}
+			mockStorage.On("CountUsers").Return(tt.users, nil).Maybe()
+			mockStorage.On("RedeemInvite", mock.Anything).Return(nil, nil).Maybe()
+
+			_, _, err := srv.RegisterUser(context.Background(), "alice", "password", "a@example.com", tt.invite)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.ErrorIs(t, err, service.ErrAccessDenied)
+			mockStorage.AssertNotCalled(t, "CreateUser", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+// TestRegisterFirstUserWhenClosed проверяет, что первый пользователь регистрируется и при закрытой регистрации
+func TestRegisterFirstUserWhenClosed(t *testing.T) {
+	srv, mockStorage, _, _ := newProvisioningService()
+	srv.Registration.Mode = service.RegistrationClosed
+	mockStorage.On("CountUsers").Return(0, nil)
+	mockStorage.On("CreateFirstUser", "alice", mock.Anything, "a@example.com", mock.Anything).Return(0, errors.New("сбой БД"))
+
+	_, _, err := srv.RegisterUser(context.Background(), "alice", "password", "a@example.com", "")
+	assert.NotErrorIs(t, err, service.ErrRegistrationClosed)
+	mockStorage.AssertCalled(t, "CreateFirstUser", "alice", mock.Anything, "a@example.com", mock.Anything)
+	mockStorage.AssertNotCalled(t, "CreateUser", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+// TestRegisterFirstUserRace проверяет, что из одновременных первых регистраций без приглашения
+// проходит одна: опоздавшая получает ошибку допуска своего режима
+func TestRegisterFirstUserRace(t *testing.T) {
+	for mode, wantErr := range map[string]error{
+		service.RegistrationClosed: service.ErrRegistrationClosed,
+		service.RegistrationInvite: service.ErrInvalidInvite,
+	} {
+		t.Run(mode, func(t *testing.T) {
+			srv, mockStorage, _, _ := newProvisioningService()
+			srv.Registration.Mode = mode
+			// Обе регистрации видели пустую таблицу, но первым пользователем стал другой
+			mockStorage.On("CountUsers").Return(0, nil)
+			mockStorage.On("CreateFirstUser", "bob", mock.Anything, "b@example.com", mock.Anything).
+				Return(0, fmt.Errorf("ошибка: %w", storagedb.ErrNotFirstUser)).Once()
+
+			_, _, err := srv.RegisterUser(context.Background(), "bob", "password", "b@example.com", "")
+			assert.ErrorIs(t, err, wantErr)
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+// TestRegisterFirstUserOpen проверяет, что и в открытом режиме администратором становится только
+// первая регистрация, а опоздавшая регистрация проходит как обычная
+func TestRegisterFirstUserOpen(t *testing.T) {
+	srv, mockStorage, _, _ := newProvisioningService()
+	srv.Registration.Mode = service.RegistrationOpen
+	mockStorage.On("CountUsers").Return(0, nil)
+	mockStorage.On("CreateFirstUser", "bob", mock.Anything, "b@example.com", mock.Anything).
+		Return(0, fmt.Errorf("ошибка: %w", storagedb.ErrNotFirstUser)).Once()
+	mockStorage.On("CreateUser", "bob", mock.Anything, "b@example.com", mock.Anything).Return(0, errors.New("сбой БД")).Once()
+
+	_, _, err := srv.RegisterUser(context.Background(), "bob", "password", "b@example.com", "")
+	assert.NotErrorIs(t, err, service.ErrAccessDenied)
+	mockStorage.AssertExpectations(t)
+}
+
+// TestRegisterWithInvite проверяет, что квота приглашения назначается бакету,
+// а использование возвращается, если пользователя создать не удалось
+func TestRegisterWithInvite(t *testing.T) {
+	srv, mockStorage, mockAdmin, mockBuckets := newProvisioningService()
+	srv.Registration.Mode = service.RegistrationInvite
+	invite := &models.Invite{ID: 5, MaxUses: 2, Uses: 1, QuotaBytes: 1 << 30}
+	mockStorage.On("CountUsers").Return(3, nil)
+	mockStorage.On("RedeemInvite", mock.MatchedBy(func(hash string) bool { return len(hash) == 64 })).Return(invite, nil)
+
+	// Сбой создания записи возвращает использование приглашения
+	mockStorage.On("CreateUser", "alice", mock.Anything, "a@example.com", mock.Anything).Return(0, errors.New("имя занято")).Once()
+	mockStorage.On("ReleaseInvite", 5).Return(nil).Once()
+	_, _, err := srv.RegisterUser(context.Background(), "alice", "password", "a@example.com", "code")
+	require.Error(t, err)
+
+	// Успешная регистрация создает бакет с квотой приглашения
+	var config *models.MinioConfig
+	mockStorage.On("CreateUser", "bob", mock.Anything, "a@example.com", mock.Anything).
+		Run(func(args mock.Arguments) { config = args.Get(3).(*models.MinioConfig) }).
+		Return(7, nil).Once()
+	mockAdmin.On("AddUser", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockAdmin.On("AddCannedPolicy", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockAdmin.On("AttachPolicy", mock.Anything, mock.Anything).Return(madmin.PolicyAssociationResp{}, nil)
+	mockBuckets.On("MakeBucket", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockAdmin.On("SetBucketQuota", mock.Anything, mock.Anything, mock.MatchedBy(func(quota *madmin.BucketQuota) bool {
+		return quota.Size == 1<<30 && quota.Type == madmin.HardQuota
+	})).Return(nil).Once()
+	mockStorage.On("SetProvisioningState", 7, mock.Anything, mock.Anything).Return(true, nil)
+	mockStorage.On("GetUserByID", 7).Return(&models.User{ID: 7, UserName: "bob", QuotaBytes: 1 << 30}, nil)
+
+	_, _, err = srv.RegisterUser(context.Background(), "bob", "password", "a@example.com", "code")
+	require.NoError(t, err)
+	require.NotNil(t, config)
+	assert.Equal(t, int64(1<<30), config.QuotaBytes)
+
+	mockStorage.AssertExpectations(t)
+	mockAdmin.AssertExpectations(t)
+}
+
+// TestCreateInvite проверяет, что приглашения создает только администратор и с допустимыми параметрами
+func TestCreateInvite(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	srv := &service.Service{Storagedb: mockStorage}
+	mockStorage.On("GetUserByID", 1).Return(&models.User{ID: 1, IsAdmin: true}, nil)
+	mockStorage.On("GetUserByID", 2).Return(&models.User{ID: 2}, nil)
+
+	_, _, err := srv.CreateInvite(context.Background(), 2, service.InviteOptions{TTL: time.Hour, MaxUses: 1})
+	assert.ErrorIs(t, err, service.ErrAccessDenied)
+
+	_, _, err = srv.CreateInvite(context.Background(), 1, service.InviteOptions{TTL: time.Hour})
+	assert.ErrorIs(t, err, service.ErrInvalidInviteOptions)
+	_, _, err = srv.CreateInvite(context.Background(), 1, service.InviteOptions{MaxUses: 1})
+	assert.ErrorIs(t, err, service.ErrInvalidInviteOptions)
+
+	var stored *models.Invite
+	mockStorage.On("CreateInvite", mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(0).(*models.Invite)
+	}).Return(nil).Once()
+
+	invite, code, err := srv.CreateInvite(context.Background(), 1, service.InviteOptions{TTL: time.Hour, MaxUses: 3, QuotaBytes: 1 << 30})
+	require.NoError(t, err)
+	require.NotEmpty(t, code)
+	assert.Same(t, stored, invite)
+	assert.NotEqual(t, code, invite.CodeHash, "Код не должен храниться в открытом виде")
+	assert.Equal(t, 1, invite.CreatedBy)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), invite.ExpiresAt, time.Minute)
+
+	mockStorage.On("DeleteInvite", 9).Return(false, nil).Once()
+	assert.ErrorIs(t, srv.DeleteInvite(context.Background(), 1, 9), service.ErrInviteNotFound)
+}
+
+// TestTOTPEnrollment проверяет подключение второго фактора: секрет включается только после верного кода,
+// а коды восстановления хранятся в виде хешей
+func TestTOTPEnrollment(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	srv := &service.Service{Storagedb: mockStorage}
+	mockStorage.On("GetUserByID", 1).Return(&models.User{ID: 1, UserName: "alice"}, nil)
+
+	var secret string
+	mockStorage.On("SaveTOTPSecret", 1, mock.Anything).Run(func(args mock.Arguments) {
+		secret = args.String(1)
+	}).Return(true, nil).Once()
+
+	enrollment, err := srv.BeginTOTPEnrollment(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, secret, enrollment.Secret)
+	assert.Contains(t, enrollment.URI, "otpauth://totp/NASForHome:alice?")
+
+	pending := &models.TOTP{UserID: 1, Secret: secret}
+	mockStorage.On("GetTOTP", 1).Return(pending, nil)
+
+	// Неверный код не включает второй фактор
+	_, err = srv.ConfirmTOTPEnrollment(context.Background(), 1, "000000x")
+	assert.ErrorIs(t, err, service.ErrInvalidMFACode)
+
+	code, err := totp.Code(secret, totp.Counter(time.Now()))
+	require.NoError(t, err)
+	var hashes []string
+	mockStorage.On("EnableTOTP", 1, totp.Counter(time.Now()), mock.Anything).Run(func(args mock.Arguments) {
+		hashes = args.Get(2).([]string)
+	}).Return(true, nil).Once()
+
+	codes, err := srv.ConfirmTOTPEnrollment(context.Background(), 1, code)
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+	require.Len(t, hashes, 10)
+	assert.Regexp(t, `^[a-z2-7]{5}-[a-z2-7]{5}$`, codes[0])
+	assert.NotContains(t, hashes, codes[0], "Коды восстановления не должны храниться в открытом виде")
+
+	// Включенный второй фактор нельзя перезаписать новым секретом
+	mockStorage.On("SaveTOTPSecret", 1, mock.Anything).Return(false, nil).Once()
+	_, err = srv.BeginTOTPEnrollment(context.Background(), 1)
+	assert.ErrorIs(t, err, service.ErrMFAAlreadyEnabled)
+
+	mockStorage.AssertExpectations(t)
+}
+
+// TestMFALogin проверяет двухшаговый вход: после пароля выдается только токен первого шага,
+// который вместе с кодом обменивается на пару токенов, а каждый код принимается один раз
+func TestMFALogin(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	srv := &service.Service{
+		Storagedb: mockStorage,
+		JWTConfig: service.JWTConfig{
+			AccessSecret:  "test-access-secret",
+			RefreshSecret: "test-refresh-secret",
+			AccessTTL:     900,
+			RefreshTTL:    604800,
+		},
+	}
+	// Токены выдаются в сеансе 1, который остается действующим
+	mockStorage.On("CreateSession", mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(0).(*models.Session).ID = 1
+	}).Return(nil).Maybe()
+	mockStorage.On("SessionActive", mock.Anything, 1).Return(true, nil).Maybe()
+
+	hash, err := srv.PasswordHash("password")
+	require.NoError(t, err)
+	user := &models.User{ID: 1, UserName: "alice", PasswordHash: hash, ProvisioningState: models.ProvisioningReady}
+	mockStorage.On("GetUserByUsername", "alice").Return(user, nil)
+	mockStorage.On("GetUserByID", 1).Return(user, nil)
+
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	mockStorage.On("GetTOTP", 1).Return(&models.TOTP{UserID: 1, Secret: secret, Enabled: true}, nil)
+
+	_, tokens, err := srv.LoginUser(context.Background(), "alice", "password", "192.0.2.1")
+	assert.Nil(t, tokens, "Без кода токены не выдаются")
+	var challenge *service.MFARequiredError
+	require.ErrorAs(t, err, &challenge)
+	assert.ErrorIs(t, err, service.ErrMFARequired)
+
+	// Токен первого шага не дает доступа к API
+	_, err = srv.AuthenticateAccessToken(challenge.Token)
+	assert.Error(t, err)
+
+	// Неверный код отклоняется
+	mockStorage.On("RecordLoginFailure", &models.LoginFailure{Username: "alice", IP: "192.0.2.1", Reason: models.LoginFailureMFA}).Return(nil)
+	_, _, err = srv.CompleteMFALogin(context.Background(), challenge.Token, "12345a", "192.0.2.1")
+	assert.ErrorIs(t, err, service.ErrInvalidMFACode)
+
+	code, err := totp.Code(secret, totp.Counter(time.Now()))
+	require.NoError(t, err)
+	counter := totp.Counter(time.Now())
+	mockStorage.On("UseTOTPCounter", 1, counter).Return(true, nil).Once()
+
+	_, tokens, err = srv.CompleteMFALogin(context.Background(), challenge.Token, code, "192.0.2.1")
+	require.NoError(t, err)
+	require.NotNil(t, tokens)
+	_, err = srv.AuthenticateAccessToken(tokens.AccessToken)
+	assert.NoError(t, err)
+
+	// Повторно тот же код не принимается
+	mockStorage.On("UseTOTPCounter", 1, counter).Return(false, nil).Once()
+	_, _, err = srv.CompleteMFALogin(context.Background(), challenge.Token, code, "192.0.2.1")
+	assert.ErrorIs(t, err, service.ErrInvalidMFACode)
+
+	// Код восстановления принимается независимо от регистра и дефиса
+	mockStorage.On("UseRecoveryCode", 1, mock.Anything).Return(true, nil).Once()
+	_, tokens, err = srv.CompleteMFALogin(context.Background(), challenge.Token, "ABCDE-fghij", "192.0.2.1")
+	require.NoError(t, err)
+	assert.NotNil(t, tokens)
+
+	// Access токен не подходит вместо токена первого шага
+	_, _, err = srv.CompleteMFALogin(context.Background(), tokens.AccessToken, code, "192.0.2.1")
+	assert.ErrorIs(t, err, service.ErrInvalidToken)
+
+	mockStorage.AssertExpectations(t)
+}
+
+// TestPasskeys проверяет добавление ключа доступа и вход с ним программным аутентификатором
+func TestPasskeys(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	srv := &service.Service{
+		Storagedb: mockStorage,
+		JWTConfig: service.JWTConfig{
+			AccessSecret:  "test-access-secret",
+			RefreshSecret: "test-refresh-secret",
+			AccessTTL:     900,
+			RefreshTTL:    604800,
+		},
+		WebAuthn: webauthn.Config{RPID: "nas.example.com", RPName: "NASForHome", Origins: []string{"https://nas.example.com"}},
+	}
+	// Токены выдаются в сеансе 1, который остается действующим
+	mockStorage.On("CreateSession", mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(0).(*models.Session).ID = 1
+	}).Return(nil).Maybe()
+	mockStorage.On("SessionActive", mock.Anything, 1).Return(true, nil).Maybe()
+	ctx := context.Background()
+
+	user := &models.User{ID: 1, UserName: "alice", ProvisioningState: models.ProvisioningReady}
+	mockStorage.On("GetUserByID", 1).Return(user, nil)
+	mockStorage.On("ListWebAuthnCredentials", 1).Return(nil, nil)
+
+	// Сохраненный вызов возвращается при завершении церемонии
+	var challenge *models.WebAuthnChallenge
+	mockStorage.On("CreateWebAuthnChallenge", mock.Anything).Run(func(args mock.Arguments) {
+		challenge = args.Get(0).(*models.WebAuthnChallenge)
+	}).Return(nil)
+	consume := func(purpose string) {
+		mockStorage.On("ConsumeWebAuthnChallenge", challenge.ChallengeHash, purpose).Return(challenge, nil).Once()
+	}
+
+	auth, err := webauthntest.New("https://nas.example.com")
+	require.NoError(t, err)
+
+	// Вызов, выданный одному пользователю, не подходит другому
+	opts, err := srv.BeginPasskeyRegistration(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, challenge.UserID)
+	assert.Equal(t, webauthn.Bytes("1"), opts.User.ID, "Идентификатор пользователя не содержит личных данных")
+	resp, err := auth.Create(opts)
+	require.NoError(t, err)
+	consume(models.WebAuthnPurposeRegistration)
+	_, err = srv.FinishPasskeyRegistration(ctx, 2, "Ноутбук", resp)
+	assert.ErrorIs(t, err, service.ErrInvalidPasskey)
+
+	opts, err = srv.BeginPasskeyRegistration(ctx, 1)
+	require.NoError(t, err)
+	resp, err = auth.Create(opts)
+	require.NoError(t, err)
+	consume(models.WebAuthnPurposeRegistration)
+	mockStorage.On("GetWebAuthnCredential", auth.CredentialID()).Return(nil, nil).Once()
+	mockStorage.On("CreateWebAuthnCredential", mock.MatchedBy(func(cred *models.WebAuthnCredential) bool {
+		return cred.UserID == 1 && cred.Name == "Ноутбук" && string(cred.PublicKey) == string(auth.PublicKey())
+	})).Return(nil).Once()
+
+	passkey, err := srv.FinishPasskeyRegistration(ctx, 1, " Ноутбук ", resp)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"internal"}, passkey.Transports)
+
+	// Повторно ответ на тот же вызов не принимается
+	mockStorage.On("ConsumeWebAuthnChallenge", challenge.ChallengeHash, models.WebAuthnPurposeRegistration).Return(nil, nil).Once()
+	_, err = srv.FinishPasskeyRegistration(ctx, 1, "Ноутбук", resp)
+	assert.ErrorIs(t, err, service.ErrInvalidPasskey)
+
+	// Вход без имени пользователя: владелец определяется по ключу
+	passkey.ID = 5
+	mockStorage.On("GetWebAuthnCredential", auth.CredentialID()).Return(passkey, nil)
+	login := func() (*models.User, *service.TokenPair, error) {
+		opts, err := srv.BeginPasskeyLogin(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, challenge.UserID)
+		assert.Empty(t, opts.AllowCredentials)
+		resp, err := auth.Get(opts)
+		require.NoError(t, err)
+		consume(models.WebAuthnPurposeLogin)
+		return srv.FinishPasskeyLogin(ctx, resp)
+	}
+
+	mockStorage.On("UpdateWebAuthnSignCount", 5, uint32(1)).Return(true, nil).Once()
+	loggedIn, tokens, err := login()
+	require.NoError(t, err)
+	assert.Equal(t, 1, loggedIn.ID)
+	_, err = srv.AuthenticateAccessToken(tokens.AccessToken)
+	assert.NoError(t, err)
+
+	// Счетчик подписей не вырос: ключ мог быть скопирован
+	passkey.SignCount = 1
+	auth.SignCount = 0
+	_, _, err = login()
+	assert.ErrorIs(t, err, service.ErrInvalidPasskey)
+
+	mockStorage.AssertExpectations(t)
+}
+
+// TestDirectoryAccountPassword проверяет действия с паролем для пользователей каталога
+func TestDirectoryAccountPassword(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	directory := new(MockAuthenticator)
+	srv := &service.Service{Storagedb: mockStorage, Authenticator: directory}
+	ctx := context.Background()
+
+	// Пароль пользователя каталога меняется только в каталоге
+	_, err := srv.ChangePassword(ctx, 7, "old-secret", "new-secret")
+	assert.ErrorIs(t, err, service.ErrDirectoryManagedPassword)
+	assert.ErrorIs(t, srv.RequestPasswordReset(ctx, "a@example.com"), service.ErrDirectoryManagedPassword)
+	assert.ErrorIs(t, srv.ResetPassword(ctx, "code", "new-secret"), service.ErrDirectoryManagedPassword)
+
+	// Имя пользователя задает каталог
+	mockStorage.On("GetUserByID", 7).Return(&models.User{ID: 7, UserName: "alice"}, nil)
+	name := "root"
+	_, _, err = srv.UpdateProfile(ctx, 7, service.ProfileUpdate{Username: &name})
+	assert.ErrorIs(t, err, service.ErrAccessDenied)
+
+	// Второй фактор отключается по паролю каталога той же записи
+	directory.On("Authenticate", "alice", "wrong").Return(nil, nil).Once()
+	assert.ErrorIs(t, srv.DisableTOTP(ctx, 7, "wrong"), service.ErrAccessDenied)
+
+	directory.On("Authenticate", "alice", "secret").Return(&models.DirectoryUser{ID: "uuid-alice", Username: "alice"}, nil).Once()
+	mockStorage.On("GetUserIDByIdentity", "ldap", "uuid-alice").Return(7, nil).Once()
+	mockStorage.On("GetTOTP", 7).Return(&models.TOTP{UserID: 7, Enabled: true}, nil).Once()
+	mockStorage.On("DeleteTOTP", 7).Return(nil).Once()
+	assert.NoError(t, srv.DisableTOTP(ctx, 7, "secret"))
+
+	directory.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+}
+
+// TestDirectoryLogin проверяет вход через каталог: создание пользователя при первом входе и роль по группам
+func TestDirectoryLogin(t *testing.T) {
+	srv, mockStorage, mockAdmin, mockBuckets := newProvisioningService()
+	directory := new(MockAuthenticator)
+	srv.Authenticator = directory
+	ctx := context.Background()
+
+	// Пароль проверяет каталог, локальный хеш не используется
+	directory.On("Authenticate", "alice", "wrong").Return(nil, nil).Once()
+	mockStorage.On("RecordLoginFailure", mock.Anything).Return(nil).Once()
+	_, _, err := srv.LoginUser(ctx, "alice", "wrong", "192.0.2.1")
+	assert.Error(t, err)
+	mockStorage.AssertNotCalled(t, "GetUserByUsername", mock.Anything)
+
+	// При первом входе создаются пользователь и хранилище, а роль берется из групп
+	directory.On("Authenticate", "Alice", "secret").Return(&models.DirectoryUser{
+		ID: "uuid-alice", Username: "Alice", Email: "a@example.com", Groups: []string{"nas-admins"}, Role: models.UserRoleAdmin,
+	}, nil).Once()
+	mockStorage.On("GetUserIDByIdentity", "ldap", "uuid-alice").Return(0, nil).Once()
+	mockStorage.On("GetUserByUsername", "alice").Return(nil, storagedb.ErrUserNotFound).Once()
+	storageName, _ := expectCreateUser(mockStorage, "alice", 7)
+	mockStorage.On("SetProvisioningState", 7, models.ProvisioningPending, models.ProvisioningUnverified).Return(true, nil).Once()
+	mockStorage.On("MarkEmailVerified", 7).Return(true, nil).Once()
+	mockAdmin.On("AddUser", mock.Anything, storageName, mock.Anything).Return(nil).Once()
+	mockAdmin.On("AddCannedPolicy", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+	mockAdmin.On("AttachPolicy", mock.Anything, mock.Anything).Return(madmin.PolicyAssociationResp{}, nil).Once()
+	mockBuckets.On("MakeBucket", mock.Anything, storageName, mock.Anything).Return(nil).Once()
+	mockStorage.On("SetProvisioningState", 7, models.ProvisioningPending, models.ProvisioningMinioUser).Return(true, nil).Once()
+	mockStorage.On("SetProvisioningState", 7, models.ProvisioningMinioUser, models.ProvisioningPolicy).Return(true, nil).Once()
+	mockStorage.On("SetProvisioningState", 7, models.ProvisioningPolicy, models.ProvisioningReady).Return(true, nil).Once()
+	mockStorage.On("GetUserByID", 7).Return(&models.User{ID: 7, UserName: "alice", Email: "a@example.com",
+		EmailVerified: true, ProvisioningState: models.ProvisioningReady}, nil).Once()
+	mockStorage.On("LinkUserIdentity", "ldap", "uuid-alice", 7).Return(nil).Once()
+	mockStorage.On("SetUserAdmin", 7, true).Return(nil).Once()
+	mockStorage.On("GetTOTP", 7).Return(nil, nil)
+
+	user, tokens, err := srv.LoginUser(ctx, "Alice", "secret", "192.0.2.1")
+	require.NoError(t, err)
+	assert.Equal(t, 7, user.ID)
+	assert.True(t, user.IsAdmin)
+	assert.NotNil(t, tokens)
+
+	// Пользователь, исключенный из группы администраторов, теряет роль при следующем входе
+	directory.On("Authenticate", "alice", "secret").Return(&models.DirectoryUser{
+		ID: "uuid-alice", Username: "alice", Email: "a@example.com", Role: models.UserRoleUser,
+	}, nil).Once()
+	mockStorage.On("GetUserIDByIdentity", "ldap", "uuid-alice").Return(7, nil).Once()
+	mockStorage.On("GetUserByID", 7).Return(&models.User{ID: 7, UserName: "alice",
+		IsAdmin: true, ProvisioningState: models.ProvisioningReady}, nil).Once()
+	mockStorage.On("SetUserAdmin", 7, false).Return(nil).Once()
+
+	user, _, err = srv.LoginUser(ctx, "alice", "secret", "192.0.2.1")
+	require.NoError(t, err)
+	assert.False(t, user.IsAdmin)
+
+	// Без адреса почты пользователя NAS создать нельзя
+	directory.On("Authenticate", "bob", "secret").Return(&models.DirectoryUser{ID: "uuid-bob", Username: "bob"}, nil).Once()
+	mockStorage.On("GetUserIDByIdentity", "ldap", "uuid-bob").Return(0, nil).Once()
+	mockStorage.On("GetUserByUsername", "bob").Return(nil, storagedb.ErrUserNotFound).Once()
+	_, _, err = srv.LoginUser(ctx, "bob", "secret", "192.0.2.1")
+	assert.ErrorIs(t, err, service.ErrDirectoryEmailMissing)
+
+	// Локальный пользователь, взявший имя администратора каталога, не получает его вход и роль
+	directory.On("Authenticate", "root", "secret").Return(&models.DirectoryUser{
+		ID: "uuid-root", Username: "root", Email: "root@example.com", Role: models.UserRoleAdmin,
+	}, nil).Once()
+	mockStorage.On("GetUserIDByIdentity", "ldap", "uuid-root").Return(0, nil).Once()
+	mockStorage.On("GetUserByUsername", "root").Return(&models.User{ID: 9, UserName: "root"}, nil).Once()
+	_, _, err = srv.LoginUser(ctx, "root", "secret", "192.0.2.1")
+	assert.ErrorIs(t, err, service.ErrDirectoryUserConflict)
+	mockStorage.AssertNotCalled(t, "SetUserAdmin", 9, true)
+
+	directory.AssertExpectations(t)
+	mockStorage.AssertExpectations(t)
+	mockAdmin.AssertExpectations(t)
+	mockBuckets.AssertExpectations(t)
 }
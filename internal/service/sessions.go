@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+)
+
+const (
+	refreshIDLength        = 16 // Случайных байт в идентификаторе refresh токена
+	maxSessionDeviceLength = 100
+	maxSessionAgentLength  = 512
+	maxSessionIPLength     = 64
+
+	// Подтвержденный сеанс не проверяется в БД повторно в течение sessionCheckTTL. Столько же
+	// access токен сеанса, завершенного через другой экземпляр сервера, еще может приниматься.
+	sessionCheckTTL   = 30 * time.Second
+	maxCachedSessions = 4096 // После этого размера из кэша удаляются устаревшие записи
+)
+
+// ErrSessionNotFound возвращается, если у пользователя нет такого сеанса
+var ErrSessionNotFound = errors.New("сеанс не найден")
+
+// ClientInfo данные устройства, с которого выполняется вход или обновление токенов
+type ClientInfo struct {
+	IP         string
+	UserAgent  string
+	DeviceName string // Название, указанное клиентом; если пусто, определяется по UserAgent
+}
+
+// clientContextKey ключ контекста с данными устройства
+type clientContextKey struct{}
+
+// WithClient возвращает контекст с данными устройства, которые сохраняются в сеансе при выдаче токенов
+func WithClient(ctx context.Context, client ClientInfo) context.Context {
+	return context.WithValue(ctx, clientContextKey{}, client)
+}
+
+// clientFromContext возвращает данные устройства из контекста с обрезанными до размеров столбцов строками
+func clientFromContext(ctx context.Context) ClientInfo {
+	client, _ := ctx.Value(clientContextKey{}).(ClientInfo)
+	client.IP = truncateRunes(client.IP, maxSessionIPLength)
+	client.UserAgent = truncateRunes(client.UserAgent, maxSessionAgentLength)
+	client.DeviceName = strings.TrimSpace(client.DeviceName)
+	if client.DeviceName == "" {
+		client.DeviceName = deviceFromUserAgent(client.UserAgent)
+	}
+	client.DeviceName = truncateRunes(client.DeviceName, maxSessionDeviceLength)
+	return client
+}
+
+// sessionCache запоминает недавно подтвержденные сеансы, чтобы не обращаться к БД на каждый запрос
+type sessionCache struct {
+	mu      sync.Mutex
+	checked map[int]time.Time // Время последней проверки по ID сеанса
+}
+
+// fresh сообщает, что сеанс подтвержден в БД не раньше sessionCheckTTL назад
+func (c *sessionCache) fresh(id int, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	checkedAt, ok := c.checked[id]
+	return ok && now.Sub(checkedAt) < sessionCheckTTL
+}
+
+// remember отмечает сеанс как подтвержденный
+func (c *sessionCache) remember(id int, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.checked == nil {
+		c.checked = make(map[int]time.Time)
+	}
+	if len(c.checked) >= maxCachedSessions {
+		for cachedID, checkedAt := range c.checked {
+			if now.Sub(checkedAt) >= sessionCheckTTL {
+				delete(c.checked, cachedID)
+			}
+		}
+	}
+	c.checked[id] = now
+}
+
+// forget убирает сеанс из кэша, чтобы следующий запрос проверил его в БД
+func (c *sessionCache) forget(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.checked, id)
+}
+
+// sessionActive проверяет, что сеанс пользователя не завершен и не истек
+func (s *Service) sessionActive(userID, id int) (bool, error) {
+	now := time.Now()
+	if s.sessions.fresh(id, now) {
+		return true, nil
+	}
+
+	active, err := s.Storagedb.SessionActive(userID, id)
+	if err != nil {
+		return false, err
+	}
+	if active {
+		s.sessions.remember(id, now)
+	}
+	return active, nil
+}
+
+// startSession создает сеанс для устройства из контекста и выдает в нем пару токенов
+func (s *Service) startSession(ctx context.Context, user *models.User) (*TokenPair, error) {
+	refreshID, err := s.generateSecretKey(refreshIDLength)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания сеанса: %w", err)
+	}
+
+	client := clientFromContext(ctx)
+	session := &models.Session{
+		UserID:      user.ID,
+		RefreshHash: hashToken(refreshID),
+		DeviceName:  client.DeviceName,
+		IP:          client.IP,
+		UserAgent:   client.UserAgent,
+		ExpiresAt:   time.Now().Add(time.Duration(s.JWTConfig.RefreshTTL) * time.Second),
+	}
+	if err := s.Storagedb.CreateSession(session); err != nil {
+		return nil, err
+	}
+
+	return s.GenerateTokenPair(user, session.ID, refreshID)
+}
+
+// rotateSession выдает новую пару токенов в сеансе, если refreshID — последний выданный в нем токен
+func (s *Service) rotateSession(ctx context.Context, user *models.User, sessionID int, refreshID string) (*TokenPair, error) {
+	newRefreshID, err := s.generateSecretKey(refreshIDLength)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка обновления сеанса: %w", err)
+	}
+
+	client := clientFromContext(ctx)
+	expiresAt := time.Now().Add(time.Duration(s.JWTConfig.RefreshTTL) * time.Second)
+	ok, err := s.Storagedb.RotateSession(sessionID, user.ID, hashToken(refreshID), hashToken(newRefreshID), client.IP, client.UserAgent, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	// Сеанс завершен, истек или токен уже использован
+	if !ok {
+		return nil, ErrTokenRevoked
+	}
+
+	return s.GenerateTokenPair(user, sessionID, newRefreshID)
+}
+
+// ListSessions возвращает действующие сеансы пользователя
+func (s *Service) ListSessions(ctx context.Context, userID int) ([]models.Session, error) {
+	return s.Storagedb.ListSessions(userID)
+}
+
+// DeleteSession завершает сеанс пользователя: его refresh и access токены больше не принимаются
+func (s *Service) DeleteSession(ctx context.Context, userID, id int) error {
+	ok, err := s.Storagedb.DeleteSession(userID, id)
+	if err != nil {
+		return err
+	}
+	s.sessions.forget(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// deviceFromUserAgent определяет браузер и систему по заголовку User-Agent, например "Firefox, Linux"
+func deviceFromUserAgent(userAgent string) string {
+	var browser string
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	case strings.HasPrefix(userAgent, "curl/"):
+		browser = "curl"
+	}
+
+	var system string
+	switch {
+	case strings.Contains(userAgent, "Windows"):
+		system = "Windows"
+	case strings.Contains(userAgent, "Android"):
+		system = "Android"
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		system = "iOS"
+	case strings.Contains(userAgent, "Mac OS X"):
+		system = "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		system = "Linux"
+	}
+
+	switch {
+	case browser != "" && system != "":
+		return browser + ", " + system
+	case browser != "":
+		return browser
+	default:
+		return system
+	}
+}
+
+// truncateRunes обрезает строку до limit символов, не разрывая многобайтовые символы
+func truncateRunes(value string, limit int) string {
+	runes := []rune(value)
+	if len(runes) <= limit {
+		return value
+	}
+	return string(runes[:limit])
+}
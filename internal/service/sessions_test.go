@@ -0,0 +1,111 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com.Vova4o/nasforhome/internal/service"
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoginCreatesSession проверяет, что вход сохраняет сеанс с данными устройства, а токены получают его ID
+func TestLoginCreatesSession(t *testing.T) {
+	srv, mockStorage := newThrottledService(t, service.LoginLimits{})
+
+	var stored *models.Session
+	mockStorage.On("CreateSession", mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(0).(*models.Session)
+		stored.ID = 4
+	}).Return(nil).Once()
+
+	ctx := service.WithClient(context.Background(), service.ClientInfo{
+		IP:        "192.0.2.1",
+		UserAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0",
+	})
+	_, tokens, err := srv.LoginUser(ctx, "alice", "secret", "192.0.2.1")
+	require.NoError(t, err)
+
+	require.NotNil(t, stored)
+	assert.Equal(t, "Firefox, Linux", stored.DeviceName, "Название устройства определяется по User-Agent")
+	assert.Equal(t, "192.0.2.1", stored.IP)
+	assert.Len(t, stored.RefreshHash, 64, "В БД хранится только хеш")
+	assert.NotContains(t, tokens.RefreshToken, stored.RefreshHash)
+
+	claims, err := srv.VerifyAccessToken(tokens.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, 4, claims.SessionID)
+	mockStorage.AssertExpectations(t)
+}
+
+// TestSessionDeviceName проверяет название, указанное клиентом, и обрезку длинных значений
+func TestSessionDeviceName(t *testing.T) {
+	srv, mockStorage := newThrottledService(t, service.LoginLimits{})
+
+	var stored *models.Session
+	mockStorage.On("CreateSession", mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(0).(*models.Session)
+	}).Return(nil).Once()
+
+	ctx := service.WithClient(context.Background(), service.ClientInfo{
+		UserAgent:  strings.Repeat("я", 600),
+		DeviceName: "  " + strings.Repeat("ноутбук", 20),
+	})
+	_, _, err := srv.LoginUser(ctx, "alice", "secret", "192.0.2.1")
+	require.NoError(t, err)
+
+	require.NotNil(t, stored)
+	assert.Equal(t, 100, len([]rune(stored.DeviceName)))
+	assert.True(t, strings.HasPrefix(stored.DeviceName, "ноутбук"))
+	assert.Equal(t, 512, len([]rune(stored.UserAgent)))
+}
+
+// TestDeleteSession проверяет завершение сеанса и ошибку для чужого или несуществующего сеанса
+func TestDeleteSession(t *testing.T) {
+	mockStorage := new(MockStorageDB)
+	srv := &service.Service{Storagedb: mockStorage}
+
+	mockStorage.On("DeleteSession", 1, 4).Return(true, nil).Once()
+	mockStorage.On("DeleteSession", 2, 4).Return(false, nil).Once()
+
+	assert.NoError(t, srv.DeleteSession(context.Background(), 1, 4))
+	assert.ErrorIs(t, srv.DeleteSession(context.Background(), 2, 4), service.ErrSessionNotFound)
+	mockStorage.AssertExpectations(t)
+}
+
+// TestDeletedSessionRevokesAccessToken проверяет, что access токен завершенного сеанса перестает приниматься
+func TestDeletedSessionRevokesAccessToken(t *testing.T) {
+	srv, mockStorage := newThrottledService(t, service.LoginLimits{})
+	mockStorage.On("GetUserByID", 1).Return(&models.User{ID: 1, UserName: "alice", ProvisioningState: models.ProvisioningReady}, nil)
+	mockStorage.On("CreateSession", mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(0).(*models.Session).ID = 4
+	}).Return(nil).Once()
+
+	_, tokens, err := srv.LoginUser(context.Background(), "alice", "secret", "192.0.2.1")
+	require.NoError(t, err)
+
+	// Подтвержденный сеанс запоминается и не проверяется в БД на каждый запрос
+	mockStorage.On("SessionActive", 1, 4).Return(true, nil).Once()
+	for range 2 {
+		_, err = srv.AuthenticateAccessToken(tokens.AccessToken)
+		require.NoError(t, err)
+	}
+
+	// Завершение сеанса сбрасывает кэш: следующий запрос видит, что сеанса нет
+	mockStorage.On("DeleteSession", 1, 4).Return(true, nil).Once()
+	require.NoError(t, srv.DeleteSession(context.Background(), 1, 4))
+	mockStorage.On("SessionActive", 1, 4).Return(false, nil).Once()
+	_, err = srv.AuthenticateAccessToken(tokens.AccessToken)
+	assert.ErrorIs(t, err, service.ErrTokenRevoked)
+
+	// Токен без сеанса не принимается
+	tokens, err = srv.GenerateTokenPair(&models.User{ID: 1, UserName: "alice"}, 0, "refresh-id")
+	require.NoError(t, err)
+	_, err = srv.AuthenticateAccessToken(tokens.AccessToken)
+	assert.ErrorIs(t, err, service.ErrTokenRevoked)
+
+	mockStorage.AssertExpectations(t)
+}
@@ -0,0 +1,336 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com.Vova4o/nasforhome/pkg/storagedb"
+	"github.com/minio/minio-go/v7"
+)
+
+// sharedBucketPrefix префикс бакетов общих пространств
+const sharedBucketPrefix = "shared-"
+
+// resourceNamePattern допустимое имя общего пространства или группы: имя бакета с префиксом
+// должно оставаться корректным для S3
+var resourceNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,54}[a-z0-9]$`)
+
+// Ошибки общих пространств
+var (
+	ErrAccessDenied     = errors.New("доступ запрещен")
+	ErrInvalidSpaceName = errors.New("имя пространства может содержать только строчные латинские буквы, цифры и дефис (3-56 символов)")
+	ErrInvalidSpaceRole = errors.New("некорректная роль в общем пространстве")
+	ErrUserNotFound     = errors.New("пользователь не найден")
+	ErrGroupNotFound    = errors.New("группа не найдена")
+)
+
+// spaceContextKey ключ контекста с ID общего пространства
+type spaceContextKey struct{}
+
+// WithSpace возвращает контекст, в котором файловые операции выполняются в общем пространстве, а не в личном бакете
+func WithSpace(ctx context.Context, spaceID int) context.Context {
+	return context.WithValue(ctx, spaceContextKey{}, spaceID)
+}
+
+// spaceFromContext возвращает ID общего пространства из контекста
+func spaceFromContext(ctx context.Context) (int, bool) {
+	spaceID, ok := ctx.Value(spaceContextKey{}).(int)
+	return spaceID, ok
+}
+
+// CreateSharedSpace создает общее пространство с отдельным бакетом; создатель становится его владельцем
+func (s *Service) CreateSharedSpace(ctx context.Context, ownerID int, name string) (*models.SharedSpace, error) {
+	if !resourceNamePattern.MatchString(name) {
+		return nil, ErrInvalidSpaceName
+	}
+	// Пока хранилище владельца не создано, у него нет пользователя и политики MinIO для доступа к бакету
+	owner, err := s.Storagedb.GetUserByID(ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения пользователя: %w", err)
+	}
+	if err := storageReady(owner); err != nil {
+		return nil, err
+	}
+
+	// Уникальность имени гарантирует БД, поэтому запись создается до бакета
+	space := &models.SharedSpace{
+		Name:       name,
+		BucketName: sharedBucketPrefix + name,
+		OwnerID:    ownerID,
+		Role:       models.SpaceRoleOwner,
+	}
+	if err := s.Storagedb.CreateSharedSpace(space); err != nil {
+		return nil, err
+	}
+
+	err = s.Buckets.MakeBucket(ctx, space.BucketName, minio.MakeBucketOptions{})
+	if err != nil && minio.ToErrorResponse(err).Code != "BucketAlreadyOwnedByYou" {
+		if delErr := s.Storagedb.DeleteSharedSpace(space.ID); delErr != nil {
+			log.Printf("ошибка удаления общего пространства %d: %v", space.ID, delErr)
+		}
+		return nil, fmt.Errorf("ошибка создания бакета: %w", err)
+	}
+
+	s.refreshSharesPolicies(ctx, ownerID)
+	return space, nil
+}
+
+// ListSharedSpaces возвращает общие пространства пользователя с его ролью в каждом
+func (s *Service) ListSharedSpaces(ctx context.Context, userID int) ([]models.SharedSpace, error) {
+	return s.Storagedb.ListUserSharedSpaces(userID)
+}
+
+// DeleteSharedSpace удаляет общее пространство вместе с файлами. Доступно только владельцу.
+func (s *Service) DeleteSharedSpace(ctx context.Context, userID, spaceID int) error {
+	space, role, err := s.spaceAccess(userID, spaceID)
+	if err != nil {
+		return err
+	}
+	if role != models.SpaceRoleOwner {
+		return fmt.Errorf("%w: удалить пространство может только владелец", ErrAccessDenied)
+	}
+
+	members, err := s.Storagedb.ListSpaceMembers(spaceID)
+	if err != nil {
+		return err
+	}
+	grants, err := s.Storagedb.ListSpaceGroupGrants(spaceID)
+	if err != nil {
+		return err
+	}
+
+	err = s.Buckets.RemoveBucketWithOptions(ctx, space.BucketName, minio.RemoveBucketOptions{ForceDelete: true})
+	if err != nil && minio.ToErrorResponse(err).Code != "NoSuchBucket" {
+		return fmt.Errorf("ошибка удаления бакета: %w", err)
+	}
+	if err := s.Storagedb.DeleteSharedSpace(spaceID); err != nil {
+		return err
+	}
+
+	affected := []int{userID}
+	for _, member := range members {
+		affected = append(affected, member.UserID)
+	}
+	s.refreshSharesPolicies(ctx, affected...)
+	for _, grant := range grants {
+		s.refreshGroupPolicy(ctx, &models.Group{ID: grant.GroupID, Name: grant.GroupName})
+	}
+
+	return nil
+}
+
+// ListSpaceMembers возвращает участников общего пространства. Доступно любому участнику.
+func (s *Service) ListSpaceMembers(ctx context.Context, userID, spaceID int) ([]models.SpaceMember, error) {
+	if _, _, err := s.spaceAccess(userID, spaceID); err != nil {
+		return nil, err
+	}
+	return s.Storagedb.ListSpaceMembers(spaceID)
+}
+
+// GrantSpaceAccess выдает пользователю роль viewer или editor в общем пространстве. Доступно только владельцу.
+func (s *Service) GrantSpaceAccess(ctx context.Context, ownerID, spaceID int, username, role string) (*models.SpaceMember, error) {
+	if role != models.SpaceRoleViewer && role != models.SpaceRoleEditor {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSpaceRole, role)
+	}
+
+	space, ownerRole, err := s.spaceAccess(ownerID, spaceID)
+	if err != nil {
+		return nil, err
+	}
+	if ownerRole != models.SpaceRoleOwner {
+		return nil, fmt.Errorf("%w: управлять доступом может только владелец", ErrAccessDenied)
+	}
+
+	user, err := s.grantee(username)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == space.OwnerID {
+		return nil, fmt.Errorf("%w: владелец уже имеет полный доступ", ErrInvalidSpaceRole)
+	}
+
+	if err := s.Storagedb.SetSpaceMember(spaceID, user.ID, role); err != nil {
+		return nil, err
+	}
+	s.refreshSharesPolicies(ctx, user.ID)
+
+	return &models.SpaceMember{SpaceID: spaceID, UserID: user.ID, UserName: user.UserName, Role: role}, nil
+}
+
+// RevokeSpaceAccess отзывает доступ участника. Владелец может отозвать доступ у любого участника,
+// участник — только у себя (выйти из пространства).
+func (s *Service) RevokeSpaceAccess(ctx context.Context, userID, spaceID, memberID int) error {
+	space, role, err := s.spaceAccess(userID, spaceID)
+	if err != nil {
+		return err
+	}
+	if role != models.SpaceRoleOwner && userID != memberID {
+		return fmt.Errorf("%w: управлять доступом может только владелец", ErrAccessDenied)
+	}
+	if memberID == space.OwnerID {
+		return fmt.Errorf("%w: владельца нельзя удалить из пространства", ErrInvalidSpaceRole)
+	}
+
+	if err := s.Storagedb.RemoveSpaceMember(spaceID, memberID); err != nil {
+		return err
+	}
+	s.refreshSharesPolicies(ctx, memberID)
+
+	return nil
+}
+
+// ListSpaceGroups возвращает группы, имеющие доступ к общему пространству. Доступно любому участнику.
+func (s *Service) ListSpaceGroups(ctx context.Context, userID, spaceID int) ([]models.SpaceGroupGrant, error) {
+	if _, _, err := s.spaceAccess(userID, spaceID); err != nil {
+		return nil, err
+	}
+	return s.Storagedb.ListSpaceGroupGrants(spaceID)
+}
+
+// GrantSpaceGroupAccess выдает группе роль viewer или editor в общем пространстве. Доступно только владельцу.
+func (s *Service) GrantSpaceGroupAccess(ctx context.Context, ownerID, spaceID int, groupName, role string) (*models.SpaceGroupGrant, error) {
+	if role != models.SpaceRoleViewer && role != models.SpaceRoleEditor {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSpaceRole, role)
+	}
+
+	_, ownerRole, err := s.spaceAccess(ownerID, spaceID)
+	if err != nil {
+		return nil, err
+	}
+	if ownerRole != models.SpaceRoleOwner {
+		return nil, fmt.Errorf("%w: управлять доступом может только владелец", ErrAccessDenied)
+	}
+
+	group, err := s.Storagedb.GetGroupByName(groupName)
+	if err != nil {
+		return nil, err
+	}
+	if group == nil {
+		return nil, ErrGroupNotFound
+	}
+
+	if err := s.Storagedb.SetSpaceGroupGrant(spaceID, group.ID, role); err != nil {
+		return nil, err
+	}
+	s.refreshGroupPolicy(ctx, group)
+
+	return &models.SpaceGroupGrant{SpaceID: spaceID, GroupID: group.ID, GroupName: group.Name, Role: role}, nil
+}
+
+// RevokeSpaceGroupAccess отзывает доступ группы к общему пространству. Доступно только владельцу.
+func (s *Service) RevokeSpaceGroupAccess(ctx context.Context, ownerID, spaceID, groupID int) error {
+	_, role, err := s.spaceAccess(ownerID, spaceID)
+	if err != nil {
+		return err
+	}
+	if role != models.SpaceRoleOwner {
+		return fmt.Errorf("%w: управлять доступом может только владелец", ErrAccessDenied)
+	}
+
+	group, err := s.Storagedb.GetGroup(groupID)
+	if err != nil {
+		return err
+	}
+	if group == nil {
+		return ErrGroupNotFound
+	}
+
+	if err := s.Storagedb.RemoveSpaceGroupGrant(spaceID, groupID); err != nil {
+		return err
+	}
+	s.refreshGroupPolicy(ctx, group)
+
+	return nil
+}
+
+// grantee возвращает пользователя, которому выдается доступ. ErrUserNotFound возвращается только
+// для отсутствующего пользователя, чтобы сбой БД не выглядел как опечатка в имени.
+func (s *Service) grantee(username string) (*models.User, error) {
+	user, err := s.Storagedb.GetUserByUsername(username)
+	if errors.Is(err, storagedb.ErrUserNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// spaceAccess возвращает пространство и роль пользователя в нем.
+// Для несуществующего пространства и для пространства без доступа возвращается одна и та же ошибка.
+func (s *Service) spaceAccess(userID, spaceID int) (*models.SharedSpace, string, error) {
+	space, err := s.Storagedb.GetSharedSpace(spaceID)
+	if err != nil {
+		return nil, "", err
+	}
+	if space == nil {
+		return nil, "", ErrAccessDenied
+	}
+	if space.OwnerID == userID {
+		return space, models.SpaceRoleOwner, nil
+	}
+
+	role, err := s.Storagedb.GetSpaceMemberRole(spaceID, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if role == "" {
+		return nil, "", ErrAccessDenied
+	}
+
+	return space, role, nil
+}
+
+// refreshSharesPolicies обновляет политики MinIO пользователей после изменения доступа.
+// Доступ уже проверяется сервисом, поэтому ошибка только логируется: политика будет
+// пересобрана при следующем изменении доступа.
+func (s *Service) refreshSharesPolicies(ctx context.Context, userIDs ...int) {
+	for _, userID := range userIDs {
+		if err := s.syncSharesPolicy(ctx, userID); err != nil {
+			log.Printf("ошибка обновления политики общих пространств пользователя %d: %v", userID, err)
+		}
+	}
+}
+
+// refreshGroupPolicy обновляет политику MinIO группы после изменения ее доступа; ошибка только логируется
+func (s *Service) refreshGroupPolicy(ctx context.Context, group *models.Group) {
+	if err := s.syncGroupPolicy(ctx, group); err != nil {
+		log.Printf("ошибка обновления политики группы %s: %v", group.Name, err)
+	}
+}
+
+// spaceOperation выполняет файловую операцию в бакете общего пространства.
+// Для роли viewer клиент MinIO не позволяет изменять файлы.
+func spaceOperation(space *models.SharedSpace, role string, operation FileOperationFunc) FileOperationFunc {
+	return func(ctx context.Context, minioClient MinioClientInterface, _ string) (any, error) {
+		if role == models.SpaceRoleViewer {
+			minioClient = readOnlyClient{minioClient}
+		}
+		return operation(ctx, minioClient, space.BucketName)
+	}
+}
+
+// readOnlyClient клиент MinIO, запрещающий изменение файлов
+type readOnlyClient struct {
+	MinioClientInterface
+}
+
+// errReadOnly ошибка записи при доступе только на чтение
+var errReadOnly = fmt.Errorf("%w: доступ только на чтение", ErrAccessDenied)
+
+func (readOnlyClient) PutObject(context.Context, string, string, io.Reader, int64, minio.PutObjectOptions) (minio.UploadInfo, error) {
+	return minio.UploadInfo{}, errReadOnly
+}
+
+func (readOnlyClient) CopyObject(context.Context, minio.CopyDestOptions, minio.CopySrcOptions) (minio.UploadInfo, error) {
+	return minio.UploadInfo{}, errReadOnly
+}
+
+func (readOnlyClient) RemoveObject(context.Context, string, string, minio.RemoveObjectOptions) error {
+	return errReadOnly
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/minio/minio-go/v7"
+)
+
+// Размеры страницы журнала изменений
+const (
+	DefaultChangesLimit = 500
+	MaxChangesLimit     = 5000
+)
+
+// ErrConflict возвращается, если файл изменился с той версии, на которой основана загрузка клиента
+var ErrConflict = errors.New("файл был изменен другим клиентом")
+
+// UploadCondition условия загрузки для обнаружения конфликтов синхронизации
+type UploadCondition struct {
+	IfMatch      string // ETag версии, которую изменял клиент ("*" — файл должен существовать); пустая строка — без проверки
+	IfNoneMatch  bool   // Только создание: файла с таким именем еще не должно быть
+	BaseSeq      int64  // Номер изменения из журнала, на котором основана версия клиента; 0 — без проверки
+	ConflictCopy bool   // При конфликте сохранить загрузку как конфликтующую копию вместо отказа
+}
+
+// conflictCopyTimeFormat формат времени в имени конфликтующей копии
+const conflictCopyTimeFormat = "2006-01-02 150405"
+
+// ChangeSet страница журнала изменений
+type ChangeSet struct {
+	Changes   []models.Change
+	NextSince int64 // Значение since для следующего запроса
+	HasMore   bool  // Есть ли еще изменения после этой страницы
+}
+
+// ListChanges возвращает изменения пользователя с номером больше since
+func (s *Service) ListChanges(ctx context.Context, userID int, since int64, limit int) (*ChangeSet, error) {
+	if since < 0 {
+		return nil, fmt.Errorf("%w: since не может быть отрицательным", ErrInvalidListOptions)
+	}
+	switch {
+	case limit == 0:
+		limit = DefaultChangesLimit
+	case limit < 0 || limit > MaxChangesLimit:
+		return nil, fmt.Errorf("%w: limit должен быть от 1 до %d", ErrInvalidListOptions, MaxChangesLimit)
+	}
+
+	// Запрашиваем на одну запись больше, чтобы узнать, есть ли следующая страница
+	changes, err := s.Storagedb.ListChanges(userID, since, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &ChangeSet{NextSince: since}
+	if len(changes) > limit {
+		changes = changes[:limit]
+		set.HasMore = true
+	}
+	set.Changes = changes
+
+	if len(changes) > 0 {
+		set.NextSince = changes[len(changes)-1].Seq
+	} else {
+		// Журнал пуст или клиент уже все получил: отдаем текущий номер, чтобы since не откатывался
+		latest, err := s.Storagedb.GetLatestChangeSeq(userID)
+		if err != nil {
+			return nil, err
+		}
+		set.NextSince = max(since, latest)
+	}
+
+	return set, nil
+}
+
+// checkSyncBase проверяет, что с момента BaseSeq файл не менялся, и возвращает последнее изменение файла.
+// Проверка по журналу не атомарна, поэтому при загрузке она дополняется условием syncBaseCondition.
+func (s *Service) checkSyncBase(ctx context.Context, userID int, key string, cond UploadCondition) (*models.Change, error) {
+	if cond.BaseSeq <= 0 {
+		return nil, nil
+	}
+	if _, ok := spaceFromContext(ctx); ok {
+		return nil, fmt.Errorf("%w: журнал изменений ведется только для личного бакета", ErrInvalidListOptions)
+	}
+
+	latest, err := s.Storagedb.GetLatestChange(userID, key)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения журнала изменений: %w", err)
+	}
+	if latest != nil && latest.Seq > cond.BaseSeq {
+		return nil, fmt.Errorf("%w: последнее изменение %d новее %d", ErrConflict, latest.Seq, cond.BaseSeq)
+	}
+
+	return latest, nil
+}
+
+// syncBaseCondition превращает проверку BaseSeq в условие записи для MinIO: файл должен быть в том же
+// состоянии, что и после изменения latest (с тем же ETag или отсутствовать). MinIO проверяет условие
+// вместе с записью, поэтому изменение, сделанное после чтения журнала, не будет перезаписано.
+// Для файла без истории в журнале ожидается его текущее состояние.
+func syncBaseCondition(ctx context.Context, minioClient MinioClientInterface, bucketName, key string, latest *models.Change, cond UploadCondition) (UploadCondition, error) {
+	if cond.BaseSeq <= 0 {
+		return cond, nil
+	}
+
+	var etag string
+	exists := false
+	if latest != nil {
+		exists = latest.Key == key && latest.Type != string(FileDeleted)
+		etag = latest.ETag
+	} else {
+		stat, err := minioClient.StatObject(ctx, bucketName, key, minio.StatObjectOptions{})
+		switch {
+		case minio.ToErrorResponse(err).Code == "NoSuchKey":
+		case err != nil:
+			return cond, fmt.Errorf("ошибка получения информации о файле: %w", err)
+		default:
+			exists, etag = true, stat.ETag
+		}
+	}
+
+	if !exists {
+		if cond.IfMatch != "" {
+			return cond, fmt.Errorf("%w: файл %s удален", ErrConflict, key)
+		}
+		cond.IfNoneMatch = true
+		return cond, nil
+	}
+	if cond.IfNoneMatch {
+		return cond, fmt.Errorf("%w: файл %s уже существует", ErrConflict, key)
+	}
+	if cond.IfMatch != "" && cond.IfMatch != "*" && strings.Trim(cond.IfMatch, `"`) != strings.Trim(etag, `"`) {
+		return cond, fmt.Errorf("%w: версия файла %s изменилась", ErrConflict, key)
+	}
+	if etag != "" {
+		cond.IfMatch = etag
+	}
+	return cond, nil
+}
+
+// etagConflict проверяет условия If-Match и If-None-Match по текущему состоянию файла.
+// Используется, когда при конфликте нужно не отказать, а выбрать другое имя, поэтому проверка не атомарна.
+func etagConflict(ctx context.Context, minioClient MinioClientInterface, bucketName, key string, cond UploadCondition) (bool, error) {
+	if cond.IfMatch == "" && !cond.IfNoneMatch {
+		return false, nil
+	}
+
+	stat, err := minioClient.StatObject(ctx, bucketName, key, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		// Файла нет: конфликт, только если клиент изменял существующую версию
+		return cond.IfMatch != "", nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("ошибка получения информации о файле: %w", err)
+	}
+
+	if cond.IfNoneMatch {
+		return true, nil
+	}
+	return cond.IfMatch != "*" && strings.Trim(cond.IfMatch, `"`) != strings.Trim(stat.ETag, `"`), nil
+}
+
+// conflictCopyName возвращает имя конфликтующей копии: "отчет (conflicted copy 2024-05-01 153000).xlsx"
+func conflictCopyName(key string, at time.Time) string {
+	dir, base := path.Split(key)
+	ext := path.Ext(base)
+	name := strings.TrimSuffix(base, ext)
+	if name == "" {
+		// Файлы вида ".bashrc" считаются именем без расширения
+		name, ext = base, ""
+	}
+	return dir + name + " (conflicted copy " + at.Format(conflictCopyTimeFormat) + ")" + ext
+}
+
+// recordChange записывает изменение в журнал и рассылает событие подписчикам.
+// Ошибка записи в журнал не отменяет уже выполненную операцию, поэтому только логируется.
+// Журнал ведется только для личного бакета: изменение в общем пространстве рассылается
+// событием без номера всем, у кого есть доступ к пространству.
+func (s *Service) recordChange(ctx context.Context, userID int, event FileEvent) {
+	if spaceID, ok := spaceFromContext(ctx); ok {
+		s.publishSpaceEvent(spaceID, event)
+		return
+	}
+
+	if s.Storagedb != nil {
+		change := &models.Change{
+			UserID: userID,
+			Type:   string(event.Type),
+			Key:    event.Key,
+			OldKey: event.OldKey,
+			Size:   event.Size,
+			ETag:   event.ETag,
+		}
+		if err := s.Storagedb.AppendChange(change); err != nil {
+			log.Printf("ошибка записи изменения %s %s в журнал: %v", event.Type, event.Key, err)
+		} else {
+			event.Seq = change.Seq
+		}
+	}
+
+	s.publishEvent(userID, event)
+}
+
+// publishSpaceEvent рассылает событие общего пространства всем пользователям с доступом к нему
+func (s *Service) publishSpaceEvent(spaceID int, event FileEvent) {
+	if s.Storagedb == nil {
+		return
+	}
+	userIDs, err := s.Storagedb.ListSpaceUserIDs(spaceID)
+	if err != nil {
+		log.Printf("ошибка рассылки события %s %s общего пространства %d: %v", event.Type, event.Key, spaceID, err)
+		return
+	}
+
+	event.Space = spaceID
+	for _, userID := range userIDs {
+		s.publishEvent(userID, event)
+	}
+}
@@ -0,0 +1,17 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConflictCopyName проверяет имя конфликтующей копии
+func TestConflictCopyName(t *testing.T) {
+	at := time.Date(2024, 5, 1, 15, 30, 0, 0, time.UTC)
+
+	assert.Equal(t, "docs/report (conflicted copy 2024-05-01 153000).xlsx", conflictCopyName("docs/report.xlsx", at))
+	assert.Equal(t, "notes (conflicted copy 2024-05-01 153000)", conflictCopyName("notes", at))
+	assert.Equal(t, ".bashrc (conflicted copy 2024-05-01 153000)", conflictCopyName(".bashrc", at))
+}
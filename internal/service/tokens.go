@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/mailer"
+	"github.com.Vova4o/nasforhome/pkg/models"
+)
+
+// userTokenLength длина одноразового токена в байтах до кодирования
+const userTokenLength = 32
+
+// ErrInvalidToken возвращается для несуществующего, уже использованного или истекшего токена
+var ErrInvalidToken = errors.New("недействительный или истекший токен")
+
+// errMailNotConfigured возвращается, если сервер не настроен на отправку почты
+var errMailNotConfigured = errors.New("отправка почты не настроена")
+
+// hashToken возвращает хеш токена для хранения в БД: утечка таблицы не дает воспользоваться токенами
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueUserToken создает одноразовый токен и сохраняет его хеш. Прежние токены
+// с тем же назначением удаляются, чтобы действовал только последний отправленный.
+func (s *Service) issueUserToken(userID int, purpose, email string, ttl time.Duration) (string, error) {
+	if err := s.Storagedb.DeleteUserTokens(userID, purpose); err != nil {
+		return "", err
+	}
+
+	token, stored, err := s.newUserToken(userID, purpose, email, ttl)
+	if err != nil {
+		return "", err
+	}
+	if err := s.Storagedb.CreateUserToken(stored); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// newUserToken создает одноразовый токен и возвращает его вместе с записью для БД, в которой только хеш
+func (s *Service) newUserToken(userID int, purpose, email string, ttl time.Duration) (string, *models.UserToken, error) {
+	token, err := s.generateSecretKey(userTokenLength)
+	if err != nil {
+		return "", nil, fmt.Errorf("ошибка генерации токена: %w", err)
+	}
+
+	return token, &models.UserToken{
+		TokenHash: hashToken(token),
+		UserID:    userID,
+		Purpose:   purpose,
+		Email:     email,
+		ExpiresAt: time.Now().Add(ttl),
+	}, nil
+}
+
+// consumeUserToken погашает токен и возвращает его данные; повторно токен использовать нельзя
+func (s *Service) consumeUserToken(token, purpose string) (*models.UserToken, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+
+	stored, err := s.Storagedb.ConsumeUserToken(hashToken(token), purpose)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, ErrInvalidToken
+	}
+	return stored, nil
+}
+
+// sendMail отправляет письмо пользователю
+func (s *Service) sendMail(ctx context.Context, msg mailer.Message) error {
+	if s.Mailer == nil {
+		return errMailNotConfigured
+	}
+	if err := s.Mailer.Send(ctx, msg); err != nil {
+		return fmt.Errorf("ошибка отправки письма: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/mailer"
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com.Vova4o/nasforhome/pkg/storagedb"
+	"github.com/google/uuid"
+)
+
+// usernamePattern допустимое имя пользователя: строчные латинские буквы, цифры, "_", "-" и ".",
+// от 3 до 32 символов, начинается и заканчивается буквой или цифрой
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{1,30}[a-z0-9]$`)
+
+// userStoragePrefix префикс бакета и ключа MinIO личного хранилища пользователя
+const userStoragePrefix = "user-"
+
+// emailChangeTokenTTL срок действия кода подтверждения нового адреса почты
+const emailChangeTokenTTL = 24 * time.Hour
+
+// Ошибки проверки данных пользователя
+var (
+	ErrInvalidUsername = errors.New("недопустимое имя пользователя")
+	ErrInvalidEmail    = errors.New("недопустимый адрес почты")
+)
+
+// ErrEmailTaken возвращается, если адрес почты уже использует другой пользователь
+var ErrEmailTaken = fmt.Errorf("%w: адрес почты уже используется", ErrConflict)
+
+// ProfileUpdate изменения профиля пользователя; nil означает, что поле не меняется
+type ProfileUpdate struct {
+	Username *string
+	Email    *string
+}
+
+// ValidateUsername проверяет имя пользователя.
+// Имя используется только для отображения и входа, поэтому его можно менять, не затрагивая хранилище.
+func ValidateUsername(username string) error {
+	if !usernamePattern.MatchString(username) {
+		return fmt.Errorf("%w: допустимы строчные латинские буквы, цифры, \"_\", \"-\" и \".\", от 3 до 32 символов", ErrInvalidUsername)
+	}
+	return nil
+}
+
+// newStorageName возвращает постоянное имя хранилища пользователя: оно служит и именем бакета,
+// и ключом доступа MinIO. Имя не зависит от имени пользователя и всегда подходит под правила S3.
+func newStorageName() string {
+	return userStoragePrefix + uuid.NewString()
+}
+
+// normalizeEmail проверяет адрес почты; допускается только сам адрес, без отображаемого имени
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", fmt.Errorf("%w: %s", ErrInvalidEmail, email)
+	}
+	return addr.Address, nil
+}
+
+// UpdateProfile меняет имя пользователя и начинает смену адреса почты.
+// Бакет и ключи MinIO не зависят от имени, поэтому при переименовании остаются прежними.
+// Новый адрес вступает в силу только после подтверждения кодом из письма, а неподтвержденный
+// при регистрации адрес заменяется сразу и подтверждается заново;
+// возвращается адрес, ожидающий подтверждения, или пустая строка.
+// Изменения применяются целиком или не применяются вовсе: оба поля проверяются до записи,
+// а имя, адрес и код сохраняются в одной транзакции, которая отменяется, если письмо не отправлено.
+func (s *Service) UpdateProfile(ctx context.Context, userID int, update ProfileUpdate) (*models.User, string, error) {
+	user, err := s.Storagedb.GetUserByID(userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	updated := *user
+	if update.Username != nil && *update.Username != user.UserName {
+		// С внешним каталогом имена задает каталог: чужое имя позволило бы занять учетную запись каталога до ее первого входа
+		if s.Authenticator != nil {
+			return nil, "", fmt.Errorf("%w: имя пользователя задается во внешнем каталоге", ErrAccessDenied)
+		}
+		if err := ValidateUsername(*update.Username); err != nil {
+			return nil, "", err
+		}
+		// Проверка дает понятную ошибку, а одновременное переименование в то же имя отсечет уникальный индекс
+		if existing, err := s.Storagedb.GetUserByUsername(*update.Username); err == nil && existing.ID != user.ID {
+			return nil, "", usernameTakenError(*update.Username)
+		}
+		updated.UserName = *update.Username
+	}
+
+	var pendingEmail string
+	if update.Email != nil && !strings.EqualFold(strings.TrimSpace(*update.Email), user.Email) {
+		pendingEmail, err = normalizeEmail(*update.Email)
+		if err != nil {
+			return nil, "", err
+		}
+		// Код не отправляется на чужой адрес; одновременную смену на тот же адрес отсечет ConfirmEmailChange
+		if err := s.checkEmailAvailable(user.ID, pendingEmail); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if updated.UserName == user.UserName && pendingEmail == "" {
+		return user, "", nil
+	}
+
+	var token *models.UserToken
+	var deliver func() error
+	if pendingEmail != "" {
+		var code string
+		var msg mailer.Message
+		if user.ProvisioningState == models.ProvisioningUnverified {
+			// Адрес еще не подтвержден, поэтому его можно просто исправить и отправить код на новый
+			updated.Email = pendingEmail
+			code, token, err = s.newUserToken(user.ID, models.TokenPurposeEmailVerify, pendingEmail, emailVerifyTokenTTL)
+			msg = emailVerificationMessage(&updated, code)
+		} else {
+			code, token, err = s.newUserToken(user.ID, models.TokenPurposeEmailChange, pendingEmail, emailChangeTokenTTL)
+			msg = emailChangeMessage(&updated, pendingEmail, code)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		deliver = func() error { return s.sendMail(ctx, msg) }
+	}
+
+	err = s.Storagedb.UpdateProfile(&updated, token, deliver)
+	switch {
+	case errors.Is(err, storagedb.ErrUsernameTaken):
+		return nil, "", usernameTakenError(updated.UserName)
+	case errors.Is(err, storagedb.ErrEmailTaken):
+		return nil, "", ErrEmailTaken
+	case err != nil:
+		return nil, "", err
+	}
+
+	return &updated, pendingEmail, nil
+}
+
+// usernameTakenError возвращает ошибку занятого имени пользователя
+func usernameTakenError(username string) error {
+	return fmt.Errorf("%w: имя %s уже занято", ErrConflict, username)
+}
+
+// checkEmailAvailable проверяет, что адрес не использует другой пользователь
+func (s *Service) checkEmailAvailable(userID int, email string) error {
+	existing, err := s.Storagedb.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.ID != userID {
+		return ErrEmailTaken
+	}
+	return nil
+}
+
+// emailChangeMessage письмо с кодом подтверждения нового адреса почты
+func emailChangeMessage(user *models.User, email, token string) mailer.Message {
+	return mailer.Message{
+		To:      email,
+		Subject: "Подтверждение адреса почты",
+		Body: fmt.Sprintf("Чтобы подтвердить адрес %s для пользователя %s, введите код:\n\n%s\n\n"+
+			"Код действует %d ч. Если вы не меняли адрес, просто проигнорируйте это письмо.",
+			email, user.UserName, token, int(emailChangeTokenTTL.Hours())),
+	}
+}
+
+// ConfirmEmailChange применяет новый адрес почты по коду из письма. Код погашается вместе со сменой
+// адреса, поэтому, если адрес за это время занял другой пользователь, код остается действительным.
+func (s *Service) ConfirmEmailChange(ctx context.Context, token string) (*models.User, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+
+	stored, err := s.Storagedb.ConfirmEmailChange(hashToken(token))
+	if errors.Is(err, storagedb.ErrEmailTaken) {
+		return nil, ErrEmailTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, ErrInvalidToken
+	}
+
+	return s.Storagedb.GetUserByID(stored.UserID)
+}
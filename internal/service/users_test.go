@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestValidateUsername проверяет правила имени пользователя
+func TestValidateUsername(t *testing.T) {
+	valid := []string{"bob", "alice_smith", "j.doe", "user-42", "a1b"}
+	for _, username := range valid {
+		assert.NoError(t, ValidateUsername(username), username)
+	}
+
+	invalid := []string{
+		"",
+		"ab",
+		"Alice",
+		"alice smith",
+		"_alice",
+		"alice-",
+		"алиса",
+		"alice/../bob",
+		"a23456789012345678901234567890123",
+	}
+	for _, username := range invalid {
+		assert.ErrorIs(t, ValidateUsername(username), ErrInvalidUsername, username)
+	}
+}
+
+// TestRegisterUserInvalidUsername проверяет, что недопустимое имя отклоняется до обращения к БД и MinIO
+func TestRegisterUserInvalidUsername(t *testing.T) {
+	srv := &Service{}
+
+	_, _, err := srv.RegisterUser(context.Background(), "Bad_Name!", "password", "a@example.com", "")
+	assert.ErrorIs(t, err, ErrInvalidUsername)
+}
+
+// TestNewStorageName проверяет, что имя хранилища уникально и подходит под правила имен бакетов S3
+func TestNewStorageName(t *testing.T) {
+	bucketPattern := regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,61}[a-z0-9]$`)
+
+	first, second := newStorageName(), newStorageName()
+	assert.Regexp(t, bucketPattern, first)
+	assert.NotEqual(t, first, second)
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/mailer"
+	"github.com.Vova4o/nasforhome/pkg/models"
+)
+
+// emailVerifyTokenTTL срок действия кода подтверждения адреса при регистрации
+const emailVerifyTokenTTL = 48 * time.Hour
+
+// unverifiedAccountTTL время, после которого неподтвержденная регистрация удаляется,
+// освобождая имя пользователя и адрес почты
+const unverifiedAccountTTL = 7 * 24 * time.Hour
+
+// ErrEmailNotVerified возвращается при работе с хранилищем до подтверждения адреса почты
+var ErrEmailNotVerified = fmt.Errorf("%w: адрес почты не подтвержден", ErrAccessDenied)
+
+// ErrEmailAlreadyVerified возвращается при повторном запросе подтверждения уже подтвержденного адреса
+var ErrEmailAlreadyVerified = fmt.Errorf("%w: адрес почты уже подтвержден", ErrConflict)
+
+// registerUnverified оставляет только что созданного пользователя без хранилища до подтверждения адреса
+// и отправляет код подтверждения
+func (s *Service) registerUnverified(ctx context.Context, userID int) (*models.User, error) {
+	ok, err := s.Storagedb.SetProvisioningState(userID, models.ProvisioningPending, models.ProvisioningUnverified)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: пользователь %d", ErrProvisioningConflict, userID)
+	}
+
+	user, err := s.Storagedb.GetUserByID(userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения данных пользователя: %w", err)
+	}
+
+	// Регистрация не отменяется из-за почты: код можно запросить повторно
+	if err := s.sendEmailVerification(ctx, user); err != nil {
+		log.Printf("ошибка отправки кода подтверждения пользователю %s: %v", user.UserName, err)
+	}
+	return user, nil
+}
+
+// sendEmailVerification отправляет на адрес пользователя код подтверждения
+func (s *Service) sendEmailVerification(ctx context.Context, user *models.User) error {
+	token, err := s.issueUserToken(user.ID, models.TokenPurposeEmailVerify, user.Email, emailVerifyTokenTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.sendMail(ctx, emailVerificationMessage(user, token))
+}
+
+// emailVerificationMessage письмо с кодом подтверждения адреса, указанного при регистрации
+func emailVerificationMessage(user *models.User, token string) mailer.Message {
+	return mailer.Message{
+		To:      user.Email,
+		Subject: "Подтверждение регистрации",
+		Body: fmt.Sprintf("Чтобы завершить регистрацию пользователя %s, введите код:\n\n%s\n\n"+
+			"Код действует %d ч. Хранилище будет создано после подтверждения адреса. "+
+			"Если вы не регистрировались, просто проигнорируйте это письмо.",
+			user.UserName, token, int(emailVerifyTokenTTL.Hours())),
+	}
+}
+
+// ResendEmailVerification повторно отправляет код подтверждения; прежний код перестает действовать
+func (s *Service) ResendEmailVerification(ctx context.Context, userID int) error {
+	user, err := s.Storagedb.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.ProvisioningState != models.ProvisioningUnverified {
+		return ErrEmailAlreadyVerified
+	}
+	return s.sendEmailVerification(ctx, user)
+}
+
+// VerifyEmail подтверждает адрес почты по коду из письма и создает хранилище пользователя
+func (s *Service) VerifyEmail(ctx context.Context, token string) (*models.User, error) {
+	stored, err := s.consumeUserToken(token, models.TokenPurposeEmailVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.Storagedb.GetUserByID(stored.UserID)
+	if err != nil {
+		return nil, err
+	}
+	// Код выдан на прежний адрес, если пользователь с тех пор его исправил
+	if user.Email != stored.Email {
+		return nil, ErrInvalidToken
+	}
+
+	ok, err := s.Storagedb.MarkEmailVerified(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrEmailAlreadyVerified
+	}
+	user.EmailVerified = true
+	user.ProvisioningState = models.ProvisioningPending
+
+	if err := s.provisionOrRollback(ctx, user); err != nil {
+		return nil, err
+	}
+	return s.Storagedb.GetUserByID(user.ID)
+}
+
+// provisionOrRollback создает хранилище пользователя, а при окончательной ошибке удаляет созданное вместе с пользователем.
+// После временных сбоев пользователь остается, и создание доводит реконсилер: ответ тогда ErrAccountNotReady.
+// Если создание продвигает другой процесс, откат не выполняется.
+func (s *Service) provisionOrRollback(ctx context.Context, user *models.User) error {
+	// Создание хранилища не должно обрываться на полпути из-за отключения клиента
+	provisionCtx := context.WithoutCancel(ctx)
+	err := s.provisionWithRetry(provisionCtx, user)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, ErrProvisioningConflict):
+	case transientProvisioningError(err):
+		log.Printf("создание хранилища пользователя %s отложено до реконсилера: %v", user.UserName, err)
+		return fmt.Errorf("%w: хранилище будет создано позже: %w", ErrAccountNotReady, err)
+	default:
+		if rbErr := s.rollbackProvisioning(provisionCtx, user); rbErr != nil {
+			log.Printf("ошибка отката создания пользователя %s, откат будет повторен при запуске: %v", user.UserName, rbErr)
+		}
+	}
+	return fmt.Errorf("ошибка создания хранилища пользователя: %w", err)
+}
+
+// provisionVerifiedUser создает пользователя, адрес которого подтвержден внешним поставщиком,
+// теми же шагами, что и регистрация. Пароль случайный: войти по паролю можно, задав его через восстановление.
+func (s *Service) provisionVerifiedUser(ctx context.Context, username, email string) (*models.User, error) {
+	password, err := s.generateSecretKey(32)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка генерации пароля: %w", err)
+	}
+	passwordHash, err := s.PasswordHash(password)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка хеширования пароля: %w", err)
+	}
+
+	pending, err := s.createUser(username, passwordHash, email, 0, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Адрес уже подтвержден: пользователь проходит тот же переход, что и при подтверждении кодом
+	ok, err := s.Storagedb.SetProvisioningState(pending.ID, models.ProvisioningPending, models.ProvisioningUnverified)
+	if err == nil && ok {
+		ok, err = s.Storagedb.MarkEmailVerified(pending.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: пользователь %d", ErrProvisioningConflict, pending.ID)
+	}
+
+	if err := s.provisionOrRollback(ctx, pending); err != nil {
+		return nil, err
+	}
+	return s.Storagedb.GetUserByID(pending.ID)
+}
+
+// storageReady проверяет, что хранилище пользователя создано и с ним можно работать
+func storageReady(user *models.User) error {
+	switch user.ProvisioningState {
+	case models.ProvisioningReady:
+		return nil
+	case models.ProvisioningUnverified:
+		return ErrEmailNotVerified
+	default:
+		return ErrAccountNotReady
+	}
+}
+
+// CleanupUnverifiedUsers удаляет регистрации, адрес которых не подтвержден за unverifiedAccountTTL
+func (s *Service) CleanupUnverifiedUsers() error {
+	deleted, err := s.Storagedb.DeleteUnverifiedUsers(time.Now().Add(-unverifiedAccountTTL))
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Printf("удалено неподтвержденных регистраций: %d", deleted)
+	}
+	return nil
+}
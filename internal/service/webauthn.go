@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com.Vova4o/nasforhome/pkg/webauthn"
+)
+
+// Параметры ключей доступа
+const (
+	webauthnChallengeTTL = 5 * time.Minute // Время на подтверждение ключом в браузере
+	maxPasskeyNameLength = 100
+)
+
+// Ошибки ключей доступа
+var (
+	ErrWebAuthnDisabled   = fmt.Errorf("%w: вход по ключам доступа не настроен", ErrAccessDenied)
+	ErrInvalidPasskey     = fmt.Errorf("%w: ключ доступа не прошел проверку", ErrAccessDenied)
+	ErrPasskeyExists      = fmt.Errorf("%w: ключ доступа уже зарегистрирован", ErrConflict)
+	ErrPasskeyNotFound    = errors.New("ключ доступа не найден")
+	ErrInvalidPasskeyName = fmt.Errorf("название ключа должно быть от 1 до %d символов", maxPasskeyNameLength)
+)
+
+// BeginPasskeyRegistration начинает добавление ключа доступа и возвращает параметры для браузера.
+// Уже добавленные ключи перечисляются, чтобы аутентификатор не создал второй ключ для того же пользователя.
+func (s *Service) BeginPasskeyRegistration(ctx context.Context, userID int) (*webauthn.CreationOptions, error) {
+	if s.WebAuthn.RPID == "" {
+		return nil, ErrWebAuthnDisabled
+	}
+
+	user, err := s.Storagedb.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := s.Storagedb.ListWebAuthnCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+	exclude := make([]webauthn.CredentialDescriptor, 0, len(creds))
+	for _, cred := range creds {
+		exclude = append(exclude, webauthn.Descriptor(cred.CredentialID, cred.Transports))
+	}
+
+	challenge, err := s.beginWebAuthnCeremony(userID, models.WebAuthnPurposeRegistration)
+	if err != nil {
+		return nil, err
+	}
+
+	entity := webauthn.UserEntity{
+		ID:          passkeyUserHandle(user.ID),
+		Name:        user.UserName,
+		DisplayName: user.UserName,
+	}
+	return s.WebAuthn.NewCreationOptions(challenge, entity, exclude, webauthnChallengeTTL), nil
+}
+
+// FinishPasskeyRegistration проверяет ответ аутентификатора и сохраняет ключ доступа под названием name
+func (s *Service) FinishPasskeyRegistration(ctx context.Context, userID int, name string, cred *webauthn.RegistrationCredential) (*models.WebAuthnCredential, error) {
+	if s.WebAuthn.RPID == "" {
+		return nil, ErrWebAuthnDisabled
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxPasskeyNameLength {
+		return nil, ErrInvalidPasskeyName
+	}
+
+	stored, challenge, err := s.finishWebAuthnCeremony(cred.Response.ClientDataJSON, models.WebAuthnPurposeRegistration)
+	if err != nil {
+		return nil, err
+	}
+	// Ответ на вызов, выданный другому пользователю, не принимается
+	if stored.UserID != userID {
+		return nil, ErrInvalidPasskey
+	}
+
+	verified, err := s.WebAuthn.VerifyRegistration(challenge, cred)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	existing, err := s.Storagedb.GetWebAuthnCredential(verified.ID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrPasskeyExists
+	}
+
+	passkey := &models.WebAuthnCredential{
+		UserID:       userID,
+		Name:         name,
+		CredentialID: verified.ID,
+		PublicKey:    verified.PublicKey,
+		SignCount:    verified.SignCount,
+		Transports:   verified.Transports,
+	}
+	if err := s.Storagedb.CreateWebAuthnCredential(passkey); err != nil {
+		return nil, err
+	}
+	return passkey, nil
+}
+
+// BeginPasskeyLogin начинает вход по ключу доступа. Имя пользователя не нужно:
+// ключи создаются доступными для выбора в браузере, а пользователь определяется по выбранному ключу.
+func (s *Service) BeginPasskeyLogin(ctx context.Context) (*webauthn.RequestOptions, error) {
+	if s.WebAuthn.RPID == "" {
+		return nil, ErrWebAuthnDisabled
+	}
+
+	challenge, err := s.beginWebAuthnCeremony(0, models.WebAuthnPurposeLogin)
+	if err != nil {
+		return nil, err
+	}
+	return s.WebAuthn.NewRequestOptions(challenge, nil, webauthnChallengeTTL), nil
+}
+
+// FinishPasskeyLogin проверяет подпись ключа доступа и выдает пару токенов, как вход по паролю.
+// Второй фактор не запрашивается: ключ подтверждается PIN-кодом или биометрией на устройстве.
+func (s *Service) FinishPasskeyLogin(ctx context.Context, cred *webauthn.AssertionCredential) (*models.User, *TokenPair, error) {
+	if s.WebAuthn.RPID == "" {
+		return nil, nil, ErrWebAuthnDisabled
+	}
+
+	_, challenge, err := s.finishWebAuthnCeremony(cred.Response.ClientDataJSON, models.WebAuthnPurposeLogin)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	passkey, err := s.Storagedb.GetWebAuthnCredential(cred.RawID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if passkey == nil {
+		return nil, nil, fmt.Errorf("%w: ключ не зарегистрирован", ErrInvalidPasskey)
+	}
+	// Аутентификатор сообщает, для какого пользователя создан ключ; он должен совпадать с владельцем
+	if len(cred.Response.UserHandle) != 0 && string(cred.Response.UserHandle) != string(passkeyUserHandle(passkey.UserID)) {
+		return nil, nil, ErrInvalidPasskey
+	}
+
+	signCount, err := s.WebAuthn.VerifyAssertion(challenge, cred, passkey.PublicKey, passkey.SignCount)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+	fresh, err := s.Storagedb.UpdateWebAuthnSignCount(passkey.ID, signCount)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !fresh {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, webauthn.ErrSignCount)
+	}
+
+	user, err := s.Storagedb.GetUserByID(passkey.UserID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка аутентификации: %w", err)
+	}
+	// Те же правила, что и при входе по паролю
+	if user.ProvisioningState != models.ProvisioningReady && user.ProvisioningState != models.ProvisioningUnverified {
+		return nil, nil, ErrAccountNotReady
+	}
+
+	tokens, err := s.startSession(ctx, user)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ошибка создания токенов: %w", err)
+	}
+	return user, tokens, nil
+}
+
+// ListPasskeys возвращает ключи доступа пользователя
+func (s *Service) ListPasskeys(ctx context.Context, userID int) ([]models.WebAuthnCredential, error) {
+	return s.Storagedb.ListWebAuthnCredentials(userID)
+}
+
+// DeletePasskey удаляет ключ доступа пользователя
+func (s *Service) DeletePasskey(ctx context.Context, userID, id int) error {
+	ok, err := s.Storagedb.DeleteWebAuthnCredential(userID, id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPasskeyNotFound
+	}
+	return nil
+}
+
+// beginWebAuthnCeremony создает вызов и сохраняет его хеш; userID 0 означает, что пользователь неизвестен
+func (s *Service) beginWebAuthnCeremony(userID int, purpose string) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.Storagedb.CreateWebAuthnChallenge(&models.WebAuthnChallenge{
+		ChallengeHash: hashToken(string(challenge)),
+		UserID:        userID,
+		Purpose:       purpose,
+		ExpiresAt:     time.Now().Add(webauthnChallengeTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// finishWebAuthnCeremony погашает вызов из данных клиента; повторно ответить на тот же вызов нельзя
+func (s *Service) finishWebAuthnCeremony(clientDataJSON []byte, purpose string) (*models.WebAuthnChallenge, []byte, error) {
+	challenge, err := webauthn.Challenge(clientDataJSON)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	stored, err := s.Storagedb.ConsumeWebAuthnChallenge(hashToken(string(challenge)), purpose)
+	if err != nil {
+		return nil, nil, err
+	}
+	if stored == nil {
+		return nil, nil, fmt.Errorf("%w: вызов истек или уже использован", ErrInvalidPasskey)
+	}
+	return stored, challenge, nil
+}
+
+// passkeyUserHandle идентификатор пользователя, который хранит аутентификатор. Имя и адрес почты
+// в него не попадают: WebAuthn не гарантирует, что идентификатор останется тайным.
+func passkeyUserHandle(userID int) []byte {
+	return []byte(strconv.Itoa(userID))
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 // Config структура для хранения настроек
@@ -22,6 +23,45 @@ type Config struct {
 	UserDB           string
 	PasswordDB       string
 	NameDB           string
+	UserQuotaBytes   int64
+	MaxExtractBytes  int64
+	SMTPHost         string
+	SMTPPort         string
+	SMTPUser         string
+	SMTPPassword     string
+	MailFrom         string
+	MailFile         string
+	VerifyEmail      bool
+	RegistrationMode string
+	WebAuthnRPID     string
+	WebAuthnRPName   string
+	WebAuthnOrigins  []string
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCCreateUsers  bool
+	LDAPURL          string
+	LDAPStartTLS     bool
+	LDAPBindDN       string
+	LDAPBindPassword string
+	LDAPBaseDN       string
+	LDAPUserFilter   string
+	LDAPGroupBaseDN  string
+	LDAPGroupFilter  string
+	LDAPAdminGroups  []string
+	LDAPUserGroups   []string
+	LoginStore       string
+	LoginUserLockout int
+	LoginIPLockout   int
+	TrustedProxies   []string
+	IPRateLimit      int
+	IPRateBurst      int
+	UserRateLimit    int
+	UserRateBurst    int
+	MaxJSONBytes     int64
+	MaxUploadBytes   int64
+	MaxAdminUpload   int64
 }
 
 // New возвращает новый экземпляр Config
@@ -42,6 +82,45 @@ func New() *Config {
 		UserDB:           getEnv("USER_DB", ""),
 		PasswordDB:       getEnv("PASSWORD_DB", ""),
 		NameDB:           getEnv("NAME_DB", ""),
+		UserQuotaBytes:   getEnvInt64("USER_QUOTA_BYTES", 0),
+		MaxExtractBytes:  getEnvInt64("MAX_EXTRACT_BYTES", 2<<30),
+		SMTPHost:         os.Getenv("SMTP_HOST"),
+		SMTPPort:         getEnv("SMTP_PORT", "587"),
+		SMTPUser:         os.Getenv("SMTP_USER"),
+		SMTPPassword:     os.Getenv("SMTP_PASSWORD"),
+		MailFrom:         getEnv("MAIL_FROM", "nas@localhost"),
+		MailFile:         os.Getenv("MAIL_FILE"),
+		VerifyEmail:      getEnvBool("REQUIRE_EMAIL_VERIFICATION", true),
+		RegistrationMode: getEnv("REGISTRATION_MODE", "open"),
+		WebAuthnRPID:     getEnv("WEBAUTHN_RP_ID", "localhost"),
+		WebAuthnRPName:   getEnv("WEBAUTHN_RP_NAME", "NASForHome"),
+		WebAuthnOrigins:  getEnvList("WEBAUTHN_ORIGINS", []string{"http://localhost:8080"}),
+		OIDCIssuer:       os.Getenv("OIDC_ISSUER"),
+		OIDCClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		OIDCClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		OIDCRedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		OIDCCreateUsers:  getEnvBool("OIDC_AUTO_PROVISION", false),
+		LDAPURL:          os.Getenv("LDAP_URL"),
+		LDAPStartTLS:     getEnvBool("LDAP_START_TLS", false),
+		LDAPBindDN:       os.Getenv("LDAP_BIND_DN"),
+		LDAPBindPassword: os.Getenv("LDAP_BIND_PASSWORD"),
+		LDAPBaseDN:       os.Getenv("LDAP_BASE_DN"),
+		LDAPUserFilter:   os.Getenv("LDAP_USER_FILTER"),
+		LDAPGroupBaseDN:  os.Getenv("LDAP_GROUP_BASE_DN"),
+		LDAPGroupFilter:  os.Getenv("LDAP_GROUP_FILTER"),
+		LDAPAdminGroups:  getEnvList("LDAP_ADMIN_GROUPS", nil),
+		LDAPUserGroups:   getEnvList("LDAP_USER_GROUPS", nil),
+		LoginStore:       getEnv("LOGIN_THROTTLE_STORE", "memory"),
+		LoginUserLockout: getEnvInt("LOGIN_USER_LOCKOUT", 10),
+		LoginIPLockout:   getEnvInt("LOGIN_IP_LOCKOUT", 30),
+		TrustedProxies:   getEnvList("TRUSTED_PROXIES", nil),
+		IPRateLimit:      getEnvInt("RATE_LIMIT_IP_PER_MINUTE", 600),
+		IPRateBurst:      getEnvInt("RATE_LIMIT_IP_BURST", 100),
+		UserRateLimit:    getEnvInt("RATE_LIMIT_USER_PER_MINUTE", 1200),
+		UserRateBurst:    getEnvInt("RATE_LIMIT_USER_BURST", 200),
+		MaxJSONBytes:     getEnvInt64("MAX_JSON_BYTES", 1<<20),
+		MaxUploadBytes:   getEnvInt64("MAX_UPLOAD_BYTES", 0),
+		MaxAdminUpload:   getEnvInt64("MAX_ADMIN_UPLOAD_BYTES", 0),
 	}
 }
 
@@ -58,6 +137,19 @@ func getEnvInt(key string, defaultValue int) int {
 	return result
 }
 
+// getEnvInt64 возвращает значение переменной окружения в виде int64 или значение по умолчанию
+func getEnvInt64(key string, defaultValue int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	result, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return result
+}
+
 // getEnv возвращает значение переменной окружения или значение по умолчанию
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -67,6 +159,20 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
+// getEnvList возвращает значение переменной окружения, разделенное запятыми, или значение по умолчанию
+func getEnvList(key string, defaultValue []string) []string {
+	var result []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	if len(result) == 0 {
+		return defaultValue
+	}
+	return result
+}
+
 // getEnvBool возвращает значение переменной окружения в виде bool или значение по умолчанию
 func getEnvBool(key string, defaultValue bool) bool {
 	value := os.Getenv(key)
@@ -0,0 +1,223 @@
+package ldapauth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Значения по умолчанию для OpenLDAP со схемами inetOrgPerson и groupOfNames
+const (
+	DefaultUserFilter  = "(&(objectClass=inetOrgPerson)(uid=%s))"
+	DefaultGroupFilter = "(&(objectClass=groupOfNames)(member=%s))"
+	defaultTimeout     = 10 * time.Second
+)
+
+// Config параметры подключения к каталогу LDAP
+type Config struct {
+	URL          string // ldap://host:389 или ldaps://host:636
+	StartTLS     bool   // Перейти на TLS после подключения по ldap://
+	BindDN       string // Служебная учетная запись для поиска; пусто — анонимный поиск
+	BindPassword string
+	BaseDN       string // Где искать пользователей
+	UserFilter   string // Фильтр пользователя, %s заменяется именем
+	GroupBaseDN  string // Где искать группы; по умолчанию BaseDN
+	GroupFilter  string // Фильтр групп пользователя, %s заменяется DN пользователя
+	// AdminGroups группы (cn или DN), участники которых становятся администраторами NAS.
+	// Если список пуст, роли назначаются в самом NAS, а не по каталогу.
+	AdminGroups []string
+	// UserGroups группы, участникам которых разрешен вход. Если список пуст, войти может любой пользователь каталога.
+	UserGroups []string
+	Timeout    time.Duration
+}
+
+// conn операции с каталогом, которые нужны для входа
+type conn interface {
+	StartTLS(config *tls.Config) error
+	Bind(username, password string) error
+	Search(request *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// Authenticator проверяет пароль пользователя привязкой к каталогу LDAP под его DN
+type Authenticator struct {
+	config Config
+	dial   func() (conn, error)
+}
+
+// New проверяет настройки и возвращает Authenticator
+func New(config Config) (*Authenticator, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return nil, fmt.Errorf("некорректный адрес каталога LDAP %q", config.URL)
+	}
+	if config.StartTLS && u.Scheme == "ldaps" {
+		return nil, errors.New("StartTLS не применяется к подключению ldaps://")
+	}
+	if config.BaseDN == "" {
+		return nil, errors.New("не указан BaseDN каталога LDAP")
+	}
+	if config.UserFilter == "" {
+		config.UserFilter = DefaultUserFilter
+	}
+	if config.GroupFilter == "" {
+		config.GroupFilter = DefaultGroupFilter
+	}
+	if config.GroupBaseDN == "" {
+		config.GroupBaseDN = config.BaseDN
+	}
+	for _, filter := range []string{config.UserFilter, config.GroupFilter} {
+		if strings.Count(filter, "%s") != 1 || strings.Count(filter, "%") != 1 {
+			return nil, fmt.Errorf("фильтр %q должен содержать ровно одну подстановку %%s", filter)
+		}
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+
+	a := &Authenticator{config: config}
+	a.dial = func() (conn, error) {
+		c, err := ldap.DialURL(config.URL, ldap.DialWithDialer(&net.Dialer{Timeout: config.Timeout}))
+		if err != nil {
+			return nil, err
+		}
+		c.SetTimeout(config.Timeout)
+		return c, nil
+	}
+	return a, nil
+}
+
+// Authenticate проверяет пароль пользователя и возвращает его адрес почты, группы и роль в NAS.
+// Возвращает nil, если пользователь не найден, пароль не подходит или вход для его групп не разрешен.
+func (a *Authenticator) Authenticate(ctx context.Context, username, password string) (*models.DirectoryUser, error) {
+	// Привязка с пустым паролем в LDAP считается анонимной и проходит для любого DN
+	if username == "" || password == "" {
+		return nil, nil
+	}
+
+	c, err := a.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	// Библиотека LDAP не принимает контекст, поэтому при его отмене просто закрываем соединение
+	stop := context.AfterFunc(ctx, func() { c.Close() })
+	defer stop()
+
+	if err := a.bindService(c); err != nil {
+		return nil, err
+	}
+
+	result, err := c.Search(ldap.NewSearchRequest(
+		a.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf(a.config.UserFilter, ldap.EscapeFilter(username)),
+		[]string{"mail", "entryUUID"}, nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("ошибка поиска пользователя в каталоге LDAP: %w", err)
+	}
+	if result == nil || len(result.Entries) == 0 {
+		return nil, nil
+	}
+	if len(result.Entries) > 1 {
+		return nil, fmt.Errorf("в каталоге LDAP найдено несколько пользователей %s", username)
+	}
+	entry := result.Entries[0]
+
+	if err := c.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("ошибка проверки пароля в каталоге LDAP: %w", err)
+	}
+
+	// Группы ищем снова от имени служебной учетной записи: пользователю их чтение может быть запрещено
+	if err := a.bindService(c); err != nil {
+		return nil, err
+	}
+	groups, err := a.groups(c, entry.DN)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.DirectoryUser{
+		ID:       entry.GetAttributeValue("entryUUID"),
+		Username: username,
+		Email:    entry.GetAttributeValue("mail"),
+	}
+	// Без entryUUID пользователь определяется по DN: имя uid можно переименовать, а DN — только переносом записи
+	if user.ID == "" {
+		user.ID = entry.DN
+	}
+	for _, group := range groups {
+		user.Groups = append(user.Groups, group.GetAttributeValue("cn"))
+	}
+	if len(a.config.AdminGroups) > 0 {
+		user.Role = models.UserRoleUser
+		if memberOf(groups, a.config.AdminGroups) {
+			user.Role = models.UserRoleAdmin
+		}
+	}
+	if len(a.config.UserGroups) > 0 && user.Role != models.UserRoleAdmin && !memberOf(groups, a.config.UserGroups) {
+		return nil, nil
+	}
+	return user, nil
+}
+
+// connect подключается к каталогу и при необходимости включает TLS
+func (a *Authenticator) connect() (conn, error) {
+	c, err := a.dial()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подключения к каталогу LDAP: %w", err)
+	}
+	if a.config.StartTLS {
+		u, _ := url.Parse(a.config.URL)
+		if err := c.StartTLS(&tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("ошибка включения TLS в каталоге LDAP: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// bindService выполняет привязку служебной учетной записи; без нее поиск выполняется анонимно
+func (a *Authenticator) bindService(c conn) error {
+	if a.config.BindDN == "" {
+		return nil
+	}
+	if err := c.Bind(a.config.BindDN, a.config.BindPassword); err != nil {
+		return fmt.Errorf("ошибка привязки служебной учетной записи LDAP: %w", err)
+	}
+	return nil
+}
+
+// groups возвращает группы пользователя
+func (a *Authenticator) groups(c conn, userDN string) ([]*ldap.Entry, error) {
+	result, err := c.Search(ldap.NewSearchRequest(
+		a.config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		fmt.Sprintf(a.config.GroupFilter, ldap.EscapeFilter(userDN)),
+		[]string{"cn"}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка поиска групп пользователя в каталоге LDAP: %w", err)
+	}
+	return result.Entries, nil
+}
+
+// memberOf проверяет, входит ли пользователь хотя бы в одну из групп wanted, заданных по cn или DN
+func memberOf(groups []*ldap.Entry, wanted []string) bool {
+	return slices.ContainsFunc(groups, func(group *ldap.Entry) bool {
+		return slices.ContainsFunc(wanted, func(w string) bool {
+			return strings.EqualFold(w, group.GetAttributeValue("cn")) || strings.EqualFold(w, group.DN)
+		})
+	})
+}
@@ -0,0 +1,182 @@
+package ldapauth
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+	"testing"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const serviceDN = "cn=nas,dc=home"
+
+type fakeUser struct {
+	dn, uid, mail, password string
+	uuid                    string
+}
+
+type fakeGroup struct {
+	dn, cn  string
+	members []string
+}
+
+// fakeDirectory каталог в памяти, который понимает только фильтры по умолчанию
+type fakeDirectory struct {
+	users  []fakeUser
+	groups []fakeGroup
+
+	bound    string   // DN последней успешной привязки
+	searches []string // DN, от имени которых выполнялся поиск
+	closed   bool
+}
+
+func (d *fakeDirectory) StartTLS(config *tls.Config) error { return nil }
+
+func (d *fakeDirectory) Bind(username, password string) error {
+	if username == serviceDN && password == "service-secret" {
+		d.bound = username
+		return nil
+	}
+	for _, user := range d.users {
+		if user.dn == username && user.password == password {
+			d.bound = username
+			return nil
+		}
+	}
+	d.bound = ""
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, nil)
+}
+
+func (d *fakeDirectory) Search(request *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	d.searches = append(d.searches, d.bound)
+	result := &ldap.SearchResult{}
+	for _, user := range d.users {
+		if strings.Contains(request.Filter, "(uid="+ldap.EscapeFilter(user.uid)+")") {
+			attributes := map[string][]string{"mail": {user.mail}}
+			if user.uuid != "" {
+				attributes["entryUUID"] = []string{user.uuid}
+			}
+			result.Entries = append(result.Entries, ldap.NewEntry(user.dn, attributes))
+		}
+	}
+	for _, group := range d.groups {
+		for _, member := range group.members {
+			if strings.Contains(request.Filter, "(member="+ldap.EscapeFilter(member)+")") {
+				result.Entries = append(result.Entries, ldap.NewEntry(group.dn, map[string][]string{"cn": {group.cn}}))
+			}
+		}
+	}
+	return result, nil
+}
+
+func (d *fakeDirectory) Close() error {
+	d.closed = true
+	return nil
+}
+
+func newTestAuthenticator(t *testing.T, config Config) (*Authenticator, *fakeDirectory) {
+	config.URL = "ldap://ldap.home:389"
+	config.BaseDN = "dc=home"
+	config.BindDN = serviceDN
+	config.BindPassword = "service-secret"
+	a, err := New(config)
+	require.NoError(t, err)
+
+	dir := &fakeDirectory{
+		users: []fakeUser{
+			{dn: "uid=alice,ou=people,dc=home", uid: "alice", mail: "alice@home.lan", password: "alice-pass",
+				uuid: "5f1c2b7e-0d4e-4a8c-9a51-0c3f6e2d1b90"},
+			{dn: "uid=bob,ou=people,dc=home", uid: "bob", mail: "bob@home.lan", password: "bob-pass"},
+		},
+		groups: []fakeGroup{
+			{dn: "cn=nas-admins,ou=groups,dc=home", cn: "nas-admins", members: []string{"uid=alice,ou=people,dc=home"}},
+			{dn: "cn=family,ou=groups,dc=home", cn: "family", members: []string{"uid=alice,ou=people,dc=home"}},
+		},
+	}
+	a.dial = func() (conn, error) { return dir, nil }
+	return a, dir
+}
+
+// TestAuthenticate проверяет вход, назначение роли по группам и отказ при неверном пароле
+func TestAuthenticate(t *testing.T) {
+	a, dir := newTestAuthenticator(t, Config{AdminGroups: []string{"nas-admins"}})
+	ctx := context.Background()
+
+	user, err := a.Authenticate(ctx, "alice", "alice-pass")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, "5f1c2b7e-0d4e-4a8c-9a51-0c3f6e2d1b90", user.ID, "Пользователь определяется по entryUUID")
+	assert.Equal(t, "alice", user.Username)
+	assert.Equal(t, "alice@home.lan", user.Email)
+	assert.ElementsMatch(t, []string{"nas-admins", "family"}, user.Groups)
+	assert.Equal(t, models.UserRoleAdmin, user.Role)
+	assert.Equal(t, []string{serviceDN, serviceDN}, dir.searches, "Поиск выполняется от имени служебной учетной записи")
+	assert.True(t, dir.closed)
+
+	user, err = a.Authenticate(ctx, "bob", "bob-pass")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, models.UserRoleUser, user.Role)
+	assert.Equal(t, "uid=bob,ou=people,dc=home", user.ID, "Без entryUUID пользователь определяется по DN")
+
+	user, err = a.Authenticate(ctx, "bob", "alice-pass")
+	require.NoError(t, err)
+	assert.Nil(t, user)
+
+	user, err = a.Authenticate(ctx, "carol", "carol-pass")
+	require.NoError(t, err)
+	assert.Nil(t, user)
+}
+
+// TestAuthenticateRejectsUnsafeInput проверяет, что пустой пароль и спецсимволы фильтра не дают войти
+func TestAuthenticateRejectsUnsafeInput(t *testing.T) {
+	a, dir := newTestAuthenticator(t, Config{})
+
+	// Привязка с пустым паролем анонимна и прошла бы для любого DN
+	user, err := a.Authenticate(context.Background(), "alice", "")
+	require.NoError(t, err)
+	assert.Nil(t, user)
+	assert.Empty(t, dir.searches)
+
+	user, err = a.Authenticate(context.Background(), "*", "alice-pass")
+	require.NoError(t, err)
+	assert.Nil(t, user)
+}
+
+// TestAuthenticateUserGroups проверяет, что при заданных UserGroups войти могут только их участники и администраторы
+func TestAuthenticateUserGroups(t *testing.T) {
+	a, _ := newTestAuthenticator(t, Config{UserGroups: []string{"cn=family,ou=groups,dc=home"}})
+
+	user, err := a.Authenticate(context.Background(), "alice", "alice-pass")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Empty(t, user.Role, "Без AdminGroups роль не назначается каталогом")
+
+	user, err = a.Authenticate(context.Background(), "bob", "bob-pass")
+	require.NoError(t, err)
+	assert.Nil(t, user)
+}
+
+// TestNewValidation проверяет проверку настроек
+func TestNewValidation(t *testing.T) {
+	_, err := New(Config{URL: "http://ldap.home", BaseDN: "dc=home"})
+	assert.Error(t, err)
+
+	_, err = New(Config{URL: "ldaps://ldap.home", BaseDN: "dc=home", StartTLS: true})
+	assert.Error(t, err)
+
+	_, err = New(Config{URL: "ldap://ldap.home"})
+	assert.Error(t, err)
+
+	_, err = New(Config{URL: "ldap://ldap.home", BaseDN: "dc=home", UserFilter: "(uid=alice)"})
+	assert.Error(t, err)
+
+	a, err := New(Config{URL: "ldap://ldap.home", BaseDN: "dc=home"})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultUserFilter, a.config.UserFilter)
+	assert.Equal(t, "dc=home", a.config.GroupBaseDN)
+}
@@ -0,0 +1,156 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message письмо пользователю
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer интерфейс отправки писем
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Config настройки отправки писем
+type Config struct {
+	SMTPHost     string // Если пуст, письма не отправляются, а сохраняются в File или в лог
+	SMTPPort     string
+	SMTPUser     string
+	SMTPPassword string
+	From         string
+	File         string // Файл, в который дописываются письма вместо отправки
+}
+
+// New возвращает отправителя писем по настройкам: SMTP, файл или лог
+func New(cfg Config) Mailer {
+	switch {
+	case cfg.SMTPHost != "":
+		return &SMTPMailer{
+			Addr:     net.JoinHostPort(cfg.SMTPHost, cfg.SMTPPort),
+			Host:     cfg.SMTPHost,
+			User:     cfg.SMTPUser,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}
+	case cfg.File != "":
+		return &FileMailer{Path: cfg.File, From: cfg.From}
+	default:
+		return LogMailer{}
+	}
+}
+
+// SMTPMailer отправляет письма через SMTP-сервер
+type SMTPMailer struct {
+	Addr     string // host:port
+	Host     string
+	User     string // Если пуст, авторизация не выполняется
+	Password string
+	From     string
+}
+
+// Send отправляет письмо. net/smtp не поддерживает контекст, поэтому отмена учитывается только до отправки.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := buildMessage(m.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.User != "" {
+		auth = smtp.PlainAuth("", m.User, m.Password, m.Host)
+	}
+	if err := smtp.SendMail(m.Addr, auth, m.From, []string{msg.To}, data); err != nil {
+		return fmt.Errorf("ошибка отправки письма через SMTP: %w", err)
+	}
+	return nil
+}
+
+// FileMailer дописывает письма в файл вместо отправки. Используется для разработки и тестов.
+type FileMailer struct {
+	Path string
+	From string
+
+	mu sync.Mutex
+}
+
+// Send дописывает письмо в файл
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	data, err := buildMessage(m.From, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(m.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия файла писем: %w", err)
+	}
+	defer file.Close()
+
+	// Письма разделяются пустой строкой, как в mbox
+	if _, err := file.Write(append(data, "\r\n"...)); err != nil {
+		return fmt.Errorf("ошибка записи письма: %w", err)
+	}
+	return nil
+}
+
+// LogMailer вместо отправки пишет письма в лог. Используется для разработки,
+// когда почтовый сервер не настроен.
+type LogMailer struct{}
+
+// Send записывает письмо в лог
+func (LogMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("письмо для %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// buildMessage формирует письмо в формате RFC 5322 с телом в UTF-8
+func buildMessage(from string, msg Message, at time.Time) ([]byte, error) {
+	// Перевод строки в заголовке позволил бы подставить свои заголовки
+	for _, header := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, fmt.Errorf("недопустимый перевод строки в заголовке письма")
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", at.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	// Строки base64 не длиннее 76 символов
+	encoded := base64.StdEncoding.EncodeToString([]byte(msg.Body))
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBuildMessage проверяет заголовки и кодирование письма
+func TestBuildMessage(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	body := strings.Repeat("Код подтверждения: abc. ", 10)
+
+	data, err := buildMessage("nas@example.com", Message{To: "a@example.com", Subject: "Сброс пароля", Body: body}, at)
+	require.NoError(t, err)
+
+	headers, encoded, ok := strings.Cut(string(data), "\r\n\r\n")
+	require.True(t, ok, "Заголовки должны отделяться пустой строкой")
+	assert.Contains(t, headers, "To: a@example.com\r\n")
+	assert.Contains(t, headers, "Subject: =?utf-8?q?")
+	assert.Contains(t, headers, "Date: Wed, 01 May 2024 10:00:00 +0000")
+
+	for _, line := range strings.Split(strings.TrimSpace(encoded), "\r\n") {
+		assert.LessOrEqual(t, len(line), 76)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(strings.TrimSpace(encoded), "\r\n", ""))
+	require.NoError(t, err)
+	assert.Equal(t, body, string(decoded))
+
+	_, err = buildMessage("nas@example.com", Message{To: "a@example.com\r\nBcc: b@example.com", Subject: "x"}, at)
+	assert.Error(t, err, "Перевод строки в заголовке должен отклоняться")
+}
+
+// TestFileMailer проверяет, что письма дописываются в файл
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.txt")
+	m := New(Config{File: path, From: "nas@example.com"})
+	require.IsType(t, &FileMailer{}, m)
+
+	require.NoError(t, m.Send(context.Background(), Message{To: "a@example.com", Subject: "Первое", Body: "1"}))
+	require.NoError(t, m.Send(context.Background(), Message{To: "b@example.com", Subject: "Второе", Body: "2"}))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "From: nas@example.com\r\n"))
+	assert.Contains(t, string(data), "To: b@example.com")
+}
+
+// TestNew проверяет выбор отправителя по настройкам
+func TestNew(t *testing.T) {
+	assert.IsType(t, LogMailer{}, New(Config{}))
+
+	smtpMailer, ok := New(Config{SMTPHost: "smtp.example.com", SMTPPort: "587"}).(*SMTPMailer)
+	require.True(t, ok)
+	assert.Equal(t, "smtp.example.com:587", smtpMailer.Addr)
+}
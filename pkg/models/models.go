@@ -6,20 +6,242 @@ import (
 
 // User структура для хранения данных о пользователе
 type User struct {
-	ID              int       `db:"id"`
-	UserName        string    `db:"user_name"`
-	PasswordHash    string    `db:"password_hash"`
-	Email           string    `db:"email"`
-	MinioBucketName string    `db:"minio_bucket_name"`
-	MinioAccessKey  string    `db:"minio_access_key"` // Зашифрованный ключ доступа к MinIO
-	MinioSecretKey  string    `db:"minio_secret_key"` // Зашифрованный секретный ключ доступа к MinIO
-	CreatedAt       time.Time `db:"created_at"`
-	UpdatedAt       time.Time `db:"updated_at"`
+	ID                int       `db:"id"`
+	UserName          string    `db:"user_name"`
+	PasswordHash      string    `db:"password_hash"`
+	Email             string    `db:"email"`
+	MinioBucketName   string    `db:"minio_bucket_name"`
+	MinioAccessKey    string    `db:"minio_access_key"`   // Зашифрованный ключ доступа к MinIO
+	MinioSecretKey    string    `db:"minio_secret_key"`   // Зашифрованный секретный ключ доступа к MinIO
+	ProvisioningState string    `db:"provisioning_state"` // Последний выполненный шаг создания хранилища
+	TokenVersion      int       `db:"token_version"`      // Увеличивается при смене пароля, отзывая выданные токены
+	EmailVerified     bool      `db:"email_verified"`     // Адрес почты подтвержден кодом из письма
+	IsAdmin           bool      `db:"is_admin"`           // Администратор может приглашать пользователей
+	QuotaBytes        int64     `db:"quota_bytes"`        // Квота бакета пользователя, 0 — только общая квота
+	CreatedAt         time.Time `db:"created_at"`
+	UpdatedAt         time.Time `db:"updated_at"`
 }
 
+// Состояния создания хранилища пользователя. Каждое означает последний успешно выполненный шаг.
+const (
+	ProvisioningUnverified = "unverified" // Адрес почты не подтвержден, хранилище не создается
+	ProvisioningPending    = "pending"    // Запись в БД создана, в MinIO еще ничего нет
+	ProvisioningMinioUser  = "minio_user" // Создан пользователь MinIO
+	ProvisioningPolicy     = "policy"     // Привязана политика с доступом к бакету
+	ProvisioningReady      = "ready"      // Бакет создан, пользователь готов к работе
+	ProvisioningRollback   = "rollback"   // Создание не удалось, созданное в MinIO нужно удалить
+)
+
 // MinioConfig structure of minio config
 type MinioConfig struct {
 	BucketName string
 	AccessKey  string
 	SecretKey  string
+	QuotaBytes int64 // Жесткая квота бакета, 0 — без отдельной квоты
+}
+
+// Change запись журнала изменений файлов пользователя
+type Change struct {
+	Seq       int64     `db:"seq"` // Монотонно растущий номер изменения
+	UserID    int       `db:"user_id"`
+	Type      string    `db:"change_type"` // created, deleted, moved
+	Key       string    `db:"object_key"`
+	OldKey    string    `db:"old_key"` // Прежний ключ для перемещений
+	Size      int64     `db:"size"`
+	ETag      string    `db:"etag"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Роли участников общего пространства
+const (
+	SpaceRoleViewer = "viewer" // Только чтение
+	SpaceRoleEditor = "editor" // Чтение и запись
+	SpaceRoleOwner  = "owner"  // Запись и управление доступом
+)
+
+// SharedSpace общее пространство (отдельный бакет), доступное нескольким пользователям
+type SharedSpace struct {
+	ID         int       `db:"id"`
+	Name       string    `db:"name"`
+	BucketName string    `db:"bucket_name"`
+	OwnerID    int       `db:"owner_id"`
+	CreatedAt  time.Time `db:"created_at"`
+	Role       string    `db:"-"` // Роль пользователя, для которого получен список пространств
+}
+
+// SpaceMember участник общего пространства
+type SpaceMember struct {
+	SpaceID   int       `db:"space_id"`
+	UserID    int       `db:"user_id"`
+	UserName  string    `db:"user_name"`
+	Role      string    `db:"role"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Group группа пользователей, которой можно выдавать доступ так же, как пользователю
+type Group struct {
+	ID        int       `db:"id"`
+	Name      string    `db:"name"`
+	OwnerID   int       `db:"owner_id"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// GroupMember участник группы
+type GroupMember struct {
+	GroupID   int       `db:"group_id"`
+	UserID    int       `db:"user_id"`
+	UserName  string    `db:"user_name"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// SpaceGroupGrant доступ группы к общему пространству
+type SpaceGroupGrant struct {
+	SpaceID   int       `db:"space_id"`
+	GroupID   int       `db:"group_id"`
+	GroupName string    `db:"group_name"`
+	Role      string    `db:"role"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Назначение одноразовых токенов, отправляемых пользователю по почте
+const (
+	TokenPurposeEmailChange   = "email_change"   // Подтверждение нового адреса почты
+	TokenPurposePasswordReset = "password_reset" // Сброс забытого пароля
+	TokenPurposeEmailVerify   = "email_verify"   // Подтверждение адреса при регистрации
+)
+
+// UserToken одноразовый токен пользователя. Сам токен не хранится, только его хеш.
+type UserToken struct {
+	TokenHash string    `db:"token_hash"`
+	UserID    int       `db:"user_id"`
+	Purpose   string    `db:"purpose"`
+	Email     string    `db:"email"` // Адрес, на который отправлен токен
+	ExpiresAt time.Time `db:"expires_at"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Invite приглашение для регистрации. Сам код не хранится, только его хеш.
+type Invite struct {
+	ID         int       `db:"id"`
+	CodeHash   string    `db:"code_hash"`
+	CreatedBy  int       `db:"created_by"`
+	MaxUses    int       `db:"max_uses"`
+	Uses       int       `db:"uses"`
+	QuotaBytes int64     `db:"quota_bytes"` // Квота, назначаемая зарегистрированным по приглашению, 0 — общая
+	ExpiresAt  time.Time `db:"expires_at"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+// TOTP второй фактор пользователя: секрет приложения-аутентификатора
+type TOTP struct {
+	UserID      int       `db:"user_id"`
+	Secret      string    `db:"secret"`
+	Enabled     bool      `db:"enabled"`      // Секрет подтвержден кодом и требуется при входе
+	LastCounter int64     `db:"last_counter"` // Шаг последнего принятого кода; коды не старше него не принимаются
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+// WebAuthnCredential ключ доступа (passkey) пользователя
+type WebAuthnCredential struct {
+	ID           int        `db:"id"`
+	UserID       int        `db:"user_id"`
+	Name         string     `db:"name"`          // Название, которое пользователь дал ключу
+	CredentialID []byte     `db:"credential_id"` // Идентификатор, присвоенный аутентификатором
+	PublicKey    []byte     `db:"public_key"`    // Открытый ключ в формате COSE_Key
+	SignCount    uint32     `db:"sign_count"`    // Последнее значение счетчика подписей аутентификатора
+	Transports   []string   `db:"transports"`    // Способы связи с аутентификатором: usb, nfc, ble, internal
+	CreatedAt    time.Time  `db:"created_at"`
+	LastUsedAt   *time.Time `db:"last_used_at"`
+}
+
+// Назначение вызовов WebAuthn
+const (
+	WebAuthnPurposeRegistration = "registration" // Добавление ключа доступа
+	WebAuthnPurposeLogin        = "login"        // Вход по ключу доступа
+)
+
+// WebAuthnChallenge начатая церемония WebAuthn. Сам вызов не хранится, только его хеш.
+type WebAuthnChallenge struct {
+	ChallengeHash string    `db:"challenge_hash"`
+	UserID        int       `db:"user_id"` // 0, если пользователь еще неизвестен (вход без имени)
+	Purpose       string    `db:"purpose"`
+	ExpiresAt     time.Time `db:"expires_at"`
+}
+
+// OIDCState начатый вход через поставщика OpenID Connect. Сам параметр state не хранится, только его хеш.
+type OIDCState struct {
+	StateHash    string    `db:"state_hash"`
+	CodeVerifier string    `db:"code_verifier"` // Секрет PKCE, которым подтверждается обмен кода
+	Nonce        string    `db:"nonce"`         // Значение, которое поставщик должен вернуть в ID токене
+	ExpiresAt    time.Time `db:"expires_at"`
+}
+
+// Роли пользователей NAS, назначаемые по группам внешнего каталога
+const (
+	UserRoleUser  = "user"
+	UserRoleAdmin = "admin"
+)
+
+// DirectoryUser пользователь внешнего каталога (LDAP), подтвердивший пароль
+type DirectoryUser struct {
+	ID       string // Неизменный идентификатор записи: entryUUID или, если его нет, DN
+	Username string
+	Email    string
+	Groups   []string // Названия групп каталога
+	Role     string   // Роль по группам; пустая, если каталог не управляет ролями
+}
+
+// LoginThrottle неудачные попытки входа подряд с одним именем пользователя или с одного IP-адреса
+type LoginThrottle struct {
+	Key           string    `db:"key"` // "user:<имя>" или "ip:<адрес>"
+	Failures      int       `db:"failures"`
+	LastFailureAt time.Time `db:"last_failure_at"`
+}
+
+// Причины неудачного входа
+const (
+	LoginFailurePassword = "password" // Неверное имя пользователя или пароль
+	LoginFailureMFA      = "mfa"      // Неверный код второго фактора
+)
+
+// LoginFailure запись журнала неудачных попыток входа
+type LoginFailure struct {
+	ID        int       `db:"id"`
+	Username  string    `db:"user_name"` // Имя, указанное при входе; такого пользователя может не быть
+	IP        string    `db:"ip"`
+	Reason    string    `db:"reason"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Права персональных токенов API
+const (
+	APITokenScopeRead  = "read"  // Чтение файлов и данных пользователя
+	APITokenScopeWrite = "write" // Изменение файлов; включает чтение
+	APITokenScopeAdmin = "admin" // Административные операции; только для администраторов
+)
+
+// APIToken персональный токен доступа к API для скриптов. Сам токен не хранится, только его хеш.
+type APIToken struct {
+	ID         int        `db:"id"`
+	UserID     int        `db:"user_id"`
+	Name       string     `db:"name"`
+	TokenHash  string     `db:"token_hash"`
+	Scopes     []string   `db:"scopes"`
+	ExpiresAt  *time.Time `db:"expires_at"` // nil — бессрочный
+	LastUsedAt *time.Time `db:"last_used_at"`
+	CreatedAt  time.Time  `db:"created_at"`
+}
+
+// Session сеанс входа пользователя на устройстве. Refresh токен сеанса хранится только в виде хеша
+// и заменяется при каждом обновлении, поэтому украденный старый токен уже не подходит.
+type Session struct {
+	ID           int       `db:"id"`
+	UserID       int       `db:"user_id"`
+	RefreshHash  string    `db:"refresh_hash"`
+	DeviceName   string    `db:"device_name"`
+	IP           string    `db:"ip"`
+	UserAgent    string    `db:"user_agent"`
+	CreatedAt    time.Time `db:"created_at"`
+	LastActiveAt time.Time `db:"last_active_at"` // Вход или последнее обновление токенов
+	ExpiresAt    time.Time `db:"expires_at"`
 }
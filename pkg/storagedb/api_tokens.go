@@ -0,0 +1,131 @@
+package storagedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/lib/pq"
+)
+
+// SQL запросы для персональных токенов API
+const (
+	insertAPITokenSQL = `
+        INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, created_at
+    `
+
+	selectAPITokensSQL = `
+        SELECT id, user_id, name, token_hash, scopes, expires_at, last_used_at, created_at
+        FROM api_tokens
+        WHERE user_id = $1
+        ORDER BY id
+    `
+
+	// Время последнего использования обновляется при проверке токена, истекший токен не находится
+	useAPITokenSQL = `
+        UPDATE api_tokens
+        SET last_used_at = (now() AT TIME ZONE 'UTC')
+        WHERE token_hash = $1 AND (expires_at IS NULL OR expires_at > (now() AT TIME ZONE 'UTC'))
+        RETURNING id, user_id, name, token_hash, scopes, expires_at, last_used_at, created_at
+    `
+
+	deleteAPITokenSQL = "DELETE FROM api_tokens WHERE id = $1 AND user_id = $2"
+)
+
+// CreateAPIToken сохраняет токен API и заполняет ID и CreatedAt
+func (s *StorageDB) CreateAPIToken(token *models.APIToken) error {
+	// Время в БД хранится в UTC без часового пояса
+	var expiresAt sql.NullTime
+	if token.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: token.ExpiresAt.UTC(), Valid: true}
+	}
+
+	err := s.db.QueryRow(insertAPITokenSQL,
+		token.UserID,
+		token.Name,
+		token.TokenHash,
+		pq.Array(token.Scopes),
+		expiresAt,
+	).Scan(&token.ID, &token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения токена API: %w", err)
+	}
+	return nil
+}
+
+// ListAPITokens возвращает токены API пользователя, включая истекшие
+func (s *StorageDB) ListAPITokens(userID int) ([]models.APIToken, error) {
+	rows, err := s.db.Query(selectAPITokensSQL, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения токенов API: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения токена API: %w", err)
+		}
+		tokens = append(tokens, *token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения токенов API: %w", err)
+	}
+	return tokens, nil
+}
+
+// UseAPIToken отмечает использование токена API и возвращает его. Возвращает nil, если токена нет или он истек.
+func (s *StorageDB) UseAPIToken(tokenHash string) (*models.APIToken, error) {
+	token, err := scanAPIToken(s.db.QueryRow(useAPITokenSQL, tokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка проверки токена API: %w", err)
+	}
+	return token, nil
+}
+
+// DeleteAPIToken удаляет токен API пользователя. Возвращает false, если такого токена у пользователя нет.
+func (s *StorageDB) DeleteAPIToken(userID, id int) (bool, error) {
+	result, err := s.db.Exec(deleteAPITokenSQL, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка удаления токена API: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("ошибка удаления токена API: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// scanAPIToken читает токен API из строки результата
+func scanAPIToken(row interface{ Scan(dest ...any) error }) (*models.APIToken, error) {
+	var token models.APIToken
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Name,
+		&token.TokenHash,
+		pq.Array(&token.Scopes),
+		&expiresAt,
+		&lastUsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		token.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		token.LastUsedAt = &lastUsedAt.Time
+	}
+	return &token, nil
+}
@@ -0,0 +1,124 @@
+package storagedb
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var apiTokenColumns = []string{"id", "user_id", "name", "token_hash", "scopes", "expires_at", "last_used_at", "created_at"}
+
+// TestCreateAPIToken проверяет сохранение токена API с правами и без срока действия
+func TestCreateAPIToken(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("INSERT INTO api_tokens").
+		WithArgs(1, "backup", "hash", "{\"read\",\"write\"}", sql.NullTime{}).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(3, now))
+
+	storage := &StorageDB{db: db}
+
+	token := &models.APIToken{
+		UserID:    1,
+		Name:      "backup",
+		TokenHash: "hash",
+		Scopes:    []string{models.APITokenScopeRead, models.APITokenScopeWrite},
+	}
+	require.NoError(t, storage.CreateAPIToken(token))
+	assert.Equal(t, 3, token.ID)
+	assert.Equal(t, now, token.CreatedAt)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestUseAPIToken проверяет проверку токена API и отказ для неизвестного или истекшего токена
+func TestUseAPIToken(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	expires := now.Add(time.Hour)
+	mock.ExpectQuery("UPDATE api_tokens SET last_used_at").
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows(apiTokenColumns).AddRow(3, 1, "backup", "hash", "{read}", expires, now, now))
+	mock.ExpectQuery("UPDATE api_tokens SET last_used_at").
+		WithArgs("unknown").
+		WillReturnRows(sqlmock.NewRows(apiTokenColumns))
+
+	storage := &StorageDB{db: db}
+
+	token, err := storage.UseAPIToken("hash")
+	require.NoError(t, err)
+	require.NotNil(t, token)
+	assert.Equal(t, 1, token.UserID)
+	assert.Equal(t, []string{models.APITokenScopeRead}, token.Scopes)
+	require.NotNil(t, token.ExpiresAt)
+	assert.Equal(t, expires, *token.ExpiresAt)
+	require.NotNil(t, token.LastUsedAt)
+
+	token, err = storage.UseAPIToken("unknown")
+	assert.NoError(t, err)
+	assert.Nil(t, token, "Для неизвестного или истекшего токена возвращается nil")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestListAPITokens проверяет получение токенов пользователя
+func TestListAPITokens(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT .* FROM api_tokens WHERE user_id").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(apiTokenColumns).
+			AddRow(3, 1, "backup", "hash1", "{read}", nil, nil, now).
+			AddRow(4, 1, "sync", "hash2", "{read,write}", now, now, now))
+
+	storage := &StorageDB{db: db}
+
+	tokens, err := storage.ListAPITokens(1)
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+	assert.Nil(t, tokens[0].ExpiresAt, "Бессрочный токен")
+	assert.Nil(t, tokens[0].LastUsedAt, "Токен еще не использовался")
+	assert.Equal(t, []string{"read", "write"}, tokens[1].Scopes)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestDeleteAPIToken проверяет, что удалить можно только свой токен
+func TestDeleteAPIToken(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("DELETE FROM api_tokens WHERE id = \\$1 AND user_id = \\$2").
+		WithArgs(3, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM api_tokens WHERE id = \\$1 AND user_id = \\$2").
+		WithArgs(3, 2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	storage := &StorageDB{db: db}
+
+	ok, err := storage.DeleteAPIToken(1, 3)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = storage.DeleteAPIToken(2, 3)
+	require.NoError(t, err)
+	assert.False(t, ok, "Чужой токен не удаляется")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
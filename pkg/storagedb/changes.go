@@ -0,0 +1,127 @@
+package storagedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+)
+
+// SQL запросы для журнала изменений
+const (
+	// Номер изменения берется из счетчика пользователя. Строка пользователя остается заблокированной
+	// до фиксации вставки, поэтому запись с меньшим номером не может появиться после записи с большим
+	// и клиент, прочитавший журнал до номера N, не пропустит изменений.
+	insertChangeSQL = `
+        WITH counter AS (
+            UPDATE users SET change_seq = change_seq + 1 WHERE id = $1 RETURNING change_seq
+        )
+        INSERT INTO change_journal (user_id, user_seq, change_type, object_key, old_key, size, etag)
+        SELECT $1, change_seq, $2, $3, $4, $5, $6 FROM counter
+        RETURNING user_seq, created_at
+    `
+
+	selectChangesSQL = `
+        SELECT user_seq, user_id, change_type, object_key, old_key, size, etag, created_at
+        FROM change_journal
+        WHERE user_id = $1 AND user_seq > $2
+        ORDER BY user_seq
+        LIMIT $3
+    `
+
+	// Перемещение учитывается и для старого имени: после него файла по этому имени нет
+	selectLatestChangeForKeySQL = `
+        SELECT user_seq, user_id, change_type, object_key, old_key, size, etag, created_at
+        FROM change_journal
+        WHERE user_id = $1 AND (object_key = $2 OR old_key = $2)
+        ORDER BY user_seq DESC
+        LIMIT 1
+    `
+
+	selectLatestChangeSeqSQL = `
+        SELECT COALESCE((SELECT change_seq FROM users WHERE id = $1), 0)
+    `
+)
+
+// AppendChange добавляет запись в журнал изменений и заполняет Seq и CreatedAt
+func (s *StorageDB) AppendChange(change *models.Change) error {
+	err := s.db.QueryRow(insertChangeSQL,
+		change.UserID,
+		change.Type,
+		change.Key,
+		change.OldKey,
+		change.Size,
+		change.ETag,
+	).Scan(&change.Seq, &change.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("ошибка записи в журнал изменений: %w", err)
+	}
+	return nil
+}
+
+// ListChanges возвращает изменения пользователя с номером больше since
+func (s *StorageDB) ListChanges(userID int, since int64, limit int) ([]models.Change, error) {
+	rows, err := s.db.Query(selectChangesSQL, userID, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения журнала изменений: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []models.Change
+	for rows.Next() {
+		var change models.Change
+		if err := scanChange(rows, &change); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения журнала изменений: %w", err)
+	}
+
+	return changes, nil
+}
+
+// GetLatestChange возвращает последнее изменение файла, включая перемещение с этого имени, или nil, если изменений не было
+func (s *StorageDB) GetLatestChange(userID int, key string) (*models.Change, error) {
+	var change models.Change
+	err := scanChange(s.db.QueryRow(selectLatestChangeForKeySQL, userID, key), &change)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// GetLatestChangeSeq возвращает номер последнего изменения пользователя (0, если журнал пуст)
+func (s *StorageDB) GetLatestChangeSeq(userID int) (int64, error) {
+	var seq int64
+	if err := s.db.QueryRow(selectLatestChangeSeqSQL, userID).Scan(&seq); err != nil {
+		return 0, fmt.Errorf("ошибка получения номера изменения: %w", err)
+	}
+	return seq, nil
+}
+
+// scanChange сканирует строку журнала; sql.ErrNoRows возвращается без обертки
+func scanChange(row interface{ Scan(dest ...any) error }, change *models.Change) error {
+	err := row.Scan(
+		&change.Seq,
+		&change.UserID,
+		&change.Type,
+		&change.Key,
+		&change.OldKey,
+		&change.Size,
+		&change.ETag,
+		&change.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка сканирования записи журнала: %w", err)
+	}
+	return nil
+}
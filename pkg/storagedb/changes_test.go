@@ -0,0 +1,83 @@
+package storagedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// changeColumns колонки записи журнала изменений
+var changeColumns = []string{"seq", "user_id", "change_type", "object_key", "old_key", "size", "etag", "created_at"}
+
+// TestAppendChange проверяет запись в журнал и получение номера изменения
+func TestAppendChange(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	// Номер берется из счетчика пользователя в том же запросе
+	mock.ExpectQuery("UPDATE users SET change_seq = change_seq \\+ 1 .* INSERT INTO change_journal").
+		WithArgs(1, "created", "a.txt", "", int64(5), "etag").
+		WillReturnRows(sqlmock.NewRows([]string{"seq", "created_at"}).AddRow(42, now))
+
+	storage := &StorageDB{db: db}
+	change := &models.Change{UserID: 1, Type: "created", Key: "a.txt", Size: 5, ETag: "etag"}
+
+	err = storage.AppendChange(change)
+
+	assert.NoError(t, err, "Запись в журнал должна пройти без ошибок")
+	assert.Equal(t, int64(42), change.Seq, "Номер изменения должен заполняться из БД")
+	assert.Equal(t, now, change.CreatedAt)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestListChanges проверяет чтение журнала после заданного номера
+func TestListChanges(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT .* FROM change_journal WHERE user_id = \\$1 AND user_seq > \\$2").
+		WithArgs(1, int64(10), 2).
+		WillReturnRows(sqlmock.NewRows(changeColumns).
+			AddRow(11, 1, "created", "a.txt", "", 5, "etag", now).
+			AddRow(12, 1, "moved", "b.txt", "a.txt", 5, "etag", now))
+
+	storage := &StorageDB{db: db}
+
+	changes, err := storage.ListChanges(1, 10, 2)
+
+	assert.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.Equal(t, int64(11), changes[0].Seq)
+	assert.Equal(t, "a.txt", changes[1].OldKey)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestGetLatestChangeNone проверяет, что для неизменявшегося файла возвращается nil без ошибки
+func TestGetLatestChangeNone(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT .* FROM change_journal WHERE user_id = \\$1 AND \\(object_key = \\$2 OR old_key = \\$2\\)").
+		WithArgs(1, "a.txt").
+		WillReturnRows(sqlmock.NewRows(changeColumns))
+
+	storage := &StorageDB{db: db}
+
+	change, err := storage.GetLatestChange(1, "a.txt")
+
+	assert.NoError(t, err)
+	assert.Nil(t, change, "Изменений не было")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
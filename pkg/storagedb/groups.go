@@ -0,0 +1,175 @@
+package storagedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+)
+
+// SQL запросы для групп пользователей
+const (
+	insertGroupSQL = `
+        INSERT INTO user_groups (name, owner_id)
+        VALUES ($1, $2)
+        RETURNING id, created_at
+    `
+
+	selectGroupByIDSQL = `
+        SELECT id, name, owner_id, created_at
+        FROM user_groups
+        WHERE id = $1
+    `
+
+	selectGroupByNameSQL = `
+        SELECT id, name, owner_id, created_at
+        FROM user_groups
+        WHERE name = $1
+    `
+
+	selectUserGroupsSQL = `
+        SELECT g.id, g.name, g.owner_id, g.created_at
+        FROM user_groups g
+        WHERE g.owner_id = $1
+           OR EXISTS (SELECT 1 FROM user_group_members m WHERE m.group_id = g.id AND m.user_id = $1)
+        ORDER BY g.name
+    `
+
+	deleteGroupSQL = "DELETE FROM user_groups WHERE id = $1"
+
+	insertGroupMemberSQL = `
+        INSERT INTO user_group_members (group_id, user_id)
+        VALUES ($1, $2)
+        ON CONFLICT (group_id, user_id) DO NOTHING
+    `
+
+	deleteGroupMemberSQL = "DELETE FROM user_group_members WHERE group_id = $1 AND user_id = $2"
+
+	selectGroupMembersSQL = `
+        SELECT m.group_id, m.user_id, u.user_name, m.created_at
+        FROM user_group_members m
+        JOIN users u ON u.id = m.user_id
+        WHERE m.group_id = $1
+        ORDER BY u.user_name
+    `
+)
+
+// CreateGroup создает группу и заполняет ID и CreatedAt.
+// Владелец добавляется в участники в той же транзакции, поэтому группы без владельца среди участников не бывает.
+func (s *StorageDB) CreateGroup(group *models.Group) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		err := tx.QueryRow(insertGroupSQL, group.Name, group.OwnerID).Scan(&group.ID, &group.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("ошибка создания группы: %w", err)
+		}
+		if _, err := tx.Exec(insertGroupMemberSQL, group.ID, group.OwnerID); err != nil {
+			return fmt.Errorf("ошибка добавления владельца в группу: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetGroup возвращает группу по ID или nil, если ее нет
+func (s *StorageDB) GetGroup(id int) (*models.Group, error) {
+	return scanGroup(s.db.QueryRow(selectGroupByIDSQL, id))
+}
+
+// GetGroupByName возвращает группу по имени или nil, если ее нет
+func (s *StorageDB) GetGroupByName(name string) (*models.Group, error) {
+	return scanGroup(s.db.QueryRow(selectGroupByNameSQL, name))
+}
+
+// ListUserGroups возвращает группы, которыми пользователь владеет или в которых состоит
+func (s *StorageDB) ListUserGroups(userID int) ([]models.Group, error) {
+	rows, err := s.db.Query(selectUserGroupsSQL, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения групп: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []models.Group
+	for rows.Next() {
+		var group models.Group
+		if err := rows.Scan(&group.ID, &group.Name, &group.OwnerID, &group.CreatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования группы: %w", err)
+		}
+		groups = append(groups, group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка получения групп: %w", err)
+	}
+
+	return groups, nil
+}
+
+// DeleteGroup удаляет группу вместе с участниками и выданным ей доступом
+func (s *StorageDB) DeleteGroup(id int) error {
+	result, err := s.db.Exec(deleteGroupSQL, id)
+	if err != nil {
+		return fmt.Errorf("ошибка удаления группы: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("ошибка определения количества удаленных строк: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("группа с ID %d не найдена", id)
+	}
+
+	return nil
+}
+
+// AddGroupMember добавляет пользователя в группу; повторное добавление не считается ошибкой
+func (s *StorageDB) AddGroupMember(groupID, userID int) error {
+	if _, err := s.db.Exec(insertGroupMemberSQL, groupID, userID); err != nil {
+		return fmt.Errorf("ошибка добавления участника группы: %w", err)
+	}
+	return nil
+}
+
+// RemoveGroupMember исключает пользователя из группы
+func (s *StorageDB) RemoveGroupMember(groupID, userID int) error {
+	if _, err := s.db.Exec(deleteGroupMemberSQL, groupID, userID); err != nil {
+		return fmt.Errorf("ошибка исключения участника группы: %w", err)
+	}
+	return nil
+}
+
+// ListGroupMembers возвращает участников группы
+func (s *StorageDB) ListGroupMembers(groupID int) ([]models.GroupMember, error) {
+	rows, err := s.db.Query(selectGroupMembersSQL, groupID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения участников группы: %w", err)
+	}
+	defer rows.Close()
+
+	var members []models.GroupMember
+	for rows.Next() {
+		var member models.GroupMember
+		if err := rows.Scan(&member.GroupID, &member.UserID, &member.UserName, &member.CreatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования участника группы: %w", err)
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка получения участников группы: %w", err)
+	}
+
+	return members, nil
+}
+
+// scanGroup сканирует группу; отсутствие строки не считается ошибкой
+func scanGroup(row *sql.Row) (*models.Group, error) {
+	group := &models.Group{}
+	err := row.Scan(&group.ID, &group.Name, &group.OwnerID, &group.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения группы: %w", err)
+	}
+	return group, nil
+}
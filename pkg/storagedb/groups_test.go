@@ -0,0 +1,83 @@
+package storagedb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCreateGroup проверяет создание группы
+func TestCreateGroup(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO user_groups").
+		WithArgs("family", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(5, now))
+	mock.ExpectExec("INSERT INTO user_group_members").
+		WithArgs(5, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	storage := &StorageDB{db: db}
+	group := &models.Group{Name: "family", OwnerID: 1}
+
+	err = storage.CreateGroup(group)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, group.ID, "ID группы должен заполняться из БД")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestCreateGroupRollback проверяет, что группа не остается без владельца среди участников
+func TestCreateGroupRollback(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO user_groups").
+		WithArgs("family", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(5, time.Now()))
+	mock.ExpectExec("INSERT INTO user_group_members").
+		WithArgs(5, 1).
+		WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	storage := &StorageDB{db: db}
+
+	err = storage.CreateGroup(&models.Group{Name: "family", OwnerID: 1})
+
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Группа должна создаваться вместе с владельцем или не создаваться вовсе")
+}
+
+// TestGetGroupByNameNotFound проверяет, что отсутствие группы не считается ошибкой
+func TestGetGroupByNameNotFound(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT .* FROM user_groups WHERE name").
+		WithArgs("nobody").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "owner_id", "created_at"}))
+
+	storage := &StorageDB{db: db}
+
+	group, err := storage.GetGroupByName("nobody")
+
+	assert.NoError(t, err)
+	assert.Nil(t, group)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
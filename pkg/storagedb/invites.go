@@ -0,0 +1,129 @@
+package storagedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+)
+
+// SQL запросы для приглашений
+const (
+	insertInviteSQL = `
+        INSERT INTO invites (code_hash, created_by, max_uses, quota_bytes, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING id, created_at
+    `
+
+	selectInvitesSQL = `
+        SELECT id, code_hash, COALESCE(created_by, 0), max_uses, uses, quota_bytes, expires_at, created_at
+        FROM invites
+        ORDER BY id
+    `
+
+	deleteInviteSQL = "DELETE FROM invites WHERE id = $1"
+
+	// Использование засчитывается одним запросом, поэтому одновременные регистрации не превысят лимит
+	redeemInviteSQL = `
+        UPDATE invites
+        SET uses = uses + 1
+        WHERE code_hash = $1 AND uses < max_uses AND expires_at > (now() AT TIME ZONE 'UTC')
+        RETURNING id, code_hash, COALESCE(created_by, 0), max_uses, uses, quota_bytes, expires_at, created_at
+    `
+
+	releaseInviteSQL = "UPDATE invites SET uses = uses - 1 WHERE id = $1 AND uses > 0"
+)
+
+// scanInvite читает приглашение из строки результата
+func scanInvite(row interface{ Scan(dest ...any) error }) (*models.Invite, error) {
+	invite := &models.Invite{}
+	err := row.Scan(
+		&invite.ID,
+		&invite.CodeHash,
+		&invite.CreatedBy,
+		&invite.MaxUses,
+		&invite.Uses,
+		&invite.QuotaBytes,
+		&invite.ExpiresAt,
+		&invite.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+// CreateInvite сохраняет приглашение и заполняет ID и CreatedAt
+func (s *StorageDB) CreateInvite(invite *models.Invite) error {
+	// Время в БД хранится в UTC без часового пояса
+	err := s.db.QueryRow(insertInviteSQL,
+		invite.CodeHash,
+		invite.CreatedBy,
+		invite.MaxUses,
+		invite.QuotaBytes,
+		invite.ExpiresAt.UTC(),
+	).Scan(&invite.ID, &invite.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("ошибка создания приглашения: %w", err)
+	}
+	return nil
+}
+
+// ListInvites возвращает все приглашения, включая истекшие и исчерпанные
+func (s *StorageDB) ListInvites() ([]models.Invite, error) {
+	rows, err := s.db.Query(selectInvitesSQL)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения приглашений: %w", err)
+	}
+	defer rows.Close()
+
+	var invites []models.Invite
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка сканирования приглашения: %w", err)
+		}
+		invites = append(invites, *invite)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка получения приглашений: %w", err)
+	}
+
+	return invites, nil
+}
+
+// DeleteInvite удаляет приглашение. Возвращает false, если приглашения нет.
+func (s *StorageDB) DeleteInvite(id int) (bool, error) {
+	result, err := s.db.Exec(deleteInviteSQL, id)
+	if err != nil {
+		return false, fmt.Errorf("ошибка удаления приглашения: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("ошибка удаления приглашения: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// RedeemInvite засчитывает использование приглашения и возвращает его.
+// Если приглашения нет, оно истекло или исчерпано, возвращает nil.
+func (s *StorageDB) RedeemInvite(codeHash string) (*models.Invite, error) {
+	invite, err := scanInvite(s.db.QueryRow(redeemInviteSQL, codeHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка использования приглашения: %w", err)
+	}
+	return invite, nil
+}
+
+// ReleaseInvite возвращает использование приглашения, если регистрация по нему не состоялась
+func (s *StorageDB) ReleaseInvite(id int) error {
+	if _, err := s.db.Exec(releaseInviteSQL, id); err != nil {
+		return fmt.Errorf("ошибка возврата использования приглашения: %w", err)
+	}
+	return nil
+}
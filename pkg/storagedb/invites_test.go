@@ -0,0 +1,97 @@
+package storagedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// inviteColumns колонки приглашения
+var inviteColumns = []string{"id", "code_hash", "created_by", "max_uses", "uses", "quota_bytes", "expires_at", "created_at"}
+
+// TestCreateInvite проверяет сохранение приглашения со сроком действия в UTC
+func TestCreateInvite(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	expires := now.Add(24 * time.Hour)
+	mock.ExpectQuery("INSERT INTO invites").
+		WithArgs("hash", 1, 3, int64(1<<30), expires.UTC()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(5, now))
+
+	storage := &StorageDB{db: db}
+	invite := &models.Invite{CodeHash: "hash", CreatedBy: 1, MaxUses: 3, QuotaBytes: 1 << 30, ExpiresAt: expires}
+
+	assert.NoError(t, storage.CreateInvite(invite))
+	assert.Equal(t, 5, invite.ID)
+	assert.Equal(t, now, invite.CreatedAt)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestRedeemInvite проверяет, что засчитывается только действующее приглашение
+func TestRedeemInvite(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("UPDATE invites SET uses = uses \\+ 1").
+		WithArgs("valid").
+		WillReturnRows(sqlmock.NewRows(inviteColumns).
+			AddRow(5, "valid", 1, 3, 1, int64(1<<30), now.Add(time.Hour), now))
+	mock.ExpectQuery("UPDATE invites SET uses = uses \\+ 1").
+		WithArgs("exhausted").
+		WillReturnRows(sqlmock.NewRows(inviteColumns))
+	mock.ExpectExec("UPDATE invites SET uses = uses - 1").
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	storage := &StorageDB{db: db}
+
+	invite, err := storage.RedeemInvite("valid")
+	require.NoError(t, err)
+	require.NotNil(t, invite)
+	assert.Equal(t, 1, invite.Uses)
+	assert.Equal(t, int64(1<<30), invite.QuotaBytes)
+
+	invite, err = storage.RedeemInvite("exhausted")
+	assert.NoError(t, err)
+	assert.Nil(t, invite, "Истекшее или исчерпанное приглашение недействительно")
+
+	assert.NoError(t, storage.ReleaseInvite(5))
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestDeleteInvite проверяет удаление приглашения
+func TestDeleteInvite(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("DELETE FROM invites WHERE id").
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM invites WHERE id").
+		WithArgs(6).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	storage := &StorageDB{db: db}
+
+	ok, err := storage.DeleteInvite(5)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = storage.DeleteInvite(6)
+	assert.NoError(t, err)
+	assert.False(t, ok, "Несуществующее приглашение не удаляется")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
@@ -0,0 +1,154 @@
+package storagedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+)
+
+// loginFailureRetention сколько хранятся записи журнала неудачных попыток входа
+const loginFailureRetention = "90 days"
+
+// SQL запросы для ограничения попыток входа
+const (
+	selectLoginThrottleSQL = "SELECT key, failures, last_failure_at FROM login_throttle WHERE key = $1"
+
+	// Давно забытые счетчики удаляются, иначе адреса сканеров копились бы в таблице.
+	// Счетчик текущей попытки не трогаем: его строку держит транзакция резервирования.
+	deleteStaleLoginThrottleSQL = "DELETE FROM login_throttle WHERE last_failure_at < $1 AND key <> $2"
+
+	// Строка с нулевым счетчиком нужна, чтобы параллельные попытки ждали блокировки одной строки
+	insertLoginThrottleSQL = `
+        INSERT INTO login_throttle (key, failures, last_failure_at)
+        VALUES ($1, 0, $2)
+        ON CONFLICT (key) DO NOTHING
+    `
+
+	selectLoginThrottleForUpdateSQL = "SELECT key, failures, last_failure_at FROM login_throttle WHERE key = $1 FOR UPDATE"
+
+	// Счетчик начинается заново, если прошлая неудача была раньше $3
+	reserveLoginAttemptSQL = `
+        UPDATE login_throttle
+        SET failures = CASE WHEN last_failure_at < $3 OR failures = 0 THEN 1 ELSE failures + 1 END,
+            last_failure_at = $2
+        WHERE key = $1
+    `
+
+	releaseLoginAttemptSQL = "UPDATE login_throttle SET failures = failures - 1 WHERE key = $1 AND failures > 0"
+
+	deleteLoginThrottleSQL = "DELETE FROM login_throttle WHERE key = $1"
+
+	// Записи старше loginFailureRetention удаляются при каждой вставке: журнал нужен для разбора недавних атак
+	insertLoginFailureSQL = `
+        WITH expired AS (
+            DELETE FROM login_failures WHERE created_at < (NOW() AT TIME ZONE 'UTC') - $4::interval
+        )
+        INSERT INTO login_failures (user_name, ip, reason)
+        VALUES ($1, $2, $3)
+    `
+
+	selectLoginFailuresSQL = `
+        SELECT id, user_name, ip, reason, created_at
+        FROM login_failures
+        ORDER BY id DESC
+        LIMIT $1
+    `
+)
+
+// GetLoginThrottle возвращает счетчик неудачных попыток входа или nil, если неудач не было
+func (s *StorageDB) GetLoginThrottle(key string) (*models.LoginThrottle, error) {
+	var throttle models.LoginThrottle
+	err := s.db.QueryRow(selectLoginThrottleSQL, key).Scan(&throttle.Key, &throttle.Failures, &throttle.LastFailureAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения счетчика попыток входа: %w", err)
+	}
+	return &throttle, nil
+}
+
+// ReserveLoginAttempt под блокировкой строки передает check счетчик до попытки и, если check не вернул ошибку,
+// учитывает попытку как неудачу. Если прошлая неудача была раньше resetBefore, счетчик начинается заново.
+func (s *StorageDB) ReserveLoginAttempt(key string, at, resetBefore time.Time, check func(*models.LoginThrottle) error) error {
+	// Время в БД хранится в UTC без часового пояса
+	at, resetBefore = at.UTC(), resetBefore.UTC()
+
+	if _, err := s.db.Exec(deleteStaleLoginThrottleSQL, resetBefore, key); err != nil {
+		return fmt.Errorf("ошибка очистки счетчиков попыток входа: %w", err)
+	}
+
+	return s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(insertLoginThrottleSQL, key, at); err != nil {
+			return fmt.Errorf("ошибка сохранения счетчика попыток входа: %w", err)
+		}
+
+		var throttle models.LoginThrottle
+		err := tx.QueryRow(selectLoginThrottleForUpdateSQL, key).Scan(&throttle.Key, &throttle.Failures, &throttle.LastFailureAt)
+		if err != nil {
+			return fmt.Errorf("ошибка получения счетчика попыток входа: %w", err)
+		}
+
+		var current *models.LoginThrottle
+		if throttle.Failures > 0 && !throttle.LastFailureAt.Before(resetBefore) {
+			current = &throttle
+		}
+		if err := check(current); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(reserveLoginAttemptSQL, key, at, resetBefore); err != nil {
+			return fmt.Errorf("ошибка сохранения счетчика попыток входа: %w", err)
+		}
+		return nil
+	})
+}
+
+// ReleaseLoginAttempt уменьшает счетчик неудачных попыток входа на одну попытку
+func (s *StorageDB) ReleaseLoginAttempt(key string) error {
+	if _, err := s.db.Exec(releaseLoginAttemptSQL, key); err != nil {
+		return fmt.Errorf("ошибка сохранения счетчика попыток входа: %w", err)
+	}
+	return nil
+}
+
+// ResetLoginThrottle сбрасывает счетчик неудачных попыток входа
+func (s *StorageDB) ResetLoginThrottle(key string) error {
+	if _, err := s.db.Exec(deleteLoginThrottleSQL, key); err != nil {
+		return fmt.Errorf("ошибка сброса счетчика попыток входа: %w", err)
+	}
+	return nil
+}
+
+// RecordLoginFailure записывает неудачную попытку входа в журнал
+func (s *StorageDB) RecordLoginFailure(failure *models.LoginFailure) error {
+	if _, err := s.db.Exec(insertLoginFailureSQL, failure.Username, failure.IP, failure.Reason, loginFailureRetention); err != nil {
+		return fmt.Errorf("ошибка записи попытки входа в журнал: %w", err)
+	}
+	return nil
+}
+
+// ListLoginFailures возвращает последние limit неудачных попыток входа, начиная с новых
+func (s *StorageDB) ListLoginFailures(limit int) ([]models.LoginFailure, error) {
+	rows, err := s.db.Query(selectLoginFailuresSQL, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения журнала попыток входа: %w", err)
+	}
+	defer rows.Close()
+
+	var failures []models.LoginFailure
+	for rows.Next() {
+		var failure models.LoginFailure
+		if err := rows.Scan(&failure.ID, &failure.Username, &failure.IP, &failure.Reason, &failure.CreatedAt); err != nil {
+			return nil, fmt.Errorf("ошибка чтения журнала попыток входа: %w", err)
+		}
+		failures = append(failures, failure)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения журнала попыток входа: %w", err)
+	}
+	return failures, nil
+}
@@ -0,0 +1,122 @@
+package storagedb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoginThrottle проверяет получение, резервирование, снятие и сброс счетчика неудачных попыток входа
+func TestLoginThrottle(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	resetBefore := now.Add(-time.Hour)
+	errBlocked := errors.New("вход задержан")
+
+	mock.ExpectQuery("SELECT key, failures, last_failure_at FROM login_throttle").
+		WithArgs("user:alice").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "failures", "last_failure_at"}))
+
+	// Попытка проходит проверку и учитывается
+	mock.ExpectExec("DELETE FROM login_throttle WHERE last_failure_at < \\$1 AND key <> \\$2").
+		WithArgs(resetBefore, "user:alice").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO login_throttle .* ON CONFLICT \\(key\\) DO NOTHING").
+		WithArgs("user:alice", now).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT key, failures, last_failure_at FROM login_throttle WHERE key = \\$1 FOR UPDATE").
+		WithArgs("user:alice").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "failures", "last_failure_at"}).AddRow("user:alice", 2, now))
+	mock.ExpectExec("UPDATE login_throttle SET failures = CASE").
+		WithArgs("user:alice", now, resetBefore).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Проверка отклоняет попытку: счетчик не меняется
+	mock.ExpectExec("DELETE FROM login_throttle WHERE last_failure_at").
+		WithArgs(resetBefore, "user:alice").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO login_throttle").
+		WithArgs("user:alice", now).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT key, failures, last_failure_at FROM login_throttle WHERE key = \\$1 FOR UPDATE").
+		WithArgs("user:alice").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "failures", "last_failure_at"}).AddRow("user:alice", 3, now))
+	mock.ExpectRollback()
+
+	mock.ExpectExec("UPDATE login_throttle SET failures = failures - 1").
+		WithArgs("user:alice").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM login_throttle WHERE key = \\$1").
+		WithArgs("user:alice").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	storage := &StorageDB{db: db}
+
+	throttle, err := storage.GetLoginThrottle("user:alice")
+	require.NoError(t, err)
+	assert.Nil(t, throttle, "Без неудач счетчика нет")
+
+	var seen *models.LoginThrottle
+	err = storage.ReserveLoginAttempt("user:alice", now, resetBefore, func(throttle *models.LoginThrottle) error {
+		seen = throttle
+		return nil
+	})
+	require.NoError(t, err)
+	require.NotNil(t, seen)
+	assert.Equal(t, 2, seen.Failures, "Проверка видит счетчик до попытки")
+
+	err = storage.ReserveLoginAttempt("user:alice", now, resetBefore, func(*models.LoginThrottle) error {
+		return errBlocked
+	})
+	assert.ErrorIs(t, err, errBlocked)
+
+	require.NoError(t, storage.ReleaseLoginAttempt("user:alice"))
+	require.NoError(t, storage.ResetLoginThrottle("user:alice"))
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestLoginFailures проверяет запись и чтение журнала неудачных попыток входа
+func TestLoginFailures(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectExec("INSERT INTO login_failures").
+		WithArgs("alice", "192.0.2.1", models.LoginFailurePassword, loginFailureRetention).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT id, user_name, ip, reason, created_at FROM login_failures ORDER BY id DESC").
+		WithArgs(100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_name", "ip", "reason", "created_at"}).
+			AddRow(2, "alice", "192.0.2.1", models.LoginFailureMFA, createdAt).
+			AddRow(1, "alice", "192.0.2.1", models.LoginFailurePassword, createdAt))
+
+	storage := &StorageDB{db: db}
+
+	require.NoError(t, storage.RecordLoginFailure(&models.LoginFailure{
+		Username: "alice",
+		IP:       "192.0.2.1",
+		Reason:   models.LoginFailurePassword,
+	}))
+
+	failures, err := storage.ListLoginFailures(100)
+	require.NoError(t, err)
+	require.Len(t, failures, 2)
+	assert.Equal(t, models.LoginFailureMFA, failures[0].Reason)
+	assert.Equal(t, "192.0.2.1", failures[1].IP)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
@@ -0,0 +1,194 @@
+package storagedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+)
+
+// SQL запросы для второго фактора
+const (
+	// Подтвержденный секрет не перезаписывается: сначала второй фактор нужно отключить
+	upsertTOTPSecretSQL = `
+        INSERT INTO user_totp (user_id, secret)
+        VALUES ($1, $2)
+        ON CONFLICT (user_id) DO UPDATE
+        SET secret = EXCLUDED.secret, last_counter = 0, created_at = (now() AT TIME ZONE 'UTC')
+        WHERE user_totp.enabled = false
+    `
+
+	selectTOTPSQL = `
+        SELECT user_id, secret, enabled, last_counter, created_at
+        FROM user_totp
+        WHERE user_id = $1
+    `
+
+	enableTOTPSQL = `
+        UPDATE user_totp
+        SET enabled = true, last_counter = $2
+        WHERE user_id = $1 AND enabled = false
+    `
+
+	// Шаг принимается, только если он новее последнего принятого, поэтому код нельзя использовать повторно
+	useTOTPCounterSQL = `
+        UPDATE user_totp
+        SET last_counter = $2
+        WHERE user_id = $1 AND enabled = true AND last_counter < $2
+    `
+
+	deleteTOTPSQL = "DELETE FROM user_totp WHERE user_id = $1"
+
+	deleteRecoveryCodesSQL = "DELETE FROM user_recovery_codes WHERE user_id = $1"
+
+	insertRecoveryCodeSQL = "INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)"
+
+	useRecoveryCodeSQL = `
+        UPDATE user_recovery_codes
+        SET used_at = (now() AT TIME ZONE 'UTC')
+        WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+    `
+)
+
+// inTx выполняет fn в транзакции и откатывает ее при ошибке
+func (s *StorageDB) inTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("ошибка начала транзакции: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			fmt.Printf("ошибка отката транзакции: %v\n", rbErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("ошибка подтверждения транзакции: %w", err)
+	}
+	return nil
+}
+
+// SaveTOTPSecret сохраняет новый, еще не подтвержденный секрет.
+// Возвращает false, если у пользователя уже включен второй фактор.
+func (s *StorageDB) SaveTOTPSecret(userID int, secret string) (bool, error) {
+	result, err := s.db.Exec(upsertTOTPSecretSQL, userID, secret)
+	if err != nil {
+		return false, fmt.Errorf("ошибка сохранения секрета TOTP: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("ошибка сохранения секрета TOTP: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// GetTOTP возвращает второй фактор пользователя или nil, если он не настраивался
+func (s *StorageDB) GetTOTP(userID int) (*models.TOTP, error) {
+	var totp models.TOTP
+	err := s.db.QueryRow(selectTOTPSQL, userID).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.Enabled,
+		&totp.LastCounter,
+		&totp.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения секрета TOTP: %w", err)
+	}
+	return &totp, nil
+}
+
+// EnableTOTP включает второй фактор после подтверждения кодом с шагом counter и заменяет коды восстановления.
+// Возвращает false, если секрета нет или второй фактор уже включен.
+func (s *StorageDB) EnableTOTP(userID int, counter int64, recoveryCodeHashes []string) (bool, error) {
+	var enabled bool
+	err := s.inTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(enableTOTPSQL, userID, counter)
+		if err != nil {
+			return fmt.Errorf("ошибка включения TOTP: %w", err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("ошибка включения TOTP: %w", err)
+		}
+		if rows == 0 {
+			return nil
+		}
+
+		enabled = true
+		return replaceRecoveryCodes(tx, userID, recoveryCodeHashes)
+	})
+	if err != nil {
+		return false, err
+	}
+	return enabled, nil
+}
+
+// UseTOTPCounter отмечает шаг принятого кода. Возвращает false, если код этого или более позднего шага
+// уже был принят, то есть код используется повторно.
+func (s *StorageDB) UseTOTPCounter(userID int, counter int64) (bool, error) {
+	result, err := s.db.Exec(useTOTPCounterSQL, userID, counter)
+	if err != nil {
+		return false, fmt.Errorf("ошибка сохранения шага TOTP: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("ошибка сохранения шага TOTP: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// DeleteTOTP отключает второй фактор и удаляет коды восстановления
+func (s *StorageDB) DeleteTOTP(userID int) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(deleteTOTPSQL, userID); err != nil {
+			return fmt.Errorf("ошибка отключения TOTP: %w", err)
+		}
+		if _, err := tx.Exec(deleteRecoveryCodesSQL, userID); err != nil {
+			return fmt.Errorf("ошибка удаления кодов восстановления: %w", err)
+		}
+		return nil
+	})
+}
+
+// ReplaceRecoveryCodes заменяет коды восстановления пользователя новыми
+func (s *StorageDB) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		return replaceRecoveryCodes(tx, userID, codeHashes)
+	})
+}
+
+// replaceRecoveryCodes удаляет прежние коды восстановления и сохраняет хеши новых
+func replaceRecoveryCodes(tx *sql.Tx, userID int, codeHashes []string) error {
+	if _, err := tx.Exec(deleteRecoveryCodesSQL, userID); err != nil {
+		return fmt.Errorf("ошибка удаления кодов восстановления: %w", err)
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(insertRecoveryCodeSQL, userID, hash); err != nil {
+			return fmt.Errorf("ошибка сохранения кода восстановления: %w", err)
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCode погашает код восстановления. Возвращает false, если кода нет или он уже использован.
+func (s *StorageDB) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	result, err := s.db.Exec(useRecoveryCodeSQL, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("ошибка использования кода восстановления: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("ошибка использования кода восстановления: %w", err)
+	}
+	return rows > 0, nil
+}
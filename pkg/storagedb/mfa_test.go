@@ -0,0 +1,133 @@
+package storagedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestGetTOTP проверяет получение секрета и отсутствие настроенного второго фактора
+func TestGetTOTP(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	columns := []string{"user_id", "secret", "enabled", "last_counter", "created_at"}
+	mock.ExpectQuery("SELECT .* FROM user_totp WHERE user_id").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(1, "SECRET", true, int64(42), now))
+	mock.ExpectQuery("SELECT .* FROM user_totp WHERE user_id").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	storage := &StorageDB{db: db}
+
+	totp, err := storage.GetTOTP(1)
+	require.NoError(t, err)
+	require.NotNil(t, totp)
+	assert.True(t, totp.Enabled)
+	assert.Equal(t, int64(42), totp.LastCounter)
+
+	totp, err = storage.GetTOTP(2)
+	assert.NoError(t, err)
+	assert.Nil(t, totp, "Без настроенного второго фактора возвращается nil")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestEnableTOTP проверяет, что включение и замена кодов восстановления выполняются в одной транзакции
+func TestEnableTOTP(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE user_totp SET enabled = true").
+		WithArgs(1, int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM user_recovery_codes").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO user_recovery_codes").
+		WithArgs(1, "hash1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO user_recovery_codes").
+		WithArgs(1, "hash2").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Уже включенный второй фактор не меняется
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE user_totp SET enabled = true").
+		WithArgs(1, int64(43)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	storage := &StorageDB{db: db}
+
+	ok, err := storage.EnableTOTP(1, 42, []string{"hash1", "hash2"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = storage.EnableTOTP(1, 43, []string{"hash3"})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestUseTOTPCounter проверяет запрет повторного использования кода
+func TestUseTOTPCounter(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE user_totp SET last_counter").
+		WithArgs(1, int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE user_totp SET last_counter").
+		WithArgs(1, int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	storage := &StorageDB{db: db}
+
+	ok, err := storage.UseTOTPCounter(1, 42)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = storage.UseTOTPCounter(1, 42)
+	assert.NoError(t, err)
+	assert.False(t, ok, "Повторный код отклоняется")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestUseRecoveryCode проверяет, что код восстановления действует один раз
+func TestUseRecoveryCode(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE user_recovery_codes SET used_at").
+		WithArgs(1, "hash").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE user_recovery_codes SET used_at").
+		WithArgs(1, "hash").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	storage := &StorageDB{db: db}
+
+	ok, err := storage.UseRecoveryCode(1, "hash")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = storage.UseRecoveryCode(1, "hash")
+	assert.NoError(t, err)
+	assert.False(t, ok, "Использованный код отклоняется")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
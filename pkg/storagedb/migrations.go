@@ -45,4 +45,368 @@ var migrations = []Migration{
 			return err
 		},
 	},
+	{
+		Version:     3,
+		Description: "Создание журнала изменений файлов для синхронизации",
+		Up: func(db *sql.DB) error {
+			query := `CREATE TABLE IF NOT EXISTS change_journal (
+                seq BIGSERIAL PRIMARY KEY,
+                user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                change_type VARCHAR(16) NOT NULL,
+                object_key TEXT NOT NULL,
+                old_key TEXT NOT NULL DEFAULT '',
+                size BIGINT NOT NULL DEFAULT 0,
+                etag VARCHAR(255) NOT NULL DEFAULT '',
+                created_at TIMESTAMP DEFAULT (now() AT TIME ZONE 'UTC')
+            );
+            CREATE INDEX IF NOT EXISTS change_journal_user_seq_idx ON change_journal (user_id, seq);
+            CREATE INDEX IF NOT EXISTS change_journal_user_key_idx ON change_journal (user_id, object_key, seq);`
+			_, err := db.Exec(query)
+			return err
+		},
+		Down: func(db *sql.DB) error {
+			_, err := db.Exec("DROP TABLE IF EXISTS change_journal;")
+			return err
+		},
+	},
+	{
+		Version:     4,
+		Description: "Создание общих пространств и списков доступа к ним",
+		Up: func(db *sql.DB) error {
+			query := `CREATE TABLE IF NOT EXISTS shared_spaces (
+                id SERIAL PRIMARY KEY,
+                name VARCHAR(63) UNIQUE NOT NULL,
+                bucket_name VARCHAR(63) UNIQUE NOT NULL,
+                owner_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                created_at TIMESTAMP DEFAULT (now() AT TIME ZONE 'UTC')
+            );
+            CREATE TABLE IF NOT EXISTS shared_space_members (
+                space_id INT NOT NULL REFERENCES shared_spaces(id) ON DELETE CASCADE,
+                user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                role VARCHAR(16) NOT NULL CHECK (role IN ('viewer', 'editor')),
+                created_at TIMESTAMP DEFAULT (now() AT TIME ZONE 'UTC'),
+                PRIMARY KEY (space_id, user_id)
+            );
+            CREATE INDEX IF NOT EXISTS shared_space_members_user_idx ON shared_space_members (user_id);`
+			_, err := db.Exec(query)
+			return err
+		},
+		Down: func(db *sql.DB) error {
+			_, err := db.Exec("DROP TABLE IF EXISTS shared_space_members; DROP TABLE IF EXISTS shared_spaces;")
+			return err
+		},
+	},
+	{
+		Version:     5,
+		Description: "Создание групп пользователей и доступа групп к общим пространствам",
+		Up: func(db *sql.DB) error {
+			query := `CREATE TABLE IF NOT EXISTS user_groups (
+                id SERIAL PRIMARY KEY,
+                name VARCHAR(63) UNIQUE NOT NULL,
+                owner_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                created_at TIMESTAMP DEFAULT (now() AT TIME ZONE 'UTC')
+            );
+            CREATE TABLE IF NOT EXISTS user_group_members (
+                group_id INT NOT NULL REFERENCES user_groups(id) ON DELETE CASCADE,
+                user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                created_at TIMESTAMP DEFAULT (now() AT TIME ZONE 'UTC'),
+                PRIMARY KEY (group_id, user_id)
+            );
+            CREATE INDEX IF NOT EXISTS user_group_members_user_idx ON user_group_members (user_id);
+            CREATE TABLE IF NOT EXISTS shared_space_group_grants (
+                space_id INT NOT NULL REFERENCES shared_spaces(id) ON DELETE CASCADE,
+                group_id INT NOT NULL REFERENCES user_groups(id) ON DELETE CASCADE,
+                role VARCHAR(16) NOT NULL CHECK (role IN ('viewer', 'editor')),
+                created_at TIMESTAMP DEFAULT (now() AT TIME ZONE 'UTC'),
+                PRIMARY KEY (space_id, group_id)
+            );
+            CREATE INDEX IF NOT EXISTS shared_space_group_grants_group_idx ON shared_space_group_grants (group_id);`
+			_, err := db.Exec(query)
+			return err
+		},
+		Down: func(db *sql.DB) error {
+			_, err := db.Exec("DROP TABLE IF EXISTS shared_space_group_grants; DROP TABLE IF EXISTS user_group_members; DROP TABLE IF EXISTS user_groups;")
+			return err
+		},
+	},
+	{
+		Version:     6,
+		Description: "Добавление состояния создания хранилища пользователя",
+		Up: func(db *sql.DB) error {
+			// Существующие пользователи уже созданы полностью, а новые начинают с pending
+			query := `ALTER TABLE users
+                ADD COLUMN IF NOT EXISTS provisioning_state VARCHAR(16) NOT NULL DEFAULT 'ready',
+                ADD COLUMN IF NOT EXISTS provisioning_updated_at TIMESTAMP NOT NULL DEFAULT (now() AT TIME ZONE 'UTC');
+            ALTER TABLE users ALTER COLUMN provisioning_state SET DEFAULT 'pending';
+            CREATE INDEX IF NOT EXISTS users_provisioning_idx ON users (provisioning_updated_at) WHERE provisioning_state <> 'ready';`
+			_, err := db.Exec(query)
+			return err
+		},
+		Down: func(db *sql.DB) error {
+			_, err := db.Exec("DROP INDEX IF EXISTS users_provisioning_idx; ALTER TABLE users DROP COLUMN IF EXISTS provisioning_updated_at, DROP COLUMN IF EXISTS provisioning_state;")
+			return err
+		},
+	},
+	{
+		Version:     7,
+		Description: "Создание одноразовых токенов пользователей",
+		Up: func(db *sql.DB) error {
+			query := `CREATE TABLE IF NOT EXISTS user_tokens (
+                token_hash CHAR(64) PRIMARY KEY,
+                user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                purpose VARCHAR(32) NOT NULL,
+                email VARCHAR(255) NOT NULL,
+                expires_at TIMESTAMP NOT NULL,
+                created_at TIMESTAMP DEFAULT (now() AT TIME ZONE 'UTC')
+            );
+            CREATE INDEX IF NOT EXISTS user_tokens_user_idx ON user_tokens (user_id, purpose);`
+			_, err := db.Exec(query)
+			return err
+		},
+		Down: func(db *sql.DB) error {
+			_, err := db.Exec("DROP TABLE IF EXISTS user_tokens;")
+			return err
+		},
+	},
+	{
+		Version:     8,
+		Description: "Добавление версии токенов для отзыва сессий при смене пароля",
+		Up: func(db *sql.DB) error {
+			_, err := db.Exec("ALTER TABLE users ADD COLUMN IF NOT EXISTS token_version INT NOT NULL DEFAULT 0;")
+			return err
+		},
+		Down: func(db *sql.DB) error {
+			_, err := db.Exec("ALTER TABLE users DROP COLUMN IF EXISTS token_version;")
+			return err
+		},
+	},
+	{
+		Version:     9,
+		Description: "Добавление подтверждения адреса почты",
+		Up: func(db *sql.DB) error {
+			// Адреса существующих пользователей считаются подтвержденными, новые создаются неподтвержденными
+			query := `ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT true;
+            ALTER TABLE users ALTER COLUMN email_verified SET DEFAULT false;`
+			_, err := db.Exec(query)
+			return err
+		},
+		Down: func(db *sql.DB) error {
+			_, err := db.Exec("ALTER TABLE users DROP COLUMN IF EXISTS email_verified;")
+			return err
+		},
+	},
+	{
+		Version:     10,
+		Description: "Добавление администраторов, квот пользователей и приглашений",
+		Up: func(db *sql.DB) error {
+			// Администратором существующей установки становится первый зарегистрированный пользователь
+			query := `ALTER TABLE users
+                ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT false,
+                ADD COLUMN IF NOT EXISTS quota_bytes BIGINT NOT NULL DEFAULT 0;
+            UPDATE users SET is_admin = true WHERE id = (SELECT min(id) FROM users);
+            CREATE TABLE IF NOT EXISTS invites (
+                id SERIAL PRIMARY KEY,
+                code_hash CHAR(64) NOT NULL UNIQUE,
+                created_by INT REFERENCES users(id) ON DELETE SET NULL,
+                max_uses INT NOT NULL,
+                uses INT NOT NULL DEFAULT 0,
+                quota_bytes BIGINT NOT NULL DEFAULT 0,
+                expires_at TIMESTAMP NOT NULL,
+                created_at TIMESTAMP DEFAULT (now() AT TIME ZONE 'UTC')
+            );`
+			_, err := db.Exec(query)
+			return err
+		},
+		Down: func(db *sql.DB) error {
+			_, err := db.Exec("DROP TABLE IF EXISTS invites; ALTER TABLE users DROP COLUMN IF EXISTS quota_bytes, DROP COLUMN IF EXISTS is_admin;")
+			return err
+		},
+	},
+	{
+		Version:     11,
+		Description: "Создание второго фактора TOTP и кодов восстановления",
+		Up: func(db *sql.DB) error {
+			query := `CREATE TABLE IF NOT EXISTS user_totp (
+                user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+                secret VARCHAR(64) NOT NULL,
+                enabled BOOLEAN NOT NULL DEFAULT false,
+                last_counter BIGINT NOT NULL DEFAULT 0,
+                created_at TIMESTAMP DEFAULT (now() AT TIME ZONE 'UTC')
+            );
+            CREATE TABLE IF NOT EXISTS user_recovery_codes (
+                user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                code_hash CHAR(64) NOT NULL,
+                used_at TIMESTAMP,
+                PRIMARY KEY (user_id, code_hash)
+            );`
+			_, err := db.Exec(query)
+			return err
+		},
+		Down: func(db *sql.DB) error {
+			_, err := db.Exec("DROP TABLE IF EXISTS user_recovery_codes; DROP TABLE IF EXISTS user_totp;")
+			return err
+		},
+	},
+	{
+		Version:     12,
+		Description: "Создание ключей доступа WebAuthn и вызовов для их проверки",
+		Up: func(db *sql.DB) error {
+			query := `CREATE TABLE IF NOT EXISTS webauthn_credentials (
+                id SERIAL PRIMARY KEY,
+                user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                name VARCHAR(100) NOT NULL,
+                credential_id BYTEA NOT NULL UNIQUE,
+                public_key BYTEA NOT NULL,
+                sign_count BIGINT NOT NULL DEFAULT 0,
+                transports TEXT[] NOT NULL DEFAULT '{}',
+                created_at TIMESTAMP DEFAULT (now() AT TIME ZONE 'UTC'),
+                last_used_at TIMESTAMP
+            );
+            CREATE INDEX IF NOT EXISTS webauthn_credentials_user_idx ON webauthn_credentials (user_id);
+            CREATE TABLE IF NOT EXISTS webauthn_challenges (
+                challenge_hash CHAR(64) PRIMARY KEY,
+                user_id INT REFERENCES users(id) ON DELETE CASCADE,
+                purpose VARCHAR(32) NOT NULL,
+                expires_at TIMESTAMP NOT NULL
+            );`
+			_, err := db.Exec(query)
+			return err
+		},
+		Down: func(db *sql.DB) error {
+			_, err := db.Exec("DROP TABLE IF EXISTS webauthn_challenges; DROP TABLE IF EXISTS webauthn_credentials;")
+			return err
+		},
+	},
+	{
+		Version:     13,
+		Description: "Создание связей с учетными записями поставщика OpenID Connect",
+		Up: func(db *sql.DB) error {
+			query := `CREATE TABLE IF NOT EXISTS user_identities (
+                issuer VARCHAR(255) NOT NULL,
+                subject VARCHAR(255) NOT NULL,
+                user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                created_at TIMESTAMP DEFAULT (now() AT TIME ZONE 'UTC'),
+                PRIMARY KEY (issuer, subject)
+            );
+            CREATE TABLE IF NOT EXISTS oidc_states (
+                state_hash CHAR(64) PRIMARY KEY,
+                code_verifier VARCHAR(128) NOT NULL,
+                nonce VARCHAR(64) NOT NULL,
+                expires_at TIMESTAMP NOT NULL
+            );`
+			_, err := db.Exec(query)
+			return err
+		},
+		Down: func(db *sql.DB) error {
+			_, err := db.Exec("DROP TABLE IF EXISTS oidc_states; DROP TABLE IF EXISTS user_identities;")
+			return err
+		},
+	},
+	{
+		Version:     14,
+		Description: "Создание счетчиков и журнала неудачных попыток входа",
+		Up: func(db *sql.DB) error {
+			query := `CREATE TABLE IF NOT EXISTS login_throttle (
+                key VARCHAR(300) PRIMARY KEY,
+                failures INT NOT NULL,
+                last_failure_at TIMESTAMP NOT NULL
+            );
+            CREATE TABLE IF NOT EXISTS login_failures (
+                id SERIAL PRIMARY KEY,
+                user_name VARCHAR(255) NOT NULL,
+                ip VARCHAR(64) NOT NULL,
+                reason VARCHAR(32) NOT NULL,
+                created_at TIMESTAMP DEFAULT (now() AT TIME ZONE 'UTC')
+            );
+            CREATE INDEX IF NOT EXISTS idx_login_failures_created_at ON login_failures(created_at);`
+			_, err := db.Exec(query)
+			return err
+		},
+		Down: func(db *sql.DB) error {
+			_, err := db.Exec("DROP TABLE IF EXISTS login_failures; DROP TABLE IF EXISTS login_throttle;")
+			return err
+		},
+	},
+	{
+		Version:     15,
+		Description: "Создание персональных токенов API",
+		Up: func(db *sql.DB) error {
+			query := `CREATE TABLE IF NOT EXISTS api_tokens (
+                id SERIAL PRIMARY KEY,
+                user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                name VARCHAR(100) NOT NULL,
+                token_hash CHAR(64) NOT NULL UNIQUE,
+                scopes TEXT[] NOT NULL,
+                expires_at TIMESTAMP,
+                last_used_at TIMESTAMP,
+                created_at TIMESTAMP DEFAULT (now() AT TIME ZONE 'UTC')
+            );
+            CREATE INDEX IF NOT EXISTS idx_api_tokens_user_id ON api_tokens(user_id);`
+			_, err := db.Exec(query)
+			return err
+		},
+		Down: func(db *sql.DB) error {
+			_, err := db.Exec("DROP TABLE IF EXISTS api_tokens;")
+			return err
+		},
+	},
+	{
+		Version:     16,
+		Description: "Создание сеансов пользователей",
+		Up: func(db *sql.DB) error {
+			query := `CREATE TABLE IF NOT EXISTS user_sessions (
+                id SERIAL PRIMARY KEY,
+                user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+                refresh_hash CHAR(64) NOT NULL,
+                device_name VARCHAR(100) NOT NULL,
+                ip VARCHAR(64) NOT NULL,
+                user_agent VARCHAR(512) NOT NULL,
+                created_at TIMESTAMP DEFAULT (now() AT TIME ZONE 'UTC'),
+                last_active_at TIMESTAMP DEFAULT (now() AT TIME ZONE 'UTC'),
+                expires_at TIMESTAMP NOT NULL
+            );
+            CREATE INDEX IF NOT EXISTS idx_user_sessions_user_id ON user_sessions(user_id);`
+			_, err := db.Exec(query)
+			return err
+		},
+		Down: func(db *sql.DB) error {
+			_, err := db.Exec("DROP TABLE IF EXISTS user_sessions;")
+			return err
+		},
+	},
+	{
+		Version:     17,
+		Description: "Номера изменений в журнале для каждого пользователя",
+		Up: func(db *sql.DB) error {
+			// Номер выдается под блокировкой строки пользователя, поэтому порядок номеров совпадает с порядком фиксации.
+			// Существующие записи сохраняют свои номера, чтобы курсоры клиентов остались действительными.
+			query := `ALTER TABLE users ADD COLUMN IF NOT EXISTS change_seq BIGINT NOT NULL DEFAULT 0;
+            ALTER TABLE change_journal ADD COLUMN IF NOT EXISTS user_seq BIGINT;
+            UPDATE change_journal SET user_seq = seq WHERE user_seq IS NULL;
+            ALTER TABLE change_journal ALTER COLUMN user_seq SET NOT NULL;
+            UPDATE users SET change_seq = journal.max_seq
+            FROM (SELECT user_id, MAX(user_seq) AS max_seq FROM change_journal GROUP BY user_id) journal
+            WHERE users.id = journal.user_id;
+            CREATE UNIQUE INDEX IF NOT EXISTS change_journal_user_user_seq_idx ON change_journal (user_id, user_seq);`
+			_, err := db.Exec(query)
+			return err
+		},
+		Down: func(db *sql.DB) error {
+			_, err := db.Exec(`ALTER TABLE change_journal DROP COLUMN IF EXISTS user_seq;
+            ALTER TABLE users DROP COLUMN IF EXISTS change_seq;`)
+			return err
+		},
+	},
+	{
+		Version:     18,
+		Description: "Счетчик неудачных попыток создания хранилища",
+		Up: func(db *sql.DB) error {
+			_, err := db.Exec("ALTER TABLE users ADD COLUMN IF NOT EXISTS provisioning_failures INT NOT NULL DEFAULT 0")
+			return err
+		},
+		Down: func(db *sql.DB) error {
+			_, err := db.Exec("ALTER TABLE users DROP COLUMN IF EXISTS provisioning_failures")
+			return err
+		},
+	},
 }
@@ -0,0 +1,97 @@
+package storagedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+)
+
+// SQL запросы для входа через OpenID Connect
+const (
+	selectUserIdentitySQL = "SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2"
+
+	// Учетная запись поставщика связывается только с одним пользователем; повторная связь не меняет владельца
+	insertUserIdentitySQL = `
+        INSERT INTO user_identities (issuer, subject, user_id)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (issuer, subject) DO NOTHING
+    `
+
+	// Заодно удаляются истекшие входы, которые так и не завершили
+	insertOIDCStateSQL = `
+        WITH expired AS (
+            DELETE FROM oidc_states WHERE expires_at < (now() AT TIME ZONE 'UTC')
+        )
+        INSERT INTO oidc_states (state_hash, code_verifier, nonce, expires_at)
+        VALUES ($1, $2, $3, $4)
+    `
+
+	// Состояние удаляется при первом же использовании, поэтому код поставщика нельзя обменять повторно
+	consumeOIDCStateSQL = `
+        DELETE FROM oidc_states
+        WHERE state_hash = $1
+        RETURNING state_hash, code_verifier, nonce, expires_at
+    `
+)
+
+// GetUserIDByIdentity возвращает ID пользователя, связанного с учетной записью поставщика, или 0, если связи нет
+func (s *StorageDB) GetUserIDByIdentity(issuer, subject string) (int, error) {
+	var userID int
+	err := s.db.QueryRow(selectUserIdentitySQL, issuer, subject).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения связи с поставщиком: %w", err)
+	}
+	return userID, nil
+}
+
+// LinkUserIdentity связывает учетную запись поставщика с пользователем
+func (s *StorageDB) LinkUserIdentity(issuer, subject string, userID int) error {
+	if _, err := s.db.Exec(insertUserIdentitySQL, issuer, subject, userID); err != nil {
+		return fmt.Errorf("ошибка сохранения связи с поставщиком: %w", err)
+	}
+	return nil
+}
+
+// CreateOIDCState сохраняет состояние начатого входа через поставщика
+func (s *StorageDB) CreateOIDCState(state *models.OIDCState) error {
+	// Время в БД хранится в UTC без часового пояса
+	_, err := s.db.Exec(insertOIDCStateSQL,
+		state.StateHash,
+		state.CodeVerifier,
+		state.Nonce,
+		state.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения состояния входа: %w", err)
+	}
+	return nil
+}
+
+// ConsumeOIDCState удаляет состояние входа и возвращает его, если оно существует и не истекло; иначе возвращает nil
+func (s *StorageDB) ConsumeOIDCState(stateHash string) (*models.OIDCState, error) {
+	var state models.OIDCState
+	err := s.db.QueryRow(consumeOIDCStateSQL, stateHash).Scan(
+		&state.StateHash,
+		&state.CodeVerifier,
+		&state.Nonce,
+		&state.ExpiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка использования состояния входа: %w", err)
+	}
+
+	// Истекшее состояние тоже удаляется, но не считается действительным
+	if !state.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	return &state, nil
+}
@@ -0,0 +1,90 @@
+package storagedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestUserIdentity проверяет поиск и создание связи с учетной записью поставщика
+func TestUserIdentity(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT user_id FROM user_identities").
+		WithArgs("https://idp.example.com", "sub-1").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectExec("INSERT INTO user_identities .* ON CONFLICT \\(issuer, subject\\) DO NOTHING").
+		WithArgs("https://idp.example.com", "sub-1", 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT user_id FROM user_identities").
+		WithArgs("https://idp.example.com", "sub-1").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(7))
+
+	storage := &StorageDB{db: db}
+
+	userID, err := storage.GetUserIDByIdentity("https://idp.example.com", "sub-1")
+	require.NoError(t, err)
+	assert.Zero(t, userID, "Без связи возвращается 0")
+
+	require.NoError(t, storage.LinkUserIdentity("https://idp.example.com", "sub-1", 7))
+
+	userID, err = storage.GetUserIDByIdentity("https://idp.example.com", "sub-1")
+	require.NoError(t, err)
+	assert.Equal(t, 7, userID)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestOIDCState проверяет одноразовое использование состояния входа и отклонение истекшего
+func TestOIDCState(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expires := time.Now().Add(10 * time.Minute)
+	mock.ExpectExec("DELETE FROM oidc_states WHERE expires_at .* INSERT INTO oidc_states").
+		WithArgs("hash", "verifier", "nonce", expires.UTC()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	columns := []string{"state_hash", "code_verifier", "nonce", "expires_at"}
+	mock.ExpectQuery("DELETE FROM oidc_states WHERE state_hash").
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("hash", "verifier", "nonce", expires))
+	mock.ExpectQuery("DELETE FROM oidc_states WHERE state_hash").
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectQuery("DELETE FROM oidc_states WHERE state_hash").
+		WithArgs("old").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("old", "verifier", "nonce", time.Now().Add(-time.Minute)))
+
+	storage := &StorageDB{db: db}
+
+	err = storage.CreateOIDCState(&models.OIDCState{
+		StateHash:    "hash",
+		CodeVerifier: "verifier",
+		Nonce:        "nonce",
+		ExpiresAt:    expires,
+	})
+	require.NoError(t, err)
+
+	state, err := storage.ConsumeOIDCState("hash")
+	require.NoError(t, err)
+	require.NotNil(t, state)
+	assert.Equal(t, "verifier", state.CodeVerifier)
+
+	state, err = storage.ConsumeOIDCState("hash")
+	assert.NoError(t, err)
+	assert.Nil(t, state, "Состояние используется только один раз")
+
+	state, err = storage.ConsumeOIDCState("old")
+	assert.NoError(t, err)
+	assert.Nil(t, state, "Истекшее состояние недействительно")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
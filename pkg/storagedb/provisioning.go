@@ -0,0 +1,132 @@
+package storagedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+)
+
+// SQL запросы для состояния создания хранилища пользователя
+const (
+	updateProvisioningStateSQL = `
+        UPDATE users
+        SET provisioning_state = $1, provisioning_updated_at = (now() AT TIME ZONE 'UTC')
+        WHERE id = $2 AND provisioning_state = $3
+    `
+
+	selectStaleProvisioningSQL = `
+        SELECT id, user_name, password_hash, email, minio_bucket_name,
+               minio_access_key, minio_secret_key, provisioning_state, token_version, email_verified,
+               is_admin, quota_bytes, created_at, updated_at
+        FROM users
+        WHERE provisioning_state NOT IN ('ready', 'unverified') AND provisioning_updated_at < $1
+        ORDER BY id
+    `
+
+	// Неудачная попытка откладывает следующую: реконсилер берет только не продвигавшиеся с staleBefore создания
+	addProvisioningFailureSQL = `
+        UPDATE users
+        SET provisioning_failures = provisioning_failures + 1, provisioning_updated_at = (now() AT TIME ZONE 'UTC')
+        WHERE id = $1
+        RETURNING provisioning_failures
+    `
+
+	// Подтверждение адреса разрешает создание хранилища
+	markEmailVerifiedSQL = `
+        UPDATE users
+        SET email_verified = true, provisioning_state = 'pending',
+            provisioning_updated_at = (now() AT TIME ZONE 'UTC'), updated_at = (now() AT TIME ZONE 'UTC')
+        WHERE id = $1 AND provisioning_state = 'unverified'
+    `
+
+	// У неподтвержденных пользователей нет ничего в MinIO, поэтому запись можно просто удалить
+	deleteUnverifiedUsersSQL = `
+        DELETE FROM users
+        WHERE provisioning_state = 'unverified' AND created_at < $1
+    `
+)
+
+// SetProvisioningState переводит создание хранилища пользователя из состояния from в to.
+// Возвращает false, если состояние уже изменил другой процесс.
+func (s *StorageDB) SetProvisioningState(userID int, from, to string) (bool, error) {
+	result, err := s.db.Exec(updateProvisioningStateSQL, to, userID, from)
+	if err != nil {
+		return false, fmt.Errorf("ошибка обновления состояния пользователя %d: %w", userID, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("ошибка обновления состояния пользователя %d: %w", userID, err)
+	}
+	return rows > 0, nil
+}
+
+// AddProvisioningFailure учитывает неудачную попытку создания хранилища и возвращает число неудач
+func (s *StorageDB) AddProvisioningFailure(userID int) (int, error) {
+	var failures int
+	err := s.db.QueryRow(addProvisioningFailureSQL, userID).Scan(&failures)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("ошибка учета неудачного создания пользователя %d: %w", userID, ErrUserNotFound)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("ошибка учета неудачного создания пользователя %d: %w", userID, err)
+	}
+	return failures, nil
+}
+
+// ListStaleProvisioning возвращает пользователей, создание которых не завершено и не продвигалось с staleBefore
+func (s *StorageDB) ListStaleProvisioning(staleBefore time.Time) ([]models.User, error) {
+	// Время в БД хранится в UTC без часового пояса
+	rows, err := s.db.Query(selectStaleProvisioningSQL, staleBefore.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения незавершенных пользователей: %w", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка получения незавершенных пользователей: %w", err)
+	}
+
+	return users, nil
+}
+
+// MarkEmailVerified отмечает адрес пользователя подтвержденным и переводит его в состояние pending,
+// чтобы можно было создать хранилище. Возвращает false, если пользователь не ожидал подтверждения.
+func (s *StorageDB) MarkEmailVerified(userID int) (bool, error) {
+	result, err := s.db.Exec(markEmailVerifiedSQL, userID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка подтверждения адреса пользователя %d: %w", userID, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("ошибка подтверждения адреса пользователя %d: %w", userID, err)
+	}
+	return rows > 0, nil
+}
+
+// DeleteUnverifiedUsers удаляет пользователей, не подтвердивших адрес с момента createdBefore,
+// освобождая их имена и адреса. Возвращает число удаленных.
+func (s *StorageDB) DeleteUnverifiedUsers(createdBefore time.Time) (int64, error) {
+	result, err := s.db.Exec(deleteUnverifiedUsersSQL, createdBefore.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("ошибка удаления неподтвержденных пользователей: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка удаления неподтвержденных пользователей: %w", err)
+	}
+	return rows, nil
+}
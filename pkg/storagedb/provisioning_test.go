@@ -0,0 +1,137 @@
+package storagedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSetProvisioningState проверяет переход состояния только из ожидаемого
+func TestSetProvisioningState(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE users SET provisioning_state").
+		WithArgs("minio_user", 1, "pending").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users SET provisioning_state").
+		WithArgs("minio_user", 1, "pending").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	storage := &StorageDB{db: db}
+
+	ok, err := storage.SetProvisioningState(1, "pending", "minio_user")
+	assert.NoError(t, err)
+	assert.True(t, ok, "Состояние должно измениться")
+
+	ok, err = storage.SetProvisioningState(1, "pending", "minio_user")
+	assert.NoError(t, err)
+	assert.False(t, ok, "Повторный переход из устаревшего состояния должен отклоняться")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestListStaleProvisioning проверяет получение прерванных созданий пользователей
+func TestListStaleProvisioning(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	staleBefore := now.Add(-time.Minute)
+	columns := []string{
+		"id", "user_name", "password_hash", "email", "minio_bucket_name",
+		"minio_access_key", "minio_secret_key", "provisioning_state", "token_version", "email_verified",
+		"is_admin", "quota_bytes", "created_at", "updated_at",
+	}
+	mock.ExpectQuery("SELECT .* FROM users WHERE provisioning_state NOT IN \\('ready', 'unverified'\\)").
+		WithArgs(staleBefore.UTC()).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(3, "carol", "hash", "c@example.com", "user-carol", "user-carol", "secret", "policy", 0, true, false, 0, now, now))
+
+	storage := &StorageDB{db: db}
+
+	users, err := storage.ListStaleProvisioning(staleBefore)
+
+	assert.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, "policy", users[0].ProvisioningState)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestAddProvisioningFailure проверяет учет неудачных попыток создания хранилища
+func TestAddProvisioningFailure(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("UPDATE users SET provisioning_failures = provisioning_failures \\+ 1").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"provisioning_failures"}).AddRow(2))
+	mock.ExpectQuery("UPDATE users SET provisioning_failures").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"provisioning_failures"}))
+
+	storage := &StorageDB{db: db}
+
+	failures, err := storage.AddProvisioningFailure(3)
+	require.NoError(t, err)
+	assert.Equal(t, 2, failures)
+
+	_, err = storage.AddProvisioningFailure(4)
+	assert.ErrorIs(t, err, ErrUserNotFound, "Удаленный пользователь не должен считаться неудачей")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestMarkEmailVerified проверяет, что подтверждение переводит в pending только ожидающих подтверждения
+func TestMarkEmailVerified(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE users SET email_verified = true, provisioning_state = 'pending'").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users SET email_verified = true").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	storage := &StorageDB{db: db}
+
+	ok, err := storage.MarkEmailVerified(1)
+	assert.NoError(t, err)
+	assert.True(t, ok, "Адрес должен быть подтвержден")
+
+	ok, err = storage.MarkEmailVerified(1)
+	assert.NoError(t, err)
+	assert.False(t, ok, "Повторное подтверждение не должно менять состояние")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestDeleteUnverifiedUsers проверяет удаление давно не подтвержденных регистраций
+func TestDeleteUnverifiedUsers(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	createdBefore := time.Now().Add(-time.Hour)
+	mock.ExpectExec("DELETE FROM users WHERE provisioning_state = 'unverified'").
+		WithArgs(createdBefore.UTC()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	storage := &StorageDB{db: db}
+
+	deleted, err := storage.DeleteUnverifiedUsers(createdBefore)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
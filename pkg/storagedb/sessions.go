@@ -0,0 +1,132 @@
+package storagedb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+)
+
+// SQL запросы для сеансов пользователей
+const (
+	// Заодно удаляются истекшие сеансы пользователя, иначе они копились бы в таблице
+	insertSessionSQL = `
+        WITH expired AS (
+            DELETE FROM user_sessions WHERE user_id = $1 AND expires_at < (now() AT TIME ZONE 'UTC')
+        )
+        INSERT INTO user_sessions (user_id, refresh_hash, device_name, ip, user_agent, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at, last_active_at
+    `
+
+	selectSessionsSQL = `
+        SELECT id, user_id, refresh_hash, device_name, ip, user_agent, created_at, last_active_at, expires_at
+        FROM user_sessions
+        WHERE user_id = $1 AND expires_at > (now() AT TIME ZONE 'UTC')
+        ORDER BY last_active_at DESC
+    `
+
+	// Токен заменяется, только если предъявлен текущий: старый refresh токен после обновления не подходит
+	rotateSessionSQL = `
+        UPDATE user_sessions
+        SET refresh_hash = $4, ip = $5, user_agent = $6, expires_at = $7, last_active_at = (now() AT TIME ZONE 'UTC')
+        WHERE id = $1 AND user_id = $2 AND refresh_hash = $3 AND expires_at > (now() AT TIME ZONE 'UTC')
+    `
+
+	selectSessionActiveSQL = `
+        SELECT EXISTS(
+            SELECT 1 FROM user_sessions
+            WHERE id = $1 AND user_id = $2 AND expires_at > (now() AT TIME ZONE 'UTC')
+        )
+    `
+
+	deleteSessionSQL = "DELETE FROM user_sessions WHERE id = $1 AND user_id = $2"
+)
+
+// CreateSession сохраняет сеанс и заполняет ID, CreatedAt и LastActiveAt
+func (s *StorageDB) CreateSession(session *models.Session) error {
+	// Время в БД хранится в UTC без часового пояса
+	err := s.db.QueryRow(insertSessionSQL,
+		session.UserID,
+		session.RefreshHash,
+		session.DeviceName,
+		session.IP,
+		session.UserAgent,
+		session.ExpiresAt.UTC(),
+	).Scan(&session.ID, &session.CreatedAt, &session.LastActiveAt)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения сеанса: %w", err)
+	}
+	return nil
+}
+
+// ListSessions возвращает действующие сеансы пользователя, начиная с последнего активного
+func (s *StorageDB) ListSessions(userID int) ([]models.Session, error) {
+	rows, err := s.db.Query(selectSessionsSQL, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения сеансов: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&session.RefreshHash,
+			&session.DeviceName,
+			&session.IP,
+			&session.UserAgent,
+			&session.CreatedAt,
+			&session.LastActiveAt,
+			&session.ExpiresAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения сеанса: %w", err)
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения сеансов: %w", err)
+	}
+	return sessions, nil
+}
+
+// RotateSession заменяет refresh токен сеанса и обновляет адрес и время активности.
+// Возвращает false, если сеанса нет, он истек или oldHash уже не текущий.
+func (s *StorageDB) RotateSession(id, userID int, oldHash, newHash, ip, userAgent string, expiresAt time.Time) (bool, error) {
+	result, err := s.db.Exec(rotateSessionSQL, id, userID, oldHash, newHash, ip, userAgent, expiresAt.UTC())
+	if err != nil {
+		return false, fmt.Errorf("ошибка обновления сеанса: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("ошибка обновления сеанса: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// SessionActive проверяет, что у пользователя есть действующий сеанс с таким ID
+func (s *StorageDB) SessionActive(userID, id int) (bool, error) {
+	var active bool
+	if err := s.db.QueryRow(selectSessionActiveSQL, id, userID).Scan(&active); err != nil {
+		return false, fmt.Errorf("ошибка проверки сеанса: %w", err)
+	}
+	return active, nil
+}
+
+// DeleteSession завершает сеанс пользователя. Возвращает false, если такого сеанса у пользователя нет.
+func (s *StorageDB) DeleteSession(userID, id int) (bool, error) {
+	result, err := s.db.Exec(deleteSessionSQL, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка завершения сеанса: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("ошибка завершения сеанса: %w", err)
+	}
+	return rows > 0, nil
+}
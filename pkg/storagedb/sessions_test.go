@@ -0,0 +1,144 @@
+package storagedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCreateSession проверяет сохранение сеанса с данными устройства
+func TestCreateSession(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	expires := now.Add(time.Hour)
+	mock.ExpectQuery("INSERT INTO user_sessions").
+		WithArgs(1, "hash", "Firefox, Linux", "192.0.2.1", "Mozilla/5.0", expires.UTC()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "last_active_at"}).AddRow(4, now, now))
+
+	storage := &StorageDB{db: db}
+
+	session := &models.Session{
+		UserID:      1,
+		RefreshHash: "hash",
+		DeviceName:  "Firefox, Linux",
+		IP:          "192.0.2.1",
+		UserAgent:   "Mozilla/5.0",
+		ExpiresAt:   expires,
+	}
+	require.NoError(t, storage.CreateSession(session))
+	assert.Equal(t, 4, session.ID)
+	assert.Equal(t, now, session.LastActiveAt)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestListSessions проверяет получение действующих сеансов пользователя
+func TestListSessions(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	columns := []string{"id", "user_id", "refresh_hash", "device_name", "ip", "user_agent", "created_at", "last_active_at", "expires_at"}
+	mock.ExpectQuery("SELECT .* FROM user_sessions WHERE user_id = \\$1 AND expires_at >").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(5, 1, "hash5", "Телефон", "192.0.2.2", "curl/8.0", now, now, now.Add(time.Hour)).
+			AddRow(4, 1, "hash4", "Firefox, Linux", "192.0.2.1", "Mozilla/5.0", now, now.Add(-time.Hour), now.Add(time.Hour)))
+
+	storage := &StorageDB{db: db}
+
+	sessions, err := storage.ListSessions(1)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, "Телефон", sessions[0].DeviceName)
+	assert.Equal(t, "192.0.2.1", sessions[1].IP)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestRotateSession проверяет, что refresh токен заменяется только при предъявлении текущего
+func TestRotateSession(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expires := time.Now().Add(time.Hour)
+	mock.ExpectExec("UPDATE user_sessions SET refresh_hash = \\$4").
+		WithArgs(4, 1, "old", "new", "192.0.2.1", "Mozilla/5.0", expires.UTC()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE user_sessions SET refresh_hash = \\$4").
+		WithArgs(4, 1, "old", "newer", "192.0.2.1", "Mozilla/5.0", expires.UTC()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	storage := &StorageDB{db: db}
+
+	ok, err := storage.RotateSession(4, 1, "old", "new", "192.0.2.1", "Mozilla/5.0", expires)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = storage.RotateSession(4, 1, "old", "newer", "192.0.2.1", "Mozilla/5.0", expires)
+	require.NoError(t, err)
+	assert.False(t, ok, "Уже замененный токен не принимается")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestSessionActive проверяет проверку действующего сеанса пользователя
+func TestSessionActive(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT EXISTS\\(\\s*SELECT 1 FROM user_sessions").
+		WithArgs(4, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT EXISTS\\(\\s*SELECT 1 FROM user_sessions").
+		WithArgs(4, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	storage := &StorageDB{db: db}
+
+	active, err := storage.SessionActive(1, 4)
+	require.NoError(t, err)
+	assert.True(t, active)
+
+	active, err = storage.SessionActive(2, 4)
+	require.NoError(t, err)
+	assert.False(t, active, "Чужой сеанс не считается действующим")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestDeleteSession проверяет, что завершить можно только свой сеанс
+func TestDeleteSession(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("DELETE FROM user_sessions WHERE id = \\$1 AND user_id = \\$2").
+		WithArgs(4, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM user_sessions WHERE id = \\$1 AND user_id = \\$2").
+		WithArgs(4, 2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	storage := &StorageDB{db: db}
+
+	ok, err := storage.DeleteSession(1, 4)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = storage.DeleteSession(2, 4)
+	require.NoError(t, err)
+	assert.False(t, ok, "Чужой сеанс не завершается")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
@@ -0,0 +1,342 @@
+package storagedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+)
+
+// SQL запросы для общих пространств
+const (
+	insertSharedSpaceSQL = `
+        INSERT INTO shared_spaces (name, bucket_name, owner_id)
+        VALUES ($1, $2, $3)
+        RETURNING id, created_at
+    `
+
+	selectSharedSpaceSQL = `
+        SELECT id, name, bucket_name, owner_id, created_at
+        FROM shared_spaces
+        WHERE id = $1
+    `
+
+	// Роль складывается из прямого доступа и доступа через группы; editor сильнее viewer
+	selectUserSharedSpacesSQL = `
+        SELECT s.id, s.name, s.bucket_name, s.owner_id, s.created_at,
+               CASE WHEN s.owner_id = $1 THEN 'owner'
+                    WHEN bool_or(a.role = 'editor') THEN 'editor'
+                    ELSE 'viewer' END
+        FROM shared_spaces s
+        LEFT JOIN (
+            SELECT space_id, role FROM shared_space_members WHERE user_id = $1
+            UNION ALL
+            SELECT g.space_id, g.role
+            FROM shared_space_group_grants g
+            JOIN user_group_members gm ON gm.group_id = g.group_id
+            WHERE gm.user_id = $1
+        ) a ON a.space_id = s.id
+        WHERE s.owner_id = $1 OR a.space_id IS NOT NULL
+        GROUP BY s.id
+        ORDER BY s.name
+    `
+
+	selectUserDirectSharedSpacesSQL = `
+        SELECT s.id, s.name, s.bucket_name, s.owner_id, s.created_at,
+               CASE WHEN s.owner_id = $1 THEN 'owner' ELSE m.role END
+        FROM shared_spaces s
+        LEFT JOIN shared_space_members m ON m.space_id = s.id AND m.user_id = $1
+        WHERE s.owner_id = $1 OR m.user_id = $1
+        ORDER BY s.name
+    `
+
+	selectGroupSharedSpacesSQL = `
+        SELECT s.id, s.name, s.bucket_name, s.owner_id, s.created_at, g.role
+        FROM shared_spaces s
+        JOIN shared_space_group_grants g ON g.space_id = s.id
+        WHERE g.group_id = $1
+        ORDER BY s.name
+    `
+
+	deleteSharedSpaceSQL = "DELETE FROM shared_spaces WHERE id = $1"
+
+	upsertSpaceMemberSQL = `
+        INSERT INTO shared_space_members (space_id, user_id, role)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (space_id, user_id) DO UPDATE SET role = EXCLUDED.role
+    `
+
+	deleteSpaceMemberSQL = "DELETE FROM shared_space_members WHERE space_id = $1 AND user_id = $2"
+
+	selectSpaceMembersSQL = `
+        SELECT m.space_id, m.user_id, u.user_name, m.role, m.created_at
+        FROM shared_space_members m
+        JOIN users u ON u.id = m.user_id
+        WHERE m.space_id = $1
+        ORDER BY u.user_name
+    `
+
+	// Все, у кого есть доступ: владелец, участники и участники групп с доступом
+	selectSpaceUserIDsSQL = `
+        SELECT owner_id FROM shared_spaces WHERE id = $1
+        UNION
+        SELECT user_id FROM shared_space_members WHERE space_id = $1
+        UNION
+        SELECT gm.user_id
+        FROM shared_space_group_grants g
+        JOIN user_group_members gm ON gm.group_id = g.group_id
+        WHERE g.space_id = $1
+    `
+
+	selectSpaceMemberRoleSQL = `
+        SELECT role FROM (
+            SELECT role FROM shared_space_members WHERE space_id = $1 AND user_id = $2
+            UNION ALL
+            SELECT g.role
+            FROM shared_space_group_grants g
+            JOIN user_group_members gm ON gm.group_id = g.group_id
+            WHERE g.space_id = $1 AND gm.user_id = $2
+        ) r
+        ORDER BY CASE role WHEN 'editor' THEN 0 ELSE 1 END
+        LIMIT 1
+    `
+
+	upsertSpaceGroupGrantSQL = `
+        INSERT INTO shared_space_group_grants (space_id, group_id, role)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (space_id, group_id) DO UPDATE SET role = EXCLUDED.role
+    `
+
+	deleteSpaceGroupGrantSQL = "DELETE FROM shared_space_group_grants WHERE space_id = $1 AND group_id = $2"
+
+	selectSpaceGroupGrantsSQL = `
+        SELECT g.space_id, g.group_id, ug.name, g.role, g.created_at
+        FROM shared_space_group_grants g
+        JOIN user_groups ug ON ug.id = g.group_id
+        WHERE g.space_id = $1
+        ORDER BY ug.name
+    `
+)
+
+// CreateSharedSpace создает общее пространство и заполняет ID и CreatedAt
+func (s *StorageDB) CreateSharedSpace(space *models.SharedSpace) error {
+	err := s.db.QueryRow(insertSharedSpaceSQL, space.Name, space.BucketName, space.OwnerID).
+		Scan(&space.ID, &space.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("ошибка создания общего пространства: %w", err)
+	}
+	return nil
+}
+
+// GetSharedSpace возвращает общее пространство по ID или nil, если его нет
+func (s *StorageDB) GetSharedSpace(id int) (*models.SharedSpace, error) {
+	space := &models.SharedSpace{}
+	err := s.db.QueryRow(selectSharedSpaceSQL, id).Scan(
+		&space.ID,
+		&space.Name,
+		&space.BucketName,
+		&space.OwnerID,
+		&space.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения общего пространства: %w", err)
+	}
+	return space, nil
+}
+
+// ListUserSharedSpaces возвращает пространства, которыми пользователь владеет или к которым
+// имеет доступ напрямую либо через группы, с итоговой ролью
+func (s *StorageDB) ListUserSharedSpaces(userID int) ([]models.SharedSpace, error) {
+	return s.querySharedSpaces(selectUserSharedSpacesSQL, userID)
+}
+
+// ListUserDirectSharedSpaces возвращает пространства, которыми пользователь владеет или
+// к которым ему выдан доступ напрямую, без учета групп
+func (s *StorageDB) ListUserDirectSharedSpaces(userID int) ([]models.SharedSpace, error) {
+	return s.querySharedSpaces(selectUserDirectSharedSpacesSQL, userID)
+}
+
+// ListGroupSharedSpaces возвращает пространства, доступ к которым выдан группе, с ролью группы
+func (s *StorageDB) ListGroupSharedSpaces(groupID int) ([]models.SharedSpace, error) {
+	return s.querySharedSpaces(selectGroupSharedSpacesSQL, groupID)
+}
+
+// querySharedSpaces выполняет запрос списка пространств, последняя колонка которого — роль
+func (s *StorageDB) querySharedSpaces(query string, args ...any) ([]models.SharedSpace, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения общих пространств: %w", err)
+	}
+	defer rows.Close()
+
+	var spaces []models.SharedSpace
+	for rows.Next() {
+		var space models.SharedSpace
+		if err := rows.Scan(
+			&space.ID,
+			&space.Name,
+			&space.BucketName,
+			&space.OwnerID,
+			&space.CreatedAt,
+			&space.Role,
+		); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования общего пространства: %w", err)
+		}
+		spaces = append(spaces, space)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка получения общих пространств: %w", err)
+	}
+
+	return spaces, nil
+}
+
+// DeleteSharedSpace удаляет общее пространство вместе со списком доступа
+func (s *StorageDB) DeleteSharedSpace(id int) error {
+	result, err := s.db.Exec(deleteSharedSpaceSQL, id)
+	if err != nil {
+		return fmt.Errorf("ошибка удаления общего пространства: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("ошибка определения количества удаленных строк: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("общее пространство с ID %d не найдено", id)
+	}
+
+	return nil
+}
+
+// SetSpaceMember выдает пользователю роль в общем пространстве или меняет существующую
+func (s *StorageDB) SetSpaceMember(spaceID, userID int, role string) error {
+	if _, err := s.db.Exec(upsertSpaceMemberSQL, spaceID, userID, role); err != nil {
+		return fmt.Errorf("ошибка выдачи доступа к общему пространству: %w", err)
+	}
+	return nil
+}
+
+// RemoveSpaceMember отзывает доступ пользователя к общему пространству
+func (s *StorageDB) RemoveSpaceMember(spaceID, userID int) error {
+	if _, err := s.db.Exec(deleteSpaceMemberSQL, spaceID, userID); err != nil {
+		return fmt.Errorf("ошибка отзыва доступа к общему пространству: %w", err)
+	}
+	return nil
+}
+
+// ListSpaceMembers возвращает участников общего пространства (без владельца)
+func (s *StorageDB) ListSpaceMembers(spaceID int) ([]models.SpaceMember, error) {
+	rows, err := s.db.Query(selectSpaceMembersSQL, spaceID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения участников общего пространства: %w", err)
+	}
+	defer rows.Close()
+
+	var members []models.SpaceMember
+	for rows.Next() {
+		var member models.SpaceMember
+		if err := rows.Scan(
+			&member.SpaceID,
+			&member.UserID,
+			&member.UserName,
+			&member.Role,
+			&member.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования участника общего пространства: %w", err)
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка получения участников общего пространства: %w", err)
+	}
+
+	return members, nil
+}
+
+// ListSpaceUserIDs возвращает ID всех пользователей с доступом к общему пространству, включая владельца
+// и участников групп
+func (s *StorageDB) ListSpaceUserIDs(spaceID int) ([]int, error) {
+	rows, err := s.db.Query(selectSpaceUserIDsSQL, spaceID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения пользователей общего пространства: %w", err)
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования пользователя общего пространства: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка получения пользователей общего пространства: %w", err)
+	}
+
+	return userIDs, nil
+}
+
+// GetSpaceMemberRole возвращает роль участника с учетом групп или пустую строку, если доступа нет
+func (s *StorageDB) GetSpaceMemberRole(spaceID, userID int) (string, error) {
+	var role string
+	err := s.db.QueryRow(selectSpaceMemberRoleSQL, spaceID, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("ошибка проверки доступа к общему пространству: %w", err)
+	}
+	return role, nil
+}
+
+// SetSpaceGroupGrant выдает группе роль в общем пространстве или меняет существующую
+func (s *StorageDB) SetSpaceGroupGrant(spaceID, groupID int, role string) error {
+	if _, err := s.db.Exec(upsertSpaceGroupGrantSQL, spaceID, groupID, role); err != nil {
+		return fmt.Errorf("ошибка выдачи доступа группе: %w", err)
+	}
+	return nil
+}
+
+// RemoveSpaceGroupGrant отзывает доступ группы к общему пространству
+func (s *StorageDB) RemoveSpaceGroupGrant(spaceID, groupID int) error {
+	if _, err := s.db.Exec(deleteSpaceGroupGrantSQL, spaceID, groupID); err != nil {
+		return fmt.Errorf("ошибка отзыва доступа группы: %w", err)
+	}
+	return nil
+}
+
+// ListSpaceGroupGrants возвращает группы, имеющие доступ к общему пространству
+func (s *StorageDB) ListSpaceGroupGrants(spaceID int) ([]models.SpaceGroupGrant, error) {
+	rows, err := s.db.Query(selectSpaceGroupGrantsSQL, spaceID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения групп общего пространства: %w", err)
+	}
+	defer rows.Close()
+
+	var grants []models.SpaceGroupGrant
+	for rows.Next() {
+		var grant models.SpaceGroupGrant
+		if err := rows.Scan(
+			&grant.SpaceID,
+			&grant.GroupID,
+			&grant.GroupName,
+			&grant.Role,
+			&grant.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("ошибка сканирования группы общего пространства: %w", err)
+		}
+		grants = append(grants, grant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка получения групп общего пространства: %w", err)
+	}
+
+	return grants, nil
+}
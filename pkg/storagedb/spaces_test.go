@@ -0,0 +1,75 @@
+package storagedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestListUserSharedSpaces проверяет получение пространств вместе с ролью пользователя с учетом групп
+func TestListUserSharedSpaces(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT .* FROM shared_spaces s LEFT JOIN .* user_group_members").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "bucket_name", "owner_id", "created_at", "role"}).
+			AddRow(1, "family", "shared-family", 1, now, "editor").
+			AddRow(2, "photos", "shared-photos", 2, now, "owner"))
+
+	storage := &StorageDB{db: db}
+
+	spaces, err := storage.ListUserSharedSpaces(2)
+
+	assert.NoError(t, err)
+	require.Len(t, spaces, 2)
+	assert.Equal(t, "editor", spaces[0].Role)
+	assert.Equal(t, "owner", spaces[1].Role)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestGetSpaceMemberRoleNone проверяет, что отсутствие доступа не считается ошибкой
+func TestGetSpaceMemberRoleNone(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT role FROM shared_space_members").
+		WithArgs(1, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}))
+
+	storage := &StorageDB{db: db}
+
+	role, err := storage.GetSpaceMemberRole(1, 3)
+
+	assert.NoError(t, err)
+	assert.Empty(t, role, "У пользователя нет доступа")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestListSpaceUserIDs проверяет получение всех пользователей с доступом к общему пространству
+func TestListSpaceUserIDs(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT owner_id FROM shared_spaces WHERE id = \\$1\\s+UNION").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id"}).AddRow(1).AddRow(2).AddRow(5))
+
+	storage := &StorageDB{db: db}
+
+	userIDs, err := storage.ListSpaceUserIDs(7)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 5}, userIDs)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
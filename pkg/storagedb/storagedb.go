@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com.Vova4o/nasforhome/pkg/models"
 	_ "github.com/lib/pq" // Драйвер PostgreSQL
@@ -21,15 +22,119 @@ type StoragerDB interface {
 
 	// Операции с пользователями
 	CreateUser(username, passwordHash, email string, config *models.MinioConfig) (int, error)
+	CreateFirstUser(username, passwordHash, email string, config *models.MinioConfig) (int, error)
 	GetUserByUsername(username string) (*models.User, error)
 	GetUserByID(id int) (*models.User, error)
+	GetUserByEmail(email string) (*models.User, error)
+	ListUsers() ([]models.User, error)
 	UpdateUser(user *models.User) error
+	UpdateProfile(user *models.User, token *models.UserToken, deliver func() error) error
+	UpdatePassword(userID int, passwordHash string) error
+	SetUserAdmin(userID int, isAdmin bool) error
 	DeleteUser(id int) error
+	CountUsers() (int, error)
+
+	// Состояние создания хранилища пользователя
+	SetProvisioningState(userID int, from, to string) (bool, error)
+	ListStaleProvisioning(staleBefore time.Time) ([]models.User, error)
+	AddProvisioningFailure(userID int) (int, error)
+	MarkEmailVerified(userID int) (bool, error)
+	DeleteUnverifiedUsers(createdBefore time.Time) (int64, error)
+
+	// Приглашения
+	CreateInvite(invite *models.Invite) error
+	ListInvites() ([]models.Invite, error)
+	DeleteInvite(id int) (bool, error)
+	RedeemInvite(codeHash string) (*models.Invite, error)
+	ReleaseInvite(id int) error
+
+	// Второй фактор и коды восстановления
+	SaveTOTPSecret(userID int, secret string) (bool, error)
+	GetTOTP(userID int) (*models.TOTP, error)
+	EnableTOTP(userID int, counter int64, recoveryCodeHashes []string) (bool, error)
+	UseTOTPCounter(userID int, counter int64) (bool, error)
+	DeleteTOTP(userID int) error
+	ReplaceRecoveryCodes(userID int, codeHashes []string) error
+	UseRecoveryCode(userID int, codeHash string) (bool, error)
+
+	// Ключи доступа WebAuthn
+	CreateWebAuthnCredential(cred *models.WebAuthnCredential) error
+	ListWebAuthnCredentials(userID int) ([]models.WebAuthnCredential, error)
+	GetWebAuthnCredential(credentialID []byte) (*models.WebAuthnCredential, error)
+	UpdateWebAuthnSignCount(id int, signCount uint32) (bool, error)
+	DeleteWebAuthnCredential(userID, id int) (bool, error)
+	CreateWebAuthnChallenge(challenge *models.WebAuthnChallenge) error
+	ConsumeWebAuthnChallenge(challengeHash, purpose string) (*models.WebAuthnChallenge, error)
+
+	// Вход через OpenID Connect
+	GetUserIDByIdentity(issuer, subject string) (int, error)
+	LinkUserIdentity(issuer, subject string, userID int) error
+	CreateOIDCState(state *models.OIDCState) error
+	ConsumeOIDCState(stateHash string) (*models.OIDCState, error)
+
+	// Ограничение и журнал неудачных попыток входа
+	GetLoginThrottle(key string) (*models.LoginThrottle, error)
+	ReserveLoginAttempt(key string, at, resetBefore time.Time, check func(*models.LoginThrottle) error) error
+	ReleaseLoginAttempt(key string) error
+	ResetLoginThrottle(key string) error
+	RecordLoginFailure(failure *models.LoginFailure) error
+	ListLoginFailures(limit int) ([]models.LoginFailure, error)
+
+	// Персональные токены API
+	CreateAPIToken(token *models.APIToken) error
+	ListAPITokens(userID int) ([]models.APIToken, error)
+	UseAPIToken(tokenHash string) (*models.APIToken, error)
+	DeleteAPIToken(userID, id int) (bool, error)
+
+	// Сеансы пользователей
+	CreateSession(session *models.Session) error
+	ListSessions(userID int) ([]models.Session, error)
+	RotateSession(id, userID int, oldHash, newHash, ip, userAgent string, expiresAt time.Time) (bool, error)
+	SessionActive(userID, id int) (bool, error)
+	DeleteSession(userID, id int) (bool, error)
+
+	// Одноразовые токены, отправляемые по почте
+	CreateUserToken(token *models.UserToken) error
+	ConsumeUserToken(tokenHash, purpose string) (*models.UserToken, error)
+	ConfirmEmailChange(tokenHash string) (*models.UserToken, error)
+	DeleteUserTokens(userID int, purpose string) error
 
 	// Операции с MinIO для пользователя
 	CreateMinIOUser(userID int, bucketName, accessKey, secretKey string) error
 	GetMinIOCredentials(userID int) (string, string, string, error)
 
+	// Журнал изменений для синхронизации
+	AppendChange(change *models.Change) error
+	ListChanges(userID int, since int64, limit int) ([]models.Change, error)
+	GetLatestChange(userID int, key string) (*models.Change, error)
+	GetLatestChangeSeq(userID int) (int64, error)
+
+	// Общие пространства и доступ к ним
+	CreateSharedSpace(space *models.SharedSpace) error
+	GetSharedSpace(id int) (*models.SharedSpace, error)
+	ListUserSharedSpaces(userID int) ([]models.SharedSpace, error)
+	ListUserDirectSharedSpaces(userID int) ([]models.SharedSpace, error)
+	ListGroupSharedSpaces(groupID int) ([]models.SharedSpace, error)
+	DeleteSharedSpace(id int) error
+	SetSpaceMember(spaceID, userID int, role string) error
+	RemoveSpaceMember(spaceID, userID int) error
+	ListSpaceMembers(spaceID int) ([]models.SpaceMember, error)
+	ListSpaceUserIDs(spaceID int) ([]int, error)
+	GetSpaceMemberRole(spaceID, userID int) (string, error)
+	SetSpaceGroupGrant(spaceID, groupID int, role string) error
+	RemoveSpaceGroupGrant(spaceID, groupID int) error
+	ListSpaceGroupGrants(spaceID int) ([]models.SpaceGroupGrant, error)
+
+	// Группы пользователей
+	CreateGroup(group *models.Group) error
+	GetGroup(id int) (*models.Group, error)
+	GetGroupByName(name string) (*models.Group, error)
+	ListUserGroups(userID int) ([]models.Group, error)
+	DeleteGroup(id int) error
+	AddGroupMember(groupID, userID int) error
+	RemoveGroupMember(groupID, userID int) error
+	ListGroupMembers(groupID int) ([]models.GroupMember, error)
+
 	// Управление миграциями
 	InitDB() error
 	GetCurrentDBVersion() (int, error)
@@ -40,14 +145,16 @@ type StoragerDB interface {
 const (
 	selectUserByIDSQL = `
         SELECT id, user_name, password_hash, email, minio_bucket_name, 
-               minio_access_key, minio_secret_key, created_at, updated_at
+               minio_access_key, minio_secret_key, provisioning_state, token_version, email_verified,
+               is_admin, quota_bytes, created_at, updated_at
         FROM users
         WHERE id = $1
     `
 
 	selectUserByUsernameSQL = `
         SELECT id, user_name, password_hash, email, minio_bucket_name, 
-               minio_access_key, minio_secret_key, created_at, updated_at
+               minio_access_key, minio_secret_key, provisioning_state, token_version, email_verified,
+               is_admin, quota_bytes, created_at, updated_at
         FROM users
         WHERE user_name = $1
     `
@@ -55,18 +162,62 @@ const (
 	updateUserSQL = `
         UPDATE users
         SET user_name = $1, email = $2, minio_bucket_name = $3, 
-            minio_access_key = $4, minio_secret_key = $5
+            minio_access_key = $4, minio_secret_key = $5, updated_at = (now() AT TIME ZONE 'UTC')
         WHERE id = $6
     `
 
+	selectUserByEmailSQL = `
+        SELECT id, user_name, password_hash, email, minio_bucket_name, 
+               minio_access_key, minio_secret_key, provisioning_state, token_version, email_verified,
+               is_admin, quota_bytes, created_at, updated_at
+        FROM users
+        WHERE lower(email) = lower($1)
+    `
+
+	// Смена пароля отзывает все выданные токены и завершает сеансы пользователя
+	updatePasswordSQL = `
+        WITH ended AS (
+            DELETE FROM user_sessions WHERE user_id = $2
+        ), revoked AS (
+            DELETE FROM api_tokens WHERE user_id = $2
+        )
+        UPDATE users
+        SET password_hash = $1, token_version = token_version + 1, updated_at = (now() AT TIME ZONE 'UTC')
+        WHERE id = $2
+    `
+
+	updateUserAdminSQL = `
+        UPDATE users
+        SET is_admin = $1, updated_at = (now() AT TIME ZONE 'UTC')
+        WHERE id = $2
+    `
+
+	selectUsersSQL = `
+        SELECT id, user_name, password_hash, email, minio_bucket_name, 
+               minio_access_key, minio_secret_key, provisioning_state, token_version, email_verified,
+               is_admin, quota_bytes, created_at, updated_at
+        FROM users
+        ORDER BY id
+    `
+
 	deleteUserSQL = "DELETE FROM users WHERE id = $1"
 
+	// Пользователи создаются под рекомендательной блокировкой: иначе две одновременные первые
+	// регистрации обе увидели бы пустую таблицу и стали администраторами
+	lockCreateUserSQL = "SELECT pg_advisory_xact_lock($1)"
+
+	hasUsersSQL = "SELECT EXISTS (SELECT 1 FROM users)"
+
+	// Администратором становится только пользователь, созданный через CreateFirstUser
 	createUserSQL = `
-        INSERT INTO users (user_name, password_hash, email, minio_bucket_name, minio_access_key, minio_secret_key) 
-        VALUES ($1, $2, $3, $4, $5, $6) 
+        INSERT INTO users (user_name, password_hash, email, minio_bucket_name, minio_access_key, minio_secret_key,
+                           quota_bytes, is_admin) 
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8) 
         RETURNING id
     `
 
+	countUsersSQL = "SELECT count(*) FROM users"
+
 	updateMinioUserSQL = `
         UPDATE users
         SET minio_bucket_name = $1, minio_access_key = $2, minio_secret_key = $3
@@ -107,8 +258,11 @@ func (s *StorageDB) Close() error {
 	return s.db.Close()
 }
 
+// ErrUserNotFound возвращается, если пользователь не найден; методы оборачивают ее, проверять нужно через errors.Is
+var ErrUserNotFound = errors.New("пользователь не найден")
+
 // scanUser сканирует результат запроса в структуру User
-func scanUser(row *sql.Row) (*models.User, error) {
+func scanUser(row interface{ Scan(dest ...any) error }) (*models.User, error) {
 	user := &models.User{}
 	err := row.Scan(
 		&user.ID,
@@ -118,46 +272,68 @@ func scanUser(row *sql.Row) (*models.User, error) {
 		&user.MinioBucketName,
 		&user.MinioAccessKey,
 		&user.MinioSecretKey,
+		&user.ProvisioningState,
+		&user.TokenVersion,
+		&user.EmailVerified,
+		&user.IsAdmin,
+		&user.QuotaBytes,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("пользователь не найден")
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("ошибка сканирования данных пользователя: %w", err)
 	}
 	return user, nil
 }
 
+// createUserLockKey ключ рекомендательной блокировки создания пользователей
+const createUserLockKey = 0x6e6173 // "nas"
+
+// ErrNotFirstUser возвращается CreateFirstUser, если пользователи уже есть
+var ErrNotFirstUser = errors.New("пользователи уже зарегистрированы")
+
 // CreateUser создает нового пользователя со всеми необходимыми данными
 func (s *StorageDB) CreateUser(username, passwordHash, email string, minioConfig *models.MinioConfig) (int, error) {
-	tx, err := s.db.Begin()
-	if err != nil {
-		return 0, err
-	}
+	return s.createUser(username, passwordHash, email, minioConfig, false)
+}
 
-	defer func() {
-		if err != nil {
-			err := tx.Rollback()
-			if err != nil {
-				fmt.Printf("ошибка отката транзакции: %v\n", err)
-			}
-		}
-	}()
+// CreateFirstUser создает пользователя-администратора, только если других пользователей еще нет;
+// иначе возвращает ErrNotFirstUser. Используется для регистрации первого пользователя без приглашения.
+func (s *StorageDB) CreateFirstUser(username, passwordHash, email string, minioConfig *models.MinioConfig) (int, error) {
+	return s.createUser(username, passwordHash, email, minioConfig, true)
+}
 
+// createUser создает пользователя под блокировкой, чтобы проверки наличия других пользователей не гонялись
+func (s *StorageDB) createUser(username, passwordHash, email string, minioConfig *models.MinioConfig, firstOnly bool) (int, error) {
 	var id int
-	err = tx.QueryRow(createUserSQL, username, passwordHash, email,
-		minioConfig.BucketName, minioConfig.AccessKey, minioConfig.SecretKey).Scan(&id)
-	if err != nil {
-		return 0, fmt.Errorf("ошибка создания пользователя: %w", err)
-	}
+	err := s.inTx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(lockCreateUserSQL, createUserLockKey); err != nil {
+			return fmt.Errorf("ошибка блокировки создания пользователя: %w", err)
+		}
+
+		if firstOnly {
+			var exists bool
+			if err := tx.QueryRow(hasUsersSQL).Scan(&exists); err != nil {
+				return fmt.Errorf("ошибка проверки пользователей: %w", err)
+			}
+			if exists {
+				return ErrNotFirstUser
+			}
+		}
 
-	err = tx.Commit()
+		err := tx.QueryRow(createUserSQL, username, passwordHash, email,
+			minioConfig.BucketName, minioConfig.AccessKey, minioConfig.SecretKey, minioConfig.QuotaBytes, firstOnly).Scan(&id)
+		if err != nil {
+			return fmt.Errorf("ошибка создания пользователя: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return 0, fmt.Errorf("ошибка подтверждения транзакции: %w", err)
+		return 0, err
 	}
-
 	return id, nil
 }
 
@@ -181,6 +357,74 @@ func (s *StorageDB) GetUserByID(id int) (*models.User, error) {
 	return user, nil
 }
 
+// GetUserByEmail возвращает пользователя по адресу почты без учета регистра или nil, если такого нет
+func (s *StorageDB) GetUserByEmail(email string) (*models.User, error) {
+	user, err := scanUser(s.db.QueryRow(selectUserByEmailSQL, email))
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения пользователя по адресу почты: %w", err)
+	}
+	return user, nil
+}
+
+// UpdatePassword сохраняет новый хеш пароля и отзывает выданные пользователю токены, сеансы и токены API
+func (s *StorageDB) UpdatePassword(userID int, passwordHash string) error {
+	result, err := s.db.Exec(updatePasswordSQL, passwordHash, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка обновления пароля: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("ошибка обновления пароля: %w", ErrUserNotFound)
+	}
+	return nil
+}
+
+// SetUserAdmin назначает или снимает роль администратора
+func (s *StorageDB) SetUserAdmin(userID int, isAdmin bool) error {
+	result, err := s.db.Exec(updateUserAdminSQL, isAdmin, userID)
+	if err != nil {
+		return fmt.Errorf("ошибка изменения роли пользователя: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("ошибка изменения роли пользователя: %w", ErrUserNotFound)
+	}
+	return nil
+}
+
+// ListUsers возвращает всех пользователей
+func (s *StorageDB) ListUsers() ([]models.User, error) {
+	rows, err := s.db.Query(selectUsersSQL)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка пользователей: %w", err)
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка получения списка пользователей: %w", err)
+	}
+
+	return users, nil
+}
+
+// CountUsers возвращает количество пользователей
+func (s *StorageDB) CountUsers() (int, error) {
+	var count int
+	if err := s.db.QueryRow(countUsersSQL).Scan(&count); err != nil {
+		return 0, fmt.Errorf("ошибка подсчета пользователей: %w", err)
+	}
+	return count, nil
+}
+
 // UpdateUser обновляет информацию о пользователе
 func (s *StorageDB) UpdateUser(user *models.User) error {
 	_, err := s.db.Exec(updateUserSQL,
@@ -51,8 +51,9 @@ func TestCreateUser(t *testing.T) {
 
 	// Настраиваем ожидания для транзакций и запросов
 	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(createUserLockKey).WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectQuery("INSERT INTO users").
-		WithArgs(username, passwordHash, email, minioConfig.BucketName, minioConfig.AccessKey, minioConfig.SecretKey).
+		WithArgs(username, passwordHash, email, minioConfig.BucketName, minioConfig.AccessKey, minioConfig.SecretKey, minioConfig.QuotaBytes, false).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(expectedID))
 	mock.ExpectCommit()
 
@@ -68,6 +69,42 @@ func TestCreateUser(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
 }
 
+// TestCreateFirstUser проверяет, что первый пользователь без приглашения создается,
+// только пока других пользователей нет
+func TestCreateFirstUser(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	minioConfig := &models.MinioConfig{BucketName: "test-bucket", AccessKey: "test-access", SecretKey: "test-secret"}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(createUserLockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM users\\)").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	// Администратором становится только первый пользователь
+	mock.ExpectQuery("INSERT INTO users").
+		WithArgs("admin", "hash", "admin@example.com", "test-bucket", "test-access", "test-secret", int64(0), true).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	// Другой пользователь успел зарегистрироваться, пока ждали блокировку
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(createUserLockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM users\\)").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	storage := &StorageDB{db: db}
+
+	id, err := storage.CreateFirstUser("admin", "hash", "admin@example.com", minioConfig)
+	require.NoError(t, err)
+	assert.Equal(t, 1, id)
+
+	_, err = storage.CreateFirstUser("late", "hash", "late@example.com", minioConfig)
+	assert.ErrorIs(t, err, ErrNotFirstUser)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
 // TestCreateUserError проверяет обработку ошибок при создании пользователя
 func TestCreateUserError(t *testing.T) {
 	// Создаем мок БД
@@ -87,8 +124,9 @@ func TestCreateUserError(t *testing.T) {
 
 	// Настраиваем ожидания для ошибки при выполнении запроса
 	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(createUserLockKey).WillReturnResult(sqlmock.NewResult(0, 0))
 	mock.ExpectQuery("INSERT INTO users").
-		WithArgs(username, passwordHash, email, minioConfig.BucketName, minioConfig.AccessKey, minioConfig.SecretKey).
+		WithArgs(username, passwordHash, email, minioConfig.BucketName, minioConfig.AccessKey, minioConfig.SecretKey, minioConfig.QuotaBytes, false).
 		WillReturnError(errors.New("ошибка создания пользователя"))
 	mock.ExpectRollback()
 
@@ -118,12 +156,13 @@ func TestGetUserByUsername(t *testing.T) {
 	// Настраиваем ожидания для запроса
 	columns := []string{
 		"id", "user_name", "password_hash", "email", "minio_bucket_name",
-		"minio_access_key", "minio_secret_key", "created_at", "updated_at",
+		"minio_access_key", "minio_secret_key", "provisioning_state", "token_version", "email_verified",
+		"is_admin", "quota_bytes", "created_at", "updated_at",
 	}
 	mock.ExpectQuery("SELECT .* FROM users WHERE user_name").
 		WithArgs(username).
 		WillReturnRows(sqlmock.NewRows(columns).
-			AddRow(1, username, "hashedpass", "test@example.com", "bucket", "access", "secret", now, now))
+			AddRow(1, username, "hashedpass", "test@example.com", "bucket", "access", "secret", "ready", 0, true, false, 0, now, now))
 
 	// Создаем экземпляр StorageDB с моком
 	storage := &StorageDB{db: db}
@@ -181,12 +220,13 @@ func TestGetUserByID(t *testing.T) {
 	// Настраиваем ожидания для запроса
 	columns := []string{
 		"id", "user_name", "password_hash", "email", "minio_bucket_name",
-		"minio_access_key", "minio_secret_key", "created_at", "updated_at",
+		"minio_access_key", "minio_secret_key", "provisioning_state", "token_version", "email_verified",
+		"is_admin", "quota_bytes", "created_at", "updated_at",
 	}
 	mock.ExpectQuery("SELECT .* FROM users WHERE id").
 		WithArgs(userID).
 		WillReturnRows(sqlmock.NewRows(columns).
-			AddRow(userID, username, "hashedpass", "test@example.com", "bucket", "access", "secret", now, now))
+			AddRow(userID, username, "hashedpass", "test@example.com", "bucket", "access", "secret", "ready", 0, true, false, 0, now, now))
 
 	// Создаем экземпляр StorageDB с моком
 	storage := &StorageDB{db: db}
@@ -376,3 +416,106 @@ func TestGetMinIOCredentialsEmpty(t *testing.T) {
 	assert.Contains(t, err.Error(), "не настроен MinIO", "Текст ошибки должен содержать ожидаемое сообщение")
 	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
 }
+
+// TestListUsers проверяет получение списка всех пользователей
+func TestListUsers(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	columns := []string{
+		"id", "user_name", "password_hash", "email", "minio_bucket_name",
+		"minio_access_key", "minio_secret_key", "provisioning_state", "token_version", "email_verified",
+		"is_admin", "quota_bytes", "created_at", "updated_at",
+	}
+	mock.ExpectQuery("SELECT .* FROM users ORDER BY id").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, "alice", "hash", "a@example.com", "user-alice", "user-alice", "secret", "ready", 0, true, false, 0, now, now).
+			AddRow(2, "bob", "hash", "b@example.com", "", "", "", "pending", 0, true, false, 0, now, now))
+
+	storage := &StorageDB{db: db}
+
+	users, err := storage.ListUsers()
+
+	assert.NoError(t, err, "Получение списка должно пройти без ошибок")
+	require.Len(t, users, 2)
+	assert.Equal(t, "alice", users[0].UserName)
+	assert.Equal(t, "", users[1].MinioBucketName)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestGetUserByEmail проверяет поиск пользователя по адресу почты
+func TestGetUserByEmail(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	columns := []string{
+		"id", "user_name", "password_hash", "email", "minio_bucket_name",
+		"minio_access_key", "minio_secret_key", "provisioning_state", "token_version", "email_verified",
+		"is_admin", "quota_bytes", "created_at", "updated_at",
+	}
+	mock.ExpectQuery("SELECT .* FROM users WHERE lower\\(email\\) = lower\\(\\$1\\)").
+		WithArgs("Alice@Example.com").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, "alice", "hash", "alice@example.com", "bucket", "access", "secret", "ready", 2, true, false, 0, now, now))
+	mock.ExpectQuery("SELECT .* FROM users WHERE lower\\(email\\)").
+		WithArgs("nobody@example.com").
+		WillReturnError(sql.ErrNoRows)
+
+	storage := &StorageDB{db: db}
+
+	user, err := storage.GetUserByEmail("Alice@Example.com")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+	assert.Equal(t, 2, user.TokenVersion)
+
+	user, err = storage.GetUserByEmail("nobody@example.com")
+	assert.NoError(t, err, "Отсутствие пользователя не является ошибкой")
+	assert.Nil(t, user)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestUpdatePassword проверяет, что смена пароля увеличивает версию токенов и отзывает токены API
+func TestUpdatePassword(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("DELETE FROM api_tokens WHERE user_id = \\$2 .* UPDATE users SET password_hash = \\$1, token_version = token_version \\+ 1").
+		WithArgs("newhash", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users SET password_hash").
+		WithArgs("newhash", 99).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	storage := &StorageDB{db: db}
+
+	assert.NoError(t, storage.UpdatePassword(1, "newhash"))
+	assert.Error(t, storage.UpdatePassword(99, "newhash"), "Для несуществующего пользователя должна быть ошибка")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+func TestSetUserAdmin(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE users SET is_admin = \\$1").
+		WithArgs(true, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users SET is_admin = \\$1").
+		WithArgs(false, 99).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	storage := &StorageDB{db: db}
+
+	assert.NoError(t, storage.SetUserAdmin(1, true))
+	assert.Error(t, storage.SetUserAdmin(99, false), "Для несуществующего пользователя должна быть ошибка")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
@@ -0,0 +1,187 @@
+package storagedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/lib/pq"
+)
+
+// SQL запросы для одноразовых токенов пользователей
+const (
+	insertUserTokenSQL = `
+        INSERT INTO user_tokens (token_hash, user_id, purpose, email, expires_at)
+        VALUES ($1, $2, $3, $4, $5)
+        RETURNING created_at
+    `
+
+	// Токен удаляется при первом же использовании, поэтому повторно его применить нельзя
+	consumeUserTokenSQL = `
+        DELETE FROM user_tokens
+        WHERE token_hash = $1 AND purpose = $2
+        RETURNING token_hash, user_id, purpose, email, expires_at, created_at
+    `
+
+	deleteUserTokensSQL = "DELETE FROM user_tokens WHERE user_id = $1 AND purpose = $2"
+
+	// Уникальный индекс различает регистр, поэтому адрес, отличающийся только регистром, проверяется отдельно
+	updateUserEmailSQL = `
+        UPDATE users
+        SET email = $1, updated_at = (now() AT TIME ZONE 'UTC')
+        WHERE id = $2 AND NOT EXISTS (SELECT 1 FROM users WHERE lower(email) = lower($1) AND id <> $2)
+    `
+)
+
+// ErrEmailTaken возвращается, если адрес почты уже использует другой пользователь
+var ErrEmailTaken = errors.New("адрес почты уже используется")
+
+// ErrUsernameTaken возвращается, если имя пользователя уже занято
+var ErrUsernameTaken = errors.New("имя пользователя уже занято")
+
+// usersEmailConstraint ограничение уникальности адреса почты в таблице users
+const usersEmailConstraint = "users_email_key"
+
+// UpdateProfile в одной транзакции сохраняет имя и адрес пользователя и, если token задан, заменяет им
+// токены того же назначения. deliver, если задан, вызывается перед фиксацией: при его ошибке изменения отменяются.
+// Для занятого имени возвращает ErrUsernameTaken, для занятого адреса — ErrEmailTaken.
+func (s *StorageDB) UpdateProfile(user *models.User, token *models.UserToken, deliver func() error) error {
+	return s.inTx(func(tx *sql.Tx) error {
+		_, err := tx.Exec(updateUserSQL,
+			user.UserName,
+			user.Email,
+			user.MinioBucketName,
+			user.MinioAccessKey,
+			user.MinioSecretKey,
+			user.ID,
+		)
+		if isUniqueViolation(err) {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Constraint == usersEmailConstraint {
+				return ErrEmailTaken
+			}
+			return ErrUsernameTaken
+		}
+		if err != nil {
+			return fmt.Errorf("ошибка обновления пользователя: %w", err)
+		}
+
+		if token != nil {
+			if _, err := tx.Exec(deleteUserTokensSQL, user.ID, token.Purpose); err != nil {
+				return fmt.Errorf("ошибка удаления токенов: %w", err)
+			}
+			// Время в БД хранится в UTC без часового пояса
+			err := tx.QueryRow(insertUserTokenSQL,
+				token.TokenHash,
+				token.UserID,
+				token.Purpose,
+				token.Email,
+				token.ExpiresAt.UTC(),
+			).Scan(&token.CreatedAt)
+			if err != nil {
+				return fmt.Errorf("ошибка сохранения токена: %w", err)
+			}
+		}
+
+		if deliver != nil {
+			return deliver()
+		}
+		return nil
+	})
+}
+
+// CreateUserToken сохраняет хеш одноразового токена и заполняет CreatedAt
+func (s *StorageDB) CreateUserToken(token *models.UserToken) error {
+	// Время в БД хранится в UTC без часового пояса
+	err := s.db.QueryRow(insertUserTokenSQL,
+		token.TokenHash,
+		token.UserID,
+		token.Purpose,
+		token.Email,
+		token.ExpiresAt.UTC(),
+	).Scan(&token.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения токена: %w", err)
+	}
+	return nil
+}
+
+// ConsumeUserToken удаляет токен и возвращает его, если он существует и не истек; иначе возвращает nil
+func (s *StorageDB) ConsumeUserToken(tokenHash, purpose string) (*models.UserToken, error) {
+	return scanConsumedToken(s.db.QueryRow(consumeUserTokenSQL, tokenHash, purpose))
+}
+
+// ConfirmEmailChange погашает токен смены адреса и в той же транзакции меняет адрес пользователя.
+// Возвращает nil, если токена нет или он истек, и ErrEmailTaken, если адрес уже занят;
+// в последнем случае токен остается действительным.
+func (s *StorageDB) ConfirmEmailChange(tokenHash string) (*models.UserToken, error) {
+	var token *models.UserToken
+	err := s.inTx(func(tx *sql.Tx) error {
+		var err error
+		token, err = scanConsumedToken(tx.QueryRow(consumeUserTokenSQL, tokenHash, models.TokenPurposeEmailChange))
+		if err != nil || token == nil {
+			return err
+		}
+
+		result, err := tx.Exec(updateUserEmailSQL, token.Email, token.UserID)
+		if isUniqueViolation(err) {
+			return ErrEmailTaken
+		}
+		if err != nil {
+			return fmt.Errorf("ошибка смены адреса почты: %w", err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("ошибка смены адреса почты: %w", err)
+		}
+		if rows == 0 {
+			return ErrEmailTaken
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// scanConsumedToken читает погашенный токен; для отсутствующего или истекшего возвращает nil
+func scanConsumedToken(row *sql.Row) (*models.UserToken, error) {
+	var token models.UserToken
+	err := row.Scan(
+		&token.TokenHash,
+		&token.UserID,
+		&token.Purpose,
+		&token.Email,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка использования токена: %w", err)
+	}
+
+	// Истекший токен тоже удаляется, но не считается действительным
+	if !token.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	return &token, nil
+}
+
+// DeleteUserTokens удаляет все токены пользователя с заданным назначением
+func (s *StorageDB) DeleteUserTokens(userID int, purpose string) error {
+	if _, err := s.db.Exec(deleteUserTokensSQL, userID, purpose); err != nil {
+		return fmt.Errorf("ошибка удаления токенов: %w", err)
+	}
+	return nil
+}
+
+// isUniqueViolation сообщает, что запрос нарушил уникальный индекс
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
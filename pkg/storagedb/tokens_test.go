@@ -0,0 +1,205 @@
+package storagedb
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tokenColumns колонки одноразового токена
+var tokenColumns = []string{"token_hash", "user_id", "purpose", "email", "expires_at", "created_at"}
+
+// TestCreateUserToken проверяет сохранение токена со сроком действия в UTC
+func TestCreateUserToken(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	expires := now.Add(time.Hour)
+	mock.ExpectQuery("INSERT INTO user_tokens").
+		WithArgs("hash", 1, models.TokenPurposeEmailChange, "new@example.com", expires.UTC()).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(now))
+
+	storage := &StorageDB{db: db}
+	token := &models.UserToken{
+		TokenHash: "hash",
+		UserID:    1,
+		Purpose:   models.TokenPurposeEmailChange,
+		Email:     "new@example.com",
+		ExpiresAt: expires,
+	}
+
+	assert.NoError(t, storage.CreateUserToken(token))
+	assert.Equal(t, now, token.CreatedAt)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestConsumeUserToken проверяет, что действует только существующий неистекший токен
+func TestConsumeUserToken(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("DELETE FROM user_tokens").
+		WithArgs("valid", models.TokenPurposeEmailChange).
+		WillReturnRows(sqlmock.NewRows(tokenColumns).
+			AddRow("valid", 1, models.TokenPurposeEmailChange, "new@example.com", now.Add(time.Hour), now))
+	mock.ExpectQuery("DELETE FROM user_tokens").
+		WithArgs("expired", models.TokenPurposeEmailChange).
+		WillReturnRows(sqlmock.NewRows(tokenColumns).
+			AddRow("expired", 1, models.TokenPurposeEmailChange, "new@example.com", now.Add(-time.Hour), now))
+	mock.ExpectQuery("DELETE FROM user_tokens").
+		WithArgs("used", models.TokenPurposeEmailChange).
+		WillReturnRows(sqlmock.NewRows(tokenColumns))
+
+	storage := &StorageDB{db: db}
+
+	token, err := storage.ConsumeUserToken("valid", models.TokenPurposeEmailChange)
+	require.NoError(t, err)
+	require.NotNil(t, token)
+	assert.Equal(t, "new@example.com", token.Email)
+
+	token, err = storage.ConsumeUserToken("expired", models.TokenPurposeEmailChange)
+	assert.NoError(t, err)
+	assert.Nil(t, token, "Истекший токен недействителен")
+
+	token, err = storage.ConsumeUserToken("used", models.TokenPurposeEmailChange)
+	assert.NoError(t, err)
+	assert.Nil(t, token, "Использованный токен недействителен")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestConfirmEmailChange проверяет, что код погашается в одной транзакции со сменой адреса,
+// а занятый адрес откатывает транзакцию
+func TestConfirmEmailChange(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	row := func(hash string) *sqlmock.Rows {
+		return sqlmock.NewRows(tokenColumns).
+			AddRow(hash, 1, models.TokenPurposeEmailChange, "new@example.com", now.Add(time.Hour), now)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM user_tokens").
+		WithArgs("valid", models.TokenPurposeEmailChange).
+		WillReturnRows(row("valid"))
+	mock.ExpectExec("UPDATE users\\s+SET email = \\$1").
+		WithArgs("new@example.com", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Адрес, отличающийся регистром, уже занят
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM user_tokens").
+		WithArgs("taken", models.TokenPurposeEmailChange).
+		WillReturnRows(row("taken"))
+	mock.ExpectExec("UPDATE users\\s+SET email = \\$1").
+		WithArgs("new@example.com", 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	// Одновременная смена на тот же адрес нарушает уникальный индекс
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM user_tokens").
+		WithArgs("race", models.TokenPurposeEmailChange).
+		WillReturnRows(row("race"))
+	mock.ExpectExec("UPDATE users\\s+SET email = \\$1").
+		WithArgs("new@example.com", 1).
+		WillReturnError(&pq.Error{Code: "23505"})
+	mock.ExpectRollback()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM user_tokens").
+		WithArgs("used", models.TokenPurposeEmailChange).
+		WillReturnRows(sqlmock.NewRows(tokenColumns))
+	mock.ExpectCommit()
+
+	storage := &StorageDB{db: db}
+
+	token, err := storage.ConfirmEmailChange("valid")
+	require.NoError(t, err)
+	require.NotNil(t, token)
+	assert.Equal(t, 1, token.UserID)
+
+	_, err = storage.ConfirmEmailChange("taken")
+	assert.ErrorIs(t, err, ErrEmailTaken)
+	_, err = storage.ConfirmEmailChange("race")
+	assert.ErrorIs(t, err, ErrEmailTaken)
+
+	token, err = storage.ConfirmEmailChange("used")
+	assert.NoError(t, err)
+	assert.Nil(t, token, "Использованный токен недействителен")
+
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestUpdateProfile проверяет, что имя, адрес и код сохраняются вместе и отменяются вместе
+func TestUpdateProfile(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	user := &models.User{ID: 1, UserName: "alice.smith", Email: "a@example.com", MinioBucketName: "user-1", MinioAccessKey: "user-1"}
+	token := &models.UserToken{TokenHash: "hash", UserID: 1, Purpose: models.TokenPurposeEmailChange,
+		Email: "new@example.com", ExpiresAt: now.Add(time.Hour)}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users\\s+SET user_name = \\$1").
+		WithArgs("alice.smith", "a@example.com", "user-1", "user-1", "", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM user_tokens WHERE user_id = \\$1 AND purpose = \\$2").
+		WithArgs(1, models.TokenPurposeEmailChange).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO user_tokens").
+		WithArgs("hash", 1, models.TokenPurposeEmailChange, "new@example.com", token.ExpiresAt.UTC()).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(now))
+	mock.ExpectCommit()
+
+	// Письмо не отправлено: переименование и код отменяются
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users\\s+SET user_name = \\$1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM user_tokens").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO user_tokens").WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(now))
+	mock.ExpectRollback()
+
+	// Одновременное переименование в то же имя нарушает уникальный индекс
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users\\s+SET user_name = \\$1").
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "users_user_name_key"})
+	mock.ExpectRollback()
+
+	storage := &StorageDB{db: db}
+
+	delivered := false
+	require.NoError(t, storage.UpdateProfile(user, token, func() error {
+		delivered = true
+		return nil
+	}))
+	assert.True(t, delivered)
+	assert.Equal(t, now, token.CreatedAt)
+
+	errSend := errors.New("почта недоступна")
+	err = storage.UpdateProfile(user, token, func() error { return errSend })
+	assert.ErrorIs(t, err, errSend)
+
+	err = storage.UpdateProfile(user, nil, nil)
+	assert.ErrorIs(t, err, ErrUsernameTaken)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
@@ -0,0 +1,215 @@
+package storagedb
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/lib/pq"
+)
+
+// SQL запросы для ключей доступа WebAuthn
+const (
+	insertWebAuthnCredentialSQL = `
+        INSERT INTO webauthn_credentials (user_id, name, credential_id, public_key, sign_count, transports)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id, created_at
+    `
+
+	selectWebAuthnCredentialsSQL = `
+        SELECT id, user_id, name, credential_id, public_key, sign_count, transports, created_at, last_used_at
+        FROM webauthn_credentials
+        WHERE user_id = $1
+        ORDER BY id
+    `
+
+	selectWebAuthnCredentialSQL = `
+        SELECT id, user_id, name, credential_id, public_key, sign_count, transports, created_at, last_used_at
+        FROM webauthn_credentials
+        WHERE credential_id = $1
+    `
+
+	// Счетчик обновляется, только если вырос: два входа с одной подписью не пройдут оба.
+	// Аутентификаторы без счетчика всегда присылают 0.
+	updateWebAuthnSignCountSQL = `
+        UPDATE webauthn_credentials
+        SET sign_count = $2, last_used_at = (now() AT TIME ZONE 'UTC')
+        WHERE id = $1 AND ($2 = 0 OR sign_count < $2)
+    `
+
+	deleteWebAuthnCredentialSQL = "DELETE FROM webauthn_credentials WHERE id = $1 AND user_id = $2"
+
+	// Заодно удаляются истекшие вызовы: церемонии, которые так и не завершили, иначе копились бы в таблице
+	insertWebAuthnChallengeSQL = `
+        WITH expired AS (
+            DELETE FROM webauthn_challenges WHERE expires_at < (now() AT TIME ZONE 'UTC')
+        )
+        INSERT INTO webauthn_challenges (challenge_hash, user_id, purpose, expires_at)
+        VALUES ($1, $2, $3, $4)
+    `
+
+	// Вызов удаляется при первом же использовании, поэтому ответ аутентификатора нельзя применить повторно
+	consumeWebAuthnChallengeSQL = `
+        DELETE FROM webauthn_challenges
+        WHERE challenge_hash = $1 AND purpose = $2
+        RETURNING challenge_hash, user_id, purpose, expires_at
+    `
+)
+
+// CreateWebAuthnCredential сохраняет ключ доступа и заполняет ID и CreatedAt
+func (s *StorageDB) CreateWebAuthnCredential(cred *models.WebAuthnCredential) error {
+	transports := cred.Transports
+	if transports == nil {
+		transports = []string{}
+	}
+
+	err := s.db.QueryRow(insertWebAuthnCredentialSQL,
+		cred.UserID,
+		cred.Name,
+		cred.CredentialID,
+		cred.PublicKey,
+		int64(cred.SignCount),
+		pq.Array(transports),
+	).Scan(&cred.ID, &cred.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения ключа доступа: %w", err)
+	}
+	return nil
+}
+
+// ListWebAuthnCredentials возвращает ключи доступа пользователя
+func (s *StorageDB) ListWebAuthnCredentials(userID int) ([]models.WebAuthnCredential, error) {
+	rows, err := s.db.Query(selectWebAuthnCredentialsSQL, userID)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения ключей доступа: %w", err)
+	}
+	defer rows.Close()
+
+	var creds []models.WebAuthnCredential
+	for rows.Next() {
+		cred, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка чтения ключа доступа: %w", err)
+		}
+		creds = append(creds, *cred)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ошибка чтения ключей доступа: %w", err)
+	}
+	return creds, nil
+}
+
+// GetWebAuthnCredential возвращает ключ доступа по идентификатору аутентификатора или nil, если его нет
+func (s *StorageDB) GetWebAuthnCredential(credentialID []byte) (*models.WebAuthnCredential, error) {
+	cred, err := scanWebAuthnCredential(s.db.QueryRow(selectWebAuthnCredentialSQL, credentialID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения ключа доступа: %w", err)
+	}
+	return cred, nil
+}
+
+// UpdateWebAuthnSignCount сохраняет счетчик подписей после входа.
+// Возвращает false, если счетчик уже не меньше signCount, то есть подпись используется повторно.
+func (s *StorageDB) UpdateWebAuthnSignCount(id int, signCount uint32) (bool, error) {
+	result, err := s.db.Exec(updateWebAuthnSignCountSQL, id, int64(signCount))
+	if err != nil {
+		return false, fmt.Errorf("ошибка обновления ключа доступа: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("ошибка обновления ключа доступа: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// DeleteWebAuthnCredential удаляет ключ доступа пользователя. Возвращает false, если такого ключа у пользователя нет.
+func (s *StorageDB) DeleteWebAuthnCredential(userID, id int) (bool, error) {
+	result, err := s.db.Exec(deleteWebAuthnCredentialSQL, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("ошибка удаления ключа доступа: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("ошибка удаления ключа доступа: %w", err)
+	}
+	return rows > 0, nil
+}
+
+// CreateWebAuthnChallenge сохраняет хеш вызова начатой церемонии
+func (s *StorageDB) CreateWebAuthnChallenge(challenge *models.WebAuthnChallenge) error {
+	// Вход без имени пользователя начинается, пока пользователь неизвестен
+	var userID sql.NullInt64
+	if challenge.UserID != 0 {
+		userID = sql.NullInt64{Int64: int64(challenge.UserID), Valid: true}
+	}
+
+	// Время в БД хранится в UTC без часового пояса
+	_, err := s.db.Exec(insertWebAuthnChallengeSQL,
+		challenge.ChallengeHash,
+		userID,
+		challenge.Purpose,
+		challenge.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("ошибка сохранения вызова WebAuthn: %w", err)
+	}
+	return nil
+}
+
+// ConsumeWebAuthnChallenge удаляет вызов и возвращает его, если он существует и не истек; иначе возвращает nil
+func (s *StorageDB) ConsumeWebAuthnChallenge(challengeHash, purpose string) (*models.WebAuthnChallenge, error) {
+	var challenge models.WebAuthnChallenge
+	var userID sql.NullInt64
+	err := s.db.QueryRow(consumeWebAuthnChallengeSQL, challengeHash, purpose).Scan(
+		&challenge.ChallengeHash,
+		&userID,
+		&challenge.Purpose,
+		&challenge.ExpiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка использования вызова WebAuthn: %w", err)
+	}
+	challenge.UserID = int(userID.Int64)
+
+	// Истекший вызов тоже удаляется, но не считается действительным
+	if !challenge.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	return &challenge, nil
+}
+
+// scanWebAuthnCredential читает ключ доступа из строки результата
+func scanWebAuthnCredential(row interface{ Scan(dest ...any) error }) (*models.WebAuthnCredential, error) {
+	var cred models.WebAuthnCredential
+	var signCount int64
+	var lastUsedAt sql.NullTime
+	err := row.Scan(
+		&cred.ID,
+		&cred.UserID,
+		&cred.Name,
+		&cred.CredentialID,
+		&cred.PublicKey,
+		&signCount,
+		pq.Array(&cred.Transports),
+		&cred.CreatedAt,
+		&lastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	cred.SignCount = uint32(signCount)
+	if lastUsedAt.Valid {
+		cred.LastUsedAt = &lastUsedAt.Time
+	}
+	return &cred, nil
+}
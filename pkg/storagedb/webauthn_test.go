@@ -0,0 +1,144 @@
+package storagedb
+
+import (
+	"testing"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/models"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCreateWebAuthnCredential проверяет сохранение ключа доступа со способами связи
+func TestCreateWebAuthnCredential(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("INSERT INTO webauthn_credentials").
+		WithArgs(1, "Телефон", []byte{1, 2}, []byte{3, 4}, int64(5), "{\"usb\",\"nfc\"}").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(7, now))
+
+	storage := &StorageDB{db: db}
+
+	cred := &models.WebAuthnCredential{
+		UserID:       1,
+		Name:         "Телефон",
+		CredentialID: []byte{1, 2},
+		PublicKey:    []byte{3, 4},
+		SignCount:    5,
+		Transports:   []string{"usb", "nfc"},
+	}
+	require.NoError(t, storage.CreateWebAuthnCredential(cred))
+	assert.Equal(t, 7, cred.ID)
+	assert.Equal(t, now, cred.CreatedAt)
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestGetWebAuthnCredential проверяет получение ключа доступа и отсутствие неизвестного ключа
+func TestGetWebAuthnCredential(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Now()
+	columns := []string{"id", "user_id", "name", "credential_id", "public_key", "sign_count", "transports", "created_at", "last_used_at"}
+	mock.ExpectQuery("SELECT .* FROM webauthn_credentials WHERE credential_id").
+		WithArgs([]byte{1, 2}).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(7, 1, "Телефон", []byte{1, 2}, []byte{3, 4}, int64(5), "{usb,internal}", now, nil))
+	mock.ExpectQuery("SELECT .* FROM webauthn_credentials WHERE credential_id").
+		WithArgs([]byte{9}).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	storage := &StorageDB{db: db}
+
+	cred, err := storage.GetWebAuthnCredential([]byte{1, 2})
+	require.NoError(t, err)
+	require.NotNil(t, cred)
+	assert.Equal(t, 1, cred.UserID)
+	assert.Equal(t, uint32(5), cred.SignCount)
+	assert.Equal(t, []string{"usb", "internal"}, cred.Transports)
+	assert.Nil(t, cred.LastUsedAt, "Ключ еще не использовался для входа")
+
+	cred, err = storage.GetWebAuthnCredential([]byte{9})
+	assert.NoError(t, err)
+	assert.Nil(t, cred, "Для неизвестного ключа возвращается nil")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestUpdateWebAuthnSignCount проверяет, что повторная подпись с тем же счетчиком не принимается
+func TestUpdateWebAuthnSignCount(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE webauthn_credentials SET sign_count").
+		WithArgs(7, int64(6)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE webauthn_credentials SET sign_count").
+		WithArgs(7, int64(6)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	storage := &StorageDB{db: db}
+
+	ok, err := storage.UpdateWebAuthnSignCount(7, 6)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = storage.UpdateWebAuthnSignCount(7, 6)
+	require.NoError(t, err)
+	assert.False(t, ok, "Счетчик не вырос")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
+
+// TestWebAuthnChallenge проверяет сохранение вызова для входа без имени и его одноразовое использование
+func TestWebAuthnChallenge(t *testing.T) {
+	// Создаем мок БД
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expires := time.Now().Add(5 * time.Minute)
+	mock.ExpectExec("DELETE FROM webauthn_challenges WHERE expires_at .* INSERT INTO webauthn_challenges").
+		WithArgs("hash", nil, models.WebAuthnPurposeLogin, expires.UTC()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	columns := []string{"challenge_hash", "user_id", "purpose", "expires_at"}
+	mock.ExpectQuery("DELETE FROM webauthn_challenges WHERE challenge_hash").
+		WithArgs("hash", models.WebAuthnPurposeLogin).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("hash", nil, models.WebAuthnPurposeLogin, expires))
+	mock.ExpectQuery("DELETE FROM webauthn_challenges WHERE challenge_hash").
+		WithArgs("hash", models.WebAuthnPurposeLogin).
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectQuery("DELETE FROM webauthn_challenges WHERE challenge_hash").
+		WithArgs("old", models.WebAuthnPurposeRegistration).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("old", 1, models.WebAuthnPurposeRegistration, time.Now().Add(-time.Minute)))
+
+	storage := &StorageDB{db: db}
+
+	err = storage.CreateWebAuthnChallenge(&models.WebAuthnChallenge{
+		ChallengeHash: "hash",
+		Purpose:       models.WebAuthnPurposeLogin,
+		ExpiresAt:     expires,
+	})
+	require.NoError(t, err)
+
+	challenge, err := storage.ConsumeWebAuthnChallenge("hash", models.WebAuthnPurposeLogin)
+	require.NoError(t, err)
+	require.NotNil(t, challenge)
+	assert.Equal(t, 0, challenge.UserID, "Пользователь входа без имени неизвестен")
+
+	challenge, err = storage.ConsumeWebAuthnChallenge("hash", models.WebAuthnPurposeLogin)
+	assert.NoError(t, err)
+	assert.Nil(t, challenge, "Вызов используется только один раз")
+
+	challenge, err = storage.ConsumeWebAuthnChallenge("old", models.WebAuthnPurposeRegistration)
+	assert.NoError(t, err)
+	assert.Nil(t, challenge, "Истекший вызов недействителен")
+	assert.NoError(t, mock.ExpectationsWereMet(), "Все ожидания должны быть выполнены")
+}
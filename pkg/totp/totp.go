@@ -0,0 +1,90 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Параметры одноразовых паролей по времени (RFC 6238), которые понимают
+// распространенные приложения-аутентификаторы: SHA-1, 6 цифр, шаг 30 секунд
+const (
+	Digits     = 6
+	Period     = 30 // Шаг в секундах
+	secretSize = 20 // Длина секрета в байтах, как рекомендует RFC 4226 для SHA-1
+)
+
+// encoding base32 без выравнивания, как принято в otpauth URI
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret возвращает новый случайный секрет в base32
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("ошибка генерации секрета: %w", err)
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// URI возвращает otpauth URI для добавления секрета в приложение-аутентификатор (обычно через QR-код)
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(Period))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Counter возвращает номер шага для момента времени
+func Counter(at time.Time) int64 {
+	return at.Unix() / Period
+}
+
+// Code возвращает код для номера шага
+func Code(secret string, counter int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("некорректный секрет: %w", err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Динамическое усечение (RFC 4226, раздел 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1_000_000), nil
+}
+
+// Validate проверяет код для момента at с допуском skew шагов в обе стороны на расхождение часов.
+// Возвращает номер шага совпавшего кода, чтобы вызывающий мог запретить его повторное использование.
+func Validate(secret, code string, at time.Time, skew int) (int64, bool) {
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Counter(at)
+	for delta := -int64(skew); delta <= int64(skew); delta++ {
+		expected, err := Code(secret, current+delta)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return current + delta, true
+		}
+	}
+	return 0, false
+}
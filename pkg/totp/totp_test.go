@@ -0,0 +1,64 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret секрет "12345678901234567890" из тестовых векторов RFC 6238 в base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCode проверяет коды по тестовым векторам RFC 6238 (последние 6 цифр 8-значных кодов для SHA-1)
+func TestCode(t *testing.T) {
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range vectors {
+		code, err := Code(rfcSecret, Counter(time.Unix(unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, want, code, "время %d", unix)
+	}
+}
+
+// TestValidate проверяет допуск на расхождение часов и номер совпавшего шага
+func TestValidate(t *testing.T) {
+	at := time.Unix(1111111111, 0)
+	previous, err := Code(rfcSecret, Counter(at)-1)
+	require.NoError(t, err)
+
+	counter, ok := Validate(rfcSecret, previous, at, 1)
+	assert.True(t, ok, "Код предыдущего шага принимается")
+	assert.Equal(t, Counter(at)-1, counter)
+
+	_, ok = Validate(rfcSecret, previous, at, 0)
+	assert.False(t, ok, "Без допуска код предыдущего шага отклоняется")
+
+	_, ok = Validate(rfcSecret, "12345", at, 1)
+	assert.False(t, ok, "Код неверной длины отклоняется")
+}
+
+// TestGenerateSecretAndURI проверяет, что новый секрет подходит для генерации кодов и попадает в URI
+func TestGenerateSecretAndURI(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	_, err = Code(secret, 1)
+	assert.NoError(t, err)
+
+	uri, err := url.Parse(URI("NASForHome", "alice", secret))
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/NASForHome:alice", uri.Path)
+	assert.Equal(t, secret, uri.Query().Get("secret"))
+	assert.Equal(t, "NASForHome", uri.Query().Get("issuer"))
+}
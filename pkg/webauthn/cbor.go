@@ -0,0 +1,146 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxCBORDepth ограничивает вложенность, чтобы ответ аутентификатора не исчерпал стек
+const maxCBORDepth = 16
+
+// errCBORTruncated возвращается, если данные закончились раньше значения
+var errCBORTruncated = errors.New("cbor: данные обрезаны")
+
+// decodeCBOR разбирает одно значение CBOR (RFC 8949) и возвращает его вместе с оставшимися байтами.
+// Поддерживается подмножество, которое встречается в ответах аутентификаторов: целые числа,
+// байтовые и текстовые строки, массивы, словари, теги и простые значения.
+// Целые числа возвращаются как int64, ключи словарей — как int64 или string.
+func decodeCBOR(data []byte) (any, []byte, error) {
+	return decodeCBORValue(data, 0)
+}
+
+func decodeCBORValue(data []byte, depth int) (any, []byte, error) {
+	if depth > maxCBORDepth {
+		return nil, nil, errors.New("cbor: слишком глубокая вложенность")
+	}
+	if len(data) == 0 {
+		return nil, nil, errCBORTruncated
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	arg, rest, err := decodeCBORArgument(info, data[1:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: слишком большое число")
+		}
+		return int64(arg), rest, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, errors.New("cbor: слишком большое число")
+		}
+		return -1 - int64(arg), rest, nil
+	case 2, 3:
+		if arg > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+		value := rest[:arg]
+		if major == 3 {
+			return string(value), rest[arg:], nil
+		}
+		return append([]byte(nil), value...), rest[arg:], nil
+	case 4:
+		// Каждый элемент занимает хотя бы байт: проверка не дает выделить память под несуществующие элементы
+		if arg > uint64(len(rest)) {
+			return nil, nil, errCBORTruncated
+		}
+		items := make([]any, 0, arg)
+		for range arg {
+			var item any
+			item, rest, err = decodeCBORValue(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, rest, nil
+	case 5:
+		if arg > uint64(len(rest))/2 {
+			return nil, nil, errCBORTruncated
+		}
+		items := make(map[any]any, arg)
+		for range arg {
+			var key, value any
+			key, rest, err = decodeCBORValue(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("cbor: неподдерживаемый тип ключа %T", key)
+			}
+			if _, ok := items[key]; ok {
+				return nil, nil, fmt.Errorf("cbor: повторяющийся ключ %v", key)
+			}
+			value, rest, err = decodeCBORValue(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			items[key] = value
+		}
+		return items, rest, nil
+	case 6:
+		// Теги не влияют на разбор ответов аутентификаторов, возвращается помеченное значение
+		return decodeCBORValue(rest, depth+1)
+	default:
+		switch info {
+		case 20:
+			return false, rest, nil
+		case 21:
+			return true, rest, nil
+		case 22, 23:
+			return nil, rest, nil
+		default:
+			return nil, nil, fmt.Errorf("cbor: неподдерживаемое простое значение %d", info)
+		}
+	}
+}
+
+// decodeCBORArgument читает аргумент заголовка: длину, число или номер тега.
+// Значения неопределенной длины не поддерживаются: аутентификаторы обязаны использовать каноническую форму.
+func decodeCBORArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		if len(data) < 1 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(data[0]), data[1:], nil
+	case info == 25:
+		if len(data) < 2 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26:
+		if len(data) < 4 {
+			return 0, nil, errCBORTruncated
+		}
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27:
+		if len(data) < 8 {
+			return 0, nil, errCBORTruncated
+		}
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	default:
+		return 0, nil, fmt.Errorf("cbor: неподдерживаемый заголовок %d", info)
+	}
+}
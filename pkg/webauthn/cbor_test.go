@@ -0,0 +1,50 @@
+package webauthn
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDecodeCBOR проверяет разбор значений из ответов аутентификаторов
+func TestDecodeCBOR(t *testing.T) {
+	// {1: 2, 3: -7, "a": h'0102', "b": [true, null]} и лишний байт в конце
+	data := []byte{0xa4, 0x01, 0x02, 0x03, 0x26, 0x61, 'a', 0x42, 0x01, 0x02, 0x61, 'b', 0x82, 0xf5, 0xf6, 0xff}
+
+	value, rest, err := decodeCBOR(data)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xff}, rest)
+	assert.Equal(t, map[any]any{
+		int64(1): int64(2),
+		int64(3): int64(-7),
+		"a":      []byte{1, 2},
+		"b":      []any{true, nil},
+	}, value)
+}
+
+// TestDecodeCBORRejected проверяет, что некорректные данные не приводят к панике или большим выделениям памяти
+func TestDecodeCBORRejected(t *testing.T) {
+	tests := map[string][]byte{
+		"пусто":                    {},
+		"обрезанная строка":        {0x45, 0x01},
+		"огромный массив":          {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"огромный словарь":         {0xba, 0xff, 0xff, 0xff, 0xff},
+		"неопределенная длина":     {0x5f, 0x41, 0x01, 0xff},
+		"число с плавающей точкой": {0xf9, 0x3c, 0x00},
+		"повторяющийся ключ":       {0xa2, 0x01, 0x01, 0x01, 0x02},
+		"ключ-массив":              {0xa1, 0x80, 0x01},
+		"слишком большое число":    {0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+	for name, data := range tests {
+		_, _, err := decodeCBOR(data)
+		assert.Error(t, err, name)
+	}
+
+	deep := make([]byte, maxCBORDepth+2)
+	for i := range deep {
+		deep[i] = 0x81 // массив из одного элемента
+	}
+	_, _, err := decodeCBOR(deep)
+	assert.Error(t, err)
+}
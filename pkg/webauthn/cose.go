@@ -0,0 +1,121 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// Алгоритмы подписи COSE, которые принимает сервер
+const (
+	AlgES256 int64 = -7   // ECDSA P-256 с SHA-256
+	AlgEdDSA int64 = -8   // Ed25519
+	AlgRS256 int64 = -257 // RSASSA-PKCS1-v1_5 с SHA-256
+)
+
+// supportedAlgs алгоритмы в порядке предпочтения сервера
+var supportedAlgs = []int64{AlgES256, AlgEdDSA, AlgRS256}
+
+// Параметры ключей COSE (RFC 9053)
+const (
+	coseKeyType  = 1
+	coseKeyAlg   = 3
+	coseCurve    = -1
+	coseX        = -2
+	coseY        = -3
+	coseRSAN     = -1
+	coseRSAE     = -2
+	coseTypeOKP  = 1
+	coseTypeEC2  = 2
+	coseTypeRSA  = 3
+	coseP256     = 1
+	coseEd25519  = 6
+	minRSAKeyLen = 2048
+)
+
+// publicKey открытый ключ учетных данных
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey разбирает открытый ключ в формате COSE_Key
+func parsePublicKey(data []byte) (*publicKey, error) {
+	value, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка разбора ключа: %w", err)
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("лишние данные после ключа")
+	}
+	params, ok := value.(map[any]any)
+	if !ok {
+		return nil, errors.New("ключ должен быть словарем COSE")
+	}
+
+	kty, _ := params[int64(coseKeyType)].(int64)
+	alg, _ := params[int64(coseKeyAlg)].(int64)
+
+	switch {
+	case kty == coseTypeEC2 && alg == AlgES256:
+		crv, _ := params[int64(coseCurve)].(int64)
+		x, _ := params[int64(coseX)].([]byte)
+		y, _ := params[int64(coseY)].([]byte)
+		if crv != coseP256 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("некорректный ключ ES256")
+		}
+		// crypto/ecdh проверяет, что точка лежит на кривой
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("некорректный ключ ES256: %w", err)
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		return &publicKey{alg: alg, key: key}, nil
+	case kty == coseTypeOKP && alg == AlgEdDSA:
+		crv, _ := params[int64(coseCurve)].(int64)
+		x, _ := params[int64(coseX)].([]byte)
+		if crv != coseEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("некорректный ключ EdDSA")
+		}
+		return &publicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case kty == coseTypeRSA && alg == AlgRS256:
+		n, _ := params[int64(coseRSAN)].([]byte)
+		e, _ := params[int64(coseRSAE)].([]byte)
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("некорректный ключ RS256")
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < minRSAKeyLen || key.E < 3 || key.E%2 == 0 {
+			return nil, errors.New("некорректный ключ RS256")
+		}
+		return &publicKey{alg: alg, key: key}, nil
+	default:
+		return nil, fmt.Errorf("неподдерживаемый алгоритм ключа %d", alg)
+	}
+}
+
+// verify проверяет подпись данных ключом
+func (k *publicKey) verify(data, signature []byte) error {
+	var ok bool
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		ok = ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !ok {
+		return errors.New("неверная подпись")
+	}
+	return nil
+}
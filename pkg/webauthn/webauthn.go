@@ -0,0 +1,439 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Типы ответов клиента (WebAuthn Level 2, раздел 5.8.1)
+const (
+	clientDataCreate = "webauthn.create"
+	clientDataGet    = "webauthn.get"
+)
+
+// Флаги данных аутентификатора
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+	flagExtensions   = 0x80
+)
+
+// Параметры церемоний
+const (
+	challengeSize       = 32
+	maxCredentialIDSize = 1023
+	credentialType      = "public-key"
+)
+
+// ErrInvalidResponse возвращается, если ответ аутентификатора не прошел проверку
+var ErrInvalidResponse = errors.New("ответ аутентификатора не прошел проверку")
+
+// ErrSignCount возвращается, если счетчик подписей не вырос: ключ мог быть скопирован
+var ErrSignCount = fmt.Errorf("%w: счетчик подписей не увеличился, ключ мог быть скопирован", ErrInvalidResponse)
+
+// Config параметры проверяющей стороны (Relying Party)
+type Config struct {
+	RPID    string   // Домен, к которому привязываются ключи, например nas.example.com
+	RPName  string   // Название сервиса, которое показывает браузер
+	Origins []string // Адреса веб-интерфейса, с которых принимаются ответы, например https://nas.example.com
+}
+
+// Validate проверяет, что адреса веб-интерфейса относятся к домену RPID
+func (c Config) Validate() error {
+	if c.RPID == "" {
+		return errors.New("не указан домен RP ID")
+	}
+	if len(c.Origins) == 0 {
+		return errors.New("не указаны адреса веб-интерфейса")
+	}
+	for _, origin := range c.Origins {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+			return fmt.Errorf("некорректный адрес веб-интерфейса %q", origin)
+		}
+		host := u.Hostname()
+		if host != c.RPID && !strings.HasSuffix(host, "."+c.RPID) {
+			return fmt.Errorf("адрес %q не относится к домену %q", origin, c.RPID)
+		}
+	}
+	return nil
+}
+
+// Bytes двоичные данные, которые в JSON кодируются base64url без дополнения, как в WebAuthn
+type Bytes []byte
+
+// MarshalJSON кодирует данные в base64url
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON декодирует данные из base64url; дополнение '=' допускается
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return fmt.Errorf("некорректное значение base64url: %w", err)
+	}
+	*b = decoded
+	return nil
+}
+
+// RelyingParty описание сервиса для браузера
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserEntity описание пользователя для браузера. ID не должен содержать личных данных.
+type UserEntity struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialParameter алгоритм, который сервер готов принять
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+// CredentialDescriptor ссылка на существующий ключ
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         Bytes    `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+// AuthenticatorSelection требования к аутентификатору
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+// CreationOptions параметры navigator.credentials.create()
+type CreationOptions struct {
+	Challenge              Bytes                  `json:"challenge"`
+	RP                     RelyingParty           `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"` // В миллисекундах
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions параметры navigator.credentials.get()
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	Timeout          int64                  `json:"timeout"` // В миллисекундах
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// AttestationResponse ответ аутентификатора при создании ключа
+type AttestationResponse struct {
+	ClientDataJSON    Bytes    `json:"clientDataJSON"`
+	AttestationObject Bytes    `json:"attestationObject"`
+	Transports        []string `json:"transports"`
+}
+
+// RegistrationCredential результат navigator.credentials.create() в формате PublicKeyCredential.toJSON()
+type RegistrationCredential struct {
+	ID       string              `json:"id"`
+	RawID    Bytes               `json:"rawId"`
+	Type     string              `json:"type"`
+	Response AttestationResponse `json:"response"`
+}
+
+// AssertionResponse ответ аутентификатора при входе
+type AssertionResponse struct {
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AuthenticatorData Bytes `json:"authenticatorData"`
+	Signature         Bytes `json:"signature"`
+	UserHandle        Bytes `json:"userHandle"`
+}
+
+// AssertionCredential результат navigator.credentials.get() в формате PublicKeyCredential.toJSON()
+type AssertionCredential struct {
+	ID       string            `json:"id"`
+	RawID    Bytes             `json:"rawId"`
+	Type     string            `json:"type"`
+	Response AssertionResponse `json:"response"`
+}
+
+// Credential проверенный ключ, который сохраняется за пользователем
+type Credential struct {
+	ID         []byte
+	PublicKey  []byte // Открытый ключ в формате COSE_Key
+	SignCount  uint32
+	Transports []string
+}
+
+// NewChallenge возвращает случайный вызов для церемонии
+func NewChallenge() (Bytes, error) {
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, fmt.Errorf("ошибка генерации вызова: %w", err)
+	}
+	return challenge, nil
+}
+
+// NewCreationOptions возвращает параметры создания ключа. Ключ должен быть доступен без ввода имени
+// (resident key), а вход — подтверждаться пользователем (PIN или биометрия), чтобы ключ заменял пароль.
+// Аттестация не запрашивается: сервер не ограничивает модели аутентификаторов.
+func (c Config) NewCreationOptions(challenge []byte, user UserEntity, exclude []CredentialDescriptor, timeout time.Duration) *CreationOptions {
+	params := make([]CredentialParameter, 0, len(supportedAlgs))
+	for _, alg := range supportedAlgs {
+		params = append(params, CredentialParameter{Type: credentialType, Alg: alg})
+	}
+	if exclude == nil {
+		exclude = []CredentialDescriptor{}
+	}
+
+	return &CreationOptions{
+		Challenge:          challenge,
+		RP:                 RelyingParty{ID: c.RPID, Name: c.RPName},
+		User:               user,
+		PubKeyCredParams:   params,
+		Timeout:            timeout.Milliseconds(),
+		ExcludeCredentials: exclude,
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   "required",
+		},
+		Attestation: "none",
+	}
+}
+
+// NewRequestOptions возвращает параметры входа. Пустой allow позволяет выбрать любой ключ сервиса.
+func (c Config) NewRequestOptions(challenge []byte, allow []CredentialDescriptor, timeout time.Duration) *RequestOptions {
+	if allow == nil {
+		allow = []CredentialDescriptor{}
+	}
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          timeout.Milliseconds(),
+		RPID:             c.RPID,
+		AllowCredentials: allow,
+		UserVerification: "required",
+	}
+}
+
+// Descriptor возвращает ссылку на ключ для списков allowCredentials и excludeCredentials
+func Descriptor(id []byte, transports []string) CredentialDescriptor {
+	return CredentialDescriptor{Type: credentialType, ID: id, Transports: transports}
+}
+
+// clientData данные клиента, которые подписывает аутентификатор
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// Challenge возвращает вызов из данных клиента, чтобы найти начатую церемонию.
+// Сами данные при этом не проверяются.
+func Challenge(clientDataJSON []byte) ([]byte, error) {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return nil, fmt.Errorf("%w: некорректные данные клиента", ErrInvalidResponse)
+	}
+	challenge, err := base64.RawURLEncoding.DecodeString(data.Challenge)
+	if err != nil || len(challenge) == 0 {
+		return nil, fmt.Errorf("%w: некорректный вызов", ErrInvalidResponse)
+	}
+	return challenge, nil
+}
+
+// verifyClientData проверяет тип церемонии, вызов и адрес страницы
+func (c Config) verifyClientData(clientDataJSON []byte, typ string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(clientDataJSON, &data); err != nil {
+		return fmt.Errorf("%w: некорректные данные клиента", ErrInvalidResponse)
+	}
+	if data.Type != typ {
+		return fmt.Errorf("%w: неожиданный тип %q", ErrInvalidResponse, data.Type)
+	}
+	received, err := base64.RawURLEncoding.DecodeString(data.Challenge)
+	if err != nil || subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return fmt.Errorf("%w: вызов не совпадает", ErrInvalidResponse)
+	}
+	if !slices.Contains(c.Origins, data.Origin) {
+		return fmt.Errorf("%w: недопустимый адрес %q", ErrInvalidResponse, data.Origin)
+	}
+	if data.CrossOrigin {
+		return fmt.Errorf("%w: запрос из встроенной страницы другого сайта", ErrInvalidResponse)
+	}
+	return nil
+}
+
+// authenticatorData разобранные данные аутентификатора
+type authenticatorData struct {
+	rpIDHash     []byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte // COSE_Key, если в данных есть новый ключ
+}
+
+// parseAuthenticatorData разбирает данные аутентификатора (WebAuthn Level 2, раздел 6.1)
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("%w: данные аутентификатора слишком короткие", ErrInvalidResponse)
+	}
+	auth := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+
+	if auth.flags&flagAttested != 0 {
+		// AAGUID (16 байт) и длина идентификатора ключа (2 байта)
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: данные ключа обрезаны", ErrInvalidResponse)
+		}
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLen == 0 || idLen > maxCredentialIDSize || len(rest) < idLen {
+			return nil, fmt.Errorf("%w: некорректный идентификатор ключа", ErrInvalidResponse)
+		}
+		auth.credentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: некорректный ключ: %v", ErrInvalidResponse, err)
+		}
+		auth.publicKey = rest[:len(rest)-len(after)]
+		rest = after
+	}
+
+	if auth.flags&flagExtensions != 0 {
+		var err error
+		if _, rest, err = decodeCBOR(rest); err != nil {
+			return nil, fmt.Errorf("%w: некорректные расширения: %v", ErrInvalidResponse, err)
+		}
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: лишние данные аутентификатора", ErrInvalidResponse)
+	}
+	return auth, nil
+}
+
+// verifyAuthenticatorData проверяет домен и подтверждение пользователем
+func (c Config) verifyAuthenticatorData(auth *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(c.RPID))
+	if !bytes.Equal(auth.rpIDHash, rpIDHash[:]) {
+		return fmt.Errorf("%w: ключ создан для другого домена", ErrInvalidResponse)
+	}
+	if auth.flags&flagUserPresent == 0 {
+		return fmt.Errorf("%w: пользователь не подтвердил присутствие", ErrInvalidResponse)
+	}
+	if auth.flags&flagUserVerified == 0 {
+		return fmt.Errorf("%w: пользователь не подтвердил личность", ErrInvalidResponse)
+	}
+	return nil
+}
+
+// VerifyRegistration проверяет ответ на создание ключа и возвращает ключ для сохранения.
+// Аттестация не проверяется, так как она не запрашивается.
+func (c Config) VerifyRegistration(challenge []byte, cred *RegistrationCredential) (*Credential, error) {
+	if cred.Type != credentialType {
+		return nil, fmt.Errorf("%w: неожиданный тип ключа %q", ErrInvalidResponse, cred.Type)
+	}
+	if err := c.verifyClientData(cred.Response.ClientDataJSON, clientDataCreate, challenge); err != nil {
+		return nil, err
+	}
+
+	value, rest, err := decodeCBOR(cred.Response.AttestationObject)
+	if err != nil || len(rest) != 0 {
+		return nil, fmt.Errorf("%w: некорректный объект аттестации", ErrInvalidResponse)
+	}
+	attestation, ok := value.(map[any]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: некорректный объект аттестации", ErrInvalidResponse)
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: нет данных аутентификатора", ErrInvalidResponse)
+	}
+
+	auth, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.verifyAuthenticatorData(auth); err != nil {
+		return nil, err
+	}
+	if auth.credentialID == nil {
+		return nil, fmt.Errorf("%w: нет данных нового ключа", ErrInvalidResponse)
+	}
+	if len(cred.RawID) != 0 && !bytes.Equal(cred.RawID, auth.credentialID) {
+		return nil, fmt.Errorf("%w: идентификатор ключа не совпадает", ErrInvalidResponse)
+	}
+	if _, err := parsePublicKey(auth.publicKey); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	return &Credential{
+		ID:         auth.credentialID,
+		PublicKey:  auth.publicKey,
+		SignCount:  auth.signCount,
+		Transports: cred.Response.Transports,
+	}, nil
+}
+
+// VerifyAssertion проверяет подпись при входе ключом с открытым ключом publicKey и возвращает
+// новое значение счетчика подписей. Если аутентификатор ведет счетчик, он должен быть больше signCount.
+func (c Config) VerifyAssertion(challenge []byte, cred *AssertionCredential, publicKey []byte, signCount uint32) (uint32, error) {
+	if cred.Type != credentialType {
+		return 0, fmt.Errorf("%w: неожиданный тип ключа %q", ErrInvalidResponse, cred.Type)
+	}
+	if err := c.verifyClientData(cred.Response.ClientDataJSON, clientDataGet, challenge); err != nil {
+		return 0, err
+	}
+
+	auth, err := parseAuthenticatorData(cred.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.verifyAuthenticatorData(auth); err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+	clientDataHash := sha256.Sum256(cred.Response.ClientDataJSON)
+	signed := append(append([]byte(nil), cred.Response.AuthenticatorData...), clientDataHash[:]...)
+	if err := key.verify(signed, cred.Response.Signature); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	// Ключи, синхронизируемые между устройствами, не ведут счетчик и всегда присылают 0
+	if (auth.signCount != 0 || signCount != 0) && auth.signCount <= signCount {
+		return 0, ErrSignCount
+	}
+	return auth.signCount, nil
+}
@@ -0,0 +1,154 @@
+package webauthn_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com.Vova4o/nasforhome/pkg/webauthn"
+	"github.com.Vova4o/nasforhome/pkg/webauthn/webauthntest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const origin = "https://nas.example.com"
+
+var config = webauthn.Config{RPID: "nas.example.com", RPName: "NASForHome", Origins: []string{origin}}
+
+// register создает ключ программным аутентификатором и проверяет ответ
+func register(t *testing.T, auth *webauthntest.Authenticator) *webauthn.Credential {
+	t.Helper()
+	challenge, err := webauthn.NewChallenge()
+	require.NoError(t, err)
+
+	opts := config.NewCreationOptions(challenge, webauthn.UserEntity{ID: []byte("1"), Name: "alice"}, nil, time.Minute)
+	resp, err := auth.Create(opts)
+	require.NoError(t, err)
+
+	cred, err := config.VerifyRegistration(challenge, resp)
+	require.NoError(t, err)
+	return cred
+}
+
+// TestRegistrationAndAssertion проверяет создание ключа и вход с ним
+func TestRegistrationAndAssertion(t *testing.T) {
+	auth, err := webauthntest.New(origin)
+	require.NoError(t, err)
+
+	cred := register(t, auth)
+	assert.Equal(t, auth.CredentialID(), cred.ID)
+	assert.Equal(t, auth.PublicKey(), cred.PublicKey)
+	assert.Equal(t, []string{"internal"}, cred.Transports)
+
+	challenge, err := webauthn.NewChallenge()
+	require.NoError(t, err)
+	resp, err := auth.Get(config.NewRequestOptions(challenge, nil, time.Minute))
+	require.NoError(t, err)
+
+	got, err := webauthn.Challenge(resp.Response.ClientDataJSON)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(challenge), got)
+
+	count, err := config.VerifyAssertion(challenge, resp, cred.PublicKey, cred.SignCount)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), count)
+
+	// Повторное использование того же ответа выдает скопированный ключ
+	_, err = config.VerifyAssertion(challenge, resp, cred.PublicKey, count)
+	assert.ErrorIs(t, err, webauthn.ErrSignCount)
+}
+
+// TestAssertionRejected проверяет отклонение ответов с чужим вызовом, адресом, доменом или подписью
+func TestAssertionRejected(t *testing.T) {
+	auth, err := webauthntest.New(origin)
+	require.NoError(t, err)
+	cred := register(t, auth)
+
+	challenge, err := webauthn.NewChallenge()
+	require.NoError(t, err)
+	other, err := webauthn.NewChallenge()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		modify func(a *webauthntest.Authenticator)
+		config webauthn.Config
+		check  func(resp *webauthn.AssertionCredential)
+		wanted []byte
+	}{
+		{name: "чужой вызов", config: config, wanted: other},
+		{name: "чужой адрес", config: config, wanted: challenge, modify: func(a *webauthntest.Authenticator) { a.Origin = "https://evil.example" }},
+		{name: "чужой домен", config: webauthn.Config{RPID: "example.com", Origins: []string{origin}}, wanted: challenge},
+		{name: "подпись", config: config, wanted: challenge, check: func(resp *webauthn.AssertionCredential) {
+			resp.Response.Signature[len(resp.Response.Signature)-1] ^= 1
+		}},
+		{name: "данные клиента", config: config, wanted: challenge, check: func(resp *webauthn.AssertionCredential) {
+			var data map[string]any
+			require.NoError(t, json.Unmarshal(resp.Response.ClientDataJSON, &data))
+			data["crossOrigin"] = true
+			resp.Response.ClientDataJSON, _ = json.Marshal(data)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := *auth
+			if tt.modify != nil {
+				tt.modify(&a)
+			}
+			resp, err := a.Get(config.NewRequestOptions(challenge, nil, time.Minute))
+			require.NoError(t, err)
+			if tt.check != nil {
+				tt.check(resp)
+			}
+
+			_, err = tt.config.VerifyAssertion(tt.wanted, resp, cred.PublicKey, cred.SignCount)
+			assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+		})
+	}
+}
+
+// TestRegistrationRejected проверяет отклонение создания ключа с неверным вызовом и испорченным объектом
+func TestRegistrationRejected(t *testing.T) {
+	auth, err := webauthntest.New(origin)
+	require.NoError(t, err)
+
+	challenge, err := webauthn.NewChallenge()
+	require.NoError(t, err)
+	resp, err := auth.Create(config.NewCreationOptions(challenge, webauthn.UserEntity{ID: []byte("1")}, nil, time.Minute))
+	require.NoError(t, err)
+
+	other, err := webauthn.NewChallenge()
+	require.NoError(t, err)
+	_, err = config.VerifyRegistration(other, resp)
+	assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+
+	truncated := *resp
+	truncated.Response.AttestationObject = resp.Response.AttestationObject[:len(resp.Response.AttestationObject)-10]
+	_, err = config.VerifyRegistration(challenge, &truncated)
+	assert.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+
+	// Аутентификатор отказывается создавать уже зарегистрированный ключ
+	_, err = auth.Create(config.NewCreationOptions(challenge, webauthn.UserEntity{ID: []byte("1")},
+		[]webauthn.CredentialDescriptor{webauthn.Descriptor(auth.CredentialID(), nil)}, time.Minute))
+	assert.Error(t, err)
+}
+
+// TestBytesJSON проверяет кодирование двоичных данных в base64url
+func TestBytesJSON(t *testing.T) {
+	data, err := json.Marshal(webauthn.Bytes{0xfb, 0xff})
+	require.NoError(t, err)
+	assert.Equal(t, `"-_8"`, string(data))
+
+	var decoded webauthn.Bytes
+	require.NoError(t, json.Unmarshal([]byte(`"-_8="`), &decoded))
+	assert.Equal(t, webauthn.Bytes{0xfb, 0xff}, decoded)
+}
+
+// TestConfigValidate проверяет, что адреса веб-интерфейса относятся к домену
+func TestConfigValidate(t *testing.T) {
+	assert.NoError(t, config.Validate())
+	assert.NoError(t, webauthn.Config{RPID: "example.com", Origins: []string{"https://nas.example.com:8443"}}.Validate())
+	assert.Error(t, webauthn.Config{RPID: "example.com", Origins: []string{"https://example.org"}}.Validate())
+	assert.Error(t, webauthn.Config{RPID: "example.com"}.Validate())
+}
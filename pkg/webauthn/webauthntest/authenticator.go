@@ -0,0 +1,205 @@
+package webauthntest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com.Vova4o/nasforhome/pkg/webauthn"
+)
+
+// Authenticator программный аутентификатор для тестов с одним ключом ES256.
+// Он всегда подтверждает присутствие и личность пользователя и не присылает аттестацию.
+type Authenticator struct {
+	Origin     string // Адрес страницы, который попадает в данные клиента
+	SignCount  uint32 // Счетчик подписей; увеличивается при каждом входе
+	UserHandle []byte // Идентификатор пользователя, сохраненный при создании ключа
+
+	rpID         string
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+}
+
+// New создает аутентификатор для страницы origin
+func New(origin string) (*Authenticator, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		return nil, err
+	}
+	return &Authenticator{Origin: origin, key: key, credentialID: credentialID}, nil
+}
+
+// CredentialID возвращает идентификатор ключа
+func (a *Authenticator) CredentialID() []byte {
+	return a.credentialID
+}
+
+// Create выполняет navigator.credentials.create() с параметрами сервера
+func (a *Authenticator) Create(opts *webauthn.CreationOptions) (*webauthn.RegistrationCredential, error) {
+	if !slices.ContainsFunc(opts.PubKeyCredParams, func(p webauthn.CredentialParameter) bool {
+		return p.Alg == webauthn.AlgES256
+	}) {
+		return nil, errors.New("сервер не принимает ES256")
+	}
+	for _, excluded := range opts.ExcludeCredentials {
+		if string(excluded.ID) == string(a.credentialID) {
+			return nil, errors.New("ключ уже зарегистрирован")
+		}
+	}
+	a.rpID = opts.RP.ID
+	a.UserHandle = opts.User.ID
+
+	clientDataJSON, err := a.clientData("webauthn.create", opts.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	// AAGUID из нулей, длина идентификатора, идентификатор и открытый ключ COSE
+	attested := make([]byte, 16, 16+2+len(a.credentialID))
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, a.PublicKey()...)
+
+	authData := a.authenticatorData(0x01|0x04|0x40, attested)
+	attestationObject := encodeMap([][2][]byte{
+		{encodeText("fmt"), encodeText("none")},
+		{encodeText("attStmt"), encodeMap(nil)},
+		{encodeText("authData"), encodeBytes(authData)},
+	})
+
+	return &webauthn.RegistrationCredential{
+		ID:    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		RawID: a.credentialID,
+		Type:  "public-key",
+		Response: webauthn.AttestationResponse{
+			ClientDataJSON:    clientDataJSON,
+			AttestationObject: attestationObject,
+			Transports:        []string{"internal"},
+		},
+	}, nil
+}
+
+// Get выполняет navigator.credentials.get() с параметрами сервера
+func (a *Authenticator) Get(opts *webauthn.RequestOptions) (*webauthn.AssertionCredential, error) {
+	if len(opts.AllowCredentials) > 0 && !slices.ContainsFunc(opts.AllowCredentials, func(d webauthn.CredentialDescriptor) bool {
+		return string(d.ID) == string(a.credentialID)
+	}) {
+		return nil, errors.New("нет подходящего ключа")
+	}
+	if a.rpID == "" {
+		a.rpID = opts.RPID
+	}
+
+	clientDataJSON, err := a.clientData("webauthn.get", opts.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	a.SignCount++
+	authData := a.authenticatorData(0x01|0x04, nil)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &webauthn.AssertionCredential{
+		ID:    base64.RawURLEncoding.EncodeToString(a.credentialID),
+		RawID: a.credentialID,
+		Type:  "public-key",
+		Response: webauthn.AssertionResponse{
+			ClientDataJSON:    clientDataJSON,
+			AuthenticatorData: authData,
+			Signature:         signature,
+			UserHandle:        a.UserHandle,
+		},
+	}, nil
+}
+
+// PublicKey возвращает открытый ключ в формате COSE_Key
+func (a *Authenticator) PublicKey() []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+	return encodeMap([][2][]byte{
+		{encodeInt(1), encodeInt(2)},                 // kty: EC2
+		{encodeInt(3), encodeInt(webauthn.AlgES256)}, // alg
+		{encodeInt(-1), encodeInt(1)},                // crv: P-256
+		{encodeInt(-2), encodeBytes(x)},
+		{encodeInt(-3), encodeBytes(y)},
+	})
+}
+
+// clientData возвращает данные клиента, которые формирует браузер
+func (a *Authenticator) clientData(typ string, challenge []byte) ([]byte, error) {
+	data, err := json.Marshal(map[string]any{
+		"type":        typ,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ошибка кодирования данных клиента: %w", err)
+	}
+	return data, nil
+}
+
+// authenticatorData возвращает данные аутентификатора с флагами flags
+func (a *Authenticator) authenticatorData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.SignCount)
+	return append(data, attested...)
+}
+
+// encodeHead кодирует заголовок CBOR с основным типом major и аргументом arg
+func encodeHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	default:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+	}
+}
+
+func encodeInt(v int64) []byte {
+	if v < 0 {
+		return encodeHead(1, uint64(-1-v))
+	}
+	return encodeHead(0, uint64(v))
+}
+
+func encodeBytes(b []byte) []byte {
+	return append(encodeHead(2, uint64(len(b))), b...)
+}
+
+func encodeText(s string) []byte {
+	return append(encodeHead(3, uint64(len(s))), s...)
+}
+
+// encodeMap кодирует словарь из уже закодированных пар ключ-значение
+func encodeMap(pairs [][2][]byte) []byte {
+	data := encodeHead(5, uint64(len(pairs)))
+	for _, pair := range pairs {
+		data = append(data, pair[0]...)
+		data = append(data, pair[1]...)
+	}
+	return data
+}